@@ -0,0 +1,196 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	logKeywordMatchBufferLength = 200
+	logKeywordWindowSeconds     = 60
+	logKeywordWindowKeepNum     = 60
+)
+
+var logKeywordContextLines = kingpin.Flag("collector.log_keyword.context-lines", "Number of lines kept before and after each log keyword match.").Default("5").Int()
+
+// logKeywordMatchObj 一次关键字匹配及其前后几行,每个关键字保留最近的logKeywordMatchBufferLength条
+type logKeywordMatchObj struct {
+	Index   float64  `json:"index"`
+	Time    int64    `json:"time"`
+	File    string   `json:"file"`
+	Content string   `json:"content"`
+	Before  []string `json:"before"`
+	After   []string `json:"after"`
+}
+
+// logKeywordWindowCountObj 每个时间窗口(logKeywordWindowSeconds秒)内的匹配次数
+type logKeywordWindowCountObj struct {
+	Start int64   `json:"start"`
+	Count float64 `json:"count"`
+}
+
+type logKeywordMatchHttpDto struct {
+	Path       string `json:"path"`
+	Keyword    string `json:"keyword"`
+	StartTime  int64  `json:"start_time"`
+	EndTime    int64  `json:"end_time"`
+	StartIndex int    `json:"start_index"`
+	PageSize   int    `json:"page_size"`
+}
+
+type logKeywordMatchPageData struct {
+	Total        int                         `json:"total"`
+	Matches      []*logKeywordMatchObj       `json:"matches"`
+	WindowCounts []*logKeywordWindowCountObj `json:"window_counts"`
+}
+
+type logKeywordMatchHttpResult struct {
+	Status  string                   `json:"status"`
+	Message string                   `json:"message"`
+	Data    *logKeywordMatchPageData `json:"data"`
+}
+
+// recordMatch 记录匹配内容和所在窗口的计数,返回的对象在后面的行到来时继续补充After,调用方需持有collector的锁
+func (v *logKeywordObj) recordMatch(file, lineText string, nowTime int64, before []string) *logKeywordMatchObj {
+	matchObj := logKeywordMatchObj{Index: v.Count, Time: nowTime, File: file, Content: lineText, Before: before, After: []string{}}
+	v.Matches = append(v.Matches, &matchObj)
+	if len(v.Matches) > logKeywordMatchBufferLength {
+		v.Matches = v.Matches[len(v.Matches)-logKeywordMatchBufferLength:]
+	}
+	windowStart := nowTime - nowTime%logKeywordWindowSeconds
+	if len(v.WindowCounts) > 0 && v.WindowCounts[len(v.WindowCounts)-1].Start == windowStart {
+		v.WindowCounts[len(v.WindowCounts)-1].Count++
+	} else {
+		v.WindowCounts = append(v.WindowCounts, &logKeywordWindowCountObj{Start: windowStart, Count: 1})
+	}
+	if len(v.WindowCounts) > logKeywordWindowKeepNum {
+		v.WindowCounts = v.WindowCounts[len(v.WindowCounts)-logKeywordWindowKeepNum:]
+	}
+	return &matchObj
+}
+
+// handleContextLine 在匹配关键字前调用,把当前行补到还没凑够后续行的匹配里,调用方需持有collector的锁
+func (c *logKeywordCollector) handleContextLine(lineText string) {
+	contextLines := *logKeywordContextLines
+	var pendingList []*logKeywordMatchObj
+	for _, v := range c.PendingMatches {
+		v.After = append(v.After, lineText)
+		if len(v.After) < contextLines {
+			pendingList = append(pendingList, v)
+		}
+	}
+	c.PendingMatches = pendingList
+}
+
+// pushRecentLine 在匹配关键字后调用,保留最近几行作为下一次匹配的前文
+func (c *logKeywordCollector) pushRecentLine(lineText string) {
+	contextLines := *logKeywordContextLines
+	if contextLines <= 0 {
+		return
+	}
+	c.RecentLines = append(c.RecentLines, lineText)
+	if len(c.RecentLines) > contextLines {
+		c.RecentLines = c.RecentLines[len(c.RecentLines)-contextLines:]
+	}
+}
+
+func (c *logKeywordCollector) getRecentLines() []string {
+	recentLines := make([]string, len(c.RecentLines))
+	copy(recentLines, c.RecentLines)
+	return recentLines
+}
+
+func copyLogKeywordMatchObj(input *logKeywordMatchObj) *logKeywordMatchObj {
+	output := *input
+	output.Before = append([]string{}, input.Before...)
+	output.After = append([]string{}, input.After...)
+	return &output
+}
+
+func copyLogKeywordWindowCounts(input []*logKeywordWindowCountObj) (output []*logKeywordWindowCountObj) {
+	output = []*logKeywordWindowCountObj{}
+	for _, v := range input {
+		tmpWindowCount := *v
+		output = append(output, &tmpWindowCount)
+	}
+	return
+}
+
+// LogKeywordMatchesHttpHandle 分页返回时间范围内关键字的所有匹配(含前后几行)和每个窗口的匹配次数,通配符路径下汇总所有文件
+func LogKeywordMatchesHttpHandle(w http.ResponseWriter, r *http.Request) {
+	result := logKeywordMatchHttpResult{Status: "ok", Message: "success"}
+	defer func() {
+		w.Header().Set("Content-Type", "application/json")
+		d, _ := json.Marshal(result)
+		w.Write(d)
+	}()
+	var param logKeywordMatchHttpDto
+	buff, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(buff, &param)
+	}
+	if err != nil {
+		result.Status = "error"
+		result.Message = fmt.Sprintf("Handel log keyword matches http request fail,%s ", err.Error())
+		level.Error(monitorLogger).Log("msg", result.Message)
+		return
+	}
+	if param.StartIndex < 0 {
+		param.StartIndex = 0
+	}
+	if param.PageSize <= 0 {
+		param.PageSize = 20
+	}
+	matchList := []*logKeywordMatchObj{}
+	windowCountMap := make(map[int64]float64)
+	logKeywordRawConfigLock.Lock()
+	for _, v := range logKeywordCollectorJobs {
+		if v.Path != param.Path && v.PathPattern != param.Path {
+			continue
+		}
+		v.Lock.RLock()
+		for _, rule := range v.Rule {
+			if rule.Keyword != param.Keyword {
+				continue
+			}
+			for _, matchObj := range rule.Matches {
+				if (param.StartTime > 0 && matchObj.Time < param.StartTime) || (param.EndTime > 0 && matchObj.Time > param.EndTime) {
+					continue
+				}
+				matchList = append(matchList, copyLogKeywordMatchObj(matchObj))
+			}
+			for _, windowCount := range rule.WindowCounts {
+				if (param.StartTime > 0 && windowCount.Start+logKeywordWindowSeconds <= param.StartTime) || (param.EndTime > 0 && windowCount.Start > param.EndTime) {
+					continue
+				}
+				windowCountMap[windowCount.Start] += windowCount.Count
+			}
+		}
+		v.Lock.RUnlock()
+	}
+	logKeywordRawConfigLock.Unlock()
+	sort.SliceStable(matchList, func(i, j int) bool {
+		return matchList[i].Time < matchList[j].Time
+	})
+	pageData := logKeywordMatchPageData{Total: len(matchList), Matches: []*logKeywordMatchObj{}, WindowCounts: []*logKeywordWindowCountObj{}}
+	if param.StartIndex < len(matchList) {
+		endIndex := param.StartIndex + param.PageSize
+		if endIndex > len(matchList) {
+			endIndex = len(matchList)
+		}
+		pageData.Matches = matchList[param.StartIndex:endIndex]
+	}
+	for windowStart, count := range windowCountMap {
+		pageData.WindowCounts = append(pageData.WindowCounts, &logKeywordWindowCountObj{Start: windowStart, Count: count})
+	}
+	sort.Slice(pageData.WindowCounts, func(i, j int) bool {
+		return pageData.WindowCounts[i].Start < pageData.WindowCounts[j].Start
+	})
+	result.Data = &pageData
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"io/ioutil"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 )
@@ -27,6 +26,9 @@ const (
 var (
 	logKeywordCollectorJobs []*logKeywordCollector
 	logKeywordChanLength    = 100000
+	// 通配符路径下已经不再匹配的文件(如被轮转删除)的计数,按 路径模式^规则标识 累加,保证服务端按file汇总的值不会变小
+	logKeywordRetiredCountMap  = make(map[string]*logKeywordMetricObj)
+	logKeywordRetiredCountLock = new(sync.RWMutex)
 )
 
 func init() {
@@ -38,7 +40,7 @@ func NewLogMonitorCollector(logger log.Logger) (Collector, error) {
 		logMonitor: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, logMonitorCollectorName, "count_total"),
 			"Count the keyword from log file.",
-			[]string{"file", "keyword", "t_guid"}, nil,
+			[]string{"file", "keyword", "exclude_keyword", "t_guid", "real_file"}, nil,
 		),
 		logger: logger,
 	}, nil
@@ -49,16 +51,25 @@ func (c *logMonitorCollector) Update(ch chan<- prometheus.Metric) error {
 		for _, vv := range v.get() {
 			ch <- prometheus.MustNewConstMetric(c.logMonitor,
 				prometheus.GaugeValue,
-				vv.Value, vv.Path, vv.Keyword, vv.TargetEndpoint)
+				vv.Value, vv.Path, vv.Keyword, vv.ExcludeKeyword, vv.TargetEndpoint, vv.RealFile)
 		}
 	}
+	logKeywordRetiredCountLock.RLock()
+	for _, v := range logKeywordRetiredCountMap {
+		ch <- prometheus.MustNewConstMetric(c.logMonitor,
+			prometheus.GaugeValue,
+			v.Value, v.Path, v.Keyword, v.ExcludeKeyword, v.TargetEndpoint, "")
+	}
+	logKeywordRetiredCountLock.RUnlock()
 	return nil
 }
 
 type logKeywordMetricObj struct {
 	Path           string
 	Keyword        string
+	ExcludeKeyword string
 	TargetEndpoint string
+	RealFile       string
 	Value          float64
 }
 
@@ -70,38 +81,59 @@ type logRowsHttpDto struct {
 }
 
 type logKeywordFetchObj struct {
-	Index   float64 `json:"index"`
-	Content string  `json:"content"`
+	Index        float64                     `json:"index"`
+	Content      string                      `json:"content"`
+	Time         int64                       `json:"time"`
+	Before       []string                    `json:"before"`
+	After        []string                    `json:"after"`
+	WindowCounts []*logKeywordWindowCountObj `json:"window_counts"`
 }
 
 type logKeywordObj struct {
+	RuleKey        string // 匹配方式、关键字、正则开关、排除条件和目标对象拼成的唯一标识,配置更新时按它保留计数
 	Keyword        string
 	RegExp         *Regexp
+	Expression     *logKeywordExprNode
+	ExcludeKeyword string
+	ExcludeRegExp  *Regexp
 	Count          float64
 	LastMatchRow   string
+	LastMatchTime  int64
 	TargetEndpoint string
+	Matches        []*logKeywordMatchObj
+	WindowCounts   []*logKeywordWindowCountObj
 }
 
 type logKeywordCollector struct {
 	Path               string
+	PathPattern        string
 	Rule               []*logKeywordObj
 	TailSession        *tail.Tail
 	Lock               *sync.RWMutex
-	DataChan           chan string
+	DataChan           chan logTailEvent
 	ReOpenHandlerChan  chan int      `json:"-"`
 	TailTimeLock       *sync.RWMutex `json:"-"`
 	TailLastUnixTime   int64         `json:"-"`
 	DestroyChan        chan int      `json:"-"`
 	TailDataCancelChan chan int      `json:"-"`
+	Multiline          logMultilineConfig
+	RecentLines        []string              `json:"-"`
+	PendingMatches     []*logKeywordMatchObj `json:"-"`
+	Throttle           *logThrottleLimiter   `json:"-"`
+	Processed          *logTailProcessed     `json:"-"`
 }
 
-func (c *logKeywordCollector) update(rule []*logKeywordObj) {
+func (c *logKeywordCollector) update(rule []*logKeywordObj, multiline logMultilineConfig) {
 	c.Lock.Lock()
+	c.Multiline = multiline
 	for _, inputRule := range rule {
 		for _, existRule := range c.Rule {
-			if inputRule.Keyword == existRule.Keyword {
+			if inputRule.RuleKey == existRule.RuleKey {
 				inputRule.Count = existRule.Count
 				inputRule.LastMatchRow = existRule.LastMatchRow
+				inputRule.LastMatchTime = existRule.LastMatchTime
+				inputRule.Matches = existRule.Matches
+				inputRule.WindowCounts = existRule.WindowCounts
 				break
 			}
 		}
@@ -112,32 +144,37 @@ func (c *logKeywordCollector) update(rule []*logKeywordObj) {
 
 func (c *logKeywordCollector) startHandleTailData() {
 	for {
-		var lineText string
+		var event logTailEvent
 		select {
-		case lineText = <-c.DataChan:
+		case event = <-c.DataChan:
 		case <-c.TailDataCancelChan:
 			return
 		}
+		lineText := event.Text
 		//lineText := <-c.DataChan
+		handleStartTime := time.Now()
 		c.Lock.Lock()
+		nowTime := handleStartTime.Unix()
+		c.handleContextLine(lineText)
+		var beforeLines []string
 		for _, v := range c.Rule {
-			if v.RegExp != nil {
-				if pcreMatch(v.RegExp, lineText) {
-					v.Count++
-					v.LastMatchRow = lineText
+			if v.matchLine(lineText) {
+				v.Count++
+				v.LastMatchRow = lineText
+				v.LastMatchTime = nowTime
+				if beforeLines == nil {
+					beforeLines = c.getRecentLines()
 				}
-				//if ok, _ := v.RegExp.MatchString(lineText); ok {
-				//	v.Count++
-				//	v.LastMatchRow = lineText
-				//}
-			} else {
-				if strings.Contains(lineText, v.Keyword) {
-					v.Count++
-					v.LastMatchRow = lineText
+				matchObj := v.recordMatch(c.Path, lineText, nowTime, beforeLines)
+				if *logKeywordContextLines > 0 {
+					c.PendingMatches = append(c.PendingMatches, matchObj)
 				}
 			}
 		}
+		c.pushRecentLine(lineText)
 		c.Lock.Unlock()
+		c.Processed.done(event)
+		c.Throttle.addBusy(event.ReadTime, time.Since(handleStartTime))
 	}
 }
 
@@ -153,15 +190,24 @@ func (c *logKeywordCollector) init() {
 func (c *logKeywordCollector) start() {
 	level.Info(monitorLogger).Log("log_keyword -> logKeywordCollectorStart", c.Path)
 	var err error
-	c.TailSession, err = tail.TailFile(c.Path, tail.Config{Follow: true, ReOpen: true, Poll: true, Location: &tail.SeekInfo{Offset: 0, Whence: 2}})
+	offsetId := "keyword^" + c.Path
+	seekInfo := getLogTailSeekInfo("keyword", offsetId, c.Path)
+	c.TailSession, err = tail.TailFile(c.Path, tail.Config{Follow: true, ReOpen: true, Poll: true, Location: seekInfo})
 	if err != nil {
 		level.Error(monitorLogger).Log("error", fmt.Sprintf("start log keyword collector fail, path: %s, error: %v", c.Path, err))
 		return
 	}
 	c.TailLastUnixTime = 0
-	c.DataChan = make(chan string, logKeywordChanLength)
+	c.DataChan = make(chan logTailEvent, logKeywordChanLength)
+	position := newLogTailPosition(c.Path, seekInfo)
+	c.Processed = newLogTailProcessed(position)
+	c.Throttle = getLogThrottleLimiter(offsetId, "keyword", c.Path, "", false)
 	go c.startHandleTailData()
 	//go c.startFileHandlerCheck()
+	c.Lock.RLock()
+	multilineAssembler := newLogMultilineAssembler(c.Path, c.Multiline, c.DataChan, c.Throttle)
+	c.Lock.RUnlock()
+	multilineTicker := time.NewTicker(1 * time.Second)
 	reopenFlag := false
 	destroyFlag := false
 	for {
@@ -170,11 +216,23 @@ func (c *logKeywordCollector) start() {
 			reopenFlag = true
 		case <-c.DestroyChan:
 			destroyFlag = true
+		case <-multilineTicker.C:
+			c.Lock.RLock()
+			tmpMultiline := c.Multiline
+			c.Lock.RUnlock()
+			if tmpMultiline != multilineAssembler.config {
+				multilineAssembler.flush()
+				multilineAssembler = newLogMultilineAssembler(c.Path, tmpMultiline, c.DataChan, c.Throttle)
+			}
+			multilineAssembler.checkTimeout()
+			position.checkFile(c.Path)
+			updateLogTailOffset(offsetId, c.Path, c.Processed)
 		case line := <-c.TailSession.Lines:
 			if line == nil {
 				continue
 			}
-			c.DataChan <- line.Text
+			tmpInode, tmpOffset := position.addLine(line.Text)
+			multilineAssembler.push(line.Text, tmpInode, tmpOffset)
 		}
 		if reopenFlag || destroyFlag {
 			break
@@ -185,11 +243,16 @@ func (c *logKeywordCollector) start() {
 		//	c.TailTimeLock.Unlock()
 		//}
 	}
+	multilineTicker.Stop()
+	multilineAssembler.flush()
+	updateLogTailOffset(offsetId, c.Path, c.Processed)
 	c.TailSession.Stop()
 	//c.TailSession.Cleanup()
 	c.TailDataCancelChan <- 1
 	level.Info(monitorLogger).Log("log_keyword -> startLogMetricMonitorNeObj__end", c.Path)
 	if destroyFlag {
+		removeLogOffsetTracker(offsetId)
+		removeLogThrottleLimiter(offsetId)
 		return
 	}
 	//time.Sleep(60 * time.Second)
@@ -232,19 +295,46 @@ func (c *logKeywordCollector) destroy() {
 	level.Info(monitorLogger).Log("done_log_keyword_destroy:", c.Path)
 }
 
+// get file标签保持配置里的路径,和服务端配置对应,real_file是实际采集的文件
 func (c *logKeywordCollector) get() (data []*logKeywordMetricObj) {
+	path := c.Path
+	if c.PathPattern != "" {
+		path = c.PathPattern
+	}
 	for _, v := range c.Rule {
-		data = append(data, &logKeywordMetricObj{Path: c.Path, Keyword: v.Keyword, Value: v.Count, TargetEndpoint: v.TargetEndpoint})
+		data = append(data, &logKeywordMetricObj{Path: path, RealFile: c.Path, Keyword: v.Keyword, ExcludeKeyword: v.ExcludeKeyword, Value: v.Count, TargetEndpoint: v.TargetEndpoint})
 	}
 	return data
 }
 
+// retire 文件不再匹配路径模式时,把计数累加到该模式的历史计数里
+func (c *logKeywordCollector) retire() {
+	c.Lock.RLock()
+	logKeywordRetiredCountLock.Lock()
+	for _, v := range c.Rule {
+		key := fmt.Sprintf("%s^%s", c.PathPattern, v.RuleKey)
+		if _, b := logKeywordRetiredCountMap[key]; !b {
+			logKeywordRetiredCountMap[key] = &logKeywordMetricObj{Path: c.PathPattern, Keyword: v.Keyword, ExcludeKeyword: v.ExcludeKeyword, TargetEndpoint: v.TargetEndpoint}
+		}
+		logKeywordRetiredCountMap[key].Value += v.Count
+	}
+	logKeywordRetiredCountLock.Unlock()
+	c.Lock.RUnlock()
+}
+
 func (c *logKeywordCollector) getRows(keyword string) (data []*logKeywordFetchObj) {
 	data = []*logKeywordFetchObj{}
 	for _, v := range c.Rule {
 		if v.Keyword == keyword {
 			//level.Info(monitorLogger).Log("getRows:", keyword, " count:", v.Count)
-			data = append(data, &logKeywordFetchObj{Content: v.LastMatchRow, Index: v.Count})
+			fetchObj := logKeywordFetchObj{Content: v.LastMatchRow, Index: v.Count, Time: v.LastMatchTime, Before: []string{}, After: []string{}, WindowCounts: copyLogKeywordWindowCounts(v.WindowCounts)}
+			// 带上最近一次匹配的前后几行
+			if len(v.Matches) > 0 {
+				lastMatch := copyLogKeywordMatchObj(v.Matches[len(v.Matches)-1])
+				fetchObj.Before = lastMatch.Before
+				fetchObj.After = lastMatch.After
+			}
+			data = append(data, &fetchObj)
 			break
 		}
 	}
@@ -256,11 +346,15 @@ type logKeywordHttpRuleObj struct {
 	Keyword        string  `json:"keyword"`
 	Count          float64 `json:"count"`
 	TargetEndpoint string  `json:"target_endpoint"`
+	MatchType      string  `json:"match_type"`
+	ExcludeKeyword string  `json:"exclude_keyword"`
 }
 
 type logKeywordHttpDto struct {
-	Path     string                   `json:"path"`
-	Keywords []*logKeywordHttpRuleObj `json:"keywords"`
+	Path        string                   `json:"path"`
+	PathPattern string                   `json:"-"`
+	Keywords    []*logKeywordHttpRuleObj `json:"keywords"`
+	logMultilineConfig
 }
 
 type logKeywordHttpResult struct {
@@ -301,6 +395,23 @@ func logKeywordHttpAction(requestParamBuff []byte) (err error) {
 	if err != nil {
 		return
 	}
+	logKeywordRawConfigLock.Lock()
+	defer logKeywordRawConfigLock.Unlock()
+	if err = applyLogKeywordParam(param); err == nil {
+		logKeywordRawConfig = requestParamBuff
+	}
+	return
+}
+
+// applyLogKeywordParam 路径展开成具体文件后,按文件增删改collector,调用方需持有logKeywordRawConfigLock
+func applyLogKeywordParam(inputParamList []*logKeywordHttpDto) (err error) {
+	patternMap := make(map[string]bool)
+	for _, inputParam := range inputParamList {
+		if isLogPathPattern(inputParam.Path) {
+			patternMap[inputParam.Path] = true
+		}
+	}
+	param := expandLogKeywordParam(inputParamList)
 	var newCollectorList []*logKeywordCollector
 	var removePathList []string
 	for _, existCollector := range logKeywordCollectorJobs {
@@ -311,22 +422,24 @@ func logKeywordHttpAction(requestParamBuff []byte) (err error) {
 				exist = true
 				var tmpKeywordList []*logKeywordObj
 				for _, inputKeyword := range inputParam.Keywords {
-					if inputKeyword.RegularEnable {
-						tmpRegExp, tmpRegErr := PcreCompile(inputKeyword.Keyword, 0)
-						if tmpRegErr != nil {
-							err = fmt.Errorf("path:%s pcre regexp compile %s fail:%s", inputParam.Path, inputKeyword.Keyword, tmpRegErr.Message)
-							continue
-						}
-						tmpKeywordList = append(tmpKeywordList, &logKeywordObj{Keyword: inputKeyword.Keyword, RegExp: &tmpRegExp, TargetEndpoint: inputKeyword.TargetEndpoint})
-					} else {
-						tmpKeywordList = append(tmpKeywordList, &logKeywordObj{Keyword: inputKeyword.Keyword, TargetEndpoint: inputKeyword.TargetEndpoint})
+					tmpKeywordObj, tmpBuildErr := buildLogKeywordObj(inputParam.Path, inputKeyword)
+					if tmpBuildErr != nil {
+						err = tmpBuildErr
+						continue
 					}
+					// 已有collector里新增的关键字从0开始计数,原有关键字在update里沿用原来的计数
+					tmpKeywordObj.Count = 0
+					tmpKeywordList = append(tmpKeywordList, tmpKeywordObj)
 				}
-				existCollector.update(tmpKeywordList)
+				existCollector.PathPattern = inputParam.PathPattern
+				existCollector.update(tmpKeywordList, inputParam.logMultilineConfig)
 			}
 		}
 		if !exist {
 			// Remove collector
+			if existCollector.PathPattern != "" && patternMap[existCollector.PathPattern] {
+				existCollector.retire()
+			}
 			existCollector.destroy()
 			removePathList = append(removePathList, existCollector.Path)
 		}
@@ -361,25 +474,29 @@ func logKeywordHttpAction(requestParamBuff []byte) (err error) {
 			continue
 		}
 		// Add collector
-		newCollector := logKeywordCollector{Path: inputParam.Path}
+		newCollector := logKeywordCollector{Path: inputParam.Path, PathPattern: inputParam.PathPattern, Multiline: inputParam.logMultilineConfig}
 		newCollector.Lock = new(sync.RWMutex)
 		var tmpKeywordList []*logKeywordObj
 		for _, inputKeyword := range inputParam.Keywords {
-			if inputKeyword.RegularEnable {
-				tmpRegExp, tmpRegErr := PcreCompile(inputKeyword.Keyword, 0)
-				if tmpRegErr != nil {
-					err = fmt.Errorf("path:%s regexp2 regexp compile %s fail:%s", inputParam.Path, inputKeyword.Keyword, tmpRegErr.Message)
-					continue
-				}
-				tmpKeywordList = append(tmpKeywordList, &logKeywordObj{Keyword: inputKeyword.Keyword, RegExp: &tmpRegExp, Count: inputKeyword.Count, TargetEndpoint: inputKeyword.TargetEndpoint})
-			} else {
-				tmpKeywordList = append(tmpKeywordList, &logKeywordObj{Keyword: inputKeyword.Keyword, Count: inputKeyword.Count, TargetEndpoint: inputKeyword.TargetEndpoint})
+			tmpKeywordObj, tmpBuildErr := buildLogKeywordObj(inputParam.Path, inputKeyword)
+			if tmpBuildErr != nil {
+				err = tmpBuildErr
+				continue
 			}
+			tmpKeywordList = append(tmpKeywordList, tmpKeywordObj)
 		}
 		newCollector.Rule = tmpKeywordList
 		logKeywordCollectorJobs = append(logKeywordCollectorJobs, &newCollector)
 		newCollector.init()
 	}
+	// 路径模式已从配置中删除的,历史计数也不再上报
+	logKeywordRetiredCountLock.Lock()
+	for k, v := range logKeywordRetiredCountMap {
+		if !patternMap[v.Path] {
+			delete(logKeywordRetiredCountMap, k)
+		}
+	}
+	logKeywordRetiredCountLock.Unlock()
 	return err
 }
 
@@ -431,11 +548,20 @@ func LogMonitorRowsHttpHandle(w http.ResponseWriter, r *http.Request) {
 		result.Message = errorMsg
 		return
 	}
+	// 通配符路径下有多个文件时,取最近匹配到关键字的那个文件
+	var lastMatchTime int64 = -1
 	for _, v := range logKeywordCollectorJobs {
-		if v.Path == param.Path {
-			result.Data = v.getRows(param.Keyword)
-			break
+		if v.Path != param.Path && v.PathPattern != param.Path {
+			continue
+		}
+		v.Lock.RLock()
+		for _, rule := range v.Rule {
+			if rule.Keyword == param.Keyword && rule.LastMatchTime > lastMatchTime {
+				lastMatchTime = rule.LastMatchTime
+				result.Data = v.getRows(param.Keyword)
+			}
 		}
+		v.Lock.RUnlock()
 	}
 	result.Status = "ok"
 	result.Message = "success"
@@ -0,0 +1,236 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+const logKeywordMatchTypeExpression = "expression"
+
+// logKeywordExprNode 关键字布尔表达式,支持 && || ! 和括号,关键字里有空格以外的特殊字符时用双引号括起来,
+// 如 "ERROR" && !("retry ok" || timeout);开启正则时每个关键字按正则匹配
+type logKeywordExprNode struct {
+	Op       string
+	Term     string
+	RegExp   *Regexp
+	Children []*logKeywordExprNode
+}
+
+type logKeywordExprParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenizeLogKeywordExpression 操作符为 && || ! ( ),双引号内的内容原样作为关键字,其它连续文本去掉首尾空格后作为关键字
+func tokenizeLogKeywordExpression(expr string) (tokens []string, err error) {
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == ' ' || expr[i] == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case expr[i] == '!' || expr[i] == '(' || expr[i] == ')':
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case expr[i] == '"':
+			var termBuilder strings.Builder
+			i++
+			for i < len(expr) && expr[i] != '"' {
+				if expr[i] == '\\' && i+1 < len(expr) && expr[i+1] == '"' {
+					i++
+				}
+				termBuilder.WriteByte(expr[i])
+				i++
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("keyword expression:%s quote not closed", expr)
+			}
+			i++
+			// 加前缀区分关键字和操作符
+			tokens = append(tokens, "\""+termBuilder.String())
+		default:
+			termStart := i
+			for i < len(expr) && expr[i] != '(' && expr[i] != ')' && expr[i] != '"' && !strings.HasPrefix(expr[i:], "&&") && !strings.HasPrefix(expr[i:], "||") {
+				i++
+			}
+			tokens = append(tokens, "\""+strings.TrimSpace(expr[termStart:i]))
+		}
+	}
+	return
+}
+
+func parseLogKeywordExpression(expr string) (node *logKeywordExprNode, err error) {
+	parser := logKeywordExprParser{}
+	if parser.tokens, err = tokenizeLogKeywordExpression(expr); err != nil {
+		return
+	}
+	if len(parser.tokens) == 0 {
+		return nil, fmt.Errorf("keyword expression can not empty")
+	}
+	if node, err = parser.parseOr(); err != nil {
+		return
+	}
+	if parser.pos < len(parser.tokens) {
+		err = fmt.Errorf("keyword expression:%s unexpected token:%s", expr, strings.TrimPrefix(parser.tokens[parser.pos], "\""))
+	}
+	return
+}
+
+func (p *logKeywordExprParser) parseOr() (node *logKeywordExprNode, err error) {
+	if node, err = p.parseAnd(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos] == "||" {
+		p.pos++
+		rightNode, rightErr := p.parseAnd()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		node = &logKeywordExprNode{Op: "or", Children: []*logKeywordExprNode{node, rightNode}}
+	}
+	return
+}
+
+func (p *logKeywordExprParser) parseAnd() (node *logKeywordExprNode, err error) {
+	if node, err = p.parseFactor(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos] == "&&" {
+		p.pos++
+		rightNode, rightErr := p.parseFactor()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		node = &logKeywordExprNode{Op: "and", Children: []*logKeywordExprNode{node, rightNode}}
+	}
+	return
+}
+
+func (p *logKeywordExprParser) parseFactor() (node *logKeywordExprNode, err error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("keyword expression incomplete")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token {
+	case "!":
+		childNode, childErr := p.parseFactor()
+		if childErr != nil {
+			return nil, childErr
+		}
+		node = &logKeywordExprNode{Op: "not", Children: []*logKeywordExprNode{childNode}}
+	case "(":
+		if node, err = p.parseOr(); err != nil {
+			return
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, fmt.Errorf("keyword expression bracket not closed")
+		}
+		p.pos++
+	case ")", "&&", "||":
+		err = fmt.Errorf("keyword expression unexpected token:%s", token)
+	default:
+		term := strings.TrimPrefix(token, "\"")
+		if term == "" {
+			return nil, fmt.Errorf("keyword expression contains empty keyword")
+		}
+		node = &logKeywordExprNode{Op: "term", Term: term}
+	}
+	return
+}
+
+func (n *logKeywordExprNode) compile(regularEnable bool) error {
+	if n.Op == "term" {
+		if regularEnable {
+			// 多行合并后的日志带换行,和json正则一样用DOTALL让.能匹配换行
+			tmpRegExp, tmpRegErr := PcreCompile(n.Term, DOTALL)
+			if tmpRegErr != nil {
+				return fmt.Errorf("pcre regexp compile %s fail:%s", n.Term, tmpRegErr.Message)
+			}
+			n.RegExp = &tmpRegExp
+		}
+		return nil
+	}
+	for _, child := range n.Children {
+		if err := child.compile(regularEnable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *logKeywordExprNode) match(lineText string) bool {
+	switch n.Op {
+	case "and":
+		return n.Children[0].match(lineText) && n.Children[1].match(lineText)
+	case "or":
+		return n.Children[0].match(lineText) || n.Children[1].match(lineText)
+	case "not":
+		return !n.Children[0].match(lineText)
+	}
+	if n.RegExp != nil {
+		return pcreMatch(n.RegExp, lineText)
+	}
+	return strings.Contains(lineText, n.Term)
+}
+
+// buildLogKeywordObj 按配置生成匹配规则:单个关键字或布尔表达式,再加上排除条件
+func buildLogKeywordObj(path string, inputKeyword *logKeywordHttpRuleObj) (result *logKeywordObj, err error) {
+	result = &logKeywordObj{RuleKey: getLogKeywordRuleKey(inputKeyword), Keyword: inputKeyword.Keyword, Count: inputKeyword.Count, TargetEndpoint: inputKeyword.TargetEndpoint}
+	if inputKeyword.MatchType == logKeywordMatchTypeExpression {
+		if result.Expression, err = parseLogKeywordExpression(inputKeyword.Keyword); err == nil {
+			err = result.Expression.compile(inputKeyword.RegularEnable)
+		}
+	} else if inputKeyword.RegularEnable {
+		// 多行合并后的日志带换行,和json正则一样用DOTALL让.能匹配换行
+		tmpRegExp, tmpRegErr := PcreCompile(inputKeyword.Keyword, DOTALL)
+		if tmpRegErr != nil {
+			err = fmt.Errorf("pcre regexp compile %s fail:%s", inputKeyword.Keyword, tmpRegErr.Message)
+		} else {
+			result.RegExp = &tmpRegExp
+		}
+	}
+	if err == nil && inputKeyword.ExcludeKeyword != "" {
+		// 原文保留用于上报标签,开启正则时按ExcludeRegExp匹配
+		result.ExcludeKeyword = inputKeyword.ExcludeKeyword
+		if inputKeyword.RegularEnable {
+			tmpRegExp, tmpRegErr := PcreCompile(inputKeyword.ExcludeKeyword, DOTALL)
+			if tmpRegErr != nil {
+				err = fmt.Errorf("pcre regexp compile exclude %s fail:%s", inputKeyword.ExcludeKeyword, tmpRegErr.Message)
+			} else {
+				result.ExcludeRegExp = &tmpRegExp
+			}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("path:%s %s", path, err.Error())
+	}
+	return
+}
+
+func getLogKeywordRuleKey(inputKeyword *logKeywordHttpRuleObj) string {
+	return fmt.Sprintf("%s^%s^%t^%s^%s", inputKeyword.MatchType, inputKeyword.Keyword, inputKeyword.RegularEnable, inputKeyword.ExcludeKeyword, inputKeyword.TargetEndpoint)
+}
+
+// matchLine 命中关键字(或表达式成立)且不命中排除条件
+func (v *logKeywordObj) matchLine(lineText string) bool {
+	var matchFlag bool
+	if v.Expression != nil {
+		matchFlag = v.Expression.match(lineText)
+	} else if v.RegExp != nil {
+		matchFlag = pcreMatch(v.RegExp, lineText)
+	} else {
+		matchFlag = strings.Contains(lineText, v.Keyword)
+	}
+	if !matchFlag {
+		return false
+	}
+	if v.ExcludeRegExp != nil {
+		return !pcreMatch(v.ExcludeRegExp, lineText)
+	}
+	if v.ExcludeKeyword != "" {
+		return !strings.Contains(lineText, v.ExcludeKeyword)
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// 分词和错误用例和服务端 services/db/log_keyword_rule_test.go 保持一致
+
+func TestTokenizeLogKeywordExpression(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{`ERROR`, []string{`"ERROR`}, false},
+		{`ERROR && !timeout`, []string{`"ERROR`, "&&", "!", `"timeout`}, false},
+		{`"retry ok"||(a&&b)`, []string{`"retry ok`, "||", "(", `"a`, "&&", `"b`, ")"}, false},
+		{` out of memory  || oom `, []string{`"out of memory`, "||", `"oom`}, false},
+		{`"say \"hi\""`, []string{`"say "hi"`}, false},
+		{`"&&"`, []string{`"&&`}, false},
+		{`"not closed`, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := tokenizeLogKeywordExpression(tc.expr)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("tokenizeLogKeywordExpression(%q): want error %v, got %v", tc.expr, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tokenizeLogKeywordExpression(%q): want %q, got %q", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestParseLogKeywordExpressionError(t *testing.T) {
+	for _, expr := range []string{``, `   `, `a &&`, `|| a`, `(a || b`, `a) `, `a && ()`, `!`, `a "" b`, `"" || a`} {
+		if _, err := parseLogKeywordExpression(expr); err == nil {
+			t.Errorf("parseLogKeywordExpression(%q): want error, got nil", expr)
+		}
+	}
+}
+
+func TestLogKeywordExpressionMatch(t *testing.T) {
+	testCases := []struct {
+		expr string
+		line string
+		want bool
+	}{
+		{`ERROR`, `2024 ERROR db`, true},
+		{`ERROR && !timeout`, `ERROR timeout`, false},
+		{`ERROR && !timeout`, `ERROR refused`, true},
+		{`"retry ok" || (WARN && db)`, `WARN db slow`, true},
+		{`"retry ok" || (WARN && db)`, `WARN cache slow`, false},
+		{`"retry ok" || (WARN && db)`, `job retry ok`, true},
+	}
+	for _, tc := range testCases {
+		node, err := parseLogKeywordExpression(tc.expr)
+		if err != nil {
+			t.Fatalf("parseLogKeywordExpression(%q): unexpected error %v", tc.expr, err)
+		}
+		if got := node.match(tc.line); got != tc.want {
+			t.Errorf("expression %q match %q: want %v, got %v", tc.expr, tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestLogKeywordMatchLineExclude(t *testing.T) {
+	rule, err := buildLogKeywordObj("test", &logKeywordHttpRuleObj{Keyword: "ERROR", ExcludeKeyword: "retry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.matchLine("ERROR connect refused") || rule.matchLine("ERROR retry later") || rule.matchLine("INFO ok") {
+		t.Errorf("exclude keyword not applied")
+	}
+}
+
+func TestLogKeywordCollectorUpdateKeepCount(t *testing.T) {
+	buildRule := func(keyword, exclude string) *logKeywordObj {
+		rule, err := buildLogKeywordObj("test", &logKeywordHttpRuleObj{Keyword: keyword, ExcludeKeyword: exclude, TargetEndpoint: "host_1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rule
+	}
+	collector := logKeywordCollector{Lock: new(sync.RWMutex), Rule: []*logKeywordObj{buildRule("ERROR", ""), buildRule("ERROR", "retry")}}
+	collector.Rule[0].Count = 5
+	collector.Rule[1].Count = 2
+	collector.update([]*logKeywordObj{buildRule("ERROR", "retry"), buildRule("ERROR", "timeout")}, logMultilineConfig{})
+	if collector.Rule[0].Count != 2 {
+		t.Errorf("rule with same exclude keyword should keep count 2, got %v", collector.Rule[0].Count)
+	}
+	if collector.Rule[1].Count != 0 {
+		t.Errorf("rule with new exclude keyword should start from 0, got %v", collector.Rule[1].Count)
+	}
+	metricList := collector.get()
+	if len(metricList) != 2 || metricList[0].ExcludeKeyword != "retry" || metricList[1].ExcludeKeyword != "timeout" {
+		t.Errorf("metric should carry exclude keyword, got %+v %+v", metricList[0], metricList[1])
+	}
+}
@@ -12,8 +12,11 @@ import (
 	"github.com/hpcloud/tail"
 	"github.com/prometheus/client_golang/prometheus"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
 	"reflect"
+	"sort"
 	//"regexp"
 	"strconv"
 	"strings"
@@ -33,11 +36,18 @@ var (
 	logMetricMonitorMetricLock = new(sync.RWMutex)
 	monitorLogger              log.Logger
 	logMetricChanLength        = 100000
+	// 分位值按采集周期内的样本计算,样本超过上限后做蓄水池抽样,避免大流量日志占用过多内存
+	logMetricSampleLimit    = 10000
+	logMetricDefaultBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+	logMetricQuantileMap    = map[string]float64{"p50": 0.5, "p90": 0.9, "p95": 0.95, "p99": 0.99}
+	logMetricSummaryList    = []float64{0.5, 0.9, 0.95, 0.99}
 )
 
 type logMetricMonitorCollector struct {
-	logMetricMonitor *prometheus.Desc
-	logger           log.Logger
+	logMetricMonitor          *prometheus.Desc
+	logMetricMonitorHistogram *prometheus.Desc
+	logMetricMonitorSummary   *prometheus.Desc
+	logger                    log.Logger
 }
 
 func InitMonitorLogger(logger log.Logger) {
@@ -55,6 +65,16 @@ func LogMetricMonitorCollector(logger log.Logger) (Collector, error) {
 			"Show log_metric data from log file.",
 			[]string{"key", "tags", "path", "agg", "t_endpoint", "service_group", "code", "retcode"}, nil,
 		),
+		logMetricMonitorHistogram: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, log_metricCollectorName, "histogram"),
+			"Show log_metric histogram from log file.",
+			[]string{"key", "tags", "path", "t_endpoint", "service_group", "code", "retcode"}, nil,
+		),
+		logMetricMonitorSummary: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, log_metricCollectorName, "summary"),
+			"Show log_metric summary from log file.",
+			[]string{"key", "tags", "path", "t_endpoint", "service_group", "code", "retcode"}, nil,
+		),
 		logger: logger,
 	}, nil
 }
@@ -65,9 +85,29 @@ func (c *logMetricMonitorCollector) Update(ch chan<- prometheus.Metric) error {
 		if !v.Display {
 			continue
 		}
-		ch <- prometheus.MustNewConstMetric(c.logMetricMonitor,
-			prometheus.GaugeValue,
-			v.Value, v.Metric, v.TagsString, v.Path, v.Agg, v.TEndpoint, v.ServiceGroup, v.Code, v.RetCode)
+		switch v.Agg {
+		case "histogram":
+			if v.Cumulative == nil {
+				continue
+			}
+			// 累计值只在输出时取整一次,保证各个桶和count一致
+			bucketMap := make(map[float64]uint64)
+			for i, bucket := range v.Buckets {
+				bucketMap[bucket] = uint64(math.Round(v.Cumulative.BucketCounts[i]))
+			}
+			ch <- prometheus.MustNewConstHistogram(c.logMetricMonitorHistogram,
+				uint64(math.Round(v.Cumulative.Count)), v.Cumulative.Sum, bucketMap, v.Metric, v.TagsString, v.Path, v.TEndpoint, v.ServiceGroup, v.Code, v.RetCode)
+		case "summary":
+			if v.Cumulative == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstSummary(c.logMetricMonitorSummary,
+				uint64(math.Round(v.Cumulative.Count)), v.Cumulative.Sum, v.Quantiles, v.Metric, v.TagsString, v.Path, v.TEndpoint, v.ServiceGroup, v.Code, v.RetCode)
+		default:
+			ch <- prometheus.MustNewConstMetric(c.logMetricMonitor,
+				prometheus.GaugeValue,
+				v.Value, v.Metric, v.TagsString, v.Path, v.Agg, v.TEndpoint, v.ServiceGroup, v.Code, v.RetCode)
+		}
 	}
 	logMetricMonitorMetricLock.RUnlock()
 	return nil
@@ -82,17 +122,21 @@ type logMetricMonitorNeObj struct {
 	TailSession        *tail.Tail             `json:"-"`
 	Lock               *sync.RWMutex          `json:"-"`
 	Path               string                 `json:"path"`
+	PathPattern        string                 `json:"-"`
 	TargetEndpoint     string                 `json:"target_endpoint"`
 	ServiceGroup       string                 `json:"service_group"`
 	JsonConfig         []*logMetricJsonNeObj  `json:"config"`
 	MetricConfig       []*logMetricNeObj      `json:"custom"`
 	MetricGroupConfig  []*logMetricGroupNeObj `json:"metric_group_config"`
-	DataChan           chan string            `json:"-"`
+	DataChan           chan logTailEvent      `json:"-"`
 	ReOpenHandlerChan  chan int               `json:"-"`
 	TailTimeLock       *sync.RWMutex          `json:"-"`
 	TailLastUnixTime   int64                  `json:"-"`
 	DestroyChan        chan int               `json:"-"`
 	TailDataCancelChan chan int               `json:"-"`
+	Throttle           *logThrottleLimiter    `json:"-"`
+	Processed          *logTailProcessed      `json:"-"`
+	logMultilineConfig
 }
 
 type logMetricGroupNeObj struct {
@@ -101,6 +145,7 @@ type logMetricGroupNeObj struct {
 	LogMetricGroup string                      `json:"log_metric_group"`
 	LogType        string                      `json:"log_type"`
 	JsonRegular    string                      `json:"json_regular"`
+	Delimiter      string                      `json:"delimiter"`
 	ParamList      []*logMetricParamNeObj      `json:"param_list"`
 	MetricConfig   []*logMetricNeObj           `json:"custom"`
 }
@@ -130,6 +175,7 @@ type logMetricNeObj struct {
 	Title        string                     `json:"title"`
 	AggType      string                     `json:"agg_type"`
 	Step         int64                      `json:"step"`
+	Buckets      []float64                  `json:"buckets"`
 	StringMap    []*logMetricStringMapNeObj `json:"string_map"`
 	TagConfig    []*LogMetricConfigTag      `json:"tag_config"`
 	LogParamName string                     `json:"log_param_name"`
@@ -152,44 +198,145 @@ type logMetricStringMapNeObj struct {
 }
 
 type logMetricDisplayObj struct {
-	Id             string            `json:"id"`
-	Metric         string            `json:"metric"`
-	Path           string            `json:"path"`
-	Agg            string            `json:"agg"`
-	TEndpoint      string            `json:"t_endpoint"`
-	ServiceGroup   string            `json:"service_group"`
-	Tags           []string          `json:"tags"`
-	TagsString     string            `json:"tags_string"`
-	Value          float64           `json:"value"`
-	ValueObj       logMetricValueObj `json:"value_obj"`
-	Step           int64             `json:"step"`
-	Display        bool              `json:"display"` // 用来控制采集间隔,默认最小间隔10s,当间隔为30s时,通过display来控制30s才出现汇总一次数据
-	UpdateTime     int64             `json:"update_time"`
-	Code           string            `json:"code"`
-	RetCode        string            `json:"ret_code"`
-	LastActiveTime int64             `json:"last_active_time"`
-	ByAvgFlag      bool              `json:"by_avg_flag"`
+	Id             string               `json:"id"`
+	Metric         string               `json:"metric"`
+	Path           string               `json:"path"`
+	Agg            string               `json:"agg"`
+	TEndpoint      string               `json:"t_endpoint"`
+	ServiceGroup   string               `json:"service_group"`
+	Tags           []string             `json:"tags"`
+	TagsString     string               `json:"tags_string"`
+	Value          float64              `json:"value"`
+	ValueObj       logMetricValueObj    `json:"value_obj"`
+	Step           int64                `json:"step"`
+	Display        bool                 `json:"display"` // 用来控制采集间隔,默认最小间隔10s,当间隔为30s时,通过display来控制30s才出现汇总一次数据
+	UpdateTime     int64                `json:"update_time"`
+	Code           string               `json:"code"`
+	RetCode        string               `json:"ret_code"`
+	LastActiveTime int64                `json:"last_active_time"`
+	ByAvgFlag      bool                 `json:"by_avg_flag"`
+	Buckets        []float64            `json:"buckets"`
+	Cumulative     *logMetricCounterObj `json:"cumulative"` // histogram和summary的累计计数,跨采集周期一直累加
+	Quantiles      map[float64]float64  `json:"quantiles"`
 }
 
 type logMetricValueObj struct {
-	Sum   float64
-	Count float64
-	Max   float64
-	Min   float64
+	Sum     float64
+	Count   float64
+	Max     float64
+	Min     float64
+	Samples []float64 // 只有分位值、histogram、summary才保留样本
+}
+
+// logMetricCounterObj 抽样折算后的计数不是整数,累计时保留小数
+type logMetricCounterObj struct {
+	Count        float64
+	Sum          float64
+	BucketCounts []float64
+}
+
+func isLogMetricSampleAgg(agg string) bool {
+	if _, ok := logMetricQuantileMap[agg]; ok {
+		return true
+	}
+	return agg == "histogram" || agg == "summary"
+}
+
+// newLogMetricValueObj weight是日志被抽样时每个样本代表的行数,没有抽样时为1
+func newLogMetricValueObj(agg string, value, weight float64) logMetricValueObj {
+	valueObj := logMetricValueObj{Sum: value * weight, Max: value, Min: value, Count: weight}
+	if isLogMetricSampleAgg(agg) {
+		valueObj.Samples = []float64{value}
+	}
+	return valueObj
+}
+
+func (v *logMetricValueObj) add(agg string, value, weight float64) {
+	v.Sum += value * weight
+	v.Count += weight
+	if v.Max < value {
+		v.Max = value
+	}
+	if v.Min > value {
+		v.Min = value
+	}
+	if isLogMetricSampleAgg(agg) {
+		v.addSample(value)
+	}
+}
+
+func (v *logMetricValueObj) addSample(value float64) {
+	if len(v.Samples) < logMetricSampleLimit {
+		v.Samples = append(v.Samples, value)
+		return
+	}
+	if index := rand.Int63n(int64(v.Count)); index < int64(logMetricSampleLimit) {
+		v.Samples[index] = value
+	}
+}
+
+// calcLogMetricQuantile 按最近秩法取分位值
+func calcLogMetricQuantile(samples []float64, quantile float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	sortSamples := make([]float64, len(samples))
+	copy(sortSamples, samples)
+	sort.Float64s(sortSamples)
+	index := int(math.Ceil(quantile*float64(len(sortSamples)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sortSamples[index]
+}
+
+// updateLogMetricCumulative histogram和summary是累计值,每次展示时把新样本累加到上次的结果上
+func (v *logMetricDisplayObj) updateLogMetricCumulative(existObj *logMetricDisplayObj) {
+	if len(v.Buckets) == 0 {
+		v.Buckets = logMetricDefaultBuckets
+	}
+	if existObj != nil && existObj != v && existObj.Cumulative != nil && len(existObj.Cumulative.BucketCounts) == len(v.Buckets) {
+		v.Cumulative = &logMetricCounterObj{Count: existObj.Cumulative.Count, Sum: existObj.Cumulative.Sum, BucketCounts: append([]float64{}, existObj.Cumulative.BucketCounts...)}
+	}
+	if v.Cumulative == nil || len(v.Cumulative.BucketCounts) != len(v.Buckets) {
+		v.Cumulative = &logMetricCounterObj{BucketCounts: make([]float64, len(v.Buckets))}
+	}
+	// 样本被抽样过时按比例折算,保证count和sum准确
+	var scale float64 = 1
+	if len(v.ValueObj.Samples) > 0 && v.ValueObj.Count > float64(len(v.ValueObj.Samples)) {
+		scale = v.ValueObj.Count / float64(len(v.ValueObj.Samples))
+	}
+	for _, sample := range v.ValueObj.Samples {
+		for i, bucket := range v.Buckets {
+			if sample <= bucket {
+				v.Cumulative.BucketCounts[i] += scale
+			}
+		}
+	}
+	v.Cumulative.Count += v.ValueObj.Count
+	v.Cumulative.Sum += v.ValueObj.Sum
+	if v.Agg == "summary" {
+		v.Quantiles = make(map[float64]float64)
+		for _, quantile := range logMetricSummaryList {
+			v.Quantiles[quantile] = calcLogMetricQuantile(v.ValueObj.Samples, quantile)
+		}
+	}
 }
 
 func (c *logMetricMonitorNeObj) startHandleTailData() {
 	for {
-		var lineText string
+		var event logTailEvent
 		select {
-		case lineText = <-c.DataChan:
+		case event = <-c.DataChan:
 		case <-c.TailDataCancelChan:
 			level.Info(monitorLogger).Log("log_metric -> logMetricMonitorNeObj_tail_data_cancel", fmt.Sprintf("path:%s,serviceGroup:%s", c.Path, c.ServiceGroup))
 			return
 		}
+		lineText := event.Text
 		//lineText := <-c.DataChan
 		//level.Info(monitorLogger).Log("log_metric_get_new_line ->", lineText)
 		//lineText = strings.ReplaceAll(lineText, "\\t", "    ")
+		handleStartTime := time.Now()
 		c.Lock.RLock()
 		for _, rule := range c.JsonConfig {
 			if rule.Regexp == nil {
@@ -253,6 +400,32 @@ func (c *logMetricMonitorNeObj) startHandleTailData() {
 						metricGroup.DataChannel <- fetchParamValueMap
 					}
 				}
+			} else if isLogStructuredType(metricGroup.LogType) {
+				// 配置了截取正则时先截取出结构化部分,再按格式解析,参数的json_key就是字段名
+				structuredText := lineText
+				if metricGroup.JsonRegexp != nil {
+					if fetchList := pcreMatchSubString(metricGroup.JsonRegexp, lineText); len(fetchList) > 0 {
+						structuredText = fetchList[0]
+					} else {
+						continue
+					}
+				}
+				fields, parseErr := parseLogStructuredLine(metricGroup.LogType, metricGroup.Delimiter, structuredText)
+				if parseErr != nil {
+					continue
+				}
+				allMatchFlag := true
+				for _, metricParam := range metricGroup.ParamList {
+					if fetchValue, ok := fields[metricParam.JsonKey]; !ok {
+						allMatchFlag = false
+						break
+					} else {
+						fetchParamValueMap[metricParam.Name] = transMetricGroupData(fetchValue, metricParam.StringMap)
+					}
+				}
+				if allMatchFlag {
+					metricGroup.DataChannel <- fetchParamValueMap
+				}
 			} else {
 				allMatchFlag := true
 				for _, metricParam := range metricGroup.ParamList {
@@ -271,6 +444,8 @@ func (c *logMetricMonitorNeObj) startHandleTailData() {
 			}
 		}
 		c.Lock.RUnlock()
+		c.Processed.done(event)
+		c.Throttle.addBusy(event.ReadTime, time.Since(handleStartTime))
 	}
 }
 
@@ -315,15 +490,24 @@ func pcreMatchSubString(re *Regexp, lineText string) (matchList []string) {
 func (c *logMetricMonitorNeObj) start() {
 	level.Info(monitorLogger).Log("log_metric -> startLogMetricMonitorNeObj__start", fmt.Sprintf("path:%s,serviceGroup:%s", c.Path, c.ServiceGroup))
 	var err error
-	c.TailSession, err = tail.TailFile(c.Path, tail.Config{Follow: true, ReOpen: true, Location: &tail.SeekInfo{Offset: 0, Whence: 2}})
+	offsetId := fmt.Sprintf("metric^%s^%s", c.Path, c.ServiceGroup)
+	seekInfo := getLogTailSeekInfo("metric", offsetId, c.Path)
+	c.TailSession, err = tail.TailFile(c.Path, tail.Config{Follow: true, ReOpen: true, Location: seekInfo})
 	if err != nil {
 		level.Error(monitorLogger).Log("msg", fmt.Sprintf("start log metric collector fail, path: %s, error: %v", c.Path, err))
 		return
 	}
 	c.TailLastUnixTime = 0
-	c.DataChan = make(chan string, logMetricChanLength)
+	c.DataChan = make(chan logTailEvent, logMetricChanLength)
+	position := newLogTailPosition(c.Path, seekInfo)
+	c.Processed = newLogTailProcessed(position)
+	c.Throttle = getLogThrottleLimiter(offsetId, "metric", c.Path, c.ServiceGroup, true)
 	go c.startHandleTailData()
 	//go c.startFileHandlerCheck()
+	c.Lock.RLock()
+	multilineAssembler := newLogMultilineAssembler(c.Path, c.logMultilineConfig, c.DataChan, c.Throttle)
+	c.Lock.RUnlock()
+	multilineTicker := time.NewTicker(1 * time.Second)
 	reopenFlag := false
 	destroyFlag := false
 	for {
@@ -332,12 +516,25 @@ func (c *logMetricMonitorNeObj) start() {
 			reopenFlag = true
 		case <-c.DestroyChan:
 			destroyFlag = true
+		case <-multilineTicker.C:
+			// 配置变更后重建合并器,先把缓存的事件输出
+			c.Lock.RLock()
+			tmpMultiline := c.logMultilineConfig
+			c.Lock.RUnlock()
+			if tmpMultiline != multilineAssembler.config {
+				multilineAssembler.flush()
+				multilineAssembler = newLogMultilineAssembler(c.Path, tmpMultiline, c.DataChan, c.Throttle)
+			}
+			multilineAssembler.checkTimeout()
+			position.checkFile(c.Path)
+			updateLogTailOffset(offsetId, c.Path, c.Processed)
 		case line := <-c.TailSession.Lines:
 			if line == nil {
 				continue
 			}
 			//level.Info(monitorLogger).Log("log_metric -> get_new_line", fmt.Sprintf("path:%s,serviceGroup:%s,text:%s", c.Path, c.ServiceGroup, line.Text))
-			c.DataChan <- line.Text
+			tmpInode, tmpOffset := position.addLine(line.Text)
+			multilineAssembler.push(line.Text, tmpInode, tmpOffset)
 		}
 		if reopenFlag || destroyFlag {
 			break
@@ -348,12 +545,17 @@ func (c *logMetricMonitorNeObj) start() {
 		//	c.TailTimeLock.Unlock()
 		//}
 	}
+	multilineTicker.Stop()
+	multilineAssembler.flush()
+	updateLogTailOffset(offsetId, c.Path, c.Processed)
 	c.TailSession.Stop()
 	//c.TailSession.Cleanup()
 	c.TailDataCancelChan <- 1
 	level.Info(monitorLogger).Log("log_metric -> startLogMetricMonitorNeObj__end", fmt.Sprintf("path:%s,serviceGroup:%s", c.Path, c.ServiceGroup))
 	if destroyFlag {
 		level.Info(monitorLogger).Log("log_metric -> destroy", fmt.Sprintf("path:%s,serviceGroup:%s", c.Path, c.ServiceGroup))
+		removeLogOffsetTracker(offsetId)
+		removeLogThrottleLimiter(offsetId)
 		return
 	}
 	if reopenFlag {
@@ -394,6 +596,7 @@ func (c *logMetricMonitorNeObj) new(input *logMetricMonitorNeObj) {
 	level.Info(monitorLogger).Log("newLogMetricMonitorNeObj", c.Path)
 	c.TargetEndpoint = input.TargetEndpoint
 	c.ServiceGroup = input.ServiceGroup
+	c.logMultilineConfig = input.logMultilineConfig
 	c.JsonConfig = []*logMetricJsonNeObj{}
 	c.TailTimeLock = new(sync.RWMutex)
 	c.ReOpenHandlerChan = make(chan int, 1)
@@ -401,7 +604,7 @@ func (c *logMetricMonitorNeObj) new(input *logMetricMonitorNeObj) {
 	c.TailDataCancelChan = make(chan int, 1)
 	var err error
 	for _, jsonObj := range input.JsonConfig {
-		tmpReg, tmpErr := PcreCompile(jsonObj.Regular, 0)
+		tmpReg, tmpErr := PcreCompile(jsonObj.Regular, DOTALL)
 		if tmpErr != nil {
 			err = fmt.Errorf(tmpErr.Message)
 			level.Error(monitorLogger).Log("newLogMetricMonitorNeObj", fmt.Sprintf("regexpError:%s ", err.Error()))
@@ -434,7 +637,7 @@ func (c *logMetricMonitorNeObj) update(input *logMetricMonitorNeObj) {
 	newJsonConfigList := []*logMetricJsonNeObj{}
 	var err error
 	for _, jsonObj := range input.JsonConfig {
-		tmpExp, tmpErr := PcreCompile(jsonObj.Regular, 0)
+		tmpExp, tmpErr := PcreCompile(jsonObj.Regular, DOTALL)
 		if tmpErr != nil {
 			err = fmt.Errorf(tmpErr.Message)
 			level.Error(monitorLogger).Log("newLogMetricMonitorNeObj", fmt.Sprintf("regexpError:%s ", err.Error()))
@@ -482,6 +685,7 @@ func (c *logMetricMonitorNeObj) update(input *logMetricMonitorNeObj) {
 	c.TargetEndpoint = input.TargetEndpoint
 	c.ServiceGroup = input.ServiceGroup
 	c.MetricGroupConfig = newMetricGroupList
+	c.logMultilineConfig = input.logMultilineConfig
 	level.Info(monitorLogger).Log("MetricGroupConfig: ", fmt.Sprintf("len:%d", len(c.MetricGroupConfig)))
 	c.Lock.Unlock()
 }
@@ -491,13 +695,22 @@ func initLogMetricGroupNeObj(metricGroupObj *logMetricGroupNeObj) {
 		metricGroupObj.DataChannel = make(chan map[string]interface{}, logMetricChanLength)
 	}
 	if metricGroupObj.LogType == "json" {
-		tmpExp, tmpErr := PcreCompile(metricGroupObj.JsonRegular, 0)
+		// 多行合并后的json带换行,用DOTALL让.能匹配换行,对单行日志没有影响
+		tmpExp, tmpErr := PcreCompile(metricGroupObj.JsonRegular, DOTALL)
 		if tmpErr != nil {
 			err := fmt.Errorf(tmpErr.Message)
 			level.Error(monitorLogger).Log("newLogMetricMonitorNeObj", fmt.Sprintf("logType:json regexpError:%s ", err.Error()))
 			return
 		}
 		metricGroupObj.JsonRegexp = &tmpExp
+	} else if isLogStructuredType(metricGroupObj.LogType) {
+		if metricGroupObj.JsonRegular != "" {
+			if tmpExp, tmpErr := PcreCompile(metricGroupObj.JsonRegular, DOTALL); tmpErr != nil {
+				level.Error(monitorLogger).Log("newLogMetricMonitorNeObj", fmt.Sprintf("logType:%s regexpError:%s ", metricGroupObj.LogType, tmpErr.Message))
+			} else {
+				metricGroupObj.JsonRegexp = &tmpExp
+			}
+		}
 	} else {
 		for _, metricParamObj := range metricGroupObj.ParamList {
 			if newRegExp, compileErr := PcreCompile(metricParamObj.Regular, 0); compileErr != nil {
@@ -591,11 +804,12 @@ func LogMetricMonitorHttpHandle(w http.ResponseWriter, r *http.Request) {
 }
 
 func LogMetricMonitorHandleAction(requestParamBuff []byte) error {
-	var param []*logMetricMonitorNeObj
-	err := json.Unmarshal(requestParamBuff, &param)
+	// 通配符和日期占位符路径展开成具体文件,每个文件一个采集任务
+	param, err := expandLogMetricParam(requestParamBuff)
 	if err != nil {
 		return err
 	}
+	logMetricRawConfig = requestParamBuff
 	var tmpLogMetricObjJobs []*logMetricMonitorNeObj
 	deletePathMap := make(map[string]int)
 	for _, logMetricMonitorJob := range logMetricMonitorJobs {
@@ -604,6 +818,7 @@ func LogMetricMonitorHandleAction(requestParamBuff []byte) error {
 			if paramObj.Path == logMetricMonitorJob.Path && paramObj.ServiceGroup == logMetricMonitorJob.ServiceGroup {
 				delFlag = false
 				// update config
+				logMetricMonitorJob.PathPattern = paramObj.PathPattern
 				logMetricMonitorJob.update(paramObj)
 				break
 			}
@@ -635,7 +850,7 @@ func LogMetricMonitorHandleAction(requestParamBuff []byte) error {
 			continue
 		}
 		// add config
-		newLogMetricObj := logMetricMonitorNeObj{Path: paramObj.Path, ServiceGroup: paramObj.ServiceGroup, Lock: new(sync.RWMutex)}
+		newLogMetricObj := logMetricMonitorNeObj{Path: paramObj.Path, PathPattern: paramObj.PathPattern, ServiceGroup: paramObj.ServiceGroup, Lock: new(sync.RWMutex)}
 		newLogMetricObj.new(paramObj)
 		tmpLogMetricObjJobs = append(tmpLogMetricObjJobs, &newLogMetricObj)
 	}
@@ -693,6 +908,8 @@ func calcLogMetricData() {
 	//appendDisplayMap := make(map[string]int)
 	valueCountMap := make(map[string]*logMetricDisplayObj)
 	for _, lmObj := range logMetricMonitorJobs {
+		// 日志被抽样时按读到的行数和实际处理的行数折算
+		sampleScale := lmObj.Throttle.takeSampleScale()
 		for _, jsonObj := range lmObj.JsonConfig {
 			// pull channel data list
 			jsonDataList := []map[string]interface{}{}
@@ -719,17 +936,10 @@ func calcLogMetricData() {
 						//isMatchNewDataFlag = true
 						tmpMetricKey := fmt.Sprintf("%s^%s^%s^%s", lmObj.Path, metricConfig.Metric, metricConfig.AggType, tmpTagString)
 						if valueExistObj, keyExist := valueCountMap[tmpMetricKey]; keyExist {
-							valueExistObj.ValueObj.Sum += metricValueFloat
-							valueExistObj.ValueObj.Count++
-							if valueExistObj.ValueObj.Max < metricValueFloat {
-								valueExistObj.ValueObj.Max = metricValueFloat
-							}
-							if valueExistObj.ValueObj.Min > metricValueFloat {
-								valueExistObj.ValueObj.Min = metricValueFloat
-							}
+							valueExistObj.ValueObj.add(metricConfig.AggType, metricValueFloat, sampleScale)
 							valueExistObj.LastActiveTime = nowTimeUnix
 						} else {
-							valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricConfig.Metric, Path: lmObj.Path, Agg: metricConfig.AggType, TEndpoint: lmObj.TargetEndpoint, ServiceGroup: lmObj.ServiceGroup, TagsString: tmpTagString, Step: metricConfig.Step, ValueObj: logMetricValueObj{Sum: metricValueFloat, Max: metricValueFloat, Min: metricValueFloat, Count: 1}, LastActiveTime: nowTimeUnix}
+							valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricConfig.Metric, Path: lmObj.Path, Agg: metricConfig.AggType, TEndpoint: lmObj.TargetEndpoint, ServiceGroup: lmObj.ServiceGroup, TagsString: tmpTagString, Step: metricConfig.Step, Buckets: metricConfig.Buckets, ValueObj: newLogMetricValueObj(metricConfig.AggType, metricValueFloat, sampleScale), LastActiveTime: nowTimeUnix}
 						}
 					}
 				}
@@ -765,17 +975,10 @@ func calcLogMetricData() {
 				tmpMetricKey := fmt.Sprintf("%s^%s^%s^%s", lmObj.Path, metricObj.Metric, metricObj.AggType, tmpTagString)
 				_, metricValueFloat := transLogMetricStringMapValue(metricObj.StringMap, customFetchString)
 				if valueExistObj, keyExist := valueCountMap[tmpMetricKey]; keyExist {
-					valueExistObj.ValueObj.Sum += metricValueFloat
-					valueExistObj.ValueObj.Count++
-					if valueExistObj.ValueObj.Max < metricValueFloat {
-						valueExistObj.ValueObj.Max = metricValueFloat
-					}
-					if valueExistObj.ValueObj.Min > metricValueFloat {
-						valueExistObj.ValueObj.Min = metricValueFloat
-					}
+					valueExistObj.ValueObj.add(metricObj.AggType, metricValueFloat, sampleScale)
 					valueExistObj.LastActiveTime = nowTimeUnix
 				} else {
-					valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricObj.Metric, Path: lmObj.Path, Agg: metricObj.AggType, TEndpoint: lmObj.TargetEndpoint, ServiceGroup: lmObj.ServiceGroup, TagsString: tmpTagString, Step: metricObj.Step, ValueObj: logMetricValueObj{Sum: metricValueFloat, Max: metricValueFloat, Min: metricValueFloat, Count: 1}, LastActiveTime: nowTimeUnix}
+					valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricObj.Metric, Path: lmObj.Path, Agg: metricObj.AggType, TEndpoint: lmObj.TargetEndpoint, ServiceGroup: lmObj.ServiceGroup, TagsString: tmpTagString, Step: metricObj.Step, Buckets: metricObj.Buckets, ValueObj: newLogMetricValueObj(metricObj.AggType, metricValueFloat, sampleScale), LastActiveTime: nowTimeUnix}
 				}
 			}
 			//valueCountMap[tmpMetricKey] = &tmpMetricObj
@@ -788,7 +991,7 @@ func calcLogMetricData() {
 				tmpMapData := <-metricGroupObj.DataChannel
 				matchDataList = append(matchDataList, tmpMapData)
 			}
-			calcMetricGroupFunc(lmObj.Path, lmObj.TargetEndpoint, lmObj.ServiceGroup, matchDataList, metricGroupObj.MetricConfig, valueCountMap)
+			calcMetricGroupFunc(lmObj.Path, lmObj.TargetEndpoint, lmObj.ServiceGroup, matchDataList, metricGroupObj.MetricConfig, valueCountMap, sampleScale)
 		}
 	}
 	// appendDisplayMap是当数据上一次采集出现，但此次采集不出现，尝试把数据补个默认点上去，现在默认值是0
@@ -854,7 +1057,7 @@ func transMetricGroupData(input interface{}, stringMap []*logMetricStringMapNeOb
 	return output
 }
 
-func calcMetricGroupFunc(logPath, endpoint, serviceGroup string, dataList []map[string]interface{}, metricConfigList []*logMetricNeObj, valueCountMap map[string]*logMetricDisplayObj) {
+func calcMetricGroupFunc(logPath, endpoint, serviceGroup string, dataList []map[string]interface{}, metricConfigList []*logMetricNeObj, valueCountMap map[string]*logMetricDisplayObj, sampleScale float64) {
 	nowTimeUnix := time.Now().Unix()
 	for _, metricConfig := range metricConfigList {
 		for _, tmpMapData := range dataList {
@@ -870,17 +1073,10 @@ func calcMetricGroupFunc(logPath, endpoint, serviceGroup string, dataList []map[
 			if metricValueFloat, b := metricValueMap[metricConfig.LogParamName]; b {
 				tmpMetricKey := fmt.Sprintf("%s^%s^%s^%s", logPath, metricConfig.Metric, metricConfig.AggType, tmpTagString)
 				if valueExistObj, keyExist := valueCountMap[tmpMetricKey]; keyExist {
-					valueExistObj.ValueObj.Sum += metricValueFloat
-					valueExistObj.ValueObj.Count++
-					if valueExistObj.ValueObj.Max < metricValueFloat {
-						valueExistObj.ValueObj.Max = metricValueFloat
-					}
-					if valueExistObj.ValueObj.Min > metricValueFloat {
-						valueExistObj.ValueObj.Min = metricValueFloat
-					}
+					valueExistObj.ValueObj.add(metricConfig.AggType, metricValueFloat, sampleScale)
 					valueExistObj.LastActiveTime = nowTimeUnix
 				} else {
-					valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricConfig.Metric, Path: logPath, Agg: metricConfig.AggType, TEndpoint: endpoint, ServiceGroup: serviceGroup, TagsString: tmpTagString, Step: metricConfig.Step, ValueObj: logMetricValueObj{Sum: metricValueFloat, Max: metricValueFloat, Min: metricValueFloat, Count: 1}, Code: tmpCode, RetCode: tmpRetCode, LastActiveTime: nowTimeUnix}
+					valueCountMap[tmpMetricKey] = &logMetricDisplayObj{Id: tmpMetricKey, Metric: metricConfig.Metric, Path: logPath, Agg: metricConfig.AggType, TEndpoint: endpoint, ServiceGroup: serviceGroup, TagsString: tmpTagString, Step: metricConfig.Step, Buckets: metricConfig.Buckets, ValueObj: newLogMetricValueObj(metricConfig.AggType, metricValueFloat, sampleScale), Code: tmpCode, RetCode: tmpRetCode, LastActiveTime: nowTimeUnix}
 				}
 			}
 		}
@@ -893,7 +1089,8 @@ func buildLogMetricDisplayMetrics(valueCountMap, existMetricMap map[string]*logM
 	for k, v := range valueCountMap {
 		lastTimestamp := nowTime
 		firstDisplay := true
-		if existObj, b := existMetricMap[k]; b {
+		existObj, b := existMetricMap[k]
+		if b {
 			firstDisplay = false
 			if !existObj.Display {
 				// keep append old data
@@ -905,8 +1102,17 @@ func buildLogMetricDisplayMetrics(valueCountMap, existMetricMap map[string]*logM
 				if v.ValueObj.Min > existObj.ValueObj.Min {
 					v.ValueObj.Min = existObj.ValueObj.Min
 				}
+				if existObj != v {
+					for _, sample := range existObj.ValueObj.Samples {
+						v.ValueObj.addSample(sample)
+					}
+				}
 				lastTimestamp = existObj.UpdateTime
 			}
+			if existObj != v && v.Cumulative == nil {
+				v.Cumulative = existObj.Cumulative
+				v.Quantiles = existObj.Quantiles
+			}
 		}
 		// check display or not
 		if v.Step < 20 || firstDisplay {
@@ -933,6 +1139,10 @@ func buildLogMetricDisplayMetrics(valueCountMap, existMetricMap map[string]*logM
 				v.Value = v.ValueObj.Min
 			case "avg":
 				avgFlag = true
+			case "p50", "p90", "p95", "p99":
+				v.Value = calcLogMetricQuantile(v.ValueObj.Samples, logMetricQuantileMap[v.Agg])
+			case "histogram", "summary":
+				v.updateLogMetricCumulative(existObj)
 			}
 		} else {
 			v.UpdateTime = lastTimestamp
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalcLogMetricQuantile(t *testing.T) {
+	samples := []float64{9, 1, 8, 2, 7, 3, 6, 4, 5, 10}
+	testCases := []struct {
+		quantile float64
+		want     float64
+	}{
+		{0, 1},
+		{0.5, 5},
+		{0.9, 9},
+		{0.95, 10},
+		{0.99, 10},
+		{1, 10},
+	}
+	for _, tc := range testCases {
+		if got := calcLogMetricQuantile(samples, tc.quantile); got != tc.want {
+			t.Errorf("quantile %v: want %v, got %v", tc.quantile, tc.want, got)
+		}
+	}
+	if got := calcLogMetricQuantile(nil, 0.5); got != 0 {
+		t.Errorf("empty samples: want 0, got %v", got)
+	}
+	if samples[0] != 9 {
+		t.Errorf("input samples should not be sorted in place")
+	}
+}
+
+func TestLogMetricValueObjReservoir(t *testing.T) {
+	valueObj := newLogMetricValueObj("p99", 0, 1)
+	total := logMetricSampleLimit * 3
+	for i := 1; i < total; i++ {
+		valueObj.add("p99", float64(i), 1)
+	}
+	if len(valueObj.Samples) != logMetricSampleLimit {
+		t.Fatalf("want %d samples, got %d", logMetricSampleLimit, len(valueObj.Samples))
+	}
+	if valueObj.Count != float64(total) {
+		t.Fatalf("want count %d, got %v", total, valueObj.Count)
+	}
+	replaced := 0
+	for _, sample := range valueObj.Samples {
+		if sample < 0 || sample >= float64(total) {
+			t.Fatalf("sample %v out of input range", sample)
+		}
+		if sample >= float64(logMetricSampleLimit) {
+			replaced++
+		}
+	}
+	// 后面2/3的数据应该替换掉大约2/3的样本
+	if replaced < logMetricSampleLimit/2 || replaced > logMetricSampleLimit*5/6 {
+		t.Errorf("reservoir not uniform, replaced %d of %d samples", replaced, logMetricSampleLimit)
+	}
+	avgObj := newLogMetricValueObj("avg", 1, 1)
+	avgObj.add("avg", 2, 1)
+	if len(avgObj.Samples) != 0 {
+		t.Errorf("avg agg should not keep samples")
+	}
+}
+
+func TestUpdateLogMetricCumulative(t *testing.T) {
+	displayObj := &logMetricDisplayObj{Agg: "histogram", Buckets: []float64{1, 5, 10}}
+	displayObj.ValueObj = newLogMetricValueObj("histogram", 0.5, 1)
+	for _, v := range []float64{3, 7, 20} {
+		displayObj.ValueObj.add("histogram", v, 1)
+	}
+	displayObj.updateLogMetricCumulative(nil)
+	wantBuckets := []float64{1, 2, 3}
+	for i, want := range wantBuckets {
+		if displayObj.Cumulative.BucketCounts[i] != want {
+			t.Errorf("bucket %v: want %v, got %v", displayObj.Buckets[i], want, displayObj.Cumulative.BucketCounts[i])
+		}
+	}
+	if displayObj.Cumulative.Count != 4 || displayObj.Cumulative.Sum != 30.5 {
+		t.Errorf("want count 4 sum 30.5, got count %v sum %v", displayObj.Cumulative.Count, displayObj.Cumulative.Sum)
+	}
+
+	// 下个周期在上次的累计值上继续累加
+	nextObj := &logMetricDisplayObj{Agg: "histogram", Buckets: []float64{1, 5, 10}}
+	nextObj.ValueObj = newLogMetricValueObj("histogram", 2, 1)
+	nextObj.updateLogMetricCumulative(displayObj)
+	if nextObj.Cumulative.BucketCounts[0] != 1 || nextObj.Cumulative.BucketCounts[1] != 3 || nextObj.Cumulative.Count != 5 {
+		t.Errorf("cumulative not carried over, got %+v", nextObj.Cumulative)
+	}
+	if displayObj.Cumulative.Count != 4 {
+		t.Errorf("previous cumulative should not be modified")
+	}
+
+	// 抽样折算后的小数计数取整后各个桶不能超过count
+	sampledObj := &logMetricDisplayObj{Agg: "histogram", Buckets: []float64{10}}
+	sampledObj.ValueObj = newLogMetricValueObj("histogram", 1, 1.5)
+	sampledObj.ValueObj.add("histogram", 100, 1.5)
+	sampledObj.ValueObj.add("histogram", 2, 1.5)
+	for i := 0; i < 3; i++ {
+		sampledObj.updateLogMetricCumulative(sampledObj)
+		count := math.Round(sampledObj.Cumulative.Count)
+		if bucket := math.Round(sampledObj.Cumulative.BucketCounts[0]); bucket > count {
+			t.Fatalf("bucket count %v greater than count %v", bucket, count)
+		}
+	}
+
+	summaryObj := &logMetricDisplayObj{Agg: "summary"}
+	summaryObj.ValueObj = newLogMetricValueObj("summary", 1, 1)
+	for i := 2; i <= 100; i++ {
+		summaryObj.ValueObj.add("summary", float64(i), 1)
+	}
+	summaryObj.updateLogMetricCumulative(nil)
+	if summaryObj.Quantiles[0.5] != 50 || summaryObj.Quantiles[0.99] != 99 {
+		t.Errorf("unexpected summary quantiles %v", summaryObj.Quantiles)
+	}
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	logMultilineDefaultMaxLines = 500
+	logMultilineDefaultTimeout  = 3
+)
+
+// logMultilineConfig 多行日志合并配置,start和continue都为空时按单行处理
+type logMultilineConfig struct {
+	MultilineStart    string `json:"multiline_start"`
+	MultilineContinue string `json:"multiline_continue"`
+	MultilineMaxLines int    `json:"multiline_max_lines"`
+	MultilineTimeout  int    `json:"multiline_timeout"`
+}
+
+// logMultilineAssembler 把tail出来的行合并成一个事件(如java异常栈、格式化的json)再交给关键字匹配和指标解析,
+// 只在tail的goroutine里使用,不需要加锁
+type logMultilineAssembler struct {
+	config         logMultilineConfig
+	startRegexp    *Regexp
+	continueRegexp *Regexp
+	maxLines       int
+	timeout        time.Duration
+	lines          []string
+	lastInode      uint64
+	lastOffset     int64
+	lastLineTime   time.Time
+	output         chan logTailEvent
+	throttle       *logThrottleLimiter
+}
+
+func newLogMultilineAssembler(path string, config logMultilineConfig, output chan logTailEvent, throttle *logThrottleLimiter) *logMultilineAssembler {
+	assembler := logMultilineAssembler{config: config, output: output, throttle: throttle, maxLines: config.MultilineMaxLines, timeout: time.Duration(config.MultilineTimeout) * time.Second}
+	if assembler.maxLines <= 0 {
+		assembler.maxLines = logMultilineDefaultMaxLines
+	}
+	if assembler.timeout <= 0 {
+		assembler.timeout = logMultilineDefaultTimeout * time.Second
+	}
+	if config.MultilineStart != "" {
+		if tmpExp, tmpErr := PcreCompile(config.MultilineStart, 0); tmpErr != nil {
+			level.Error(monitorLogger).Log("newLogMultilineAssembler", fmt.Sprintf("path:%s start regexp:%s error:%s", path, config.MultilineStart, tmpErr.Message))
+		} else {
+			assembler.startRegexp = &tmpExp
+		}
+	}
+	if config.MultilineContinue != "" {
+		if tmpExp, tmpErr := PcreCompile(config.MultilineContinue, 0); tmpErr != nil {
+			level.Error(monitorLogger).Log("newLogMultilineAssembler", fmt.Sprintf("path:%s continue regexp:%s error:%s", path, config.MultilineContinue, tmpErr.Message))
+		} else {
+			assembler.continueRegexp = &tmpExp
+		}
+	}
+	return &assembler
+}
+
+// push 有首行正则时,匹配首行的开始新事件,其它行追加;只有续行正则时,匹配续行的追加,其它行开始新事件,
+// inode和offset是这一行结束时在文件里的位置
+func (a *logMultilineAssembler) push(line string, inode uint64, offset int64) {
+	if a.startRegexp == nil && a.continueRegexp == nil {
+		a.emit(logTailEvent{Text: line, Inode: inode, Offset: offset})
+		return
+	}
+	var newEventFlag bool
+	if a.startRegexp != nil {
+		newEventFlag = pcreMatch(a.startRegexp, line)
+	}
+	if !newEventFlag && a.continueRegexp != nil {
+		newEventFlag = !pcreMatch(a.continueRegexp, line)
+	}
+	if newEventFlag || len(a.lines) == 0 {
+		a.flush()
+	}
+	a.lines = append(a.lines, line)
+	a.lastInode, a.lastOffset = inode, offset
+	a.lastLineTime = time.Now()
+	if len(a.lines) >= a.maxLines {
+		a.flush()
+	}
+}
+
+func (a *logMultilineAssembler) flush() {
+	if len(a.lines) == 0 {
+		return
+	}
+	a.emit(logTailEvent{Text: strings.Join(a.lines, "\n"), Inode: a.lastInode, Offset: a.lastOffset})
+	a.lines = []string{}
+}
+
+// emit 有限流器时由限流器决定是否输出,没有时保持阻塞写入
+func (a *logMultilineAssembler) emit(event logTailEvent) {
+	if a.throttle == nil {
+		a.output <- event
+		return
+	}
+	a.throttle.send(a.output, event)
+}
+
+// checkTimeout 超时没有新行时输出当前事件,避免最后一个事件一直等不到下一个首行
+func (a *logMultilineAssembler) checkTimeout() {
+	if len(a.lines) > 0 && time.Since(a.lastLineTime) >= a.timeout {
+		a.flush()
+	}
+}
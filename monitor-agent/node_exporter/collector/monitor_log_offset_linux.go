@@ -0,0 +1,297 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/hpcloud/tail"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	logOffsetCollectorName    = "log_offset"
+	logOffsetCheckpointPath   = "data/log_offset_checkpoint.json"
+	logOffsetCheckpointPeriod = 10
+)
+
+var (
+	logOffsetMaxCatchUpBytes = kingpin.Flag("collector.log_offset.max-catch-up", "Max bytes to re-read from the checkpoint when a log tailer restarts, skip to the end of file when exceeded.").Default("104857600").Int64()
+	logOffsetTrackerMap      = make(map[string]*logOffsetTracker)
+	logOffsetLock            = new(sync.RWMutex)
+	logOffsetLoadOnce        = new(sync.Once)
+	logOffsetLoadTime        int64
+)
+
+// logOffsetTracker 记录每个tail的文件inode和读取位置,重启或重新打开文件时从这里继续读,key为 类型^文件路径(业务日志指标再加服务组)
+type logOffsetTracker struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Path        string `json:"path"`
+	Inode       uint64 `json:"inode"`
+	Offset      int64  `json:"offset"`
+	FileSize    int64  `json:"-"`
+	FileModTime int64  `json:"-"`
+	UpdateTime  int64  `json:"update_time"`
+}
+
+type logOffsetCollector struct {
+	bytesBehind *prometheus.Desc
+	lagSeconds  *prometheus.Desc
+	offset      *prometheus.Desc
+	logger      log.Logger
+}
+
+func init() {
+	registerCollector(logOffsetCollectorName, defaultEnabled, NewLogOffsetCollector)
+}
+
+func NewLogOffsetCollector(logger log.Logger) (Collector, error) {
+	return &logOffsetCollector{
+		bytesBehind: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logOffsetCollectorName, "bytes_behind"),
+			"Bytes of the log file not read yet by the tailer.",
+			[]string{"type", "file"}, nil,
+		),
+		lagSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logOffsetCollectorName, "lag_seconds"),
+			"Seconds since the log file was last modified while the tailer is still behind.",
+			[]string{"type", "file"}, nil,
+		),
+		offset: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logOffsetCollectorName, "position_bytes"),
+			"Current read offset of the log tailer.",
+			[]string{"type", "file"}, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+func (c *logOffsetCollector) Update(ch chan<- prometheus.Metric) error {
+	nowTime := time.Now().Unix()
+	logOffsetLock.RLock()
+	for _, v := range logOffsetTrackerMap {
+		if v.UpdateTime == 0 {
+			continue
+		}
+		var bytesBehind, lagSeconds float64
+		if v.FileSize > v.Offset {
+			bytesBehind = float64(v.FileSize - v.Offset)
+			lagSeconds = float64(nowTime - v.FileModTime)
+		}
+		ch <- prometheus.MustNewConstMetric(c.bytesBehind, prometheus.GaugeValue, bytesBehind, v.Type, v.Path)
+		ch <- prometheus.MustNewConstMetric(c.lagSeconds, prometheus.GaugeValue, lagSeconds, v.Type, v.Path)
+		ch <- prometheus.MustNewConstMetric(c.offset, prometheus.GaugeValue, float64(v.Offset), v.Type, v.Path)
+	}
+	logOffsetLock.RUnlock()
+	return nil
+}
+
+func getLogFileInode(fileInfo os.FileInfo) uint64 {
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino
+	}
+	return 0
+}
+
+func loadLogOffsetCheckpoint() {
+	b, err := ioutil.ReadFile(logOffsetCheckpointPath)
+	if err != nil {
+		level.Warn(monitorLogger).Log("loadLogOffsetCheckpoint", err.Error())
+		return
+	}
+	var trackerList []*logOffsetTracker
+	if err = json.Unmarshal(b, &trackerList); err != nil {
+		level.Error(monitorLogger).Log("loadLogOffsetCheckpoint", err.Error())
+		return
+	}
+	logOffsetLock.Lock()
+	logOffsetLoadTime = time.Now().Unix()
+	for _, v := range trackerList {
+		// 只在还没开始tail前恢复,UpdateTime清零表示还没有重新读取过
+		v.UpdateTime = 0
+		logOffsetTrackerMap[v.Id] = v
+	}
+	logOffsetLock.Unlock()
+}
+
+// getLogTailSeekInfo 有检查点且inode没变时从检查点继续读;文件被截断从头读;inode变了说明文件已轮转,新文件从头读;
+// 需要补读的数据超过上限时跳到文件末尾
+func getLogTailSeekInfo(monitorType, id, path string) *tail.SeekInfo {
+	logOffsetLoadOnce.Do(loadLogOffsetCheckpoint)
+	endSeek := &tail.SeekInfo{Offset: 0, Whence: 2}
+	fileInfo, err := os.Stat(path)
+	logOffsetLock.Lock()
+	defer logOffsetLock.Unlock()
+	tracker, b := logOffsetTrackerMap[id]
+	if !b {
+		tracker = &logOffsetTracker{Id: id, Type: monitorType, Path: path}
+		logOffsetTrackerMap[id] = tracker
+	}
+	if err != nil || !b {
+		return endSeek
+	}
+	inode := getLogFileInode(fileInfo)
+	var offset int64
+	if inode == tracker.Inode {
+		if fileInfo.Size() < tracker.Offset {
+			level.Info(monitorLogger).Log("getLogTailSeekInfo", fmt.Sprintf("path:%s truncated,offset:%d size:%d,read from beginning", path, tracker.Offset, fileInfo.Size()))
+		} else {
+			offset = tracker.Offset
+		}
+	} else {
+		level.Info(monitorLogger).Log("getLogTailSeekInfo", fmt.Sprintf("path:%s rotated,inode:%d -> %d,read from beginning", path, tracker.Inode, inode))
+	}
+	if fileInfo.Size()-offset > *logOffsetMaxCatchUpBytes {
+		level.Warn(monitorLogger).Log("getLogTailSeekInfo", fmt.Sprintf("path:%s behind %d bytes more than max catch up %d,skip to end", path, fileInfo.Size()-offset, *logOffsetMaxCatchUpBytes))
+		return endSeek
+	}
+	tracker.Inode = inode
+	tracker.Offset = offset
+	return &tail.SeekInfo{Offset: offset, Whence: 0}
+}
+
+// logTailEvent 交给处理线程的一个事件(单行或合并后的多行),带上事件最后一行结束时在文件里的位置
+type logTailEvent struct {
+	Text   string
+	Inode  uint64
+	Offset int64
+	// 限流器发出这行时的秒,处理耗时算到这一秒
+	ReadTime int64
+}
+
+// logTailPosition 按tail输出的行推算读到的位置,只在tail的goroutine里使用,不需要加锁
+type logTailPosition struct {
+	inode    uint64
+	offset   int64
+	fileSize int64
+}
+
+func newLogTailPosition(path string, seekInfo *tail.SeekInfo) *logTailPosition {
+	position := logTailPosition{offset: seekInfo.Offset}
+	if fileInfo, err := os.Stat(path); err == nil {
+		position.inode = getLogFileInode(fileInfo)
+		position.fileSize = fileInfo.Size()
+		if seekInfo.Whence == 2 {
+			position.offset = fileInfo.Size()
+		}
+	}
+	return &position
+}
+
+// addLine tail会去掉行尾的换行符,每行按长度加1累计
+func (p *logTailPosition) addLine(text string) (inode uint64, offset int64) {
+	p.offset += int64(len(text)) + 1
+	return p.inode, p.offset
+}
+
+// checkFile 文件轮转后tail读完旧文件才打开新文件,超过旧文件大小的部分算新文件的;文件被截断后tail从头读
+func (p *logTailPosition) checkFile(path string) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	inode := getLogFileInode(fileInfo)
+	if inode != p.inode {
+		if p.offset < p.fileSize {
+			return
+		}
+		p.offset -= p.fileSize
+		p.inode = inode
+	} else if fileInfo.Size() < p.offset {
+		p.offset = 0
+	}
+	p.fileSize = fileInfo.Size()
+}
+
+// logTailProcessed 处理线程处理完事件后记录的位置,检查点只保存已经处理过的位置,重启后从这里继续读
+type logTailProcessed struct {
+	lock   *sync.Mutex
+	inode  uint64
+	offset int64
+}
+
+func newLogTailProcessed(position *logTailPosition) *logTailProcessed {
+	return &logTailProcessed{lock: new(sync.Mutex), inode: position.inode, offset: position.offset}
+}
+
+func (p *logTailProcessed) done(event logTailEvent) {
+	p.lock.Lock()
+	p.inode = event.Inode
+	p.offset = event.Offset
+	p.lock.Unlock()
+}
+
+func (p *logTailProcessed) get() (inode uint64, offset int64) {
+	p.lock.Lock()
+	inode, offset = p.inode, p.offset
+	p.lock.Unlock()
+	return
+}
+
+// updateLogTailOffset 记录处理线程已经处理到的位置,文件已经轮转时大小和修改时间等切到新文件再更新
+func updateLogTailOffset(id, path string, processed *logTailProcessed) {
+	if processed == nil {
+		return
+	}
+	inode, offset := processed.get()
+	fileInfo, statErr := os.Stat(path)
+	logOffsetLock.Lock()
+	if tracker, b := logOffsetTrackerMap[id]; b {
+		tracker.Inode = inode
+		tracker.Offset = offset
+		tracker.UpdateTime = time.Now().Unix()
+		if statErr == nil && getLogFileInode(fileInfo) == inode {
+			tracker.FileSize = fileInfo.Size()
+			tracker.FileModTime = fileInfo.ModTime().Unix()
+		}
+	}
+	logOffsetLock.Unlock()
+}
+
+// removeLogOffsetTracker 采集配置删除时不再保留检查点
+func removeLogOffsetTracker(id string) {
+	logOffsetLock.Lock()
+	delete(logOffsetTrackerMap, id)
+	logOffsetLock.Unlock()
+}
+
+func saveLogOffsetCheckpoint() {
+	logOffsetLock.Lock()
+	trackerList := []*logOffsetTracker{}
+	for k, v := range logOffsetTrackerMap {
+		// 重启前的检查点过了一小时还没有对应的tail,说明配置已经删除
+		if v.UpdateTime == 0 && logOffsetLoadTime > 0 && time.Now().Unix()-logOffsetLoadTime > 3600 {
+			delete(logOffsetTrackerMap, k)
+			continue
+		}
+		trackerList = append(trackerList, v)
+	}
+	b, _ := json.Marshal(trackerList)
+	logOffsetLock.Unlock()
+	// 先写临时文件再改名,避免写到一半进程退出导致检查点文件损坏
+	tmpPath := logOffsetCheckpointPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+		level.Error(monitorLogger).Log("saveLogOffsetCheckpoint", err.Error())
+		return
+	}
+	if err := os.Rename(tmpPath, logOffsetCheckpointPath); err != nil {
+		level.Error(monitorLogger).Log("saveLogOffsetCheckpoint", err.Error())
+	}
+}
+
+func StartLogOffsetCheckpointCron() {
+	logOffsetLoadOnce.Do(loadLogOffsetCheckpoint)
+	t := time.NewTicker(logOffsetCheckpointPeriod * time.Second).C
+	for {
+		<-t
+		saveLogOffsetCheckpoint()
+	}
+}
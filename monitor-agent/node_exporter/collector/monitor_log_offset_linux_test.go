@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcloud/tail"
+)
+
+func TestLogTailPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_offset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err = ioutil.WriteFile(path, []byte("line1\nline2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	position := newLogTailPosition(path, &tail.SeekInfo{Offset: 0, Whence: 2})
+	if position.offset != 12 {
+		t.Fatalf("seek to end: want offset 12, got %d", position.offset)
+	}
+	position = newLogTailPosition(path, &tail.SeekInfo{Offset: 0, Whence: 0})
+	oldInode := position.inode
+	position.addLine("line1")
+	if _, offset := position.addLine("line2"); offset != 12 {
+		t.Fatalf("want offset 12, got %d", offset)
+	}
+
+	// 轮转后旧文件读完,多出来的部分算新文件的
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("new1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	position.addLine("new1")
+	position.checkFile(path)
+	if position.inode == oldInode || position.offset != 5 {
+		t.Fatalf("after rotate: want new inode offset 5, got inode changed %v offset %d", position.inode != oldInode, position.offset)
+	}
+
+	// 截断后从头读
+	if err = ioutil.WriteFile(path, []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	position.checkFile(path)
+	if position.offset != 0 {
+		t.Fatalf("after truncate: want offset 0, got %d", position.offset)
+	}
+}
+
+func TestLogMultilineAssemblerOffset(t *testing.T) {
+	output := make(chan logTailEvent, 10)
+	singleAssembler := newLogMultilineAssembler("test", logMultilineConfig{}, output, nil)
+	singleAssembler.push("single", 2, 7)
+	if event := <-output; event.Inode != 2 || event.Offset != 7 {
+		t.Fatalf("unexpected single line event %+v", event)
+	}
+	if _, compileErr := PcreCompile(`^\d{4}-`, 0); compileErr != nil {
+		t.Skipf("pcre not available: %s", compileErr.Message)
+	}
+	assembler := newLogMultilineAssembler("test", logMultilineConfig{MultilineStart: `^\d{4}-`}, output, nil)
+	assembler.push("2024-01-01 error", 1, 17)
+	assembler.push("  at a.b.c", 1, 28)
+	assembler.push("2024-01-01 next", 1, 44)
+	event := <-output
+	if event.Text != "2024-01-01 error\n  at a.b.c" || event.Offset != 28 {
+		t.Fatalf("unexpected first event %+v", event)
+	}
+	select {
+	case event = <-output:
+		t.Fatalf("event still buffered should not be emitted, got %+v", event)
+	default:
+	}
+	assembler.flush()
+	if event = <-output; event.Offset != 44 {
+		t.Fatalf("want flushed offset 44, got %d", event.Offset)
+	}
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	logMetricLogfmtType   = "logfmt"
+	logMetricCsvType      = "csv"
+	logMetricCombinedType = "combined"
+)
+
+// combined访问日志: $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" 后面可以跟自定义字段
+var logCombinedRegexp = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]*)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\S+)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?(.*)$`)
+
+func isLogStructuredType(logType string) bool {
+	return logType == logMetricLogfmtType || logType == logMetricCsvType || logType == logMetricCombinedType
+}
+
+// parseLogStructuredLine 按日志格式解析出字段,和服务端试算的解析逻辑保持一致
+func parseLogStructuredLine(logType, delimiter, line string) (fields map[string]string, err error) {
+	line = strings.TrimSpace(line)
+	switch logType {
+	case logMetricLogfmtType:
+		fields = parseLogfmtLine(line)
+	case logMetricCsvType:
+		fields, err = parseLogCsvLine(delimiter, line)
+	case logMetricCombinedType:
+		fields, err = parseLogCombinedLine(line)
+	default:
+		err = fmt.Errorf("log type:%s is not structured", logType)
+	}
+	if err == nil && len(fields) == 0 {
+		err = fmt.Errorf("can not parse any field with log type:%s", logType)
+	}
+	return
+}
+
+// parseLogfmtLine key=value 以空格分隔,值可以用双引号包起来,没有=的key值为空
+func parseLogfmtLine(line string) (fields map[string]string) {
+	fields = make(map[string]string)
+	for i := 0; i < len(line); {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		keyStart := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[keyStart:i]
+		if i >= len(line) || line[i] == ' ' {
+			if key != "" {
+				fields[key] = ""
+			}
+			continue
+		}
+		// 跳过=
+		i++
+		var value string
+		if i < len(line) && line[i] == '"' {
+			var valueBuilder strings.Builder
+			i++
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				valueBuilder.WriteByte(line[i])
+				i++
+			}
+			// 跳过结尾的引号
+			i++
+			value = valueBuilder.String()
+		} else {
+			valueStart := i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			value = line[valueStart:i]
+		}
+		if key != "" {
+			fields[key] = value
+		}
+	}
+	return
+}
+
+// parseLogCsvLine 按分隔符切分,字段名为从1开始的列号,分隔符默认逗号
+func parseLogCsvLine(delimiter, line string) (fields map[string]string, err error) {
+	fields = make(map[string]string)
+	comma, err := getLogCsvDelimiter(delimiter)
+	if err != nil {
+		return
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	record, readErr := reader.Read()
+	if readErr != nil {
+		err = fmt.Errorf("parse csv line fail,%s ", readErr.Error())
+		return
+	}
+	for i, v := range record {
+		fields[strconv.Itoa(i+1)] = v
+	}
+	return
+}
+
+func getLogCsvDelimiter(delimiter string) (comma rune, err error) {
+	if delimiter == "" {
+		return ',', nil
+	}
+	if delimiter == "\\t" {
+		return '\t', nil
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		err = fmt.Errorf("csv delimiter:%s must be a single character", delimiter)
+		return
+	}
+	comma, _ = utf8.DecodeRuneInString(delimiter)
+	if comma == '"' || comma == '\r' || comma == '\n' || comma == utf8.RuneError {
+		err = fmt.Errorf("csv delimiter:%s illegal", delimiter)
+	}
+	return
+}
+
+// parseLogCombinedLine request再拆成method、uri、protocol,combined之后追加的字段(如request_time)按顺序命名为extra_1、extra_2...
+func parseLogCombinedLine(line string) (fields map[string]string, err error) {
+	matchList := logCombinedRegexp.FindStringSubmatch(line)
+	if len(matchList) == 0 {
+		err = fmt.Errorf("line not match combined log format")
+		return
+	}
+	fields = map[string]string{"remote_addr": matchList[1], "remote_user": matchList[2], "time_local": matchList[3], "request": matchList[4],
+		"status": matchList[5], "body_bytes_sent": matchList[6], "http_referer": matchList[7], "http_user_agent": matchList[8]}
+	if requestSplit := strings.Split(matchList[4], " "); len(requestSplit) == 3 {
+		fields["method"] = requestSplit[0]
+		fields["uri"] = requestSplit[1]
+		fields["protocol"] = requestSplit[2]
+	}
+	for i, v := range strings.Fields(matchList[9]) {
+		fields[fmt.Sprintf("extra_%d", i+1)] = strings.Trim(v, "\"")
+	}
+	return
+}
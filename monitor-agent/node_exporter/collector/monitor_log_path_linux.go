@@ -0,0 +1,241 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const logPathExpandInterval = 30
+
+var (
+	// 原始的关键字配置,日志路径是通配符或带日期占位符时,定时按它重新展开出具体文件
+	logKeywordRawConfig     []byte
+	logKeywordRawConfigLock = new(sync.Mutex)
+	// 原始的业务日志指标配置,由logMetricHttpLock保护
+	logMetricRawConfig             []byte
+	logPathDatePlaceholderReplacer = []string{"{yyyy}", "2006", "{MM}", "01", "{dd}", "02", "{HH}", "15"}
+)
+
+type logPathMatchObj struct {
+	Type  string   `json:"type"`
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+}
+
+type logPathMatchHttpResult struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Data    []*logPathMatchObj `json:"data"`
+}
+
+// isLogPathPattern 路径里有通配符或日期占位符时需要展开
+func isLogPathPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[") || strings.Contains(path, "{")
+}
+
+// expandLogPath 把日期占位符({yyyy}{MM}{dd}{HH})替换成当前时间后再按通配符匹配,只保留普通文件;不是模式的路径原样返回
+func expandLogPath(path string) (fileList []string) {
+	if !isLogPathPattern(path) {
+		return []string{path}
+	}
+	nowTime := time.Now()
+	replaceList := []string{}
+	for i := 0; i+1 < len(logPathDatePlaceholderReplacer); i += 2 {
+		replaceList = append(replaceList, logPathDatePlaceholderReplacer[i], nowTime.Format(logPathDatePlaceholderReplacer[i+1]))
+	}
+	realPath := strings.NewReplacer(replaceList...).Replace(path)
+	if !strings.ContainsAny(realPath, "*?[") {
+		return []string{realPath}
+	}
+	matchList, err := filepath.Glob(realPath)
+	if err != nil {
+		level.Error(monitorLogger).Log("expandLogPath", fmt.Sprintf("path:%s glob error:%s", path, err.Error()))
+		return
+	}
+	for _, v := range matchList {
+		if fileInfo, statErr := os.Stat(v); statErr == nil && fileInfo.Mode().IsRegular() {
+			fileList = append(fileList, v)
+		}
+	}
+	sort.Strings(fileList)
+	return
+}
+
+// expandLogKeywordParam 每个匹配到的文件生成一份配置,PathPattern记录配置里的原始路径
+func expandLogKeywordParam(param []*logKeywordHttpDto) (output []*logKeywordHttpDto) {
+	existMap := make(map[string]bool)
+	for _, inputParam := range param {
+		if !isLogPathPattern(inputParam.Path) {
+			if !existMap[inputParam.Path] {
+				existMap[inputParam.Path] = true
+				output = append(output, inputParam)
+			}
+			continue
+		}
+		for _, file := range expandLogPath(inputParam.Path) {
+			// 多个模式匹配到同一个文件时只由第一个采集,避免重复tail
+			if existMap[file] {
+				continue
+			}
+			existMap[file] = true
+			tmpParam := *inputParam
+			tmpParam.Path = file
+			tmpParam.PathPattern = inputParam.Path
+			output = append(output, &tmpParam)
+		}
+	}
+	return
+}
+
+// expandLogMetricParam 业务日志指标配置按文件展开,每个文件需要独立的配置对象(里面有各自的channel和正则),所以重新解析一份
+func expandLogMetricParam(requestParamBuff []byte) (output []*logMetricMonitorNeObj, err error) {
+	var param []*logMetricMonitorNeObj
+	if err = json.Unmarshal(requestParamBuff, &param); err != nil {
+		return
+	}
+	for i, paramObj := range param {
+		if !isLogPathPattern(paramObj.Path) {
+			output = append(output, paramObj)
+			continue
+		}
+		for _, file := range expandLogPath(paramObj.Path) {
+			var copyParam []*logMetricMonitorNeObj
+			if err = json.Unmarshal(requestParamBuff, &copyParam); err != nil {
+				return
+			}
+			tmpParamObj := copyParam[i]
+			tmpParamObj.PathPattern = paramObj.Path
+			tmpParamObj.Path = file
+			output = append(output, tmpParamObj)
+		}
+	}
+	return
+}
+
+func getLogKeywordPathKeyList(param []*logKeywordHttpDto) (keyList []string) {
+	for _, v := range param {
+		keyList = append(keyList, v.PathPattern+"^"+v.Path)
+	}
+	sort.Strings(keyList)
+	return
+}
+
+func getLogMetricPathKeyList(param []*logMetricMonitorNeObj) (keyList []string) {
+	for _, v := range param {
+		keyList = append(keyList, v.PathPattern+"^"+v.Path+"^"+v.ServiceGroup)
+	}
+	sort.Strings(keyList)
+	return
+}
+
+// StartLogPathExpandCron 定时重新展开日志路径,匹配到的文件有变化(新文件、日志按日期切换、文件被删除)时启停对应的tail
+func StartLogPathExpandCron() {
+	t := time.NewTicker(logPathExpandInterval * time.Second).C
+	for {
+		<-t
+		checkLogKeywordPathExpand()
+		checkLogMetricPathExpand()
+	}
+}
+
+func checkLogKeywordPathExpand() {
+	logKeywordRawConfigLock.Lock()
+	defer logKeywordRawConfigLock.Unlock()
+	if len(logKeywordRawConfig) == 0 {
+		return
+	}
+	var param []*logKeywordHttpDto
+	if err := json.Unmarshal(logKeywordRawConfig, &param); err != nil {
+		return
+	}
+	var existKeyList []string
+	for _, v := range logKeywordCollectorJobs {
+		existKeyList = append(existKeyList, v.PathPattern+"^"+v.Path)
+	}
+	sort.Strings(existKeyList)
+	if strings.Join(existKeyList, ",") == strings.Join(getLogKeywordPathKeyList(expandLogKeywordParam(param)), ",") {
+		return
+	}
+	level.Info(monitorLogger).Log("checkLogKeywordPathExpand", "matched files changed,reload log keyword config")
+	if err := applyLogKeywordParam(param); err != nil {
+		level.Error(monitorLogger).Log("checkLogKeywordPathExpand", err.Error())
+	}
+}
+
+func checkLogMetricPathExpand() {
+	logMetricHttpLock.Lock()
+	defer logMetricHttpLock.Unlock()
+	if len(logMetricRawConfig) == 0 {
+		return
+	}
+	param, err := expandLogMetricParam(logMetricRawConfig)
+	if err != nil {
+		return
+	}
+	if strings.Join(getLogMetricPathKeyList(logMetricMonitorJobs), ",") == strings.Join(getLogMetricPathKeyList(param), ",") {
+		return
+	}
+	level.Info(monitorLogger).Log("checkLogMetricPathExpand", "matched files changed,reload log metric config")
+	if err = LogMetricMonitorHandleAction(logMetricRawConfig); err != nil {
+		level.Error(monitorLogger).Log("checkLogMetricPathExpand", err.Error())
+	}
+}
+
+// LogPathMatchHttpHandle 返回配置的日志路径当前匹配到的文件,给服务端展示
+func LogPathMatchHttpHandle(w http.ResponseWriter, r *http.Request) {
+	result := logPathMatchHttpResult{Status: "OK", Message: "success", Data: []*logPathMatchObj{}}
+	matchMap := make(map[string]*logPathMatchObj)
+	appendMatchFile := func(monitorType, pattern, file string) {
+		if pattern == "" {
+			pattern = file
+		}
+		key := monitorType + "^" + pattern
+		if _, b := matchMap[key]; !b {
+			matchMap[key] = &logPathMatchObj{Type: monitorType, Path: pattern, Files: []string{}}
+			result.Data = append(result.Data, matchMap[key])
+		}
+		if file == "" {
+			return
+		}
+		for _, v := range matchMap[key].Files {
+			if v == file {
+				return
+			}
+		}
+		matchMap[key].Files = append(matchMap[key].Files, file)
+	}
+	// 先按原始配置列出所有路径,没有匹配到文件的模式也要返回
+	logKeywordRawConfigLock.Lock()
+	var keywordParam []*logKeywordHttpDto
+	json.Unmarshal(logKeywordRawConfig, &keywordParam)
+	for _, v := range keywordParam {
+		appendMatchFile("keyword", v.Path, "")
+	}
+	for _, v := range logKeywordCollectorJobs {
+		appendMatchFile("keyword", v.PathPattern, v.Path)
+	}
+	logKeywordRawConfigLock.Unlock()
+	logMetricHttpLock.RLock()
+	var metricParam []*logMetricMonitorNeObj
+	json.Unmarshal(logMetricRawConfig, &metricParam)
+	for _, v := range metricParam {
+		appendMatchFile("metric", v.Path, "")
+	}
+	for _, v := range logMetricMonitorJobs {
+		appendMatchFile("metric", v.PathPattern, v.Path)
+	}
+	logMetricHttpLock.RUnlock()
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
@@ -0,0 +1,315 @@
+package collector
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+const (
+	logThrottleCollectorName = "log_throttle"
+	logThrottleKeepSeconds   = 60
+	logThrottleBusySeconds   = 3
+	logThrottleReasonSample  = "sample"
+	logThrottleReasonRate    = "rate_limit"
+	logThrottleReasonFull    = "channel_full"
+)
+
+var (
+	logThrottleMaxLines   = kingpin.Flag("collector.log_throttle.max-lines", "Max log lines (multiline events) per second handled by one log monitor, 0 means no limit.").Default("20000").Int64()
+	logThrottleCpuPercent = kingpin.Flag("collector.log_throttle.cpu-percent", "Max percent of one cpu core used to match lines by one log monitor, 0 means no limit.").Default("50").Int64()
+	logThrottleLimiterMap = make(map[string]*logThrottleLimiter)
+	logThrottleLock       = new(sync.RWMutex)
+)
+
+// logThrottleLimiter 每个日志监控(关键字按文件,业务指标按文件+服务组)一个,按秒统计行数和匹配耗时,
+// 超过预算时业务指标监控按固定间隔抽样,计算指标时按抽样比例折算count和sum;
+// 关键字监控漏一行就可能漏告警,不丢弃,超过预算时暂停读取到下一秒,通道满时阻塞tail等处理线程跟上
+type logThrottleLimiter struct {
+	Id            string  `json:"id"`
+	Type          string  `json:"type"`
+	Path          string  `json:"path"`
+	ServiceGroup  string  `json:"service_group"`
+	SampleMode    bool    `json:"sample_mode"`
+	LineBudget    int64   `json:"line_budget"`
+	SampleEvery   int64   `json:"sample_every"`
+	LastLines     int64   `json:"last_lines"`
+	LastBusyMs    int64   `json:"last_busy_ms"`
+	TotalLines    float64 `json:"total_lines"`
+	SampledLines  float64 `json:"sampled_lines"`
+	RateDropLines float64 `json:"rate_drop_lines"`
+	FullDropLines float64 `json:"full_drop_lines"`
+	ThrottleTime  int64   `json:"throttle_time"`
+	Throttled     bool    `json:"throttled"`
+	lock          *sync.Mutex
+	windowStart   int64
+	windowLines   int64
+	windowSent    int64
+	busyMap       map[int64]*logThrottleBusy
+	cycleLines    float64
+	cycleSent     float64
+}
+
+// logThrottleBusy 按行被读到的那一秒累计处理耗时,处理线程积压时耗时也不会算到后面的秒里
+type logThrottleBusy struct {
+	nanos int64
+	lines int64
+}
+
+type logThrottleCollector struct {
+	lines     *prometheus.Desc
+	dropped   *prometheus.Desc
+	sample    *prometheus.Desc
+	throttled *prometheus.Desc
+	logger    log.Logger
+}
+
+type logThrottleHttpResult struct {
+	Status  string                `json:"status"`
+	Message string                `json:"message"`
+	Data    []*logThrottleLimiter `json:"data"`
+}
+
+func init() {
+	registerCollector(logThrottleCollectorName, defaultEnabled, NewLogThrottleCollector)
+}
+
+func NewLogThrottleCollector(logger log.Logger) (Collector, error) {
+	labels := []string{"type", "file", "service_group"}
+	return &logThrottleCollector{
+		lines: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logThrottleCollectorName, "lines_total"),
+			"Log lines read by the log monitor before throttling.",
+			labels, nil,
+		),
+		dropped: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logThrottleCollectorName, "dropped_lines_total"),
+			"Log lines not handled by the log monitor, reason is sample, rate_limit or channel_full.",
+			append(labels, "reason"), nil,
+		),
+		sample: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logThrottleCollectorName, "sample_every"),
+			"Current sampling interval of the log monitor, 1 means no sampling.",
+			labels, nil,
+		),
+		throttled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logThrottleCollectorName, "throttled"),
+			"Whether the log monitor dropped lines in the last minute.",
+			labels, nil,
+		),
+		logger: logger,
+	}, nil
+}
+
+func (c *logThrottleCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, v := range listLogThrottleLimiter() {
+		ch <- prometheus.MustNewConstMetric(c.lines, prometheus.CounterValue, v.TotalLines, v.Type, v.Path, v.ServiceGroup)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, v.SampledLines, v.Type, v.Path, v.ServiceGroup, logThrottleReasonSample)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, v.RateDropLines, v.Type, v.Path, v.ServiceGroup, logThrottleReasonRate)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, v.FullDropLines, v.Type, v.Path, v.ServiceGroup, logThrottleReasonFull)
+		ch <- prometheus.MustNewConstMetric(c.sample, prometheus.GaugeValue, float64(v.SampleEvery), v.Type, v.Path, v.ServiceGroup)
+		var throttledValue float64
+		if v.Throttled {
+			throttledValue = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.throttled, prometheus.GaugeValue, throttledValue, v.Type, v.Path, v.ServiceGroup)
+	}
+	return nil
+}
+
+// getLogThrottleLimiter 按id复用,tail重新打开文件后累计的丢弃计数不会清零
+func getLogThrottleLimiter(id, monitorType, path, serviceGroup string, sampleMode bool) *logThrottleLimiter {
+	logThrottleLock.Lock()
+	defer logThrottleLock.Unlock()
+	if limiter, b := logThrottleLimiterMap[id]; b {
+		return limiter
+	}
+	limiter := &logThrottleLimiter{Id: id, Type: monitorType, Path: path, ServiceGroup: serviceGroup, SampleMode: sampleMode, SampleEvery: 1, lock: new(sync.Mutex),
+		busyMap: make(map[int64]*logThrottleBusy)}
+	logThrottleLimiterMap[id] = limiter
+	return limiter
+}
+
+func removeLogThrottleLimiter(id string) {
+	logThrottleLock.Lock()
+	delete(logThrottleLimiterMap, id)
+	logThrottleLock.Unlock()
+}
+
+func listLogThrottleLimiter() (result []*logThrottleLimiter) {
+	nowTime := time.Now().Unix()
+	logThrottleLock.RLock()
+	for _, v := range logThrottleLimiterMap {
+		v.lock.Lock()
+		tmpLimiter := *v
+		v.lock.Unlock()
+		tmpLimiter.Throttled = tmpLimiter.ThrottleTime > 0 && nowTime-tmpLimiter.ThrottleTime <= logThrottleKeepSeconds
+		result = append(result, &tmpLimiter)
+	}
+	logThrottleLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return
+}
+
+// rollWindow 进入新的一秒时根据上一秒的行数和最近几秒每行的匹配耗时计算这一秒的预算,
+// 上一秒发出的行预计耗时超过cpu预算时按比例缩小行数预算,业务指标监控按上一秒行数和预算算出抽样间隔
+func (l *logThrottleLimiter) rollWindow(nowTime int64) {
+	lastLines, lastSent := l.windowLines, l.windowSent
+	if nowTime-l.windowStart > 1 {
+		lastLines, lastSent = 0, 0
+	}
+	l.windowStart = nowTime
+	l.windowLines = 0
+	l.windowSent = 0
+	l.LastLines = lastLines
+	l.LastBusyMs = 0
+	if lastBusy, b := l.busyMap[nowTime-1]; b {
+		l.LastBusyMs = lastBusy.nanos / int64(time.Millisecond)
+	}
+	var busyNanos, busyLines int64
+	for readTime, v := range l.busyMap {
+		if nowTime-readTime > logThrottleBusySeconds {
+			delete(l.busyMap, readTime)
+			continue
+		}
+		busyNanos += v.nanos
+		busyLines += v.lines
+	}
+	l.LineBudget = *logThrottleMaxLines
+	if cpuBudgetNanos := *logThrottleCpuPercent * int64(time.Second) / 100; cpuBudgetNanos > 0 && busyLines > 0 && busyNanos > 0 && lastSent > 0 {
+		lineCostNanos := float64(busyNanos) / float64(busyLines)
+		if lineCostNanos*float64(lastSent) > float64(cpuBudgetNanos) {
+			cpuLineBudget := int64(float64(cpuBudgetNanos) / lineCostNanos)
+			if cpuLineBudget < 1 {
+				cpuLineBudget = 1
+			}
+			if l.LineBudget <= 0 || cpuLineBudget < l.LineBudget {
+				l.LineBudget = cpuLineBudget
+			}
+		}
+	}
+	l.SampleEvery = 1
+	if l.SampleMode && l.LineBudget > 0 && lastLines > l.LineBudget {
+		l.SampleEvery = int64(math.Ceil(float64(lastLines) / float64(l.LineBudget)))
+	}
+}
+
+// send 在tail的goroutine里调用,记录行被读到的秒用于统计处理耗时
+func (l *logThrottleLimiter) send(output chan logTailEvent, event logTailEvent) {
+	if !l.SampleMode {
+		l.sendWait(output, event)
+		return
+	}
+	nowTime := time.Now().Unix()
+	l.lock.Lock()
+	if nowTime != l.windowStart {
+		l.rollWindow(nowTime)
+	}
+	event.ReadTime = nowTime
+	l.windowLines++
+	l.TotalLines++
+	l.cycleLines++
+	dropReason := ""
+	if l.SampleEvery > 1 && l.windowLines%l.SampleEvery != 0 {
+		dropReason = logThrottleReasonSample
+	} else if l.LineBudget > 0 && l.windowSent >= l.LineBudget {
+		dropReason = logThrottleReasonRate
+	}
+	if dropReason == "" {
+		// 业务指标监控通道满时不阻塞tail,直接丢弃并计数
+		select {
+		case output <- event:
+			l.windowSent++
+			l.cycleSent++
+		default:
+			dropReason = logThrottleReasonFull
+		}
+	}
+	switch dropReason {
+	case logThrottleReasonSample:
+		l.SampledLines++
+	case logThrottleReasonRate:
+		l.RateDropLines++
+	case logThrottleReasonFull:
+		l.FullDropLines++
+	}
+	if dropReason != "" {
+		l.ThrottleTime = nowTime
+	}
+	l.lock.Unlock()
+}
+
+// sendWait 关键字监控不丢行,这一秒的预算用完后等到下一秒再发,通道满时阻塞
+func (l *logThrottleLimiter) sendWait(output chan logTailEvent, event logTailEvent) {
+	nowTime := time.Now().Unix()
+	l.lock.Lock()
+	if nowTime != l.windowStart {
+		l.rollWindow(nowTime)
+	}
+	l.TotalLines++
+	for l.LineBudget > 0 && l.windowSent >= l.LineBudget {
+		l.ThrottleTime = nowTime
+		l.lock.Unlock()
+		time.Sleep(time.Until(time.Unix(nowTime+1, 0)))
+		nowTime = time.Now().Unix()
+		l.lock.Lock()
+		if nowTime != l.windowStart {
+			l.rollWindow(nowTime)
+		}
+	}
+	l.windowLines++
+	l.windowSent++
+	event.ReadTime = nowTime
+	l.lock.Unlock()
+	output <- event
+}
+
+// addBusy 记录处理线程匹配一行花费的时间,算到这行被读到的那一秒
+func (l *logThrottleLimiter) addBusy(readTime int64, duration time.Duration) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	if l.windowStart-readTime <= logThrottleBusySeconds {
+		busyObj, b := l.busyMap[readTime]
+		if !b {
+			busyObj = &logThrottleBusy{}
+			l.busyMap[readTime] = busyObj
+		}
+		busyObj.nanos += int64(duration)
+		busyObj.lines++
+	}
+	l.lock.Unlock()
+}
+
+// takeSampleScale 返回上次计算以来读到的行数和实际处理行数的比值,用来折算抽样后的count和sum
+func (l *logThrottleLimiter) takeSampleScale() (scale float64) {
+	scale = 1
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	if l.cycleSent > 0 && l.cycleLines > l.cycleSent {
+		scale = l.cycleLines / l.cycleSent
+	}
+	l.cycleLines = 0
+	l.cycleSent = 0
+	l.lock.Unlock()
+	return
+}
+
+func LogThrottleHttpHandle(w http.ResponseWriter, r *http.Request) {
+	result := logThrottleHttpResult{Status: "ok", Message: "success", Data: listLogThrottleLimiter()}
+	w.Header().Set("Content-Type", "application/json")
+	d, _ := json.Marshal(result)
+	w.Write(d)
+}
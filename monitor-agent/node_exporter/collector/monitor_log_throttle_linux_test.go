@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func setLogThrottleBudget(t *testing.T, maxLines, cpuPercent int64) {
+	oldMaxLines, oldCpuPercent := *logThrottleMaxLines, *logThrottleCpuPercent
+	*logThrottleMaxLines, *logThrottleCpuPercent = maxLines, cpuPercent
+	t.Cleanup(func() {
+		*logThrottleMaxLines, *logThrottleCpuPercent = oldMaxLines, oldCpuPercent
+	})
+}
+
+func waitLogThrottleNextSecond() {
+	time.Sleep(time.Until(time.Unix(time.Now().Unix()+1, 0)))
+}
+
+func TestLogThrottleKeywordNotDrop(t *testing.T) {
+	setLogThrottleBudget(t, 5, 0)
+	limiter := getLogThrottleLimiter("test^keyword", "keyword", "/tmp/keyword.log", "", false)
+	defer removeLogThrottleLimiter("test^keyword")
+	output := make(chan logTailEvent, 20)
+	waitLogThrottleNextSecond()
+	for i := 0; i < 7; i++ {
+		limiter.send(output, logTailEvent{Text: "line", Offset: int64(i)})
+	}
+	if len(output) != 7 {
+		t.Fatalf("keyword monitor should not drop lines, want 7 got %d", len(output))
+	}
+	if limiter.RateDropLines != 0 || limiter.FullDropLines != 0 || limiter.ThrottleTime == 0 {
+		t.Errorf("keyword monitor should wait instead of drop, got %+v", limiter)
+	}
+	first, last := <-output, logTailEvent{}
+	for len(output) > 0 {
+		last = <-output
+	}
+	if last.ReadTime <= first.ReadTime {
+		t.Errorf("lines over budget should be sent in next second, first %d last %d", first.ReadTime, last.ReadTime)
+	}
+}
+
+func TestLogThrottleMetricDrop(t *testing.T) {
+	setLogThrottleBudget(t, 5, 0)
+	limiter := getLogThrottleLimiter("test^metric", "metric", "/tmp/metric.log", "sg", true)
+	defer removeLogThrottleLimiter("test^metric")
+	output := make(chan logTailEvent, 6)
+	waitLogThrottleNextSecond()
+	for i := 0; i < 10; i++ {
+		limiter.send(output, logTailEvent{Text: "line"})
+	}
+	if len(output) != 5 || limiter.RateDropLines != 5 {
+		t.Errorf("want 5 sent 5 rate drop, got sent %d %+v", len(output), limiter)
+	}
+	// 业务指标监控通道满时不阻塞tail
+	limiter.lock.Lock()
+	limiter.windowSent = 0
+	limiter.lock.Unlock()
+	for i := 0; i < 3; i++ {
+		limiter.send(output, logTailEvent{Text: "line"})
+	}
+	if len(output) != 6 || limiter.FullDropLines == 0 {
+		t.Errorf("want channel full drop, got sent %d %+v", len(output), limiter)
+	}
+}
+
+func TestLogThrottleBusyReadWindow(t *testing.T) {
+	setLogThrottleBudget(t, 0, 1)
+	limiter := getLogThrottleLimiter("test^busy", "metric", "/tmp/busy.log", "sg", true)
+	defer removeLogThrottleLimiter("test^busy")
+	nowTime := time.Now().Unix()
+	limiter.lock.Lock()
+	limiter.rollWindow(nowTime)
+	limiter.windowLines, limiter.windowSent = 100, 100
+	limiter.lock.Unlock()
+	// 处理积压时这一秒读到的行的耗时在下一秒才记录,仍然算到读到的那一秒
+	for i := 0; i < 10; i++ {
+		limiter.addBusy(nowTime, time.Millisecond)
+	}
+	limiter.addBusy(nowTime-logThrottleBusySeconds-1, time.Second)
+	limiter.lock.Lock()
+	limiter.rollWindow(nowTime + 1)
+	limiter.lock.Unlock()
+	if limiter.LastBusyMs != 10 {
+		t.Errorf("want last busy 10ms, got %d", limiter.LastBusyMs)
+	}
+	// 每行1ms,cpu预算10ms,预算10行,抽样间隔100/10
+	if limiter.LineBudget != 10 || limiter.SampleEvery != 10 {
+		t.Errorf("want line budget 10 sample every 10, got %d %d", limiter.LineBudget, limiter.SampleEvery)
+	}
+	if _, b := limiter.busyMap[nowTime-logThrottleBusySeconds-1]; b {
+		t.Errorf("busy of expired window should not be kept")
+	}
+}
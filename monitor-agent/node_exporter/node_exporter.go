@@ -192,13 +192,19 @@ func main() {
 	go collector.LogKeyWordLoadConfig()
 	go collector.StartProcessMonitorCron()
 	go collector.StartCalcLogMetricCron()
+	go collector.StartLogPathExpandCron()
+	go collector.StartLogOffsetCheckpointCron()
 	// Add log monitor handle http config
 	http.HandleFunc("/log_keyword/config", collector.LogKeywordHttpHandle)
 	http.HandleFunc("/log_keyword/rows", collector.LogMonitorRowsHttpHandle)
+	http.HandleFunc("/log_path/match", collector.LogPathMatchHttpHandle)
+	http.HandleFunc("/log_keyword/matches", collector.LogKeywordMatchesHttpHandle)
 	// Add process monitor handle http config
 	http.HandleFunc("/process/config", collector.ProcessHttpHandle)
 	// Add business monitor handle http config
 	http.HandleFunc("/log_metric/config", collector.LogMetricMonitorHttpHandle)
+	// Add log monitor throttle status
+	http.HandleFunc("/log_monitor/throttle", collector.LogThrottleHttpHandle)
 
 	level.Info(logger).Log("msg", "Listening on", "address", *listenAddress)
 	server := &http.Server{Addr: *listenAddress}
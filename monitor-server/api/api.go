@@ -137,6 +137,8 @@ func init() {
 		&handlerFuncObj{Url: "/alarm/problem/query", Method: http.MethodPost, HandlerFunc: alarm.QueryProblemAlarm},
 		&handlerFuncObj{Url: "/alarm/problem/page", Method: http.MethodPost, HandlerFunc: alarm.QueryProblemAlarmByPage},
 		&handlerFuncObj{Url: "/alarm/problem/close", Method: http.MethodPost, HandlerFunc: alarm.CloseAlarm},
+		&handlerFuncObj{Url: "/alarm/problem/ack", Method: http.MethodPost, HandlerFunc: alarm.AckAlarm},
+		&handlerFuncObj{Url: "/alarm/problem/unack", Method: http.MethodPost, HandlerFunc: alarm.UnAckAlarm},
 		&handlerFuncObj{Url: "/alarm/problem/history", Method: http.MethodPost, HandlerFunc: alarm.QueryHistoryAlarm},
 		&handlerFuncObj{Url: "/alarm/problem/message", Method: http.MethodPost, HandlerFunc: alarm.UpdateAlarmCustomMessage},
 		&handlerFuncObj{Url: "/alarm/problem/notify", Method: http.MethodPost, HandlerFunc: alarm.NotifyAlarm},
@@ -237,6 +239,8 @@ func init() {
 		&handlerFuncObj{Url: "/service/log_keyword/log_keyword_config", Method: http.MethodDelete, HandlerFunc: service.DeleteLogKeyword},
 
 		&handlerFuncObj{Url: "/service/log_keyword/notify", Method: http.MethodPost, HandlerFunc: service.UpdateLogKeywordNotify},
+		&handlerFuncObj{Url: "/service/log_path/match", Method: http.MethodGet, HandlerFunc: service.GetLogPathMatch},
+		&handlerFuncObj{Url: "/service/log_keyword/alarm/matches", Method: http.MethodPost, HandlerFunc: service.ListLogKeywordAlarmMatches},
 		// 数据库关键字配置
 		&handlerFuncObj{Url: "/service/db_keyword/list", Method: http.MethodGet, HandlerFunc: service.ListDBKeywordConfig},
 		&handlerFuncObj{Url: "/service/db_keyword/db_keyword_config", Method: http.MethodPost, HandlerFunc: service.CreateDBKeywordConfig},
@@ -262,9 +266,31 @@ func init() {
 		&handlerFuncObj{Url: "/alarm/strategy", Method: http.MethodPost, HandlerFunc: alarmv2.CreateAlarmStrategy},
 		&handlerFuncObj{Url: "/alarm/strategy", Method: http.MethodPut, HandlerFunc: alarmv2.UpdateAlarmStrategy},
 		&handlerFuncObj{Url: "/alarm/strategy/:strategyGuid", Method: http.MethodDelete, HandlerFunc: alarmv2.DeleteAlarmStrategy},
+		&handlerFuncObj{Url: "/alarm/strategy/condition/preview", Method: http.MethodPost, HandlerFunc: alarm.PreviewStrategyCondition},
+		&handlerFuncObj{Url: "/alarm/strategy/backtest", Method: http.MethodPost, HandlerFunc: alarm.BacktestAlarmStrategy},
 		&handlerFuncObj{Url: "/alarm/event/callback/list", Method: http.MethodGet, HandlerFunc: alarmv2.ListCallbackEvent},
 		&handlerFuncObj{Url: "/alarm/strategy/export/:queryType/:guid", Method: http.MethodGet, HandlerFunc: alarmv2.ExportAlarmStrategy},
 		&handlerFuncObj{Url: "/alarm/strategy/import/:queryType/:guid", Method: http.MethodPost, HandlerFunc: alarmv2.ImportAlarmStrategy},
+		&handlerFuncObj{Url: "/alarm/notify/channel/types", Method: http.MethodGet, HandlerFunc: alarmv2.ListNotifyChannelType},
+		&handlerFuncObj{Url: "/alarm/notify/channel/test", Method: http.MethodPost, HandlerFunc: alarmv2.TestNotifyChannel},
+		&handlerFuncObj{Url: "/alarm/notify/delivery", Method: http.MethodPost, HandlerFunc: alarmv2.ListNotifyDelivery},
+		&handlerFuncObj{Url: "/alarm/notify/delivery/retry", Method: http.MethodPost, HandlerFunc: alarmv2.RetryNotifyDelivery},
+		&handlerFuncObj{Url: "/alarm/escalation/list", Method: http.MethodGet, HandlerFunc: alarmv2.ListAlarmEscalation},
+		&handlerFuncObj{Url: "/alarm/escalation", Method: http.MethodPost, HandlerFunc: alarmv2.CreateAlarmEscalation},
+		&handlerFuncObj{Url: "/alarm/escalation", Method: http.MethodPut, HandlerFunc: alarmv2.UpdateAlarmEscalation},
+		&handlerFuncObj{Url: "/alarm/escalation/:escalationGuid", Method: http.MethodDelete, HandlerFunc: alarmv2.DeleteAlarmEscalation},
+		&handlerFuncObj{Url: "/alarm/silence/list", Method: http.MethodPost, HandlerFunc: alarmv2.ListAlarmSilence},
+		&handlerFuncObj{Url: "/alarm/silence", Method: http.MethodPost, HandlerFunc: alarmv2.CreateAlarmSilence},
+		&handlerFuncObj{Url: "/alarm/silence", Method: http.MethodPut, HandlerFunc: alarmv2.UpdateAlarmSilence},
+		&handlerFuncObj{Url: "/alarm/silence/:silenceGuid", Method: http.MethodDelete, HandlerFunc: alarmv2.DeleteAlarmSilence},
+		&handlerFuncObj{Url: "/alarm/silence/:silenceGuid/expire", Method: http.MethodPost, HandlerFunc: alarmv2.ExpireAlarmSilence},
+		&handlerFuncObj{Url: "/alarm/silence/:silenceGuid/muted", Method: http.MethodGet, HandlerFunc: alarmv2.ListAlarmSilenceMuted},
+		&handlerFuncObj{Url: "/alarm/incident/rule/list", Method: http.MethodGet, HandlerFunc: alarmv2.ListAlarmCorrelationRule},
+		&handlerFuncObj{Url: "/alarm/incident/rule", Method: http.MethodPost, HandlerFunc: alarmv2.CreateAlarmCorrelationRule},
+		&handlerFuncObj{Url: "/alarm/incident/rule", Method: http.MethodPut, HandlerFunc: alarmv2.UpdateAlarmCorrelationRule},
+		&handlerFuncObj{Url: "/alarm/incident/rule/:ruleGuid", Method: http.MethodDelete, HandlerFunc: alarmv2.DeleteAlarmCorrelationRule},
+		&handlerFuncObj{Url: "/alarm/incident/list", Method: http.MethodPost, HandlerFunc: alarmv2.ListAlarmIncident},
+		&handlerFuncObj{Url: "/alarm/incident/:incidentId", Method: http.MethodGet, HandlerFunc: alarmv2.GetAlarmIncident},
 		// monitor
 		&handlerFuncObj{Url: "/monitor/endpoint/query", Method: http.MethodGet, HandlerFunc: monitor.ListEndpoint},
 		&handlerFuncObj{Url: "/monitor/metric/list", Method: http.MethodGet, HandlerFunc: monitor.ListMetric},
@@ -283,6 +309,11 @@ func init() {
 		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template", Method: http.MethodPut, HandlerFunc: service.UpdateLogMonitorTemplate},
 		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/:logMonitorTemplateGuid", Method: http.MethodDelete, HandlerFunc: service.DeleteLogMonitorTemplate},
 		&handlerFuncObj{Url: "/service/log_metric/affect_service_group/:logMonitorTemplateGuid", Method: http.MethodGet, HandlerFunc: service.GetLogMonitorTemplateServiceGroup},
+		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/:logMonitorTemplateGuid/version/list", Method: http.MethodGet, HandlerFunc: service.ListLogMonitorTemplateVersion},
+		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/:logMonitorTemplateGuid/version/diff", Method: http.MethodGet, HandlerFunc: service.DiffLogMonitorTemplateVersion},
+		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/:logMonitorTemplateGuid/version/:version", Method: http.MethodGet, HandlerFunc: service.GetLogMonitorTemplateVersion},
+		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/version/rollout", Method: http.MethodPost, HandlerFunc: service.RolloutLogMonitorTemplateVersion},
+		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/version/rollback", Method: http.MethodPost, HandlerFunc: service.RollbackLogMonitorTemplateVersion},
 		&handlerFuncObj{Url: "/service/log_metric/regexp/match", Method: http.MethodPost, HandlerFunc: service.CheckLogMonitorRegExpMatch},
 		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/export", Method: http.MethodPost, HandlerFunc: service.LogMonitorTemplateExport},
 		&handlerFuncObj{Url: "/service/log_metric/log_monitor_template/import", Method: http.MethodPost, HandlerFunc: service.LogMonitorTemplateImport},
@@ -295,6 +326,7 @@ func init() {
 		&handlerFuncObj{Url: "/service/log_metric/custom/log_metric_group", Method: http.MethodPost, HandlerFunc: service.CreateLogMetricCustomGroup},
 		&handlerFuncObj{Url: "/service/log_metric/custom/log_metric_group", Method: http.MethodPut, HandlerFunc: service.UpdateLogMetricCustomGroup},
 		&handlerFuncObj{Url: "/service/log_metric/data_map/regexp/match", Method: http.MethodPost, HandlerFunc: service.LogMonitorDataMapRegMatch},
+		&handlerFuncObj{Url: "/service/log_metric/throttle/list", Method: http.MethodGet, HandlerFunc: service.ListLogMonitorThrottle},
 		// 标签
 		&handlerFuncObj{Url: "/metric/tag/value-list", Method: http.MethodPost, HandlerFunc: monitor.QueryMetricTagValue},
 
@@ -309,7 +341,12 @@ func init() {
 		&handlerFuncObj{Url: "/dashboard/custom/permission", Method: http.MethodPost, HandlerFunc: monitor.UpdateCustomDashboardPermission},
 		&handlerFuncObj{Url: "/dashboard/custom/export", Method: http.MethodPost, HandlerFunc: monitor.ExportCustomDashboard},
 		&handlerFuncObj{Url: "/dashboard/custom/import", Method: http.MethodPost, HandlerFunc: monitor.ImportCustomDashboard},
+		&handlerFuncObj{Url: "/dashboard/custom/grafana/export", Method: http.MethodPost, HandlerFunc: monitor.ExportGrafanaDashboard},
+		&handlerFuncObj{Url: "/dashboard/custom/grafana/import", Method: http.MethodPost, HandlerFunc: monitor.ImportGrafanaDashboard},
 		&handlerFuncObj{Url: "/dashboard/custom/trans_import", Method: http.MethodPost, HandlerFunc: monitor.TransImportCustomDashboard},
+		&handlerFuncObj{Url: "/dashboard/custom/variable", Method: http.MethodGet, HandlerFunc: monitor.ListCustomDashboardVariable},
+		&handlerFuncObj{Url: "/dashboard/custom/variable", Method: http.MethodPost, HandlerFunc: monitor.SaveCustomDashboardVariable},
+		&handlerFuncObj{Url: "/dashboard/custom/variable/options", Method: http.MethodPost, HandlerFunc: monitor.QueryCustomDashboardVariableOptions},
 		&handlerFuncObj{Url: "/chart/shared/list", Method: http.MethodPost, HandlerFunc: monitor.GetSharedChartList},
 		&handlerFuncObj{Url: "/chart/custom", Method: http.MethodPost, HandlerFunc: monitor.AddCustomChart},
 		&handlerFuncObj{Url: "/chart/custom/copy", Method: http.MethodPost, HandlerFunc: monitor.CopyCustomChart},
@@ -330,12 +367,18 @@ func init() {
 		&handlerFuncObj{Url: "/config/remote/write", Method: http.MethodPost, HandlerFunc: config_new.RemoteWriteConfigCreate},
 		&handlerFuncObj{Url: "/config/remote/write", Method: http.MethodPut, HandlerFunc: config_new.RemoteWriteConfigUpdate},
 		&handlerFuncObj{Url: "/config/remote/write", Method: http.MethodDelete, HandlerFunc: config_new.RemoteWriteConfigDelete},
+		&handlerFuncObj{Url: "/config/datasource", Method: http.MethodGet, HandlerFunc: config_new.DatasourceList},
+		&handlerFuncObj{Url: "/config/datasource", Method: http.MethodPost, HandlerFunc: config_new.DatasourceCreate},
+		&handlerFuncObj{Url: "/config/datasource", Method: http.MethodPut, HandlerFunc: config_new.DatasourceUpdate},
+		&handlerFuncObj{Url: "/config/datasource", Method: http.MethodDelete, HandlerFunc: config_new.DatasourceDelete},
+		&handlerFuncObj{Url: "/config/datasource/check", Method: http.MethodPost, HandlerFunc: config_new.DatasourceCheck},
 
 		// 类型配置
 		&handlerFuncObj{Url: "/config/type/query", Method: http.MethodGet, HandlerFunc: monitor.QueryTypeConfigList},
 		&handlerFuncObj{Url: "/config/type", Method: http.MethodPost, HandlerFunc: monitor.AddTypeConfig},
 		&handlerFuncObj{Url: "/config/type-batch", Method: http.MethodPost, HandlerFunc: monitor.BatchAddTypeConfig},
 		&handlerFuncObj{Url: "/config/type", Method: http.MethodDelete, HandlerFunc: monitor.DeleteTypeConfig},
+		&handlerFuncObj{Url: "/config/type/datasource", Method: http.MethodPut, HandlerFunc: monitor.UpdateTypeConfigDatasource},
 
 		// 获取seed
 		&handlerFuncObj{Url: "/seed", Method: http.MethodGet, HandlerFunc: monitor.GetEncryptSeed},
@@ -389,6 +432,7 @@ func InitHttpServer() {
 	r.POST(fmt.Sprintf("%s/register", urlPrefix), user.Register)
 	r.GET(fmt.Sprintf("%s/logout", urlPrefix), user.Logout)
 	r.GET(fmt.Sprintf("%s/check", urlPrefix), user.HealthCheck)
+	r.GET(fmt.Sprintf("%s/metrics", urlPrefix), dashboard_new.QueryCacheMetrics)
 	r.GET(fmt.Sprintf("%s/demo", urlPrefix), dashboard.DisplayWatermark)
 	r.POST(fmt.Sprintf("%s/webhook", urlPrefix), alarm.AcceptAlert)
 	r.POST(fmt.Sprintf("%s/openapi/alarm/send", urlPrefix), alarm.OpenAlarmApi)
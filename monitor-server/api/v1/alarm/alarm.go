@@ -39,6 +39,8 @@ func AcceptAlert(c *gin.Context) {
 		alarms = append(alarms, &tmpAlarm)
 	}
 	alarms = db.UpdateAlarms(alarms)
+	db.DetectAlarmFlapping(alarms)
+	db.CorrelateAlarmIncident(alarms)
 	var treeventSendObj m.EventTreeventNotifyDto
 	for _, v := range alarms {
 		log.Logger.Debug("update alarm result", log.JsonObj("alarm", v))
@@ -155,12 +157,21 @@ func buildNewAlarm(param *m.AMRespAlert, nowTime time.Time) (alarm m.AlarmHandle
 	//	}
 	//}
 	if operation == "same" {
+		if alarm.Status == "firing" && existAlarm.Id > 0 {
+			// 等待恢复期间又收到firing,继续保持告警
+			db.ClearAlarmRecoverPending(existAlarm.Id)
+		}
 		return alarm, fmt.Errorf("Accept alert msg ,firing repeat,do nothing! ")
 	}
 	if operation == "add" && param.Status == "resolved" {
 		return alarm, fmt.Errorf("Accept alert msg ,cat not add resolved,do nothing! ")
 	}
 	if operation == "resolve" {
+		// 阈值配置了恢复条件的,先记录等待,由定时任务确认满足恢复条件后再恢复
+		if !multipleConditionFlag && hasStrategyRecoverConfig(&strategyObj) {
+			db.SetAlarmRecoverPending(existAlarm.Id, nowTime)
+			return alarm, fmt.Errorf("Alarm:%d wait for recover condition ", existAlarm.Id)
+		}
 		alarm.Id = existAlarm.Id
 		alarm.AlarmStrategy = existAlarm.AlarmStrategy
 		alarm.StrategyId = existAlarm.StrategyId
@@ -172,6 +183,9 @@ func buildNewAlarm(param *m.AMRespAlert, nowTime time.Time) (alarm m.AlarmHandle
 		if !db.InActiveWindowList(strategyObj.ActiveWindow) {
 			return alarm, fmt.Errorf("Alarm:%s not in active window:%s ", strategyObj.Guid, strategyObj.ActiveWindow)
 		}
+		if silenceGuid := db.MatchAlarmSilence(&m.AlarmSilenceMatchParam{Endpoint: alarm.Endpoint, EndpointGroup: strategyObj.EndpointGroup, Metric: alarm.SMetric, AlarmStrategy: alarm.AlarmStrategy, AlarmName: alarm.AlarmName, Tags: alarm.Tags}); silenceGuid != "" {
+			return alarm, fmt.Errorf("Alarm:%s endpoint:%s muted by silence:%s ", alarm.AlarmStrategy, alarm.Endpoint, silenceGuid)
+		}
 		alarm.StartValue = alertValue
 		alarm.Start = nowTime
 	}
@@ -645,6 +659,7 @@ func QueryProblemAlarmByPage(c *gin.Context) {
 		UserRoles:           mid.GetOperateUserRoles(c),
 		Token:               c.GetHeader("Authorization"),
 		Query:               param.Query,
+		AckFilter:           param.Ack,
 	})
 	if err != nil {
 		mid.ReturnQueryTableError(c, "alarm", err)
@@ -750,6 +765,33 @@ func CloseAlarm(c *gin.Context) {
 	mid.ReturnSuccess(c)
 }
 
+// AckAlarm 确认告警,表示已有人在处理
+func AckAlarm(c *gin.Context) {
+	var param m.AlarmAckParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		mid.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.AckAlarm(param.Id, mid.GetOperateUser(c)); err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	mid.ReturnSuccess(c)
+}
+
+func UnAckAlarm(c *gin.Context) {
+	var param m.AlarmAckParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		mid.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.UnAckAlarm(param.Id); err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	mid.ReturnSuccess(c)
+}
+
 func UpdateAlarmCustomMessage(c *gin.Context) {
 	var param m.UpdateAlarmCustomMessageDto
 	if err := c.ShouldBindJSON(&param); err != nil {
@@ -854,6 +896,9 @@ func QueryEntityAlarm(c *gin.Context) {
 			alarmObj, err = db.GetAlarmEvent("alarm", "", 0, "firing")
 		} else {
 			alarmObj, err = db.GetAlarmEvent("alarm", notifyGuid, id, alarmStatus)
+			if err == nil && notifyGuid != "custom_alarm_guid" {
+				alarmObj.AckUser, alarmObj.AckTime = db.GetAlarmAckInfo(id)
+			}
 		}
 		if err != nil {
 			result.Status = "ERROR"
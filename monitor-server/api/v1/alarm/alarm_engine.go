@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
 	"github.com/WeBankPartners/open-monitor/monitor-server/models"
-	"github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
 	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
 	"strconv"
 	"strings"
@@ -16,6 +15,7 @@ func StartAlarmEngineCron() {
 	for {
 		<-t
 		go doMonitorEngineRuleJob()
+		go doAlarmRecoverPendingJob()
 	}
 }
 
@@ -39,8 +39,19 @@ func doMonitorEngineRuleJob() {
 	if err != nil {
 		return
 	}
+	// 静默每轮只加载一次,逐条告警在内存里匹配
+	db.LoadActiveAlarmSilence()
 	var alarmList []*models.AlarmHandleObj
 	for _, row := range alarmStrategyMetricRows {
+		if row.ConditionType == models.StrategyConditionNoData {
+			alarmObjList, tmpErr := buildNoDataAlarm(row, existAlarmRows)
+			if tmpErr != nil {
+				log.Logger.Warn("doAlarmEngineRuleJob buildNoDataAlarm fail", log.String("alarmStrategyMetric", row.Guid), log.Error(tmpErr))
+			} else if len(alarmObjList) > 0 {
+				alarmList = append(alarmList, alarmObjList...)
+			}
+			continue
+		}
 		condition, threshold, illegal := analyzeCondition(row.Condition)
 		if illegal {
 			log.Logger.Info("doAlarmEngineRuleJob condition illegal", log.String("alarmStrategyMetric", row.Guid), log.String("condition", row.Condition))
@@ -57,6 +68,8 @@ func doMonitorEngineRuleJob() {
 		return
 	}
 	alarmList = db.UpdateAlarms(alarmList)
+	db.DetectAlarmFlapping(alarmList)
+	db.CorrelateAlarmIncident(alarmList)
 	for _, v := range alarmList {
 		log.Logger.Debug("update alarm result", log.JsonObj("alarm", v))
 		if v.AlarmConditionGuid != "" {
@@ -149,7 +162,8 @@ func buildMonitorEngineAlarm(alarmStrategyMetric *models.AlarmStrategyMetric, co
 	}
 	endTime := time.Now().Unix()
 	startTime := endTime - lastSec
-	queryData, queryErr := datasource.QueryPrometheusRange(alarmStrategyMetric.MonitorEngineExpr, startTime, endTime, 10)
+	// 异常检测条件查出来的是偏离值,和静态阈值一样比较
+	queryData, queryErr := db.QueryStrategyConditionDataByDatasource(db.GetMetricDatasource(alarmStrategyMetric.Metric), alarmStrategyMetric.MonitorEngineExpr, alarmStrategyMetric.ConditionType, alarmStrategyMetric.AnomalyWindow, startTime, endTime)
 	if queryErr != nil {
 		err = fmt.Errorf("query prometheus data fail,%s ", queryErr.Error())
 		return
@@ -183,6 +197,15 @@ func buildMonitorEngineAlarm(alarmStrategyMetric *models.AlarmStrategyMetric, co
 		// 如果有一个不符合的值，则算是不满足
 		if firingNonMatch {
 			if tmpExistAlarm.Id > 0 {
+				// 阈值配置了恢复条件的,恢复持续时间内都满足恢复条件才恢复,避免在阈值附近反复告警恢复
+				strategyObj, _, tmpGetStrategyErr := db.GetAlarmStrategy(alarmStrategyMetric.AlarmStrategy, alarmStrategyMetric.CrcHash)
+				if tmpGetStrategyErr == nil && hasStrategyRecoverConfig(&strategyObj) {
+					recovered, hasData, recoverValue := checkStrategyRecover(&strategyObj, alarmStrategyMetric.MonitorEngineExpr, alarmStrategyMetric.ConditionType, alarmStrategyMetric.AnomalyWindow, queryObj.Metric, condition, threshold)
+					if !recovered || !hasData {
+						continue
+					}
+					endValue = recoverValue
+				}
 				// 有正在发生的告警，需要恢复
 				alarmObj.Id = tmpExistAlarm.Id
 				alarmObj.AlarmStrategy = tmpExistAlarm.AlarmStrategy
@@ -213,6 +236,11 @@ func buildMonitorEngineAlarm(alarmStrategyMetric *models.AlarmStrategyMetric, co
 						log.Logger.Error("buildMonitorEngineAlarm get endpoint fail", log.JsonObj("labels", queryObj.Metric), log.Error(tmpGetEndpointErr))
 						continue
 					}
+					// 命中静默规则的跳过
+					if silenceGuid := db.MatchAlarmSilence(&models.AlarmSilenceMatchParam{Endpoint: endpointObj.Guid, EndpointGroup: strategyObj.EndpointGroup, Metric: strategyObj.MetricName, AlarmStrategy: strategyObj.Guid, AlarmName: strategyObj.Name, Tags: tmpTags}); silenceGuid != "" {
+						log.Logger.Info("buildMonitorEngineAlarm alarm muted by silence", log.String("alarmStrategy", alarmStrategyMetric.AlarmStrategy), log.String("endpoint", endpointObj.Guid), log.String("silence", silenceGuid))
+						continue
+					}
 					alarmObj.Endpoint = endpointObj.Guid
 					alarmObj.Tags = tmpTags
 					alarmObj.AlarmConditionCrcHash = alarmStrategyMetric.CrcHash
@@ -250,7 +278,7 @@ func buildMonitorEngineAlarm(alarmStrategyMetric *models.AlarmStrategyMetric, co
 func matchMonitorEngineExistAlarm(metaMap map[string]string, existAlarmRows []*models.AlarmTable, tags string, alarmStrategyMetric *models.AlarmStrategyMetric) (existAlarm *models.AlarmTable) {
 	existAlarm = &models.AlarmTable{}
 	for _, v := range existAlarmRows {
-		if v.AlarmStrategy == alarmStrategyMetric.AlarmStrategy && v.Tags == tags {
+		if v.AlarmStrategy == alarmStrategyMetric.AlarmStrategy && v.Tags == tags && v.SCond != models.StrategyConditionNoData {
 			existAlarm = v
 			break
 		}
@@ -0,0 +1,147 @@
+package alarm
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// buildNoDataAlarm 无数据告警,按对象组成员逐个检查持续时间内有没有数据,没有数据的对象产生告警,重新有数据或已移出对象组的恢复
+func buildNoDataAlarm(alarmStrategyMetric *models.AlarmStrategyMetric, existAlarmRows []*models.AlarmTable) (alarmObjList []*models.AlarmHandleObj, err error) {
+	lastSec := analyzeLast(alarmStrategyMetric.Last)
+	if lastSec == 0 {
+		err = fmt.Errorf("lastConfig:%s illegal", alarmStrategyMetric.Last)
+		return
+	}
+	if alarmStrategyMetric.MonitorEngineExpr == "" {
+		return
+	}
+	strategyObj, _, getStrategyErr := db.GetAlarmStrategy(alarmStrategyMetric.AlarmStrategy, alarmStrategyMetric.CrcHash)
+	if getStrategyErr != nil {
+		err = fmt.Errorf("get strategy object fail,%s ", getStrategyErr.Error())
+		return
+	}
+	endTime := time.Now().Unix()
+	queryData, queryErr := db.QueryStrategyConditionDataByDatasource(db.GetMetricDatasource(alarmStrategyMetric.Metric), alarmStrategyMetric.MonitorEngineExpr, "", "", endTime-lastSec, endTime)
+	if queryErr != nil {
+		// 查询失败不能当成无数据,避免Prometheus异常时全部对象告警
+		err = fmt.Errorf("query prometheus data fail,%s ", queryErr.Error())
+		return
+	}
+	var missEndpointList []*models.EndpointNewTable
+	if strings.Contains(strategyObj.MetricExpr, "$guid") || strings.Contains(strategyObj.MetricExpr, "$address") || strings.Contains(strategyObj.MetricExpr, "$ip") {
+		endpointList, getEndpointErr := db.GetEndpointGroupMemberList(strategyObj.EndpointGroup)
+		if getEndpointErr != nil {
+			err = fmt.Errorf("get endpoint group member fail,%s ", getEndpointErr.Error())
+			return
+		}
+		labelBindList := getEndpointLabelBindList(strategyObj.MetricExpr)
+		for _, endpoint := range endpointList {
+			if !matchEndpointSeries(endpoint, labelBindList, queryData.Result) {
+				missEndpointList = append(missEndpointList, endpoint)
+			}
+		}
+	} else if len(queryData.Result) == 0 {
+		// 表达式不区分对象(如层级对象的日志指标),整组没有数据时按对象组告警
+		endpointGroupObj, getGroupErr := db.GetSimpleEndpointGroup(strategyObj.EndpointGroup)
+		if getGroupErr != nil {
+			err = getGroupErr
+			return
+		}
+		if endpointGroupObj.ServiceGroup != "" {
+			missEndpointList = append(missEndpointList, &models.EndpointNewTable{Guid: "sg__" + endpointGroupObj.ServiceGroup, AlarmEnable: 1})
+		} else {
+			missEndpointList = append(missEndpointList, &models.EndpointNewTable{Guid: "eg__" + endpointGroupObj.Guid, AlarmEnable: 1})
+		}
+	}
+	missEndpointMap := make(map[string]bool)
+	for _, endpoint := range missEndpointList {
+		missEndpointMap[endpoint.Guid] = true
+	}
+	existEndpointMap := make(map[string]bool)
+	for _, existAlarm := range existAlarmRows {
+		// 同一策略下可能有多个条件,只处理本条件产生的无数据告警
+		if existAlarm.AlarmStrategy != alarmStrategyMetric.AlarmStrategy || existAlarm.SCond != models.StrategyConditionNoData || existAlarm.SMetric != strategyObj.MetricName {
+			continue
+		}
+		existEndpointMap[existAlarm.Endpoint] = true
+		if missEndpointMap[existAlarm.Endpoint] {
+			continue
+		}
+		alarmObjList = append(alarmObjList, &models.AlarmHandleObj{AlarmTable: models.AlarmTable{Id: existAlarm.Id, AlarmStrategy: existAlarm.AlarmStrategy, StrategyId: existAlarm.StrategyId, Status: "ok", End: time.Now()},
+			NotifyEnable: strategyObj.NotifyEnable, NotifyDelay: strategyObj.NotifyDelaySecond})
+	}
+	if len(missEndpointList) == 0 || !db.InActiveWindowList(strategyObj.ActiveWindow) {
+		return
+	}
+	for _, endpoint := range missEndpointList {
+		if existEndpointMap[endpoint.Guid] || endpoint.AlarmEnable == 0 || !db.CheckEndpointActiveAlert(endpoint.Guid) {
+			continue
+		}
+		if silenceGuid := db.MatchAlarmSilence(&models.AlarmSilenceMatchParam{Endpoint: endpoint.Guid, EndpointGroup: strategyObj.EndpointGroup, Metric: strategyObj.MetricName, AlarmStrategy: strategyObj.Guid, AlarmName: strategyObj.Name}); silenceGuid != "" {
+			log.Logger.Info("buildNoDataAlarm alarm muted by silence", log.String("alarmStrategy", strategyObj.Guid), log.String("endpoint", endpoint.Guid), log.String("silence", silenceGuid))
+			continue
+		}
+		alarmObjList = append(alarmObjList, &models.AlarmHandleObj{AlarmTable: models.AlarmTable{
+			Endpoint:      endpoint.Guid,
+			Status:        "firing",
+			Start:         time.Now(),
+			AlarmStrategy: strategyObj.Guid,
+			SMetric:       strategyObj.MetricName,
+			SExpr:         strategyObj.MetricExpr,
+			SCond:         models.StrategyConditionNoData,
+			SLast:         strategyObj.Last,
+			SPriority:     strategyObj.Priority,
+			AlarmName:     strategyObj.Name,
+			Content:       strategyObj.Content,
+		}, AlarmConditionCrcHash: alarmStrategyMetric.CrcHash, NotifyEnable: strategyObj.NotifyEnable, NotifyDelay: strategyObj.NotifyDelaySecond})
+	}
+	return
+}
+
+var endpointLabelBindRegexp = regexp.MustCompile(`(\w+)\s*=~?\s*"\$(guid|address|ip)([^"]*)"`)
+
+// endpointLabelBind 表达式中绑定了对象变量的标签,如 instance="$address"
+type endpointLabelBind struct {
+	Label  string
+	Key    string
+	Suffix string
+}
+
+func getEndpointLabelBindList(metricExpr string) (result []*endpointLabelBind) {
+	for _, matchList := range endpointLabelBindRegexp.FindAllStringSubmatch(metricExpr, -1) {
+		result = append(result, &endpointLabelBind{Label: matchList[1], Key: matchList[2], Suffix: matchList[3]})
+	}
+	return
+}
+
+// matchEndpointSeries 查询结果中有任一序列在表达式绑定的标签上指向该对象即认为对象有数据
+func matchEndpointSeries(endpoint *models.EndpointNewTable, labelBindList []*endpointLabelBind, seriesList []models.PrometheusResult) bool {
+	for _, series := range seriesList {
+		for _, bind := range labelBindList {
+			v := series.Metric[bind.Label]
+			if v == "" {
+				continue
+			}
+			switch bind.Key {
+			case "guid":
+				if v == endpoint.Guid {
+					return true
+				}
+			case "address":
+				if endpoint.AgentAddress != "" && v == endpoint.AgentAddress {
+					return true
+				}
+			case "ip":
+				if endpoint.Ip != "" && (v == endpoint.Ip+bind.Suffix || v == endpoint.Ip || strings.HasPrefix(v, endpoint.Ip+":")) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package alarm
+
+import (
+	"fmt"
+	mid "github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreviewStrategyCondition 用历史数据预览阈值条件,返回参与比较的值和按持续时间模拟出的告警区间
+func PreviewStrategyCondition(c *gin.Context) {
+	var param m.StrategyConditionPreviewParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		mid.ReturnValidateError(c, err.Error())
+		return
+	}
+	param.Condition = strings.ReplaceAll(param.Condition, " ", "")
+	condition, threshold, illegal := analyzeCondition(param.Condition)
+	if illegal || !mid.IsIllegalCond(param.Condition) || (param.Last != "" && !mid.IsIllegalLast(param.Last)) {
+		mid.ReturnValidateError(c, fmt.Sprintf("condition: %s or last: %s illegal", param.Condition, param.Last))
+		return
+	}
+	if param.ConditionType != "" && !m.IsStrategyAnomalyCondition(param.ConditionType) {
+		mid.ReturnValidateError(c, fmt.Sprintf("condition_type: %s illegal", param.ConditionType))
+		return
+	}
+	if param.End <= 0 {
+		param.End = time.Now().Unix()
+	}
+	if param.Start <= 0 || param.Start >= param.End {
+		param.Start = param.End - 86400
+	}
+	metricRow, err := db.GetSimpleMetric(param.Metric)
+	if err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	endpointObj, err := db.GetEndpointNew(&m.EndpointNewTable{Guid: param.Endpoint})
+	if err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	promQl := db.ReplacePromQlKeyword(metricRow.PromExpr, metricRow.Metric, &endpointObj, nil)
+	queryData, err := db.QueryStrategyConditionData(promQl, param.ConditionType, param.AnomalyWindow, param.Start, param.End)
+	if err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	result := []*m.StrategyConditionPreviewSeries{}
+	lastSec := analyzeLast(param.Last)
+	for _, queryObj := range queryData.Result {
+		delete(queryObj.Metric, "__name__")
+		result = append(result, buildConditionPreviewSeries(queryObj, condition, threshold, lastSec))
+	}
+	mid.ReturnSuccessData(c, result)
+}
+
+// buildConditionPreviewSeries 连续满足条件达到持续时间时告警,出现一个不满足的值时恢复
+// 只是近似模拟,没有计算恢复条件、恢复持续时间和抖动抑制,结果可能比实际告警更频繁
+func buildConditionPreviewSeries(queryObj m.PrometheusResult, condition string, threshold float64, lastSec int64) *m.StrategyConditionPreviewSeries {
+	series := &m.StrategyConditionPreviewSeries{Tags: queryObj.Metric, Values: [][]float64{}, Alarms: []*m.StrategyConditionPreviewAlarm{}}
+	var matchStart int64 = -1
+	var firingAlarm *m.StrategyConditionPreviewAlarm
+	for _, v := range queryObj.Values {
+		timestamp, tsOk := v[0].(float64)
+		valueString, valueOk := v[1].(string)
+		if !tsOk || !valueOk {
+			continue
+		}
+		tmpValue, parseErr := strconv.ParseFloat(valueString, 64)
+		if parseErr != nil {
+			continue
+		}
+		series.Values = append(series.Values, []float64{timestamp, tmpValue})
+		if !compareFloatValue(tmpValue, threshold, condition) {
+			if firingAlarm != nil {
+				firingAlarm.End = int64(timestamp)
+				firingAlarm = nil
+			}
+			matchStart = -1
+			continue
+		}
+		if matchStart < 0 {
+			matchStart = int64(timestamp)
+		}
+		if firingAlarm == nil && int64(timestamp)-matchStart >= lastSec {
+			firingAlarm = &m.StrategyConditionPreviewAlarm{Start: int64(timestamp), StartValue: tmpValue}
+			series.Alarms = append(series.Alarms, firingAlarm)
+		}
+	}
+	return series
+}
+
+// BacktestAlarmStrategy 保存阈值配置前,按对象组当前成员替换表达式后用历史数据模拟每个对象每组标签的告警和恢复区间
+func BacktestAlarmStrategy(c *gin.Context) {
+	var param m.AlarmStrategyBacktestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		mid.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.EndpointGroup == "" || len(param.Conditions) == 0 {
+		mid.ReturnValidateError(c, "endpoint_group and conditions can not empty")
+		return
+	}
+	for _, condition := range param.Conditions {
+		if condition.ConditionType == m.StrategyConditionNoData {
+			if analyzeLast(condition.Last) == 0 {
+				mid.ReturnValidateError(c, fmt.Sprintf("nodata last: %s illegal", condition.Last))
+				return
+			}
+			continue
+		}
+		condition.Condition = strings.ReplaceAll(condition.Condition, " ", "")
+		if _, _, illegal := analyzeCondition(condition.Condition); illegal || !mid.IsIllegalCond(condition.Condition) || (condition.Last != "" && !mid.IsIllegalLast(condition.Last)) {
+			mid.ReturnValidateError(c, fmt.Sprintf("condition: %s or last: %s illegal", condition.Condition, condition.Last))
+			return
+		}
+	}
+	if param.End <= 0 {
+		param.End = time.Now().Unix()
+	}
+	if param.Start <= 0 || param.Start >= param.End {
+		param.Start = param.End - 86400
+	}
+	if param.End-param.Start > 7*86400 {
+		mid.ReturnValidateError(c, "backtest range can not more than 7 days")
+		return
+	}
+	endpointGroupObj, err := db.GetSimpleEndpointGroup(param.EndpointGroup)
+	if err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	groupEndpoint := "eg__" + endpointGroupObj.Guid
+	if endpointGroupObj.ServiceGroup != "" {
+		groupEndpoint = "sg__" + endpointGroupObj.ServiceGroup
+	}
+	endpointList, exprList, err := db.BuildStrategyConditionExprList(&param.GroupStrategyObj)
+	if err != nil {
+		mid.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	endpointGuidList := []string{}
+	for _, endpoint := range endpointList {
+		endpointGuidList = append(endpointGuidList, endpoint.Guid)
+	}
+	result := m.AlarmStrategyBacktestResult{Start: param.Start, End: param.End, Conditions: []*m.AlarmStrategyBacktestSeries{}}
+	for i, condition := range param.Conditions {
+		queryData, queryErr := db.QueryStrategyConditionLongTermData(condition.Metric, endpointGuidList, exprList[i], condition.ConditionType, condition.AnomalyWindow, param.Start, param.End)
+		if queryErr != nil {
+			mid.ReturnHandleError(c, queryErr.Error(), queryErr)
+			return
+		}
+		metricRow, getMetricErr := db.GetSimpleMetric(condition.Metric)
+		if getMetricErr != nil {
+			mid.ReturnHandleError(c, getMetricErr.Error(), getMetricErr)
+			return
+		}
+		labelBindList := getEndpointLabelBindList(metricRow.PromExpr)
+		lastSec := analyzeLast(condition.Last)
+		if condition.ConditionType == m.StrategyConditionNoData {
+			result.Conditions = append(result.Conditions, buildNoDataBacktestSeries(i, condition.Metric, queryData.Result, endpointList, labelBindList, groupEndpoint, lastSec, param.Start, param.End)...)
+			continue
+		}
+		conditionOperator, threshold, _ := analyzeCondition(condition.Condition)
+		for _, queryObj := range queryData.Result {
+			delete(queryObj.Metric, "__name__")
+			tmpTags, _ := getNewAlarmTags(&m.AMRespAlert{Labels: queryObj.Metric})
+			previewSeries := buildConditionPreviewSeries(queryObj, conditionOperator, threshold, lastSec)
+			result.Conditions = append(result.Conditions, buildBacktestSeries(i, condition.Metric, getBacktestSeriesEndpoint(queryObj, endpointList, labelBindList, groupEndpoint), tmpTags, previewSeries.Alarms, param.End))
+		}
+	}
+	mid.ReturnSuccessData(c, result)
+}
+
+func getBacktestSeriesEndpoint(queryObj m.PrometheusResult, endpointList []*m.EndpointNewTable, labelBindList []*endpointLabelBind, groupEndpoint string) string {
+	for _, endpoint := range endpointList {
+		if matchEndpointSeries(endpoint, labelBindList, []m.PrometheusResult{queryObj}) {
+			return endpoint.Guid
+		}
+	}
+	return groupEndpoint
+}
+
+func buildBacktestSeries(conditionIndex int, metric, endpoint, tags string, alarms []*m.StrategyConditionPreviewAlarm, end int64) *m.AlarmStrategyBacktestSeries {
+	series := &m.AlarmStrategyBacktestSeries{ConditionIndex: conditionIndex, Metric: metric, Endpoint: endpoint, Tags: tags, FiringCount: len(alarms), Alarms: alarms}
+	for _, alarm := range alarms {
+		if alarm.End > 0 {
+			series.FiringSecond += alarm.End - alarm.Start
+		} else {
+			series.FiringSecond += end - alarm.Start
+		}
+	}
+	return series
+}
+
+// buildNoDataBacktestSeries 无数据条件按对象统计数据点的间隔,间隔超过持续时间的区间算一次告警
+func buildNoDataBacktestSeries(conditionIndex int, metric string, seriesList []m.PrometheusResult, endpointList []*m.EndpointNewTable, labelBindList []*endpointLabelBind, groupEndpoint string, lastSec, start, end int64) (result []*m.AlarmStrategyBacktestSeries) {
+	endpointTimestampMap := make(map[string][]int64)
+	for _, queryObj := range seriesList {
+		tmpEndpoint := getBacktestSeriesEndpoint(queryObj, endpointList, labelBindList, groupEndpoint)
+		for _, v := range queryObj.Values {
+			if timestamp, ok := v[0].(float64); ok {
+				endpointTimestampMap[tmpEndpoint] = append(endpointTimestampMap[tmpEndpoint], int64(timestamp))
+			}
+		}
+	}
+	// 有数据但都对应不到具体对象的,说明表达式不区分对象,按对象组整体判断
+	_, groupOnly := endpointTimestampMap[groupEndpoint]
+	groupOnly = groupOnly && len(endpointTimestampMap) == 1
+	checkEndpointList := []string{groupEndpoint}
+	if len(endpointList) > 0 && !groupOnly {
+		checkEndpointList = []string{}
+		for _, endpoint := range endpointList {
+			checkEndpointList = append(checkEndpointList, endpoint.Guid)
+		}
+	}
+	for _, endpointGuid := range checkEndpointList {
+		timestampList := endpointTimestampMap[endpointGuid]
+		sort.Slice(timestampList, func(i, j int) bool {
+			return timestampList[i] < timestampList[j]
+		})
+		alarms := []*m.StrategyConditionPreviewAlarm{}
+		prevTimestamp := start
+		for _, timestamp := range timestampList {
+			if timestamp-prevTimestamp > lastSec {
+				alarms = append(alarms, &m.StrategyConditionPreviewAlarm{Start: prevTimestamp + lastSec, End: timestamp})
+			}
+			prevTimestamp = timestamp
+		}
+		if end-prevTimestamp > lastSec {
+			alarms = append(alarms, &m.StrategyConditionPreviewAlarm{Start: prevTimestamp + lastSec})
+		}
+		result = append(result, buildBacktestSeries(conditionIndex, metric, endpointGuid, "", alarms, end))
+	}
+	return
+}
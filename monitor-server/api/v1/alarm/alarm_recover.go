@@ -0,0 +1,138 @@
+package alarm
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkStrategyRecover 阈值配置了恢复条件或恢复持续时间时,恢复持续时间内的值都要满足恢复条件才算恢复,
+// 没配置恢复持续时间只看最新的值,没配置恢复条件时不满足告警条件即满足恢复条件
+func checkStrategyRecover(strategyObj *models.AlarmStrategyMetricObj, promQl, conditionType, anomalyWindow string, seriesTags map[string]string, condition string, threshold float64) (recovered, hasData bool, endValue float64) {
+	recoverCondition, recoverThreshold, illegal := analyzeCondition(strategyObj.RecoverCondition)
+	if strategyObj.RecoverCondition == "" || illegal {
+		recoverCondition = ""
+	}
+	recoverSec := analyzeLast(strategyObj.RecoverLast)
+	endTime := time.Now().Unix()
+	startTime := endTime - recoverSec
+	if recoverSec == 0 {
+		startTime = endTime - 60
+	}
+	queryData, queryErr := db.QueryStrategyConditionDataByDatasource(db.GetMetricDatasource(strategyObj.Metric), promQl, conditionType, anomalyWindow, startTime, endTime)
+	if queryErr != nil {
+		log.Logger.Warn("checkStrategyRecover query prometheus data fail", log.String("alarmStrategy", strategyObj.Guid), log.Error(queryErr))
+		return
+	}
+	for _, queryObj := range queryData.Result {
+		if !matchRecoverSeries(queryObj.Metric, seriesTags) {
+			continue
+		}
+		var valueList []float64
+		for _, v := range queryObj.Values {
+			if tmpValue, tmpParseErr := strconv.ParseFloat(v[1].(string), 64); tmpParseErr == nil {
+				valueList = append(valueList, tmpValue)
+			}
+		}
+		if len(valueList) == 0 {
+			continue
+		}
+		if recoverSec == 0 {
+			valueList = valueList[len(valueList)-1:]
+		}
+		hasData, recovered = true, true
+		for _, value := range valueList {
+			endValue = value
+			if recoverCondition != "" {
+				recovered = compareFloatValue(value, recoverThreshold, recoverCondition)
+			} else {
+				recovered = !compareFloatValue(value, threshold, condition)
+			}
+			if !recovered {
+				break
+			}
+		}
+		break
+	}
+	return
+}
+
+// matchRecoverSeries 查询结果的标签都要和告警的标签一致
+func matchRecoverSeries(metric, seriesTags map[string]string) bool {
+	for k, v := range metric {
+		if k == "__name__" || k == "job" || k == "instance" {
+			continue
+		}
+		if seriesTags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func hasStrategyRecoverConfig(strategyObj *models.AlarmStrategyMetricObj) bool {
+	return strategyObj.RecoverCondition != "" || strategyObj.RecoverLast != ""
+}
+
+// doAlarmRecoverPendingJob Prometheus规则告警收到恢复后,按阈值配置的恢复条件确认恢复
+func doAlarmRecoverPendingJob() {
+	alarmRows, err := db.GetAlarmRecoverPendingList()
+	if err != nil {
+		log.Logger.Warn("doAlarmRecoverPendingJob fail", log.Error(err))
+		return
+	}
+	var alarmList []*models.AlarmHandleObj
+	for _, row := range alarmRows {
+		// 恢复条件和持续时间按产生告警的条件取
+		strategyObj, getStrategyErr := db.GetAlarmStrategyByAlarm(row)
+		if getStrategyErr != nil {
+			log.Logger.Warn("doAlarmRecoverPendingJob get strategy fail", log.Int("alarmId", row.Id), log.Error(getStrategyErr))
+			continue
+		}
+		nowTime := time.Now()
+		recoverSec := analyzeLast(strategyObj.RecoverLast)
+		if nowTime.Sub(row.RecoverTime) < time.Duration(recoverSec)*time.Second {
+			continue
+		}
+		recovered, hasData, endValue := true, false, float64(0)
+		condition, threshold, illegal := analyzeCondition(strings.ReplaceAll(row.SCond, " ", ""))
+		endpointObj, getEndpointErr := db.GetEndpointNew(&models.EndpointNewTable{Guid: row.Endpoint})
+		if !illegal && getEndpointErr == nil {
+			promQl := db.ReplacePromQlKeyword(row.SExpr, row.SMetric, &endpointObj, nil)
+			if !strings.Contains(promQl, "$") {
+				// 异常检测条件比较的是偏离值,按条件类型查询
+				recovered, hasData, endValue = checkStrategyRecover(&strategyObj, promQl, strategyObj.ConditionType, strategyObj.AnomalyWindow, getAlarmTagMap(row.Tags), condition, threshold)
+			}
+		}
+		// 查不到数据时按恢复持续时间内没有再次告警来确认恢复
+		if hasData && !recovered {
+			continue
+		}
+		alarmList = append(alarmList, &models.AlarmHandleObj{AlarmTable: models.AlarmTable{Id: row.Id, AlarmStrategy: row.AlarmStrategy, StrategyId: row.StrategyId, Status: "ok", EndValue: endValue, End: nowTime},
+			NotifyEnable: strategyObj.NotifyEnable, NotifyDelay: strategyObj.NotifyDelaySecond})
+	}
+	if len(alarmList) == 0 {
+		return
+	}
+	alarmList = db.UpdateAlarms(alarmList)
+	db.CorrelateAlarmIncident(alarmList)
+	for _, v := range alarmList {
+		if v.NotifyEnable == 0 {
+			continue
+		}
+		go db.NotifyStrategyAlarm(v)
+	}
+}
+
+func getAlarmTagMap(tags string) map[string]string {
+	tagMap := make(map[string]string)
+	for _, tag := range strings.Split(tags, "^") {
+		if kvIndex := strings.Index(tag, ":"); kvIndex > 0 {
+			tagMap[tag[:kvIndex]] = tag[kvIndex+1:]
+		}
+	}
+	return tagMap
+}
@@ -0,0 +1,90 @@
+package config_new
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func DatasourceList(c *gin.Context) {
+	result, err := db.ListMonitorDatasource()
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+// DatasourceCreate 数据源的地址和token所有图表和告警都会用到,只允许管理员增删改
+func DatasourceCreate(c *gin.Context) {
+	if !middleware.IsAdminOperator(c) {
+		middleware.ReturnValidateError(c, "only admin can change datasource")
+		return
+	}
+	var param models.MonitorDatasourceTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	err := db.CreateMonitorDatasource(&param, middleware.GetOperateUser(c))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, param)
+	}
+}
+
+func DatasourceUpdate(c *gin.Context) {
+	if !middleware.IsAdminOperator(c) {
+		middleware.ReturnValidateError(c, "only admin can change datasource")
+		return
+	}
+	var param models.MonitorDatasourceTable
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Guid == "" {
+		middleware.ReturnParamEmptyError(c, "guid")
+		return
+	}
+	err := db.UpdateMonitorDatasource(&param, middleware.GetOperateUser(c))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DatasourceDelete(c *gin.Context) {
+	if !middleware.IsAdminOperator(c) {
+		middleware.ReturnValidateError(c, "only admin can change datasource")
+		return
+	}
+	guid := c.Query("guid")
+	if guid == "" {
+		middleware.ReturnParamEmptyError(c, "guid")
+		return
+	}
+	err := db.DeleteMonitorDatasource(guid)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DatasourceCheck(c *gin.Context) {
+	guid := c.Query("guid")
+	if guid == "" {
+		middleware.ReturnParamEmptyError(c, "guid")
+		return
+	}
+	result, err := db.CheckMonitorDatasource(guid)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	mid "github.com/WeBankPartners/open-monitor/monitor-server/middleware"
 	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
 	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
-	ds "github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
 	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
 	"github.com/gin-gonic/gin"
 	"io/ioutil"
@@ -418,7 +417,7 @@ func getPieData(paramConfig *m.PieChartConfigObj) (result []*m.QueryMonitorData,
 			return
 		}
 		result[0].ChartType = "line"
-		serialList := ds.PrometheusData(result[0])
+		serialList, _, _ := db.QueryMonitorSerialData(result[0], paramConfig.PieAggType)
 		if len(serialList) > 0 {
 			valueMap := make(map[float64]int)
 			for _, v := range serialList[0].Data {
@@ -439,7 +438,7 @@ func getPieData(paramConfig *m.PieChartConfigObj) (result []*m.QueryMonitorData,
 	} else {
 		for _, queryObj := range result {
 			log.Logger.Info("queryObj", log.JsonObj("data", queryObj))
-			ds.PrometheusData(queryObj)
+			db.QueryMonitorSerialData(queryObj, paramConfig.PieAggType)
 		}
 	}
 	return
@@ -611,7 +610,7 @@ func GetChartsByEndpoint(c *gin.Context) {
 	}
 	// Query data
 	log.Logger.Debug("Query param", log.StringList("endpoint", query.Endpoint), log.StringList("metric", query.Metric), log.Int64("start", query.Start), log.Int64("end", query.End), log.String("promQl", query.PromQ))
-	serials := ds.PrometheusData(&query)
+	serials, _, _ := db.QueryMonitorSerialData(&query, c.Query("agg"))
 	for _, s := range serials {
 		if strings.Contains(s.Name, "$metric") {
 			s.Name = strings.Replace(s.Name, "$metric", metric, -1)
@@ -9,11 +9,17 @@ import (
 	ds "github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
 	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
 	"github.com/gin-gonic/gin"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// QueryCacheMetrics 看板查询缓存的命中指标,供Prometheus采集
+func QueryCacheMetrics(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(ds.QueryCacheMetrics()))
+}
+
 func ChartList(c *gin.Context) {
 	var id, groupId int
 	if c.Query("id") != "" {
@@ -201,16 +207,23 @@ func GetCustomChartConfig(param *models.ChartQueryParam, result *models.EChartOp
 		err = getErr
 		return
 	}
+	var variableValueMap map[string]string
+	if chartSeries, variableValueMap, err = db.ResolveChartSeriesVariables(param.CustomDashboard, param.Variables, chartSeries); err != nil {
+		return
+	}
 	if len(chartSeries) == 0 {
 		log.Logger.Warn("Can not find chart series", log.String("guid", param.CustomChartGuid))
 		return
 	}
+	defer func() { replaceQueryVariables(queryList, variableValueMap) }()
 	err = chartCompare(param)
 	if err != nil {
 		return
 	}
 	//param.Aggregate = chartList[0].AggType
 	param.Unit = chartObj.Unit
+	// 图表配置了数据源时按图表的数据源查询,多个数据源时分别查询后合并
+	chartDatasourceList := db.SplitDatasourceList(chartObj.Datasource)
 	result.Title = chartObj.Name
 	queryList = []*models.QueryMonitorData{}
 	legend := "$custom"
@@ -231,7 +244,7 @@ func GetCustomChartConfig(param *models.ChartQueryParam, result *models.EChartOp
 			log.Logger.Debug("getChartConfigByCustom $app_metric")
 			legend = "$app_metric"
 			tmpPromQl = db.ReplacePromQlKeyword(tmpPromQl, dataConfig.Metric, &models.EndpointNewTable{}, dataConfig.Tags)
-			queryList = append(queryList, &models.QueryMonitorData{Start: param.Start, End: param.End, PromQ: tmpPromQl, Legend: legend, Metric: []string{dataConfig.Metric}, Endpoint: []string{dataConfig.Endpoint}, CompareLegend: param.Compare.CompareFirstLegend, SameEndpoint: true, Step: param.Step, Cluster: "default", CustomDashboard: true, Tags: tmpTags})
+			queryList = append(queryList, &models.QueryMonitorData{Start: param.Start, End: param.End, PromQ: tmpPromQl, Legend: legend, Metric: []string{dataConfig.Metric}, Endpoint: []string{dataConfig.Endpoint}, CompareLegend: param.Compare.CompareFirstLegend, SameEndpoint: true, Step: param.Step, Cluster: "default", CustomDashboard: true, Tags: tmpTags, DatasourceList: chartDatasourceList})
 		} else {
 			endpointList := []*models.EndpointNewTable{}
 			if dataConfig.ServiceGroup == "" {
@@ -253,13 +266,23 @@ func GetCustomChartConfig(param *models.ChartQueryParam, result *models.EChartOp
 			}
 			for _, endpoint := range endpointList {
 				tmpPromQL := db.ReplacePromQlKeyword(tmpPromQl, dataConfig.Metric, endpoint, dataConfig.Tags)
-				queryList = append(queryList, &models.QueryMonitorData{Start: param.Start, End: param.End, PromQ: tmpPromQL, Legend: legend, Metric: []string{dataConfig.Metric}, Endpoint: []string{endpoint.Guid}, Step: endpoint.Step, Cluster: endpoint.Cluster, CustomDashboard: true})
+				queryList = append(queryList, &models.QueryMonitorData{Start: param.Start, End: param.End, PromQ: tmpPromQL, Legend: legend, Metric: []string{dataConfig.Metric}, Endpoint: []string{endpoint.Guid}, Step: endpoint.Step, Cluster: endpoint.Cluster, CustomDashboard: true, DatasourceList: chartDatasourceList})
 			}
 		}
 	}
 	return
 }
 
+// replaceQueryVariables 指标表达式里引用的看板变量(如间隔)在生成查询后替换
+func replaceQueryVariables(queryList []*models.QueryMonitorData, variableValueMap map[string]string) {
+	if len(variableValueMap) == 0 {
+		return
+	}
+	for _, query := range queryList {
+		query.PromQ = db.ReplaceDashboardVariable(query.PromQ, variableValueMap)
+	}
+}
+
 func chartCompare(param *models.ChartQueryParam) error {
 	var err error
 	if param.Compare == nil {
@@ -302,6 +325,13 @@ func GetChartConfigByCustom(param *models.ChartQueryParam) (queryList []*models.
 	queryList = []*models.QueryMonitorData{}
 	var endpointList []*models.EndpointNewTable
 	var serviceGroupTag string
+	// 图表配置引用看板变量时先换成变量的值,表达式里剩下的引用在生成查询后替换
+	variableValueMap, resolveErr := db.ResolveChartQueryVariables(param)
+	if resolveErr != nil {
+		err = resolveErr
+		return
+	}
+	defer func() { replaceQueryVariables(queryList, variableValueMap) }()
 	for _, dataConfig := range param.Data {
 		endpointList = []*models.EndpointNewTable{}
 		tmpMonitorType := dataConfig.EndpointType
@@ -429,7 +459,9 @@ func GetChartComparisonQueryData(queryList []*models.QueryMonitorData, param mod
 		if query.Cluster != "" && query.Cluster != "default" {
 			query.Cluster = db.GetClusterAddress(query.Cluster)
 		}
-		curResultList := mergePrometheusData(param.CalcPeriod, param.CalcMethod, ds.PrometheusData(query))
+		db.FillQueryDatasource(query)
+		curSerials, _, _ := db.QueryMonitorSerialData(query, param.CalcMethod)
+		curResultList := mergePrometheusData(param.CalcPeriod, param.CalcMethod, curSerials)
 		switch param.ComparisonType {
 		case "day":
 			difference = 86400
@@ -441,7 +473,8 @@ func GetChartComparisonQueryData(queryList []*models.QueryMonitorData, param mod
 		}
 		query.Start = query.Start - difference
 		query.End = query.End - difference
-		historyResultList := mergePrometheusData(param.CalcPeriod, param.CalcMethod, ds.PrometheusData(query))
+		historySerials, _, _ := db.QueryMonitorSerialData(query, param.CalcMethod)
+		historyResultList := mergePrometheusData(param.CalcPeriod, param.CalcMethod, historySerials)
 		var comparisonSerialList []*models.SerialModel
 		// 计算同环比数据
 		if len(historyResultList) == 0 || len(curResultList) == 0 {
@@ -541,10 +574,6 @@ func GetChartQueryData(queryList []*models.QueryMonitorData, param *models.Chart
 	serials := []*models.SerialModel{}
 	var err error
 	var logType string
-	archiveQueryFlag := false
-	if param.Start < (time.Now().Unix()-models.Config().ArchiveMysql.LocalStorageMaxDay*86400) && db.ArchiveEnable {
-		archiveQueryFlag = true
-	}
 	startTimestamp := float64(param.Start * 1000)
 	endTimestamp := float64(param.End * 1000)
 	for _, query := range queryList {
@@ -552,17 +581,7 @@ func GetChartQueryData(queryList []*models.QueryMonitorData, param *models.Chart
 		if query.Cluster != "" && query.Cluster != "default" {
 			query.Cluster = db.GetClusterAddress(query.Cluster)
 		}
-		if archiveQueryFlag {
-			// Query db archive data
-			tmpErr, tmpStep, tmpSerials := db.GetArchiveData(query, param.Aggregate)
-			if tmpErr != nil {
-				err = tmpErr
-				break
-			}
-			serials = append(serials, tmpSerials...)
-			param.Step = tmpStep
-			continue
-		}
+		db.FillQueryDatasource(query)
 		if param.LineType == 2 {
 			query.ComparisonFlag = "Y"
 		}
@@ -573,37 +592,16 @@ func GetChartQueryData(queryList []*models.QueryMonitorData, param *models.Chart
 			}
 			query.ServiceConfiguration = logType
 		}
-		tmpSerials := ds.PrometheusData(query)
-		// 如果归档数据可用，尝试从归档数据中补全数据
-		if db.ArchiveEnable {
-			if len(tmpSerials) > 0 {
-				if len(tmpSerials[0].Data) > 0 {
-					tmpSerialDataStart := int64(tmpSerials[0].Data[0][0]) / 1000
-					// 如果查出来的数据时间和查询时间对不上，说明缺了一些数据，尝试从归档数据中去查
-					if tmpSerialDataStart > (param.Start + 120) {
-						_, _, tmpArchiveSerials := db.GetArchiveData(&models.QueryMonitorData{Start: query.Start, End: tmpSerialDataStart, Endpoint: query.Endpoint, Metric: query.Metric, Legend: query.Legend, CompareLegend: query.CompareLegend, SameEndpoint: query.SameEndpoint}, param.Aggregate)
-						for _, tmpSerial := range tmpArchiveSerials {
-							if len(tmpSerial.Data) > 0 {
-								param.Aggregate = "none"
-								for si, vv := range tmpSerials {
-									if tmpSerial.Name == vv.Name {
-										tmpSerials[si].Data = append(tmpSerial.Data, vv.Data...)
-									}
-								}
-							}
-						}
-					}
-				}
-			} else {
-				tmpErr, tmpStep, tmpSerials := db.GetArchiveData(&models.QueryMonitorData{Start: query.Start, End: query.End, Endpoint: query.Endpoint, Metric: query.Metric, Legend: query.Legend, CompareLegend: query.CompareLegend, SameEndpoint: query.SameEndpoint}, param.Aggregate)
-				if tmpErr != nil {
-					err = tmpErr
-					break
-				}
-				serials = append(serials, tmpSerials...)
-				param.Step = tmpStep
-				continue
-			}
+		// 超过本地存储天数或Prometheus缺数的部分从归档库补齐
+		tmpSerials, tmpArchiveStep, tmpErr := db.QueryMonitorSerialData(query, param.Aggregate)
+		if tmpErr != nil {
+			err = tmpErr
+			break
+		}
+		if tmpArchiveStep > 0 {
+			// 归档数据已经是按分钟聚合过的
+			param.Step = tmpArchiveStep
+			param.Aggregate = "none"
 		}
 		// 如果数据前后不是开始结束时间，补齐前后两个点
 		if param.Compare != nil && param.Compare.CompareSubTime > 0 {
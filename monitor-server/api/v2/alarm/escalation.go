@@ -0,0 +1,67 @@
+package alarm
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+	"strings"
+)
+
+func ListAlarmEscalation(c *gin.Context) {
+	result, err := db.ListAlarmEscalation(c.Query("alarm_strategy"), c.Query("endpoint_group"))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+func CreateAlarmEscalation(c *gin.Context) {
+	var param models.AlarmEscalationObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	param.Guid = ""
+	param.Name = strings.TrimSpace(param.Name)
+	if err := db.ValidateAlarmEscalation(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.CreateAlarmEscalation(&param, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func UpdateAlarmEscalation(c *gin.Context) {
+	var param models.AlarmEscalationObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Guid == "" {
+		middleware.ReturnValidateError(c, "guid can not empty")
+		return
+	}
+	param.Name = strings.TrimSpace(param.Name)
+	if err := db.ValidateAlarmEscalation(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.UpdateAlarmEscalation(&param, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DeleteAlarmEscalation(c *gin.Context) {
+	if err := db.DeleteAlarmEscalation(c.Param("escalationGuid")); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
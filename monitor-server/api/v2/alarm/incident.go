@@ -0,0 +1,98 @@
+package alarm
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"strings"
+)
+
+func ListAlarmCorrelationRule(c *gin.Context) {
+	result, err := db.ListAlarmCorrelationRule()
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+func CreateAlarmCorrelationRule(c *gin.Context) {
+	var param models.AlarmCorrelationRuleObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	param.Name = strings.TrimSpace(param.Name)
+	if err := db.ValidateAlarmCorrelationRule(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.CreateAlarmCorrelationRule(&param, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func UpdateAlarmCorrelationRule(c *gin.Context) {
+	var param models.AlarmCorrelationRuleObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Guid == "" {
+		middleware.ReturnValidateError(c, "guid can not empty")
+		return
+	}
+	param.Name = strings.TrimSpace(param.Name)
+	if err := db.ValidateAlarmCorrelationRule(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.UpdateAlarmCorrelationRule(&param, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DeleteAlarmCorrelationRule(c *gin.Context) {
+	if err := db.DeleteAlarmCorrelationRule(c.Param("ruleGuid")); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func ListAlarmIncident(c *gin.Context) {
+	var param models.AlarmIncidentQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.PageSize == 0 {
+		param.PageSize = 20
+	}
+	pageInfo, rowData, err := db.ListAlarmIncident(&param)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func GetAlarmIncident(c *gin.Context) {
+	incidentId, err := strconv.Atoi(c.Param("incidentId"))
+	if err != nil || incidentId <= 0 {
+		middleware.ReturnValidateError(c, "incidentId illegal")
+		return
+	}
+	result, err := db.GetAlarmIncident(incidentId)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
@@ -0,0 +1,61 @@
+package alarm
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func ListNotifyChannelType(c *gin.Context) {
+	middleware.ReturnSuccessData(c, db.ListNotifyChannelType())
+}
+
+func TestNotifyChannel(c *gin.Context) {
+	var param models.NotifyChannelTestParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	// 没有保存过的渠道会往页面填的任意地址发请求,只允许管理员测试
+	if param.Channel.Guid == "" && !middleware.IsAdminOperator(c) {
+		middleware.ReturnValidateError(c, "only admin can test unsaved notify channel")
+		return
+	}
+	attempt, err := db.TestNotifyChannel(&param, middleware.GetOperateUser(c))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, attempt)
+	}
+}
+
+func ListNotifyDelivery(c *gin.Context) {
+	var param models.NotifyDeliveryQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.PageSize == 0 {
+		param.PageSize = 20
+	}
+	pageInfo, rowData, err := db.ListNotifyDelivery(&param)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func RetryNotifyDelivery(c *gin.Context) {
+	var param models.NotifyDeliveryRetryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.RetryNotifyDelivery(param.Ids, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
@@ -0,0 +1,90 @@
+package alarm
+
+import (
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+func ListAlarmSilence(c *gin.Context) {
+	var param models.AlarmSilenceQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.PageSize == 0 {
+		param.PageSize = 20
+	}
+	pageInfo, rowData, err := db.ListAlarmSilence(&param)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, rowData)
+	}
+}
+
+func CreateAlarmSilence(c *gin.Context) {
+	var param models.AlarmSilenceObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	startTime, endTime, err := db.ValidateAlarmSilence(&param)
+	if err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err = db.CreateAlarmSilence(&param, startTime, endTime, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func UpdateAlarmSilence(c *gin.Context) {
+	var param models.AlarmSilenceObj
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Guid == "" {
+		middleware.ReturnValidateError(c, "guid can not empty")
+		return
+	}
+	startTime, endTime, err := db.ValidateAlarmSilence(&param)
+	if err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err = db.UpdateAlarmSilence(&param, startTime, endTime, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func ExpireAlarmSilence(c *gin.Context) {
+	if err := db.ExpireAlarmSilence(c.Param("silenceGuid"), middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func DeleteAlarmSilence(c *gin.Context) {
+	if err := db.DeleteAlarmSilence(c.Param("silenceGuid")); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccess(c)
+	}
+}
+
+func ListAlarmSilenceMuted(c *gin.Context) {
+	result, err := db.ListAlarmSilenceMuted(c.Param("silenceGuid"))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
@@ -68,6 +68,14 @@ func CreateAlarmStrategy(c *gin.Context) {
 		middleware.ReturnValidateError(c, err.Error())
 		return
 	}
+	if err := validateStrategyRecover(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.ValidateNotifyChannelList(param.NotifyList); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	if err := db.ValidateAlarmStrategyName(&param); err != nil {
 		middleware.ReturnValidateError(c, err.Error())
 		return
@@ -107,6 +115,14 @@ func UpdateAlarmStrategy(c *gin.Context) {
 		middleware.ReturnValidateError(c, err.Error())
 		return
 	}
+	if err := validateStrategyRecover(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if err := db.ValidateNotifyChannelList(param.NotifyList); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	if err := db.ValidateAlarmStrategyName(&param); err != nil {
 		middleware.ReturnValidateError(c, err.Error())
 		return
@@ -126,14 +142,55 @@ func UpdateAlarmStrategy(c *gin.Context) {
 
 func validateStrategyCondition(strategyList []*models.StrategyConditionObj) (err error) {
 	for _, v := range strategyList {
+		if v.ConditionType == models.StrategyConditionNoData {
+			if v.Last == "" || !middleware.IsIllegalLast(v.Last) {
+				err = fmt.Errorf("nodata last: %s illegal", v.Last)
+				return
+			}
+			continue
+		}
 		if !middleware.IsIllegalCond(v.Condition) || !middleware.IsIllegalLast(v.Last) {
 			err = fmt.Errorf("condition: %s or last: %s illegal", v.Condition, v.Last)
 			return
 		}
+		if v.ConditionType != "" && !models.IsStrategyAnomalyCondition(v.ConditionType) {
+			err = fmt.Errorf("condition_type: %s illegal", v.ConditionType)
+			return
+		}
+		if v.AnomalyWindow != "" && !middleware.IsIllegalLast(v.AnomalyWindow) {
+			err = fmt.Errorf("anomaly_window: %s illegal", v.AnomalyWindow)
+			return
+		}
 	}
 	return
 }
 
+func validateStrategyRecover(param *models.GroupStrategyObj) (err error) {
+	if err = validateRecoverConfig(&param.RecoverCondition, param.RecoverLast, param.FlapCount, param.FlapWindow); err != nil {
+		return
+	}
+	for _, condition := range param.Conditions {
+		if err = validateRecoverConfig(&condition.RecoverCondition, condition.RecoverLast, condition.FlapCount, condition.FlapWindow); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func validateRecoverConfig(recoverCondition *string, recoverLast string, flapCount, flapWindow int) error {
+	*recoverCondition = strings.ReplaceAll(*recoverCondition, " ", "")
+	if *recoverCondition != "" && !middleware.IsIllegalCond(*recoverCondition) {
+		return fmt.Errorf("recover_condition: %s illegal", *recoverCondition)
+	}
+	if recoverLast != "" && !middleware.IsIllegalLast(recoverLast) {
+		return fmt.Errorf("recover_last: %s illegal", recoverLast)
+	}
+	if flapCount < 0 || (flapCount > 0 && flapWindow <= 0) {
+		return fmt.Errorf("flap_count: %d or flap_window: %d illegal", flapCount, flapWindow)
+	}
+	return nil
+}
+
 func DeleteAlarmStrategy(c *gin.Context) {
 	strategyGuid := c.Param("strategyGuid")
 	endpointGroup, err := db.DeleteAlarmStrategy(strategyGuid)
@@ -185,6 +185,10 @@ func GetCustomDashboard(c *gin.Context) {
 	} else {
 		customDashboardDto.PanelGroupList = strings.Split(customDashboard.PanelGroups, ",")
 	}
+	if customDashboardDto.Variables, err = db.ListCustomDashboardVariable(id); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
 	if boardRoleRelList, err = db.QueryCustomDashboardPermissionByDashboard(id); err != nil {
 		middleware.ReturnServerHandleError(c, err)
 		return
@@ -531,6 +535,63 @@ func ExportCustomDashboard(c *gin.Context) {
 	var err error
 	var param models.CustomDashboardExportParam
 	var result *models.CustomDashboardExportDto
+	if err = c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if param.Id == 0 {
+		middleware.ReturnParamEmptyError(c, "param id")
+		return
+	}
+	if result, err = getCustomDashboardExportData(param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if result == nil {
+		middleware.ReturnValidateError(c, "id is invalid")
+		return
+	}
+	b, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		middleware.ReturnHandleError(c, "export custom dashboard fail, json marshal object error", marshalErr)
+		return
+	}
+	c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%d_%s.json", result.Id, time.Now().Format("20060102150405")))
+	c.Data(http.StatusOK, "application/octet-stream", b)
+}
+
+// ExportGrafanaDashboard 看板导出为Grafana看板json
+func ExportGrafanaDashboard(c *gin.Context) {
+	var err error
+	var param models.CustomDashboardExportParam
+	var result *models.CustomDashboardExportDto
+	if err = c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Id == 0 {
+		middleware.ReturnParamEmptyError(c, "param id")
+		return
+	}
+	if result, err = getCustomDashboardExportData(param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if result == nil {
+		middleware.ReturnValidateError(c, "id is invalid")
+		return
+	}
+	b, marshalErr := json.MarshalIndent(db.BuildGrafanaDashboard(result), "", "  ")
+	if marshalErr != nil {
+		middleware.ReturnHandleError(c, "export grafana dashboard fail, json marshal object error", marshalErr)
+		return
+	}
+	c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=grafana_%d_%s.json", result.Id, time.Now().Format("20060102150405")))
+	c.Data(http.StatusOK, "application/octet-stream", b)
+}
+
+// getCustomDashboardExportData 查询看板和指定图表的配置,看板不存在时返回nil
+func getCustomDashboardExportData(param models.CustomDashboardExportParam) (result *models.CustomDashboardExportDto, err error) {
 	var customDashboard *models.CustomDashboardTable
 	var customChartExtendList []*models.CustomChartExtend
 	var configMap = make(map[string][]*models.CustomChartSeriesConfig)
@@ -540,21 +601,11 @@ func ExportCustomDashboard(c *gin.Context) {
 	var dashboardPermissionList []*models.CustomDashBoardRoleRel
 	var useRoles []string
 	var mgmtRole string
-	if err = c.ShouldBindJSON(&param); err != nil {
-		middleware.ReturnServerHandleError(c, err)
-		return
-	}
-	if param.Id == 0 {
-		middleware.ReturnParamEmptyError(c, "param id")
-		return
-	}
 	// 获取自定义看板
 	if customDashboard, err = db.GetCustomDashboardById(param.Id); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
 	if customDashboard == nil || customDashboard.Id == 0 {
-		middleware.ReturnValidateError(c, "id is invalid")
 		return
 	}
 	if len(param.ChartIds) > 0 {
@@ -563,7 +614,6 @@ func ExportCustomDashboard(c *gin.Context) {
 		}
 	}
 	if dashboardPermissionList, err = db.QueryCustomDashboardRoleRelByCustomDashboard(customDashboard.Id); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
 	for _, role := range dashboardPermissionList {
@@ -586,53 +636,42 @@ func ExportCustomDashboard(c *gin.Context) {
 	if customDashboard.LogMetricGroup != nil {
 		result.LogMetricGroup = *customDashboard.LogMetricGroup
 	}
+	if result.Variables, err = db.ListCustomDashboardVariable(customDashboard.Id); err != nil {
+		return
+	}
 	if customChartExtendList, err = db.QueryCustomChartListByDashboard(customDashboard.Id); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
 	if configMap, err = db.QueryAllChartSeriesConfig(); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
 	if tagMap, err = db.QueryAllChartSeriesTag(); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
 	if tagValueMap, err = db.QueryAllChartSeriesTagValue(); err != nil {
-		middleware.ReturnServerHandleError(c, err)
 		return
 	}
-	if len(customChartExtendList) > 0 {
-		for _, chartExtend := range customChartExtendList {
-			// 只导出指定图表数据
-			if exportChartIdMap[chartExtend.Guid] {
-				chart, err2 := db.CreateCustomChartDto(chartExtend, configMap, tagMap, tagValueMap)
-				if err2 != nil {
-					middleware.ReturnServerHandleError(c, err)
-					return
-				}
-				if chart != nil {
-					result.Charts = append(result.Charts, chart)
-				}
-			}
+	for _, chartExtend := range customChartExtendList {
+		// 只导出指定图表数据
+		if !exportChartIdMap[chartExtend.Guid] {
+			continue
+		}
+		chart, chartErr := db.CreateCustomChartDto(chartExtend, configMap, tagMap, tagValueMap)
+		if chartErr != nil {
+			err = chartErr
+			return
+		}
+		if chart != nil {
+			result.Charts = append(result.Charts, chart)
 		}
 	}
-	b, marshalErr := json.Marshal(result)
-	if marshalErr != nil {
-		middleware.ReturnHandleError(c, "export custom dashboard fail, json marshal object error", marshalErr)
-		return
-	}
-	c.Writer.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%d_%s.json", result.Id, time.Now().Format("20060102150405")))
-	c.Data(http.StatusOK, "application/octet-stream", b)
+	return
 }
 
 func ImportCustomDashboard(c *gin.Context) {
 	var param *models.CustomDashboardExportDto
 	var customDashboard *models.CustomDashboardTable
 	var importRes *models.CustomDashboardImportRes
-	var customDashboardList []*models.CustomDashboardTable
-	var permissionMap map[string]bool
-	var hasPerm bool
 	rule, _ := c.GetPostForm("rule")
 	useRoleStr, _ := c.GetPostForm("useRoles")
 	mgmtRole, _ := c.GetPostForm("mgmtRoles")
@@ -671,27 +710,10 @@ func ImportCustomDashboard(c *gin.Context) {
 		return
 	}
 	// 判断操作人是否有覆盖看板权限
-	if customDashboardList, err = db.QueryCustomDashboardListByName(param.Name); err != nil {
+	if err = checkImportCoverPermission(c, param.Name, rule); err != nil {
+		middleware.ReturnServerHandleError(c, err)
 		return
 	}
-	if rule == string(models.ImportRuleCover) && len(customDashboardList) > 0 {
-		if permissionMap, err = db.GetDashboardPermissionMap(customDashboardList[0].Id, string(models.PermissionMgmt)); err != nil {
-			middleware.ReturnServerHandleError(c, err)
-			return
-		}
-		if len(permissionMap) == 0 {
-			permissionMap = make(map[string]bool)
-		}
-		for _, userRole := range middleware.GetOperateUserRoles(c) {
-			if permissionMap[userRole] {
-				hasPerm = true
-			}
-		}
-		if !hasPerm {
-			middleware.ReturnServerHandleError(c, fmt.Errorf("dashboard %s no edit permission", param.Name))
-			return
-		}
-	}
 	errMsgObj := middleware.GetMessageMap(c)
 	if customDashboard, importRes, err = db.ImportCustomDashboard(param, middleware.GetOperateUser(c), rule, mgmtRole, useRoles, errMsgObj); err != nil {
 		middleware.ReturnServerHandleError(c, err)
@@ -708,6 +730,102 @@ func ImportCustomDashboard(c *gin.Context) {
 	middleware.ReturnSuccess(c)
 }
 
+// checkImportCoverPermission 覆盖导入同名看板时,操作人要有该看板的管理权限
+func checkImportCoverPermission(c *gin.Context, name, rule string) (err error) {
+	var customDashboardList []*models.CustomDashboardTable
+	var permissionMap map[string]bool
+	if customDashboardList, err = db.QueryCustomDashboardListByName(name); err != nil {
+		return
+	}
+	if rule != string(models.ImportRuleCover) || len(customDashboardList) == 0 {
+		return
+	}
+	if permissionMap, err = db.GetDashboardPermissionMap(customDashboardList[0].Id, string(models.PermissionMgmt)); err != nil {
+		return
+	}
+	for _, userRole := range middleware.GetOperateUserRoles(c) {
+		if permissionMap[userRole] {
+			return
+		}
+	}
+	return fmt.Errorf("dashboard %s no edit permission", name)
+}
+
+// ImportGrafanaDashboard 导入Grafana看板json,表达式里的变量可以通过variables指定对应的对象或层级对象
+func ImportGrafanaDashboard(c *gin.Context) {
+	var param *models.CustomDashboardExportDto
+	var grafanaDashboard *models.GrafanaDashboard
+	var importParam models.GrafanaImportParam
+	var importResult *models.GrafanaImportResult
+	var customDashboard *models.CustomDashboardTable
+	var importRes *models.CustomDashboardImportRes
+	rule, _ := c.GetPostForm("rule")
+	useRoleStr, _ := c.GetPostForm("useRoles")
+	mgmtRole, _ := c.GetPostForm("mgmtRoles")
+	if rule == "" || len(useRoleStr) == 0 || mgmtRole == "" {
+		middleware.ReturnParamEmptyError(c, "rule or permission")
+		return
+	}
+	importParam.Endpoint, _ = c.GetPostForm("endpoint")
+	importParam.ServiceGroup, _ = c.GetPostForm("serviceGroup")
+	if variableStr, _ := c.GetPostForm("variables"); variableStr != "" {
+		if err := json.Unmarshal([]byte(variableStr), &importParam.Variables); err != nil {
+			middleware.ReturnValidateError(c, "variables json unmarshal fail,"+err.Error())
+			return
+		}
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		middleware.ReturnHandleError(c, "file open error ", err)
+		return
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		middleware.ReturnHandleError(c, "read content fail error ", err)
+		return
+	}
+	if grafanaDashboard, err = db.ParseGrafanaDashboard(b); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param, importResult, err = db.ConvertGrafanaDashboard(grafanaDashboard, importParam); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if strings.TrimSpace(param.Name) == "" {
+		middleware.ReturnParamEmptyError(c, "import data is empty")
+		return
+	}
+	if len(param.Charts) == 0 {
+		middleware.ReturnParamEmptyError(c, "import dashboard chart is empty")
+		return
+	}
+	if err = checkImportCoverPermission(c, param.Name, rule); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if customDashboard, importRes, err = db.ImportCustomDashboard(param, middleware.GetOperateUser(c), rule, mgmtRole, strings.Split(useRoleStr, ","), middleware.GetMessageMap(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if customDashboard != nil && customDashboard.Id != 0 {
+		middleware.ReturnServerHandleError(c, fmt.Errorf(middleware.GetMessageMap(c).DashboardIdExistError))
+		return
+	}
+	if importRes != nil {
+		for chartName, metricList := range importRes.ChartMap {
+			importResult.ChartMap[chartName] = append(importResult.ChartMap[chartName], metricList...)
+		}
+	}
+	middleware.ReturnSuccessData(c, importResult)
+}
+
 func TransImportCustomDashboard(c *gin.Context) {
 	var param *models.CustomDashboardExportDto
 	var customDashboard *models.CustomDashboardTable
@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/db"
+	"github.com/gin-gonic/gin"
+)
+
+// ListCustomDashboardVariable 看板变量列表
+func ListCustomDashboardVariable(c *gin.Context) {
+	dashboardId, _ := strconv.Atoi(c.Query("customDashboard"))
+	if dashboardId <= 0 {
+		middleware.ReturnParamEmptyError(c, "customDashboard")
+		return
+	}
+	result, err := db.ListCustomDashboardVariable(dashboardId)
+	if err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	middleware.ReturnSuccessData(c, result)
+}
+
+// SaveCustomDashboardVariable 保存看板变量和默认值,需要看板管理权限
+func SaveCustomDashboardVariable(c *gin.Context) {
+	var param models.CustomDashboardVariableParam
+	var permission bool
+	var err error
+	if err = c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if permission, err = CheckHasDashboardManagePermission(param.CustomDashboard, middleware.GetOperateUserRoles(c), middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if !permission {
+		middleware.ReturnServerHandleError(c, fmt.Errorf("no edit permission"))
+		return
+	}
+	if err = db.SaveCustomDashboardVariable(&param, middleware.GetOperateUser(c)); err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	middleware.ReturnSuccess(c)
+}
+
+// QueryCustomDashboardVariableOptions 变量的可选值,标签值变量按指标标签查,其它从对象、层级对象和对象组表里查
+func QueryCustomDashboardVariableOptions(c *gin.Context) {
+	var param models.DashboardVariableOptionParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	variable, err := db.GetCustomDashboardVariable(param.CustomDashboard, param.Name)
+	if err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	result := []*models.MetricTagValueObj{}
+	if variable.Type != models.DashboardVariableTagValue {
+		if result, err = db.QueryDashboardVariableOptions(variable, param.Variables); err != nil {
+			middleware.ReturnServerHandleError(c, err)
+			return
+		}
+		middleware.ReturnSuccessData(c, result)
+		return
+	}
+	tagParam := models.QueryMetricTagParam{MetricId: variable.MetricGuid}
+	if tagParam.Endpoint, tagParam.ServiceGroup, err = db.ResolveDashboardVariableEndpoint(variable, param.Variables); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	tagValueList, serverErr, err := queryMetricTagValueList(tagParam)
+	if err != nil {
+		returnMetricTagValueError(c, serverErr, err)
+		return
+	}
+	for _, tagValue := range tagValueList {
+		if tagValue.Tag == variable.TagName {
+			result = tagValue.Values
+			break
+		}
+	}
+	middleware.ReturnSuccessData(c, result)
+}
@@ -209,35 +209,49 @@ func ImportMetric(c *gin.Context) {
 
 func QueryMetricTagValue(c *gin.Context) {
 	var param models.QueryMetricTagParam
-	var orginMetricRow *models.MetricTable
-	var logType string
 	if err := c.ShouldBindJSON(&param); err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
 		return
 	}
-	var result []*models.QueryMetricTagResultObj
+	result, serverErr, err := queryMetricTagValueList(param)
+	if err != nil {
+		returnMetricTagValueError(c, serverErr, err)
+		return
+	}
+	middleware.ReturnSuccessData(c, result)
+}
+
+// returnMetricTagValueError 指标无效和查业务配置类型失败按服务端错误返回,其它按处理错误返回
+func returnMetricTagValueError(c *gin.Context, serverErr bool, err error) {
+	if serverErr {
+		middleware.ReturnServerHandleError(c, err)
+	} else {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	}
+}
+
+// queryMetricTagValueList 查指标每个标签的可选值,看板标签值变量的可选项也用它
+func queryMetricTagValueList(param models.QueryMetricTagParam) (result []*models.QueryMetricTagResultObj, serverErr bool, err error) {
+	var orginMetricRow, metricRow *models.MetricTable
+	var logType string
 	if param.MetricId == "" {
-		middleware.ReturnSuccessData(c, result)
 		return
 	}
 	// 查指标有哪些标签
-	metricRow, err := db.GetSimpleMetric(param.MetricId)
-	if err != nil {
-		middleware.ReturnHandleError(c, err.Error(), err)
+	if metricRow, err = db.GetSimpleMetric(param.MetricId); err != nil {
 		return
 	}
 	if metricRow == nil {
-		middleware.ReturnServerHandleError(c, fmt.Errorf("metricId %s is invalid", param.MetricId))
+		err, serverErr = fmt.Errorf("metricId %s is invalid", param.MetricId), true
 		return
 	}
 	if logType, err = db.GetLogTypeByLogMetricGroup(metricRow.LogMetricGroup); err != nil {
-		middleware.ReturnServerHandleError(c, err)
+		serverErr = true
 		return
 	}
 	var tagList []string
 	// 如果是同环比指标需要用原始指标进去查询
 	if orginMetricRow, err = db.GetOriginMetricByComparisonId(param.MetricId); err != nil {
-		middleware.ReturnHandleError(c, err.Error(), err)
 		return
 	}
 	tagConfigValueMap := make(map[string][]string)
@@ -249,12 +263,10 @@ func QueryMetricTagValue(c *gin.Context) {
 		tagList, tagConfigValueMap, err = db.GetMetricTags(metricRow)
 	}
 	if err != nil {
-		middleware.ReturnHandleError(c, err.Error(), err)
 		return
 	}
 	log.Logger.Debug("QueryMetricTagValue", log.StringList("tagList", tagList))
 	if len(tagList) == 0 {
-		middleware.ReturnSuccessData(c, result)
 		return
 	}
 	var endpointObj models.EndpointNewTable
@@ -264,7 +276,6 @@ func QueryMetricTagValue(c *gin.Context) {
 		endpointList, getEndpointListErr := db.GetRecursiveEndpointByTypeNew(param.ServiceGroup, metricRow.MonitorType)
 		if getEndpointListErr != nil {
 			err = fmt.Errorf("Try to get endpoints from object:%s fail,%s ", param.ServiceGroup, getEndpointListErr.Error())
-			middleware.ReturnHandleError(c, err.Error(), err)
 			return
 		}
 		if len(endpointList) > 0 {
@@ -280,7 +291,6 @@ func QueryMetricTagValue(c *gin.Context) {
 	seriesMapList, getSeriesErr := datasource.QueryPromSeries(metricRow.PromExpr)
 	if getSeriesErr != nil {
 		err = fmt.Errorf("query prom series fail,%s ", getSeriesErr)
-		middleware.ReturnHandleError(c, err.Error(), err)
 		return
 	}
 	log.Logger.Debug("QueryPromSeries end", log.JsonObj("result", seriesMapList))
@@ -316,7 +326,7 @@ func QueryMetricTagValue(c *gin.Context) {
 		}
 		result = append(result, &models.QueryMetricTagResultObj{Tag: v, Values: valueObjList})
 	}
-	middleware.ReturnSuccessData(c, result)
+	return
 }
 
 // AddOrUpdateComparisonMetric 添加更新同环比监控配置
@@ -154,6 +154,29 @@ func DeleteTypeConfig(c *gin.Context) {
 	middleware.ReturnSuccess(c)
 }
 
+func UpdateTypeConfigDatasource(c *gin.Context) {
+	var param models.TypeConfigDatasourceParam
+	var typeConfig *models.TypeConfig
+	var err error
+	if err = c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if typeConfig, err = db.GetTypeConfig(param.Guid); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	if typeConfig == nil {
+		middleware.ReturnValidateError(c, "guid invalid")
+		return
+	}
+	if err = db.UpdateTypeConfigDatasource(param); err != nil {
+		middleware.ReturnServerHandleError(c, err)
+		return
+	}
+	middleware.ReturnSuccess(c)
+}
+
 func ConvertArr2Map(list []string) map[string]bool {
 	var hashMap = make(map[string]bool)
 	for _, s := range list {
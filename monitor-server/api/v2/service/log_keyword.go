@@ -52,6 +52,9 @@ func CreateLogKeywordMonitor(c *gin.Context) {
 			break
 		}
 	}
+	if err == nil {
+		err = validateLogMultilineConfig(param.LogMultilineConfig)
+	}
 	if err != nil {
 		middleware.ReturnValidateError(c, err.Error())
 		return
@@ -74,6 +77,10 @@ func UpdateLogKeywordMonitor(c *gin.Context) {
 		middleware.ReturnValidateError(c, fmt.Sprintf("Path:%s illegal ", param.LogPath))
 		return
 	}
+	if err := validateLogMultilineConfig(param.LogMultilineConfig); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	var endpointList []string
 	for _, v := range db.ListLogKeywordEndpointRel(param.Guid) {
 		endpointList = append(endpointList, v.SourceEndpoint)
@@ -114,6 +121,10 @@ func CreateLogKeyword(c *gin.Context) {
 	if len(param.ActiveWindowList) > 0 {
 		param.ActiveWindow = strings.Join(param.ActiveWindowList, ",")
 	}
+	if err = db.ValidateLogKeywordRule(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	var sameNameList, sameKeywordList []*models.LogKeywordConfigTable
 	sameNameList, sameKeywordList, err = db.GetLogKeywordConfigUniqueData(param.Guid, param.Name, param.Keyword, param.LogKeywordMonitor)
 	if err != nil {
@@ -151,6 +162,10 @@ func UpdateLogKeyword(c *gin.Context) {
 	if len(param.ActiveWindowList) > 0 {
 		param.ActiveWindow = strings.Join(param.ActiveWindowList, ",")
 	}
+	if err = db.ValidateLogKeywordRule(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	logKeywordConfig, getExistErr := db.GetSimpleLogKeywordConfig(param.Guid)
 	if getExistErr != nil {
 		middleware.ReturnValidateError(c, getExistErr.Error())
@@ -296,3 +311,36 @@ func UpdateLogKeywordNotify(c *gin.Context) {
 		middleware.ReturnSuccess(c)
 	}
 }
+
+// GetLogPathMatch 查询对象上日志路径当前匹配到的文件,通配符和日期占位符路径可以确认实际采集了哪些文件
+func GetLogPathMatch(c *gin.Context) {
+	endpointGuid := c.Query("endpoint")
+	if endpointGuid == "" {
+		middleware.ReturnValidateError(c, "param endpoint can not empty")
+		return
+	}
+	result, err := db.GetEndpointLogPathMatch(endpointGuid)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+// ListLogKeywordAlarmMatches 分页查看日志关键字告警期间的匹配行及其上下文,agent每个关键字只保留最近200条
+func ListLogKeywordAlarmMatches(c *gin.Context) {
+	var param models.LogKeywordAlarmMatchQueryParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.PageSize == 0 {
+		param.PageSize = 20
+	}
+	pageInfo, result, err := db.GetLogKeywordAlarmMatches(&param)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnPageData(c, pageInfo, result)
+	}
+}
@@ -67,6 +67,9 @@ func CreateLogMetricMonitor(c *gin.Context) {
 			break
 		}
 	}
+	if err == nil {
+		err = validateLogMultilineConfig(param.LogMultilineConfig)
+	}
 	if err != nil {
 		middleware.ReturnValidateError(c, err.Error())
 		return
@@ -88,6 +91,25 @@ func CreateLogMetricMonitor(c *gin.Context) {
 	}
 }
 
+// validateLogMultilineConfig 多行合并的正则要能被agent的pcre编译
+func validateLogMultilineConfig(config models.LogMultilineConfig) error {
+	for _, regular := range []string{config.MultilineStart, config.MultilineContinue} {
+		if regular == "" {
+			continue
+		}
+		if _, compileErr := pcre.Compile(regular, 0); compileErr != nil {
+			return fmt.Errorf("multiline regular:%s illegal,%s ", regular, compileErr.Message)
+		}
+	}
+	if config.MultilineMaxLines < 0 || config.MultilineMaxLines > 1000 {
+		return fmt.Errorf("multiline_max_lines should between 0 and 1000")
+	}
+	if config.MultilineTimeout < 0 || config.MultilineTimeout > 60 {
+		return fmt.Errorf("multiline_timeout should between 0 and 60 seconds")
+	}
+	return nil
+}
+
 func UpdateLogMetricMonitor(c *gin.Context) {
 	var param models.LogMetricMonitorObj
 	var list []*models.LogMetricMonitorTable
@@ -95,6 +117,10 @@ func UpdateLogMetricMonitor(c *gin.Context) {
 		middleware.ReturnValidateError(c, err.Error())
 		return
 	}
+	if err := validateLogMultilineConfig(param.LogMultilineConfig); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	result, err := db.GetLogMetricMonitor(param.Guid)
 	if err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
@@ -252,6 +278,10 @@ func CreateLogMetricConfig(c *gin.Context) {
 		middleware.ReturnValidateError(c, "regular illegal")
 		return
 	}
+	if err := db.ValidateLogMetricAggConfig(param.AggType, ""); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	err := db.CreateLogMetricConfig(&param, middleware.GetOperateUser(c))
 	if err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
@@ -279,6 +309,10 @@ func UpdateLogMetricConfig(c *gin.Context) {
 		middleware.ReturnValidateError(c, "regular illegal")
 		return
 	}
+	if err := db.ValidateLogMetricAggConfig(param.AggType, ""); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	err := db.UpdateLogMetricConfig(&param, middleware.GetOperateUser(c))
 	if err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
@@ -335,6 +369,27 @@ func CheckRegExpMatch(c *gin.Context) {
 		return
 	}
 	result := models.CheckRegExpResult{}
+	if models.IsLogMonitorStructuredType(param.LogType) {
+		// 结构化日志直接按格式解析,返回解析出的字段给参数选择
+		fields, err := db.GetLogStructuredFields(param.LogType, param.RegString, param.Delimiter, param.TestContext)
+		if err != nil {
+			result.MatchText = err.Error()
+		} else {
+			result.MatchText = "success"
+			result.JsonObj = make(map[string]interface{})
+			for k, v := range fields {
+				result.JsonObj[k] = v
+				result.JsonKeyList = append(result.JsonKeyList, k)
+			}
+			sort.Strings(result.JsonKeyList)
+		}
+		middleware.ReturnSuccessData(c, result)
+		return
+	}
+	if param.RegString == "" {
+		middleware.ReturnValidateError(c, "reg_string can not empty")
+		return
+	}
 	var matchString string
 	result.MatchText, matchString = db.CheckRegExpMatchPCRE(param)
 	if strings.HasPrefix(matchString, "{") {
@@ -463,6 +518,10 @@ func ImportLogMetricExcel(c *gin.Context) {
 		if rowIndex == 0 || len(row) < 4 {
 			continue
 		}
+		if err = db.ValidateLogMetricAggConfig(row[3], ""); err != nil {
+			middleware.ReturnValidateError(c, fmt.Sprintf("row:%d %s", rowIndex+1, err.Error()))
+			return
+		}
 		logMetricConfigList = append(logMetricConfigList, &models.LogMetricConfigObj{Metric: row[0], DisplayName: row[1], Regular: row[2], AggType: row[3], LogMetricMonitor: logMonitorGuid})
 	}
 	if len(logMetricConfigList) == 0 {
@@ -531,10 +590,22 @@ func CreateLogMonitorTemplate(c *gin.Context) {
 }
 
 func validateLogMonitorTemplateParam(param *models.LogMonitorTemplateDto) (err error) {
-	if param.LogType != models.LogMonitorJsonType && param.LogType != models.LogMonitorRegularType && param.LogType != models.LogMonitorCustomType {
+	if param.LogType != models.LogMonitorJsonType && param.LogType != models.LogMonitorRegularType && param.LogType != models.LogMonitorCustomType && !models.IsLogMonitorStructuredType(param.LogType) {
 		err = fmt.Errorf("param json type illegal")
 		return
 	}
+	if models.IsLogMonitorStructuredType(param.LogType) {
+		var jsonKeyList []string
+		for _, v := range param.ParamList {
+			jsonKeyList = append(jsonKeyList, v.JsonKey)
+		}
+		if err = db.ValidateLogStructuredParam(param.LogType, param.Delimiter, jsonKeyList); err != nil {
+			return
+		}
+	}
+	if param.LogType != models.LogMonitorCsvType {
+		param.Delimiter = ""
+	}
 	if param.CalcResultObj == nil {
 		err = fmt.Errorf("calc result can not empty")
 		return
@@ -587,6 +658,10 @@ func validateLogMonitorTemplateParam(param *models.LogMonitorTemplateDto) (err e
 				return
 			}
 		}
+		if err = db.ValidateLogMetricAggConfig(v.AggType, ""); err != nil {
+			err = fmt.Errorf("metric: %s %s", v.Metric, err.Error())
+			return
+		}
 		if v.Step == 0 {
 			v.Step = 10
 		}
@@ -594,6 +669,28 @@ func validateLogMonitorTemplateParam(param *models.LogMonitorTemplateDto) (err e
 	return
 }
 
+// validateLogMetricDerivedParam 校验派生指标名和告警阈值,表达式和引用的指标在保存时校验
+func validateLogMetricDerivedParam(derivedList []*models.LogMetricDerivedTable) (err error) {
+	for _, v := range derivedList {
+		v.Metric = strings.TrimSpace(v.Metric)
+		if middleware.IsIllegalMetric(v.Metric) {
+			return fmt.Errorf("derived metric : %s illegal", v.Metric)
+		}
+		if middleware.IsIllegalDisplayName(v.DisplayName) {
+			return fmt.Errorf("derived metric: %s metric displayName: %s illegal", v.Metric, v.DisplayName)
+		}
+		if strings.TrimSpace(v.Expression) == "" {
+			return fmt.Errorf("derived metric: %s expression can not empty", v.Metric)
+		}
+		if v.AutoAlarm {
+			if err = checkThresholdWarnConfigInvalid(v.Metric, v.RangeConfig); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 func checkThresholdWarnConfigInvalid(metric, rangeConfig string) error {
 	temp := &models.ThresholdConfig{}
 	var intTime int
@@ -655,6 +752,88 @@ func DeleteLogMonitorTemplate(c *gin.Context) {
 	}
 }
 
+func ListLogMonitorTemplateVersion(c *gin.Context) {
+	logMonitorTemplateGuid := c.Param("logMonitorTemplateGuid")
+	result, err := db.ListLogMonitorTemplateVersion(logMonitorTemplateGuid)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+func GetLogMonitorTemplateVersion(c *gin.Context) {
+	logMonitorTemplateGuid := c.Param("logMonitorTemplateGuid")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		middleware.ReturnValidateError(c, "param version illegal")
+		return
+	}
+	result, err := db.GetLogMonitorTemplateVersionData(logMonitorTemplateGuid, version)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+func DiffLogMonitorTemplateVersion(c *gin.Context) {
+	logMonitorTemplateGuid := c.Param("logMonitorTemplateGuid")
+	fromVersion, fromErr := strconv.Atoi(c.Query("from"))
+	toVersion, toErr := strconv.Atoi(c.Query("to"))
+	if fromErr != nil || toErr != nil || fromVersion <= 0 || toVersion <= 0 {
+		middleware.ReturnValidateError(c, "param from and to version illegal")
+		return
+	}
+	result, err := db.DiffLogMonitorTemplateVersion(logMonitorTemplateGuid, fromVersion, toVersion)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+	} else {
+		middleware.ReturnSuccessData(c, result)
+	}
+}
+
+// RolloutLogMonitorTemplateVersion 把模版版本发布到选中的层级对象并同步日志采集配置
+func RolloutLogMonitorTemplateVersion(c *gin.Context) {
+	var param models.LogMonitorTemplateRolloutParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	if param.Version <= 0 {
+		middleware.ReturnValidateError(c, "param version illegal")
+		return
+	}
+	result, affectEndpoints, err := db.RolloutLogMonitorTemplateVersion(&param, middleware.GetOperateUser(c))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	if err = syncLogMetricNodeExporterConfig(affectEndpoints); err != nil {
+		middleware.ReturnError(c, 200, middleware.GetMessageMap(c).SaveDoneButSyncFail, err)
+		return
+	}
+	middleware.ReturnSuccessData(c, result)
+}
+
+func RollbackLogMonitorTemplateVersion(c *gin.Context) {
+	var param models.LogMonitorTemplateRolloutParam
+	if err := c.ShouldBindJSON(&param); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
+	result, affectEndpoints, err := db.RollbackLogMonitorTemplateVersion(&param, middleware.GetOperateUser(c))
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	if err = syncLogMetricNodeExporterConfig(affectEndpoints); err != nil {
+		middleware.ReturnError(c, 200, middleware.GetMessageMap(c).SaveDoneButSyncFail, err)
+		return
+	}
+	middleware.ReturnSuccessData(c, result)
+}
+
 func CheckLogMonitorRegExpMatch(c *gin.Context) {
 	var param models.LogMonitorRegMatchParam
 	if err := c.ShouldBindJSON(&param); err != nil {
@@ -662,6 +841,19 @@ func CheckLogMonitorRegExpMatch(c *gin.Context) {
 		return
 	}
 	var result []*models.LogParamTemplateObj
+	if models.IsLogMonitorStructuredType(param.LogType) {
+		fields, err := db.GetLogStructuredFields(param.LogType, param.JsonRegular, param.Delimiter, param.DemoLog)
+		if err != nil {
+			middleware.ReturnValidateError(c, err.Error())
+			return
+		}
+		for _, v := range param.ParamList {
+			v.DemoMatchValue = fields[v.JsonKey]
+			result = append(result, v)
+		}
+		middleware.ReturnSuccessData(c, result)
+		return
+	}
 	for _, v := range param.ParamList {
 		_, v.DemoMatchValue = db.CheckRegExpMatchPCRE(models.CheckRegExpParam{RegString: v.Regular, TestContext: param.DemoLog})
 		result = append(result, v)
@@ -713,6 +905,10 @@ func CreateLogMetricGroup(c *gin.Context) {
 		middleware.ReturnServerHandleError(c, fmt.Errorf("target_value code repeat"))
 		return
 	}
+	if err := validateLogMetricDerivedParam(param.DerivedMetricList); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	if prefixMap, err = db.GetLogMetricMonitorMetricPrefixMap(param.LogMetricMonitorGuid); err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
 		return
@@ -759,6 +955,10 @@ func UpdateLogMetricGroup(c *gin.Context) {
 		middleware.ReturnServerHandleError(c, fmt.Errorf("target_value code repeat"))
 		return
 	}
+	if err := validateLogMetricDerivedParam(param.DerivedMetricList); err != nil {
+		middleware.ReturnValidateError(c, err.Error())
+		return
+	}
 	err := db.UpdateLogMetricGroup(&param, middleware.GetOperateUser(c))
 	if err != nil {
 		middleware.ReturnHandleError(c, err.Error(), err)
@@ -850,6 +1050,10 @@ func CreateLogMetricCustomGroup(c *gin.Context) {
 				middleware.ReturnValidateError(c, "log_param_name or metric param invalid")
 				return
 			}
+			if err = db.ValidateLogMetricAggConfig(metric.AggType, metric.Buckets); err != nil {
+				middleware.ReturnValidateError(c, err.Error())
+				return
+			}
 		}
 	}
 	if existLogMonitorTemplate, getErr := db.GetLogMonitorTemplateByName(param.Guid, param.Name); getErr != nil {
@@ -918,6 +1122,10 @@ func UpdateLogMetricCustomGroup(c *gin.Context) {
 				middleware.ReturnValidateError(c, "log_param_name or metric param invalid")
 				return
 			}
+			if err = db.ValidateLogMetricAggConfig(metric.AggType, metric.Buckets); err != nil {
+				middleware.ReturnValidateError(c, err.Error())
+				return
+			}
 		}
 	}
 	if err := db.ValidateLogMetricGroupName(param.Guid, param.Name, param.LogMetricMonitor); err != nil {
@@ -1085,3 +1293,15 @@ func BatchGetLogMonitorTemplate(c *gin.Context) {
 		middleware.ReturnSuccessData(c, result)
 	}
 }
+
+// ListLogMonitorThrottle 查看哪些主机上的日志监控因行数或cpu超预算被抽样、丢弃
+func ListLogMonitorThrottle(c *gin.Context) {
+	serviceGroup := c.Query("serviceGroup")
+	onlyThrottled := strings.ToLower(c.Query("throttled")) == "true"
+	result, err := db.ListLogMonitorThrottle(serviceGroup, onlyThrottled)
+	if err != nil {
+		middleware.ReturnHandleError(c, err.Error(), err)
+		return
+	}
+	middleware.ReturnSuccessData(c, result)
+}
@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	ChannelWebhook  = "webhook"
+	ChannelSlack    = "slack"
+	ChannelDingTalk = "dingtalk"
+	ChannelWeCom    = "wecom"
+	ChannelFeishu   = "feishu"
+	ChannelSms      = "sms"
+
+	defaultTimeoutSecond = 10
+)
+
+// Message 通知内容,由告警转换而来,各渠道按需取字段
+type Message struct {
+	AlarmId   int      `json:"alarm_id"`
+	AlarmName string   `json:"alarm_name"`
+	Status    string   `json:"status"`
+	Priority  string   `json:"priority"`
+	Endpoint  string   `json:"endpoint"`
+	Metric    string   `json:"metric"`
+	Subject   string   `json:"subject"`
+	Content   string   `json:"content"`
+	SmsText   string   `json:"sms_text"`
+	Time      string   `json:"time"`
+	Mails     []string `json:"mails"`
+	Phones    []string `json:"phones"`
+}
+
+// Channel 通知渠道配置
+type Channel struct {
+	Type          string
+	Url           string
+	Secret        string
+	Template      string
+	Receiver      string
+	Timeout       int
+	RetryTimes    int
+	RetryInterval int
+}
+
+type Notifier interface {
+	Type() string
+	Send(channel *Channel, message *Message) error
+}
+
+type RetryPolicy struct {
+	Times    int
+	Interval int
+}
+
+var (
+	notifierMap  = make(map[string]Notifier)
+	notifierLock = new(sync.RWMutex)
+	// 每种渠道默认的重试策略,渠道配置里没填时使用
+	defaultRetryPolicyMap = map[string]RetryPolicy{
+		ChannelWebhook:  {Times: 3, Interval: 5},
+		ChannelSlack:    {Times: 3, Interval: 5},
+		ChannelDingTalk: {Times: 2, Interval: 10},
+		ChannelWeCom:    {Times: 2, Interval: 10},
+		ChannelFeishu:   {Times: 2, Interval: 10},
+		ChannelSms:      {Times: 1, Interval: 30},
+	}
+)
+
+func Register(notifier Notifier) {
+	notifierLock.Lock()
+	notifierMap[notifier.Type()] = notifier
+	notifierLock.Unlock()
+}
+
+func GetNotifier(channelType string) (notifier Notifier, ok bool) {
+	notifierLock.RLock()
+	notifier, ok = notifierMap[channelType]
+	notifierLock.RUnlock()
+	return
+}
+
+func TypeList() (result []string) {
+	notifierLock.RLock()
+	for k := range notifierMap {
+		result = append(result, k)
+	}
+	notifierLock.RUnlock()
+	sort.Strings(result)
+	return
+}
+
+func GetRetryPolicy(channel *Channel) RetryPolicy {
+	policy := defaultRetryPolicyMap[channel.Type]
+	if channel.RetryTimes > 0 {
+		policy.Times = channel.RetryTimes
+	}
+	if channel.RetryInterval > 0 {
+		policy.Interval = channel.RetryInterval
+	}
+	if policy.Times <= 0 {
+		policy.Times = 1
+	}
+	return policy
+}
+
+// Send 只发送一次,重试交给调用方(投递队列)处理
+func Send(channel *Channel, message *Message) error {
+	notifier, ok := GetNotifier(channel.Type)
+	if !ok {
+		return fmt.Errorf("notify channel type:%s not support", channel.Type)
+	}
+	return notifier.Send(channel, message)
+}
+
+// Deliver 按渠道的重试策略发送,返回实际尝试次数
+func Deliver(channel *Channel, message *Message) (attempt int, err error) {
+	if _, ok := GetNotifier(channel.Type); !ok {
+		err = fmt.Errorf("notify channel type:%s not support", channel.Type)
+		return
+	}
+	policy := GetRetryPolicy(channel)
+	for attempt = 1; attempt <= policy.Times; attempt++ {
+		if err = Send(channel, message); err == nil {
+			return
+		}
+		if attempt < policy.Times {
+			time.Sleep(time.Duration(policy.Interval) * time.Second)
+		}
+	}
+	attempt = policy.Times
+	return
+}
+
+func renderTemplate(templateContent string, message *Message) (output string, err error) {
+	tpl, parseErr := template.New("notify").Funcs(template.FuncMap{
+		"json": func(input interface{}) string {
+			b, _ := json.Marshal(input)
+			// 去掉首尾引号,方便在json模版的字符串里直接使用
+			return strings.TrimSuffix(strings.TrimPrefix(string(b), "\""), "\"")
+		},
+		"join": strings.Join,
+	}).Parse(templateContent)
+	if parseErr != nil {
+		err = fmt.Errorf("parse notify template fail,%s ", parseErr.Error())
+		return
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, message); err != nil {
+		err = fmt.Errorf("render notify template fail,%s ", err.Error())
+		return
+	}
+	output = buf.String()
+	return
+}
+
+func splitReceiver(receiver string) (result []string) {
+	for _, v := range strings.Split(receiver, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return
+}
+
+func postJson(url string, body []byte, timeout int, header map[string]string) (respBody []byte, err error) {
+	if timeout <= 0 {
+		timeout = defaultTimeoutSecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	req, newReqErr := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if newReqErr != nil {
+		err = fmt.Errorf("new request fail,%s ", newReqErr.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, respErr := http.DefaultClient.Do(req)
+	if respErr != nil {
+		err = fmt.Errorf("do request fail,%s ", respErr.Error())
+		return
+	}
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("response status code:%d,body:%s ", resp.StatusCode, string(respBody))
+	}
+	return
+}
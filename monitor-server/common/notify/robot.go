@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 钉钉/企业微信/飞书 群机器人,接收人(receiver)填手机号,用于@对应的人
+
+type robotNotifier struct {
+	channelType string
+}
+
+type robotResponse struct {
+	ErrCode    int    `json:"errcode"`
+	ErrMsg     string `json:"errmsg"`
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+	StatusCode int    `json:"StatusCode"`
+}
+
+func init() {
+	Register(&robotNotifier{channelType: ChannelDingTalk})
+	Register(&robotNotifier{channelType: ChannelWeCom})
+	Register(&robotNotifier{channelType: ChannelFeishu})
+}
+
+func (n *robotNotifier) Type() string {
+	return n.channelType
+}
+
+func (n *robotNotifier) Send(channel *Channel, message *Message) (err error) {
+	if channel.Url == "" {
+		return fmt.Errorf("%s robot url can not empty", n.channelType)
+	}
+	text := fmt.Sprintf("%s\n%s", message.Subject, message.Content)
+	if channel.Template != "" {
+		if text, err = renderTemplate(channel.Template, message); err != nil {
+			return
+		}
+	}
+	mobiles := splitReceiver(channel.Receiver)
+	if len(mobiles) == 0 {
+		mobiles = message.Phones
+	}
+	requestUrl := channel.Url
+	var body []byte
+	switch n.channelType {
+	case ChannelDingTalk:
+		if channel.Secret != "" {
+			timestamp := fmt.Sprintf("%d", time.Now().UnixNano()/1e6)
+			sign := hmacSha256Base64(channel.Secret, timestamp+"\n"+channel.Secret)
+			requestUrl = fmt.Sprintf("%s&timestamp=%s&sign=%s", requestUrl, timestamp, url.QueryEscape(sign))
+		}
+		for _, mobile := range mobiles {
+			text = text + " @" + mobile
+		}
+		body, _ = json.Marshal(map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]string{"content": text},
+			"at":      map[string]interface{}{"atMobiles": mobiles, "isAtAll": false},
+		})
+	case ChannelWeCom:
+		body, _ = json.Marshal(map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]interface{}{"content": text, "mentioned_mobile_list": mobiles},
+		})
+	case ChannelFeishu:
+		requestBody := map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": text},
+		}
+		if channel.Secret != "" {
+			timestamp := fmt.Sprintf("%d", time.Now().Unix())
+			requestBody["timestamp"] = timestamp
+			requestBody["sign"] = hmacSha256Base64(timestamp+"\n"+channel.Secret, "")
+		}
+		body, _ = json.Marshal(requestBody)
+	}
+	respBody, postErr := postJson(requestUrl, body, channel.Timeout, nil)
+	if postErr != nil {
+		return postErr
+	}
+	var response robotResponse
+	if unmarshalErr := json.Unmarshal(respBody, &response); unmarshalErr != nil {
+		return fmt.Errorf("%s robot response unmarshal fail,%s ", n.channelType, unmarshalErr.Error())
+	}
+	if response.ErrCode != 0 || response.Code != 0 || response.StatusCode != 0 {
+		err = fmt.Errorf("%s robot response error,%s ", n.channelType, strings.TrimSpace(response.ErrMsg+response.Msg))
+	}
+	return
+}
+
+func hmacSha256Base64(key, content string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(content))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
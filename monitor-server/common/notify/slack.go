@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// slackNotifier slack/mattermost的incoming webhook,两者都接受 {"text":"..."}
+type slackNotifier struct{}
+
+type slackRequest struct {
+	Text string `json:"text"`
+}
+
+func init() {
+	Register(&slackNotifier{})
+}
+
+func (n *slackNotifier) Type() string {
+	return ChannelSlack
+}
+
+func (n *slackNotifier) Send(channel *Channel, message *Message) (err error) {
+	if channel.Url == "" {
+		return fmt.Errorf("slack webhook url can not empty")
+	}
+	text := fmt.Sprintf("*%s*\n%s", message.Subject, message.Content)
+	if channel.Template != "" {
+		if text, err = renderTemplate(channel.Template, message); err != nil {
+			return
+		}
+	}
+	body, _ := json.Marshal(slackRequest{Text: text})
+	_, err = postJson(channel.Url, body, channel.Timeout, nil)
+	return
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// smsNotifier 通过http方式调用短信网关,模版中可用 {{.Phones}} {{.SmsText}} 等字段拼请求体
+type smsNotifier struct{}
+
+func init() {
+	Register(&smsNotifier{})
+}
+
+func (n *smsNotifier) Type() string {
+	return ChannelSms
+}
+
+func (n *smsNotifier) Send(channel *Channel, message *Message) (err error) {
+	if channel.Url == "" {
+		return fmt.Errorf("sms gateway url can not empty")
+	}
+	phones := splitReceiver(channel.Receiver)
+	if len(phones) == 0 {
+		phones = message.Phones
+	}
+	if len(phones) == 0 {
+		return fmt.Errorf("sms phone list is empty")
+	}
+	smsMessage := *message
+	smsMessage.Phones = phones
+	if smsMessage.SmsText == "" {
+		smsMessage.SmsText = message.Subject
+	}
+	var body []byte
+	if channel.Template != "" {
+		bodyString, renderErr := renderTemplate(channel.Template, &smsMessage)
+		if renderErr != nil {
+			return renderErr
+		}
+		body = []byte(bodyString)
+	} else {
+		body, _ = json.Marshal(map[string]string{"phones": strings.Join(phones, ","), "content": smsMessage.SmsText})
+	}
+	header := make(map[string]string)
+	if channel.Secret != "" {
+		header["Authorization"] = channel.Secret
+	}
+	_, err = postJson(channel.Url, body, channel.Timeout, header)
+	return
+}
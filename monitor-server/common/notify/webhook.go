@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// webhookNotifier 通用http回调,body可通过模版自定义,不填模版时直接发送Message的json
+type webhookNotifier struct{}
+
+func init() {
+	Register(&webhookNotifier{})
+}
+
+func (n *webhookNotifier) Type() string {
+	return ChannelWebhook
+}
+
+func (n *webhookNotifier) Send(channel *Channel, message *Message) (err error) {
+	if channel.Url == "" {
+		return fmt.Errorf("webhook url can not empty")
+	}
+	var body []byte
+	if channel.Template != "" {
+		bodyString, renderErr := renderTemplate(channel.Template, message)
+		if renderErr != nil {
+			return renderErr
+		}
+		if !json.Valid([]byte(bodyString)) {
+			return fmt.Errorf("webhook body is not a valid json after render template")
+		}
+		body = []byte(bodyString)
+	} else {
+		body, _ = json.Marshal(message)
+	}
+	header := make(map[string]string)
+	if channel.Secret != "" {
+		header["Authorization"] = channel.Secret
+	}
+	_, err = postJson(channel.Url, body, channel.Timeout, header)
+	return
+}
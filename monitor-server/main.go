@@ -45,9 +45,13 @@ func main() {
 	go db.SyncDbMetric(true)
 	go db.StartCallCronJob()
 	go db.StartNotifyPingExport()
+	go db.StartNotifyDeliveryDispatcher()
+	go db.StartAlarmEscalationCron()
+	go db.StartAlarmFlapCron()
 	go api.InitDependenceParam()
 	go db.StartInitAlarmUniqueTags()
 	go db.SyncMetricComparison()
+	go db.StartMonitorDatasourceCron()
 	middleware.InitErrorMessageList()
 	api.InitHttpServer()
 }
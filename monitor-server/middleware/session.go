@@ -81,6 +81,17 @@ func GetOperateUserRoles(c *gin.Context) []string {
 	return c.GetStringSlice("operatorRoles")
 }
 
+// IsAdminOperator 操作人是否有配置的管理员角色
+func IsAdminOperator(c *gin.Context) bool {
+	adminRole := m.Config().DefaultAdminRole
+	for _, role := range GetOperateUserRoles(c) {
+		if adminRole != "" && role == adminRole {
+			return true
+		}
+	}
+	return false
+}
+
 func GetOperateUser(c *gin.Context) string {
 	operator := c.GetString("operatorName")
 	if operator != "" {
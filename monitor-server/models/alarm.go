@@ -69,6 +69,10 @@ type AlarmTable struct {
 	AlarmStrategy string    `json:"alarm_strategy"`
 	NotifyId      string    `json:"notify_id"`
 	AlarmName     string    `json:"alarm_name"`
+	AckUser       string    `json:"ack_user"`
+	AckTime       time.Time `json:"ack_time"`
+	Flapping      int       `json:"flapping"`
+	RecoverTime   time.Time `json:"recover_time"` // 收到恢复但还未满足恢复条件的开始时间
 }
 
 type SortAlarmList []*AlarmTable
@@ -137,6 +141,11 @@ type AlarmProblemQuery struct {
 	AlarmDetail        string                `json:"alarm_detail"`
 	AlarmMetricList    []string              `json:"alarm_metric_list"`
 	StrategyGroups     []*AlarmStrategyGroup `json:"strategy_groups"`
+	Ack                bool                  `json:"ack"`
+	AckUser            string                `json:"ack_user"`
+	AckTime            time.Time             `json:"ack_time"`
+	AckTimeString      string                `json:"ack_time_string"`
+	Flapping           int                   `json:"flapping"`
 	Log                string                `json:"log"`
 }
 
@@ -489,6 +498,7 @@ type QueryProblemAlarmPageDto struct {
 	AlarmName         []string  `json:"alarm_name"`
 	CustomDashboardId int       `json:"custom_dashboard_id"`
 	Query             string    `json:"query"`
+	Ack               string    `json:"ack"` // yes:已确认 no:未确认 空:全部
 }
 
 type QueryHistoryAlarmParam struct {
@@ -586,6 +596,10 @@ type PluginCloseAlarmOutputObj struct {
 	ErrorDetail       string `json:"errorDetail,omitempty"`
 }
 
+type AlarmAckParam struct {
+	Id int `json:"id" binding:"required"`
+}
+
 type AlarmCloseParam struct {
 	Id        int      `json:"id"`
 	Custom    bool     `json:"custom"`
@@ -760,6 +774,7 @@ type QueryAlarmCondition struct {
 	UserRoles           []string
 	Token               string
 	Query               string // 支持告警任意搜索
+	AckFilter           string // yes|no
 }
 
 type AlarmFiring struct {
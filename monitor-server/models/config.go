@@ -79,6 +79,13 @@ type DataSourceConfig struct {
 	Servers    []*DatasourceServers `json:"servers"`
 	DivideTime int64                `json:"divide_time"`
 	WaitTime   int                  `json:"wait_time"`
+	QueryCache QueryCacheConfig     `json:"query_cache"`
+}
+
+type QueryCacheConfig struct {
+	Enable      bool `json:"enable"`
+	TtlSeconds  int  `json:"ttl_seconds"`
+	MaxMemoryMb int  `json:"max_memory_mb"`
 }
 
 type DependenceConfig struct {
@@ -11,6 +11,8 @@ const (
 	UrlPrefix               = "/monitor"
 	RsaPemPath              = "/data/certs/rsa_key"
 	LogMetricName           = "node_log_metric_monitor_value"
+	LogMetricHistogramName  = "node_log_metric_monitor_histogram"
+	LogMetricSummaryName    = "node_log_metric_monitor_summary"
 	DBMonitorMetricName     = "db_monitor_value"
 	SPAlertMailKey          = "alert_mail"
 	SPMetricTemplate        = "metric_template"
@@ -19,6 +19,7 @@ type CustomChart struct {
 	CreateTime      string `json:"createTime" xorm:"create_time"`           // 创建时间
 	UpdateTime      string `json:"updateTime" xorm:"update_time"`           // 更新时间
 	LogMetricGroup  string `json:"log_metric_group" xorm:"log_metric_group"`
+	Datasource      string `json:"datasource" xorm:"datasource"` // 数据源,多个用逗号分隔
 }
 
 type CustomChartExtend struct {
@@ -41,6 +42,7 @@ type CustomChartExtend struct {
 	DisplayConfig      string `json:"displayConfig" xorm:"display_config"`            // 视图位置与长宽
 	GroupDisplayConfig string `json:"groupDisplayConfig" xorm:"group_display_config"` // 视图位置与长宽
 	LogMetricGroup     string `json:"log_metric_group" xorm:"log_metric_group"`
+	Datasource         string `json:"datasource" xorm:"datasource"`
 }
 
 type CustomChartDto struct {
@@ -60,6 +62,7 @@ type CustomChartDto struct {
 	GroupDisplayConfig interface{}             `json:"groupDisplayConfig"` // 组下面的图表位置
 	Group              string                  `json:"group"`              // 所属分组
 	LogMetricGroup     *string                 `json:"logMetricGroup"`
+	Datasource         string                  `json:"datasource"` // 数据源,多个用逗号分隔
 }
 
 type ChartSharedDto struct {
@@ -127,6 +130,7 @@ type AddCustomChartParam struct {
 	Unit          string      `json:"unit"`          // 单位
 	Group         string      `json:"group"`         // 所属分组
 	DisplayConfig interface{} `json:"displayConfig"` // 视图位置与长宽
+	Datasource    string      `json:"datasource"`    // 数据源,多个用逗号分隔
 }
 
 type CopyCustomChartParam struct {
@@ -0,0 +1,44 @@
+package models
+
+const (
+	DashboardVariableServiceGroup  = "service_group"
+	DashboardVariableEndpoint      = "endpoint"
+	DashboardVariableEndpointGroup = "endpoint_group"
+	DashboardVariableTagValue      = "tag_value"
+	DashboardVariableInterval      = "interval"
+	DashboardVariableIntervalList  = "1m,5m,10m,30m,1h"
+)
+
+// DashboardVariableReservedNames 指标表达式和图例里已经在用的占位符,变量不能重名,也不能用 t_ custom k8s 开头
+var DashboardVariableReservedNames = []string{"address", "guid", "pod", "ip", "port", "instance", "metric", "app_metric"}
+
+type CustomDashboardVariable struct {
+	Guid            string `json:"guid" xorm:"'guid' pk"`
+	CustomDashboard int    `json:"customDashboard" xorm:"custom_dashboard"` // 所属看板
+	Name            string `json:"name" xorm:"name"`                        // 变量名,图表里用 $name 或 ${name} 引用
+	DisplayName     string `json:"displayName" xorm:"display_name"`         // 显示名
+	Type            string `json:"type" xorm:"type"`                        // service_group/endpoint/endpoint_group/tag_value/interval
+	MonitorType     string `json:"monitorType" xorm:"monitor_type"`         // 对象类型,过滤对象和对象组的可选项
+	MetricGuid      string `json:"metricGuid" xorm:"metric_guid"`           // 标签值变量取哪个指标的标签
+	TagName         string `json:"tagName" xorm:"tag_name"`                 // 标签名
+	Endpoint        string `json:"endpoint" xorm:"endpoint"`                // 标签值变量按哪个对象或层级对象查询,可以引用其它变量
+	Options         string `json:"options" xorm:"options"`                  // 间隔变量的可选值,逗号分隔
+	DefaultValue    string `json:"defaultValue" xorm:"default_value"`       // 默认值,多选时逗号分隔
+	SortIndex       int    `json:"sortIndex" xorm:"sort_index"`             // 排序
+	CreateUser      string `json:"createUser" xorm:"create_user"`
+	UpdateUser      string `json:"updateUser" xorm:"update_user"`
+	CreateTime      string `json:"createTime" xorm:"create_time"`
+	UpdateTime      string `json:"updateTime" xorm:"update_time"`
+}
+
+type CustomDashboardVariableParam struct {
+	CustomDashboard int                        `json:"customDashboard" binding:"required"`
+	Variables       []*CustomDashboardVariable `json:"variables"`
+}
+
+// DashboardVariableOptionParam Variables为页面上其它变量当前选中的值,标签值变量引用其它变量时用
+type DashboardVariableOptionParam struct {
+	CustomDashboard int               `json:"customDashboard" binding:"required"`
+	Name            string            `json:"name" binding:"required"`
+	Variables       map[string]string `json:"variables"`
+}
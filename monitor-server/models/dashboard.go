@@ -9,6 +9,8 @@ type AutoCreateDashboardParam struct {
 	ServiceGroup       string
 	Operator           string
 	ErrMsgObj          *ErrorMessageObj
+	// 已有的自动看板id,不为0时只在该看板下重新生成图表
+	DashboardId int64
 }
 
 type AutoSimpleCreateDashboardParam struct {
@@ -274,6 +276,8 @@ type ChartQueryParam struct {
 	CustomChartGuid        string                  `json:"custom_chart_guid"`
 	LineType               int                     `json:"lineType"` // lineType=2 表示同环比数据
 	CalcServiceGroupEnable bool                    `json:"calc_service_group_enable"`
+	CustomDashboard        int                     `json:"custom_dashboard"` // 所属看板,图表配置引用看板变量时用
+	Variables              map[string]string       `json:"variables"`        // 看板变量当前选中的值,没传的用默认值
 }
 
 type ChartQueryConfigObj struct {
@@ -525,14 +529,15 @@ type CustomDashboardResultDto struct {
 }
 
 type CustomDashboardDto struct {
-	Name           string            `json:"name"`
-	PanelGroupList []string          `json:"panelGroupList"`
-	Charts         []*CustomChartDto `json:"charts"`
-	MgmtRoles      []string          `json:"mgmtRoles"`
-	UseRoles       []string          `json:"useRoles"`
-	TimeRange      int               `json:"timeRange"`   //时间范围
-	RefreshWeek    int               `json:"refreshWeek"` // 刷新周期
-	LogMetricGroup string            `json:"logMetricGroup"`
+	Name           string                     `json:"name"`
+	PanelGroupList []string                   `json:"panelGroupList"`
+	Charts         []*CustomChartDto          `json:"charts"`
+	MgmtRoles      []string                   `json:"mgmtRoles"`
+	UseRoles       []string                   `json:"useRoles"`
+	TimeRange      int                        `json:"timeRange"`   //时间范围
+	RefreshWeek    int                        `json:"refreshWeek"` // 刷新周期
+	LogMetricGroup string                     `json:"logMetricGroup"`
+	Variables      []*CustomDashboardVariable `json:"variables"` // 看板变量
 }
 
 type AddCustomDashboardParam struct {
@@ -567,15 +572,16 @@ type CustomDashboardExportParam struct {
 }
 
 type CustomDashboardExportDto struct {
-	Id             int               `json:"id"`
-	Name           string            `json:"name"`
-	PanelGroups    string            `json:"panelGroups"`
-	TimeRange      int               `json:"timeRange"`      //时间范围
-	RefreshWeek    int               `json:"refreshWeek"`    // 刷新周期
-	Charts         []*CustomChartDto `json:"charts"`         // 图表
-	MgmtRole       string            `json:"mgmtRole"`       // 管理角色
-	UseRoles       []string          `json:"useRoles"`       // 使用角色
-	LogMetricGroup string            `json:"logMetricGroup"` // 关联业务配置
+	Id             int                        `json:"id"`
+	Name           string                     `json:"name"`
+	PanelGroups    string                     `json:"panelGroups"`
+	TimeRange      int                        `json:"timeRange"`      //时间范围
+	RefreshWeek    int                        `json:"refreshWeek"`    // 刷新周期
+	Charts         []*CustomChartDto          `json:"charts"`         // 图表
+	MgmtRole       string                     `json:"mgmtRole"`       // 管理角色
+	UseRoles       []string                   `json:"useRoles"`       // 使用角色
+	LogMetricGroup string                     `json:"logMetricGroup"` // 关联业务配置
+	Variables      []*CustomDashboardVariable `json:"variables"`      // 看板变量
 }
 
 type CustomDashboardImportRes struct {
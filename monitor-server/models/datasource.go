@@ -26,6 +26,7 @@ type QueryMonitorData struct {
 	PieDisplayTag        string    `json:"pie_display_tag"`
 	ComparisonFlag       string    `json:"comparison_flag"`
 	ServiceConfiguration string    `json:"service_configuration"` // 业务配置, custom 表示自定义
+	DatasourceList       []string  `json:"datasource_list"`       // 查询的数据源,为空时走集群或默认数据源,多个时分别查询后合并
 }
 
 type PrometheusParam struct {
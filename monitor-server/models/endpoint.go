@@ -14,6 +14,7 @@ type MonitorTypeTable struct {
 	DisplayName string `json:"display_name" xorm:"display_name"`
 	Description string `json:"description" xorm:"description"`
 	SystemType  string `json:"system_type" xorm:"system_type"`
+	Datasource  string `json:"datasource" xorm:"datasource"`
 }
 
 type EndpointNewTable struct {
@@ -71,6 +72,7 @@ type MetricTable struct {
 	GroupType          string `json:"group_type" xorm:"-"`            // 组类型
 	GroupName          string `json:"group_name" xorm:"-"`            // 组名
 	DbMetricMonitor    string `json:"db_metric_monitor" xorm:"db_metric_monitor"`
+	Datasource         string `json:"datasource" xorm:"datasource"`
 }
 
 type MetricImportResultDto struct {
@@ -44,20 +44,21 @@ type NotifyRoleRelTable struct {
 }
 
 type NotifyObj struct {
-	Guid             string   `json:"guid" xorm:"guid"`
-	EndpointGroup    string   `json:"endpoint_group" xorm:"endpoint_group"`
-	ServiceGroup     string   `json:"service_group" xorm:"service_group"`
-	AlarmStrategy    string   `json:"alarm_strategy" xorm:"alarm_strategy"`
-	AlarmAction      string   `json:"alarm_action" xorm:"alarm_action"`
-	AlarmPriority    string   `json:"alarm_priority" xorm:"alarm_priority"`
-	NotifyNum        int      `json:"notify_num" xorm:"notify_num"`
-	ProcCallbackName string   `json:"proc_callback_name" xorm:"proc_callback_name"`
-	ProcCallbackKey  string   `json:"proc_callback_key" xorm:"proc_callback_key"`
-	CallbackUrl      string   `json:"callback_url" xorm:"callback_url"`
-	CallbackParam    string   `json:"callback_param" xorm:"callback_param"`
-	NotifyRoles      []string `json:"notify_roles"`
-	ProcCallbackMode string   `json:"proc_callback_mode" xorm:"proc_callback_mode"` // 回调模式 -> manual(手动) | auto(自动)
-	Description      string   `json:"description" xorm:"description"`
+	Guid             string              `json:"guid" xorm:"guid"`
+	EndpointGroup    string              `json:"endpoint_group" xorm:"endpoint_group"`
+	ServiceGroup     string              `json:"service_group" xorm:"service_group"`
+	AlarmStrategy    string              `json:"alarm_strategy" xorm:"alarm_strategy"`
+	AlarmAction      string              `json:"alarm_action" xorm:"alarm_action"`
+	AlarmPriority    string              `json:"alarm_priority" xorm:"alarm_priority"`
+	NotifyNum        int                 `json:"notify_num" xorm:"notify_num"`
+	ProcCallbackName string              `json:"proc_callback_name" xorm:"proc_callback_name"`
+	ProcCallbackKey  string              `json:"proc_callback_key" xorm:"proc_callback_key"`
+	CallbackUrl      string              `json:"callback_url" xorm:"callback_url"`
+	CallbackParam    string              `json:"callback_param" xorm:"callback_param"`
+	NotifyRoles      []string            `json:"notify_roles"`
+	ProcCallbackMode string              `json:"proc_callback_mode" xorm:"proc_callback_mode"` // 回调模式 -> manual(手动) | auto(自动)
+	Description      string              `json:"description" xorm:"description"`
+	Channels         []*NotifyChannelObj `json:"channels"`
 }
 
 type PageInfo struct {
@@ -112,6 +113,10 @@ type AlarmStrategyTable struct {
 	UpdateTime        string `json:"update_time" xorm:"update_time"`
 	Name              string `json:"name" xorm:"name"`
 	UpdateUser        string `json:"update_user" xorm:"update_user"`
+	RecoverCondition  string `json:"recover_condition" xorm:"recover_condition"`
+	RecoverLast       string `json:"recover_last" xorm:"recover_last"`
+	FlapCount         int    `json:"flap_count" xorm:"flap_count"`
+	FlapWindow        int    `json:"flap_window" xorm:"flap_window"`
 }
 
 type AlarmStrategyMetricObj struct {
@@ -131,11 +136,17 @@ type AlarmStrategyMetricObj struct {
 	MetricType              string       `json:"metric_type" xorm:"metric_type"`
 	ActiveWindow            string       `json:"active_window" xorm:"active_window"`
 	ConditionCrc            string       `json:"condition_crc"`
+	ConditionType           string       `json:"condition_type" xorm:"-"` // 按条件crc取时为条件的类型和异常检测窗口
+	AnomalyWindow           string       `json:"anomaly_window" xorm:"-"`
 	Tags                    []*MetricTag `json:"tags"`
 	UpdateUser              string       `json:"update_user" xorm:"update_user"`
 	LogMetricGroup          string       `json:"log_metric_group" xorm:"log_metric_group"`
 	AlarmStrategyMetricGuid string       `json:"alarm_strategy_metric_guid" xorm:"-"`
 	LogType                 string       `json:"log_type" xorm:"log_type"`
+	RecoverCondition        string       `json:"recover_condition" xorm:"recover_condition"` // 恢复条件,如<80,为空则不满足告警条件即恢复
+	RecoverLast             string       `json:"recover_last" xorm:"recover_last"`           // 恢复条件需持续的时间,如5m
+	FlapCount               int          `json:"flap_count" xorm:"flap_count"`               // flap_window秒内状态变化次数达到flap_count判定为抖动,0不检测
+	FlapWindow              int          `json:"flap_window" xorm:"flap_window"`
 }
 
 type GroupStrategyObj struct {
@@ -157,6 +168,10 @@ type GroupStrategyObj struct {
 	UpdateUser        string                  `json:"update_user"`
 	LogMetricGroup    *string                 `json:"log_metric_group"`
 	ActiveWindowList  []string                `json:"active_window_list"`
+	RecoverCondition  string                  `json:"recover_condition"`
+	RecoverLast       string                  `json:"recover_last"`
+	FlapCount         int                     `json:"flap_count"`
+	FlapWindow        int                     `json:"flap_window"`
 }
 
 type EndpointStrategyObj struct {
@@ -206,12 +221,19 @@ type AlarmNotifyTable struct {
 }
 
 type StrategyConditionObj struct {
-	Metric     string       `json:"metric"`
-	MetricName string       `json:"metric_name"`
-	Condition  string       `json:"condition"`
-	Last       string       `json:"last"`
-	Tags       []*MetricTag `json:"tags"`
-	LogType    string       `json:"logType"`
+	Metric        string       `json:"metric"`
+	MetricName    string       `json:"metric_name"`
+	Condition     string       `json:"condition"`
+	Last          string       `json:"last"`
+	Tags          []*MetricTag `json:"tags"`
+	LogType       string       `json:"logType"`
+	ConditionType string       `json:"condition_type,omitempty"` // 空为静态阈值,sigma|day|week|rate为异常检测,nodata为无数据告警,由监控引擎计算
+	AnomalyWindow string       `json:"anomaly_window,omitempty"` // sigma的移动平均窗口,rate的变化率周期,day/week的对比平均周期
+	// 条件自己的恢复和抖动配置,不配置时沿用阈值配置的,不参与条件crc计算
+	RecoverCondition string `json:"recover_condition,omitempty"`
+	RecoverLast      string `json:"recover_last,omitempty"`
+	FlapCount        int    `json:"flap_count,omitempty"`
+	FlapWindow       int    `json:"flap_window,omitempty"`
 }
 
 type MetricTag struct {
@@ -231,6 +253,8 @@ type AlarmStrategyMetric struct {
 	UpdateTime        time.Time `json:"updateTime" xorm:"update_time"`       // 更新时间
 	MonitorEngine     int       `json:"monitor_engine" xorm:"monitor_engine"`
 	MonitorEngineExpr string    `json:"monitor_engine_expr" xorm:"monitor_engine_expr"`
+	ConditionType     string    `json:"condition_type" xorm:"condition_type"`
+	AnomalyWindow     string    `json:"anomaly_window" xorm:"anomaly_window"`
 }
 
 type AlarmStrategyMetricQueryRow struct {
@@ -240,7 +264,13 @@ type AlarmStrategyMetricQueryRow struct {
 	Condition     string `json:"condition" xorm:"condition"`          // 条件
 	Last          string `json:"last" xorm:"last"`                    // 持续时间
 	CrcHash       string `json:"crc_hash" xorm:"crc_hash"`            // hash
-	MetricName    string `json:"metric_name" xorm:"metric_name"`
+	MetricName       string `json:"metric_name" xorm:"metric_name"`
+	ConditionType    string `json:"condition_type" xorm:"condition_type"`
+	AnomalyWindow    string `json:"anomaly_window" xorm:"anomaly_window"`
+	RecoverCondition string `json:"recover_condition" xorm:"recover_condition"`
+	RecoverLast      string `json:"recover_last" xorm:"recover_last"`
+	FlapCount        int    `json:"flap_count" xorm:"flap_count"`
+	FlapWindow       int    `json:"flap_window" xorm:"flap_window"`
 }
 
 type AlarmStrategyTag struct {
@@ -266,8 +296,14 @@ type AlarmStrategyMetricWithExpr struct {
 	MetricName    string `json:"metric_name" xorm:"metric_name"`
 	MetricExpr    string `json:"metric_expr" xorm:"metric_expr"`
 	MetricType    string `json:"metric_type" xorm:"metric_type"`
-	MonitorEngine int    `json:"monitor_engine" xorm:"monitor_engine"`
-	LogType       string `json:"log_type" xorm:"log_type"`
+	MonitorEngine    int    `json:"monitor_engine" xorm:"monitor_engine"`
+	LogType          string `json:"log_type" xorm:"log_type"`
+	ConditionType    string `json:"condition_type" xorm:"condition_type"`
+	AnomalyWindow    string `json:"anomaly_window" xorm:"anomaly_window"`
+	RecoverCondition string `json:"recover_condition" xorm:"recover_condition"`
+	RecoverLast      string `json:"recover_last" xorm:"recover_last"`
+	FlapCount        int    `json:"flap_count" xorm:"flap_count"`
+	FlapWindow       int    `json:"flap_window" xorm:"flap_window"`
 }
 
 type AlarmStrategyQueryParam struct {
@@ -282,3 +318,66 @@ type WorkflowDto struct {
 	Version string `json:"version"`
 	Key     string `json:"key"`
 }
+
+const (
+	StrategyConditionSigma = "sigma"
+	StrategyConditionDay   = "day"
+	StrategyConditionWeek  = "week"
+	StrategyConditionRate  = "rate"
+	// 无数据告警,last为无数据的持续时间,condition不生效
+	StrategyConditionNoData = "nodata"
+)
+
+// IsStrategyAnomalyCondition 条件类型是否为异常检测,空为静态阈值
+func IsStrategyAnomalyCondition(conditionType string) bool {
+	switch conditionType {
+	case StrategyConditionSigma, StrategyConditionDay, StrategyConditionWeek, StrategyConditionRate:
+		return true
+	}
+	return false
+}
+
+type StrategyConditionPreviewParam struct {
+	Metric        string `json:"metric" binding:"required"`
+	Endpoint      string `json:"endpoint" binding:"required"`
+	Condition     string `json:"condition" binding:"required"`
+	Last          string `json:"last"`
+	ConditionType string `json:"condition_type"`
+	AnomalyWindow string `json:"anomaly_window"`
+	Start         int64  `json:"start"`
+	End           int64  `json:"end"`
+}
+
+type StrategyConditionPreviewSeries struct {
+	Tags   map[string]string                `json:"tags"`
+	Values [][]float64                      `json:"values"` // [时间戳,参与比较的值],异常检测条件为计算后的偏离值
+	Alarms []*StrategyConditionPreviewAlarm `json:"alarms"`
+}
+
+type StrategyConditionPreviewAlarm struct {
+	Start      int64   `json:"start"`
+	End        int64   `json:"end"` // 0表示到预览结束还未恢复
+	StartValue float64 `json:"start_value"`
+}
+
+type AlarmStrategyBacktestParam struct {
+	GroupStrategyObj
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type AlarmStrategyBacktestResult struct {
+	Start      int64                          `json:"start"`
+	End        int64                          `json:"end"`
+	Conditions []*AlarmStrategyBacktestSeries `json:"conditions"`
+}
+
+type AlarmStrategyBacktestSeries struct {
+	ConditionIndex int                              `json:"condition_index"` // 对应conditions的下标
+	Metric         string                           `json:"metric"`
+	Endpoint       string                           `json:"endpoint"`
+	Tags           string                           `json:"tags"`
+	FiringCount    int                              `json:"firing_count"`
+	FiringSecond   int64                            `json:"firing_second"` // 回测范围内处于告警状态的总时长
+	Alarms         []*StrategyConditionPreviewAlarm `json:"alarms"`
+}
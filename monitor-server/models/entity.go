@@ -86,6 +86,8 @@ type AlarmEntityObj struct {
 	ToMail      string `json:"toMail"`
 	ToPhone     string `json:"toPhone"`
 	ToRole      string `json:"toRole"`
+	AckUser     string `json:"ackUser"`
+	AckTime     string `json:"ackTime"`
 }
 
 type AlarmEventEntity struct {
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+const (
+	EscalationStateRunning = "running"
+	EscalationStateDone    = "done"
+	EscalationStateCancel  = "cancel"
+)
+
+type AlarmEscalationTable struct {
+	Guid          string    `json:"guid" xorm:"guid"`
+	Name          string    `json:"name" xorm:"name"`
+	AlarmStrategy string    `json:"alarm_strategy" xorm:"alarm_strategy"`
+	EndpointGroup string    `json:"endpoint_group" xorm:"endpoint_group"`
+	Enable        int       `json:"enable" xorm:"enable"`
+	Description   string    `json:"description" xorm:"description"`
+	UpdateUser    string    `json:"update_user" xorm:"update_user"`
+	UpdateTime    time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type AlarmEscalationStepTable struct {
+	Guid       string `json:"guid" xorm:"guid"`
+	Escalation string `json:"escalation" xorm:"escalation"`
+	StepIndex  int    `json:"step_index" xorm:"step_index"`
+	Delay      int    `json:"delay" xorm:"delay"` // 距上一步(第一步为告警开始)多少分钟后执行
+	Notify     string `json:"notify" xorm:"notify"`
+}
+
+// AlarmEscalationStateTable 每条告警的升级进度,step_index为下一步要执行的步骤
+type AlarmEscalationStateTable struct {
+	AlarmId    int       `json:"alarm_id" xorm:"alarm_id"`
+	Escalation string    `json:"escalation" xorm:"escalation"`
+	StepIndex  int       `json:"step_index" xorm:"step_index"`
+	Status     string    `json:"status" xorm:"status"` // running|done|cancel
+	NextTime   time.Time `json:"next_time" xorm:"next_time"`
+	UpdateTime time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type AlarmEscalationObj struct {
+	Guid          string                    `json:"guid"`
+	Name          string                    `json:"name" binding:"required"`
+	AlarmStrategy string                    `json:"alarm_strategy"`
+	EndpointGroup string                    `json:"endpoint_group"`
+	Enable        int                       `json:"enable"`
+	Description   string                    `json:"description"`
+	Steps         []*AlarmEscalationStepObj `json:"steps"`
+	UpdateUser    string                    `json:"update_user"`
+	UpdateTime    string                    `json:"update_time"`
+}
+
+type AlarmEscalationStepObj struct {
+	Guid   string     `json:"guid"`
+	Delay  int        `json:"delay"`
+	Notify *NotifyObj `json:"notify"`
+}
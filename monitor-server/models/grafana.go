@@ -0,0 +1,150 @@
+package models
+
+import "encoding/json"
+
+const (
+	GrafanaPanelRow        = "row"
+	GrafanaPanelTimeSeries = "timeseries"
+	GrafanaPanelGraph      = "graph"
+	GrafanaPanelStat       = "stat"
+	GrafanaPanelGauge      = "gauge"
+	GrafanaPanelPie        = "piechart"
+	GrafanaPanelOldPie     = "grafana-piechart-panel"
+	GrafanaPanelTable      = "table"
+	GrafanaGridColumns     = 24 // Grafana一行24列,自定义看板一行12列,行高都是30px
+	GrafanaDatasourceVar   = "datasource"
+)
+
+// GrafanaDashboardWrapper Grafana接口导出的格式,看板在dashboard字段里;页面"Export"导出的直接就是看板
+type GrafanaDashboardWrapper struct {
+	Dashboard *GrafanaDashboard `json:"dashboard"`
+}
+
+type GrafanaDashboard struct {
+	Id            *int              `json:"id"`
+	Uid           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	Timezone      string            `json:"timezone"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Version       int               `json:"version"`
+	Refresh       interface{}       `json:"refresh"` // "30s",没有自动刷新时为false
+	Time          GrafanaTimeRange  `json:"time"`
+	Templating    GrafanaTemplating `json:"templating"`
+	Panels        []*GrafanaPanel   `json:"panels"`
+}
+
+type GrafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type GrafanaTemplating struct {
+	List []*GrafanaTemplateVar `json:"list"`
+}
+
+type GrafanaTemplateVar struct {
+	Name        string                   `json:"name"`
+	Label       string                   `json:"label,omitempty"`
+	Type        string                   `json:"type"` // datasource|custom|query|interval|constant|textbox
+	Query       interface{}              `json:"query,omitempty"`
+	Datasource  interface{}              `json:"datasource,omitempty"`
+	Current     GrafanaVarCurrent        `json:"current"`
+	Options     []*GrafanaVarValue       `json:"options,omitempty"`
+	Multi       bool                     `json:"multi"`
+	Hide        int                      `json:"hide"`
+	OpenMonitor *CustomDashboardVariable `json:"openMonitor,omitempty"` // 从本系统导出时带上原变量配置,再导入时不用按类型推断
+}
+
+type GrafanaVarCurrent struct {
+	Text  interface{} `json:"text"`
+	Value interface{} `json:"value"` // 单选为字符串,多选为数组
+}
+
+type GrafanaVarValue struct {
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+	Selected bool   `json:"selected"`
+}
+
+type GrafanaPanel struct {
+	Id          int                 `json:"id"`
+	Type        string              `json:"type"`
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	GridPos     GrafanaGridPos      `json:"gridPos"`
+	Datasource  interface{}         `json:"datasource,omitempty"` // 旧版本是数据源名称,新版本是{"type":"prometheus","uid":"xxx"}
+	Targets     []*GrafanaTarget    `json:"targets,omitempty"`
+	FieldConfig *GrafanaFieldConfig `json:"fieldConfig,omitempty"`
+	Options     json.RawMessage     `json:"options,omitempty"`
+	Collapsed   bool                `json:"collapsed,omitempty"`
+	Panels      []*GrafanaPanel     `json:"panels,omitempty"` // 折叠的行下面的面板
+	Bars        bool                `json:"bars,omitempty"`   // 旧版graph面板
+	Fill        int                 `json:"fill,omitempty"`
+}
+
+type GrafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type GrafanaFieldConfig struct {
+	Defaults  GrafanaFieldDefaults `json:"defaults"`
+	Overrides []interface{}        `json:"overrides"`
+}
+
+type GrafanaFieldDefaults struct {
+	Unit   string              `json:"unit,omitempty"`
+	Color  *GrafanaFieldColor  `json:"color,omitempty"`
+	Custom *GrafanaFieldCustom `json:"custom,omitempty"`
+}
+
+type GrafanaFieldColor struct {
+	Mode       string `json:"mode"`
+	FixedColor string `json:"fixedColor,omitempty"`
+}
+
+type GrafanaFieldCustom struct {
+	DrawStyle   string `json:"drawStyle,omitempty"` // line|bars|points
+	FillOpacity int    `json:"fillOpacity"`
+}
+
+type GrafanaTarget struct {
+	RefId        string                    `json:"refId"`
+	Expr         string                    `json:"expr"`
+	LegendFormat string                    `json:"legendFormat,omitempty"`
+	Datasource   interface{}               `json:"datasource,omitempty"`
+	Hide         bool                      `json:"hide,omitempty"`
+	OpenMonitor  *GrafanaOpenMonitorSeries `json:"openMonitor,omitempty"` // 从本系统导出时带上原图表配置,再导入时不用解析表达式
+}
+
+type GrafanaOpenMonitorSeries struct {
+	Endpoint      string    `json:"endpoint"`
+	ServiceGroup  string    `json:"serviceGroup"`
+	EndpointName  string    `json:"endpointName"`
+	MonitorType   string    `json:"monitorType"`
+	EndpointType  string    `json:"endpointType"`
+	MetricType    string    `json:"metricType"`
+	MetricGuid    string    `json:"metricGuid"`
+	Metric        string    `json:"metric"`
+	ColorGroup    string    `json:"colorGroup"`
+	PieDisplayTag string    `json:"pieDisplayTag"`
+	Tags          []*TagDto `json:"tags"`
+}
+
+// GrafanaImportResult 导入结果,ChartMap为找不到指标的图表,SkipPanels为不支持的面板,ConvertPanels为转成曲线图的面板,SkipVariables为转不成看板变量的变量
+type GrafanaImportResult struct {
+	ChartMap      map[string][]string `json:"chartMap"`
+	SkipPanels    []string            `json:"skipPanels"`
+	ConvertPanels []string            `json:"convertPanels"`
+	SkipVariables []string            `json:"skipVariables"`
+}
+
+// GrafanaImportParam 导入时表达式里的变量按Variables取值,没有的用看板里变量的当前值,都解析不出对象时用默认的对象或层级对象
+type GrafanaImportParam struct {
+	Variables    map[string]string `json:"variables"`
+	Endpoint     string            `json:"endpoint"`
+	ServiceGroup string            `json:"serviceGroup"`
+}
@@ -0,0 +1,96 @@
+package models
+
+import "time"
+
+const (
+	IncidentMatchHostIp       = "host_ip"
+	IncidentMatchServiceGroup = "service_group"
+	IncidentMatchTime         = "time"
+)
+
+// AlarmCorrelationRuleTable 告警聚合规则,按顺序匹配,命中同一规则同一关联键且在时间窗口内的告警归为同一事件
+type AlarmCorrelationRuleTable struct {
+	Guid         string    `json:"guid" xorm:"guid"`
+	Name         string    `json:"name" xorm:"name"`
+	MatchType    string    `json:"match_type" xorm:"match_type"`       // host_ip|service_group|time
+	ServiceGroup string    `json:"service_group" xorm:"service_group"` // match_type=service_group时限定的层级对象,为空则按所属根层级对象聚合;match_type=time时必填
+	TimeWindow   int       `json:"time_window" xorm:"time_window"`     // 秒,距事件最近一条告警多久内的告警可加入
+	SortIndex    int       `json:"sort_index" xorm:"sort_index"`
+	Enable       int       `json:"enable" xorm:"enable"`
+	UpdateUser   string    `json:"update_user" xorm:"update_user"`
+	UpdateTime   time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type AlarmIncidentTable struct {
+	Id             int       `json:"id" xorm:"id"`
+	Rule           string    `json:"rule" xorm:"rule"`
+	CorrelationKey string    `json:"correlation_key" xorm:"correlation_key"`
+	Status         string    `json:"status" xorm:"status"` // firing|ok
+	SPriority      string    `json:"s_priority" xorm:"s_priority"`
+	RootAlarm      int       `json:"root_alarm" xorm:"root_alarm"`
+	NotifyAlarm    int       `json:"notify_alarm" xorm:"notify_alarm"` // 代表事件发送通知的告警
+	EndAlarm       int       `json:"end_alarm" xorm:"end_alarm"`       // 最后恢复使事件结束的告警
+	AlarmCount     int       `json:"alarm_count" xorm:"alarm_count"`
+	Start          time.Time `json:"start" xorm:"start"`
+	LastTime       time.Time `json:"last_time" xorm:"last_time"`
+	End            time.Time `json:"end" xorm:"end"`
+	UpdateTime     time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type AlarmIncidentAlarmTable struct {
+	Incident int       `json:"incident" xorm:"incident"`
+	AlarmId  int       `json:"alarm_id" xorm:"alarm_id"`
+	JoinTime time.Time `json:"join_time" xorm:"join_time"`
+	Notified int       `json:"notified" xorm:"notified"` // 是否代表所属阈值配置发过firing通知
+}
+
+type AlarmCorrelationRuleObj struct {
+	Guid         string `json:"guid"`
+	Name         string `json:"name" binding:"required"`
+	MatchType    string `json:"match_type" binding:"required"`
+	ServiceGroup string `json:"service_group"`
+	TimeWindow   int    `json:"time_window"`
+	SortIndex    int    `json:"sort_index"`
+	Enable       int    `json:"enable"`
+	UpdateUser   string `json:"update_user"`
+	UpdateTime   string `json:"update_time"`
+}
+
+type AlarmIncidentQueryParam struct {
+	Status     string `json:"status"` // firing|ok,空表示全部
+	StartTime  string `json:"start_time"`
+	EndTime    string `json:"end_time"`
+	StartIndex int    `json:"startIndex"`
+	PageSize   int    `json:"pageSize"`
+}
+
+type AlarmIncidentObj struct {
+	Id             int                  `json:"id"`
+	Rule           string               `json:"rule"`
+	RuleName       string               `json:"rule_name"`
+	MatchType      string               `json:"match_type"`
+	CorrelationKey string               `json:"correlation_key"`
+	Status         string               `json:"status"`
+	SPriority      string               `json:"s_priority"`
+	AlarmCount     int                  `json:"alarm_count"`
+	Start          string               `json:"start"`
+	LastTime       string               `json:"last_time"`
+	End            string               `json:"end"`
+	RootAlarm      *AlarmIncidentItem   `json:"root_alarm"`
+	Alarms         []*AlarmIncidentItem `json:"alarms,omitempty"`
+}
+
+type AlarmIncidentItem struct {
+	Id         int     `json:"id"`
+	Endpoint   string  `json:"endpoint"`
+	Status     string  `json:"status"`
+	SMetric    string  `json:"s_metric"`
+	SPriority  string  `json:"s_priority"`
+	AlarmName  string  `json:"alarm_name"`
+	Content    string  `json:"content"`
+	Tags       string  `json:"tags"`
+	StartValue float64 `json:"start_value"`
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	IsRoot     bool    `json:"is_root"`
+}
@@ -3,11 +3,19 @@ package models
 import "time"
 
 type LogKeywordMonitorTable struct {
-	Guid         string `json:"guid"`
-	ServiceGroup string `json:"service_group"`
-	LogPath      string `json:"log_path"`
-	MonitorType  string `json:"monitor_type"`
-	UpdateTime   string `json:"update_time"`
+	Guid              string `json:"guid"`
+	ServiceGroup      string `json:"service_group"`
+	LogPath           string `json:"log_path"`
+	MonitorType       string `json:"monitor_type"`
+	UpdateTime        string `json:"update_time"`
+	MultilineStart    string `json:"multiline_start"`
+	MultilineContinue string `json:"multiline_continue"`
+	MultilineMaxLines int    `json:"multiline_max_lines"`
+	MultilineTimeout  int    `json:"multiline_timeout"`
+}
+
+func (t *LogKeywordMonitorTable) GetMultilineConfig() LogMultilineConfig {
+	return LogMultilineConfig{MultilineStart: t.MultilineStart, MultilineContinue: t.MultilineContinue, MultilineMaxLines: t.MultilineMaxLines, MultilineTimeout: t.MultilineTimeout}
 }
 
 type LogKeywordConfigTable struct {
@@ -24,8 +32,17 @@ type LogKeywordConfigTable struct {
 	ActiveWindowList  []string   `json:"active_window_list" xorm:"-"`
 	Notify            *NotifyObj `json:"notify" xorm:"-"`
 	UpdateUser        string     `json:"update_user" xorm:"update_user"`
+	MatchType         string     `json:"match_type" xorm:"match_type"`             // 匹配方式 -> keyword(单个关键字) | expression(布尔表达式)
+	ExcludeKeyword    string     `json:"exclude_keyword" xorm:"exclude_keyword"`   // 排除条件,命中的行不计数
+	ThresholdCount    int        `json:"threshold_count" xorm:"threshold_count"`   // 时间窗口内出现次数超过该值才告警,0为出现即告警
+	ThresholdWindow   int        `json:"threshold_window" xorm:"threshold_window"` // 次数统计的时间窗口(分钟)
 }
 
+const (
+	LogKeywordMatchTypeKeyword    = "keyword"
+	LogKeywordMatchTypeExpression = "expression"
+)
+
 type LogKeywordEndpointRelTable struct {
 	Guid              string `json:"guid"`
 	LogKeywordMonitor string `json:"log_keyword_monitor"`
@@ -47,6 +64,7 @@ type LogKeywordMonitorObj struct {
 	KeywordList  []*LogKeywordConfigTable      `json:"keyword_list"`
 	EndpointRel  []*LogKeywordEndpointRelTable `json:"endpoint_rel"`
 	Notify       *NotifyObj                    `json:"notify"`
+	LogMultilineConfig
 }
 
 type LogKeywordMonitorCreateObj struct {
@@ -56,6 +74,7 @@ type LogKeywordMonitorCreateObj struct {
 	MonitorType  string                        `json:"monitor_type"`
 	KeywordList  []*LogKeywordConfigTable      `json:"keyword_list"`
 	EndpointRel  []*LogKeywordEndpointRelTable `json:"endpoint_rel"`
+	LogMultilineConfig
 }
 
 type LogKeywordHttpRuleObj struct {
@@ -63,16 +82,82 @@ type LogKeywordHttpRuleObj struct {
 	Keyword        string  `json:"keyword"`
 	Count          float64 `json:"count"`
 	TargetEndpoint string  `json:"target_endpoint"`
+	MatchType      string  `json:"match_type"`
+	ExcludeKeyword string  `json:"exclude_keyword"`
 }
 
 type LogKeywordHttpDto struct {
 	Path     string                   `json:"path"`
 	Keywords []*LogKeywordHttpRuleObj `json:"keywords"`
+	LogMultilineConfig
 }
 
 type LogKeywordFetchObj struct {
-	Index   float64 `json:"index"`
-	Content string  `json:"content"`
+	Index        float64                  `json:"index"`
+	Content      string                   `json:"content"`
+	Time         int64                    `json:"time"`
+	Before       []string                 `json:"before"`
+	After        []string                 `json:"after"`
+	WindowCounts []*LogKeywordWindowCount `json:"window_counts"`
+}
+
+type LogKeywordWindowCount struct {
+	Start int64   `json:"start"`
+	Count float64 `json:"count"`
+}
+
+type LogKeywordMatchObj struct {
+	Index   float64  `json:"index"`
+	Time    int64    `json:"time"`
+	File    string   `json:"file"`
+	Content string   `json:"content"`
+	Before  []string `json:"before"`
+	After   []string `json:"after"`
+}
+
+type LogKeywordMatchHttpDto struct {
+	Path       string `json:"path"`
+	Keyword    string `json:"keyword"`
+	StartTime  int64  `json:"start_time"`
+	EndTime    int64  `json:"end_time"`
+	StartIndex int    `json:"start_index"`
+	PageSize   int    `json:"page_size"`
+}
+
+type LogKeywordAlarmMatchQueryParam struct {
+	AlarmId    int `json:"alarm_id" binding:"required"`
+	StartIndex int `json:"startIndex"`
+	PageSize   int `json:"pageSize"`
+}
+
+type LogKeywordMatchPageData struct {
+	Total        int                      `json:"total"`
+	Matches      []*LogKeywordMatchObj    `json:"matches"`
+	WindowCounts []*LogKeywordWindowCount `json:"window_counts"`
+}
+
+type LogKeywordMatchHttpResult struct {
+	Status  string                   `json:"status"`
+	Message string                   `json:"message"`
+	Data    *LogKeywordMatchPageData `json:"data"`
+}
+
+type LogKeywordAlarmMatchResp struct {
+	Matches      []*LogKeywordMatchObj    `json:"matches"`
+	WindowCounts []*LogKeywordWindowCount `json:"window_counts"`
+}
+
+// AlarmLogContextTable 日志关键字告警最近一次匹配的上下文,agent不可用时从这里取
+type AlarmLogContextTable struct {
+	AlarmId      int       `json:"alarm_id" xorm:"alarm_id"`
+	LogPath      string    `json:"log_path" xorm:"log_path"`
+	Keyword      string    `json:"keyword" xorm:"keyword"`
+	MatchTime    time.Time `json:"match_time" xorm:"match_time"`
+	Content      string    `json:"content" xorm:"content"`
+	BeforeRows   string    `json:"before_rows" xorm:"before_rows"`
+	AfterRows    string    `json:"after_rows" xorm:"after_rows"`
+	WindowCounts string    `json:"window_counts" xorm:"window_counts"`
+	UpdateTime   time.Time `json:"update_time" xorm:"update_time"`
 }
 
 type LogKeywordHttpResult struct {
@@ -87,6 +172,7 @@ type LogKeywordCronJobQuery struct {
 	LogPath              string `xorm:"log_path"`
 	MonitorType          string `xorm:"monitor_type"`
 	Keyword              string `xorm:"keyword"`
+	ExcludeKeyword       string `xorm:"exclude_keyword"`
 	NotifyEnable         int    `xorm:"notify_enable"`
 	Priority             string `xorm:"priority"`
 	SourceEndpoint       string `xorm:"source_endpoint"`
@@ -96,6 +182,8 @@ type LogKeywordCronJobQuery struct {
 	Name                 string `xorm:"name"`
 	LogKeywordConfigGuid string `xorm:"log_keyword_config_guid"`
 	ActiveWindow         string `xorm:"active_window"`
+	ThresholdCount       int    `xorm:"threshold_count"`
+	ThresholdWindow      int    `xorm:"threshold_window"`
 }
 
 type LogKeywordRowsHttpDto struct {
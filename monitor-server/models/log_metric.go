@@ -3,18 +3,59 @@ package models
 import "time"
 
 const (
-	LogMonitorJsonType    = "json"
-	LogMonitorRegularType = "regular"
-	LogMonitorCustomType  = "custom"
+	LogMonitorJsonType     = "json"
+	LogMonitorRegularType  = "regular"
+	LogMonitorCustomType   = "custom"
+	LogMonitorLogfmtType   = "logfmt"
+	LogMonitorCsvType      = "csv"
+	LogMonitorCombinedType = "combined" // nginx/apache combined访问日志
 )
 
+// IsLogMonitorStructuredType logfmt、csv、combined按格式解析出字段,参数的json_key填字段名(csv填从1开始的列号)
+func IsLogMonitorStructuredType(logType string) bool {
+	return logType == LogMonitorLogfmtType || logType == LogMonitorCsvType || logType == LogMonitorCombinedType
+}
+
+const (
+	LogMetricAggHistogram = "histogram"
+	LogMetricAggSummary   = "summary"
+)
+
+// LogMetricAggTypeList 日志指标支持的聚合方式,p50~p99为采集周期内的分位值,histogram和summary输出Prometheus原生类型
+var LogMetricAggTypeList = []string{"sum", "count", "max", "min", "avg", "p50", "p90", "p95", "p99", LogMetricAggHistogram, LogMetricAggSummary}
+
+func IsLogMetricAggType(aggType string) bool {
+	for _, v := range LogMetricAggTypeList {
+		if v == aggType {
+			return true
+		}
+	}
+	return false
+}
+
 type LogMetricMonitorTable struct {
-	Guid         string `json:"guid" xorm:"guid"`
-	ServiceGroup string `json:"service_group" xorm:"service_group"`
-	LogPath      string `json:"log_path" xorm:"log_path"`
-	MetricType   string `json:"metric_type" xorm:"metric_type"`
-	MonitorType  string `json:"monitor_type" xorm:"monitor_type"`
-	UpdateTime   string `json:"update_time" xorm:"update_time"`
+	Guid              string `json:"guid" xorm:"guid"`
+	ServiceGroup      string `json:"service_group" xorm:"service_group"`
+	LogPath           string `json:"log_path" xorm:"log_path"`
+	MetricType        string `json:"metric_type" xorm:"metric_type"`
+	MonitorType       string `json:"monitor_type" xorm:"monitor_type"`
+	UpdateTime        string `json:"update_time" xorm:"update_time"`
+	MultilineStart    string `json:"multiline_start" xorm:"multiline_start"`
+	MultilineContinue string `json:"multiline_continue" xorm:"multiline_continue"`
+	MultilineMaxLines int    `json:"multiline_max_lines" xorm:"multiline_max_lines"`
+	MultilineTimeout  int    `json:"multiline_timeout" xorm:"multiline_timeout"`
+}
+
+// LogMultilineConfig 多行日志合并配置,start和continue都为空时按单行处理
+type LogMultilineConfig struct {
+	MultilineStart    string `json:"multiline_start"`    // 新事件首行的正则
+	MultilineContinue string `json:"multiline_continue"` // 续行的正则
+	MultilineMaxLines int    `json:"multiline_max_lines"`
+	MultilineTimeout  int    `json:"multiline_timeout"` // 秒,超过该时间没有新行则输出当前事件
+}
+
+func (t *LogMetricMonitorTable) GetMultilineConfig() LogMultilineConfig {
+	return LogMultilineConfig{MultilineStart: t.MultilineStart, MultilineContinue: t.MultilineContinue, MultilineMaxLines: t.MultilineMaxLines, MultilineTimeout: t.MultilineTimeout}
 }
 
 type LogMetricJsonTable struct {
@@ -40,6 +81,7 @@ type LogMetricConfigTable struct {
 	Regular          string    `json:"regular" xorm:"regular"`
 	AggType          string    `json:"agg_type" xorm:"agg_type"`
 	Step             int64     `json:"step" xorm:"step"`
+	Buckets          string    `json:"buckets" xorm:"buckets"`
 	TagConfig        string    `json:"-" xorm:"tag_config"`
 	TagConfigList    []string  `json:"tag_config" xorm:"-"`
 	CreateUser       string    `json:"create_user" xorm:"create_user"`
@@ -65,6 +107,7 @@ type LogMetricConfigDto struct {
 	Regular          string      `json:"regular"`
 	AggType          string      `json:"agg_type"`
 	Step             int64       `json:"step"`
+	Buckets          string      `json:"buckets"`
 	TagConfigList    []string    `json:"tag_config"`
 	CreateUser       string      `json:"create_user"`
 	UpdateUser       string      `json:"update_user"`
@@ -112,6 +155,7 @@ type LogMetricMonitorObj struct {
 	MetricConfigList []*LogMetricConfigObj        `json:"metric_config_list"`
 	EndpointRel      []*LogMetricEndpointRelTable `json:"endpoint_rel"`
 	MetricGroups     []*LogMetricGroupObj         `json:"metric_groups"`
+	LogMultilineConfig
 }
 
 type LogMetricJsonObj struct {
@@ -155,6 +199,7 @@ type LogMetricMonitorCreateDto struct {
 	MetricType   string                       `json:"metric_type" xorm:"metric_type"`
 	MonitorType  string                       `json:"monitor_type" xorm:"monitor_type"`
 	EndpointRel  []*LogMetricEndpointRelTable `json:"endpoint_rel"`
+	LogMultilineConfig
 }
 
 type LogMetricNodeExporterResponse struct {
@@ -162,6 +207,19 @@ type LogMetricNodeExporterResponse struct {
 	Message string `json:"message"`
 }
 
+// LogPathMatchObj 日志路径(可带通配符和日期占位符)在agent上当前匹配到的文件,type为keyword或metric
+type LogPathMatchObj struct {
+	Type  string   `json:"type"`
+	Path  string   `json:"path"`
+	Files []string `json:"files"`
+}
+
+type LogPathMatchResponse struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Data    []*LogPathMatchObj `json:"data"`
+}
+
 type LogMetricMonitorNeObj struct {
 	Path              string                 `json:"path"`
 	TargetEndpoint    string                 `json:"target_endpoint"`
@@ -169,6 +227,7 @@ type LogMetricMonitorNeObj struct {
 	JsonConfig        []*LogMetricJsonNeObj  `json:"config"`
 	MetricConfig      []*LogMetricNeObj      `json:"custom"`
 	MetricGroupConfig []*LogMetricGroupNeObj `json:"metric_group_config"`
+	LogMultilineConfig
 }
 
 type LogMetricJsonNeObj struct {
@@ -184,6 +243,7 @@ type LogMetricNeObj struct {
 	Title        string                     `json:"title"`
 	AggType      string                     `json:"agg_type"`
 	Step         int64                      `json:"step"`
+	Buckets      []float64                  `json:"buckets"`
 	StringMap    []*LogMetricStringMapNeObj `json:"string_map"`
 	TagConfig    []*LogMetricConfigTag      `json:"tag_config"`
 	LogParamName string                     `json:"log_param_name"`
@@ -198,8 +258,10 @@ type LogMetricStringMapNeObj struct {
 }
 
 type CheckRegExpParam struct {
-	RegString   string `json:"reg_string" binding:"required"`
+	RegString   string `json:"reg_string"`
 	TestContext string `json:"test_context" binding:"required"`
+	LogType     string `json:"log_type"`
+	Delimiter   string `json:"delimiter"`
 }
 
 type CheckRegExpResult struct {
@@ -215,6 +277,7 @@ type LogMetricGroupObj struct {
 	ServiceGroup              string                 `json:"service_group"`
 	MonitorType               string                 `json:"monitor_type"`
 	JsonRegular               string                 `json:"json_regular"`
+	Delimiter                 string                 `json:"delimiter"`
 	ParamList                 []*LogMetricParamObj   `json:"param_list"`
 	MetricList                []*LogMetricConfigDto  `json:"metric_list"`
 	AutoCreateWarn            bool                   `json:"auto_create_warn"`      //自动创建告警
@@ -237,6 +300,23 @@ type LogMetricGroupWithTemplate struct {
 	AutoCreateDashboard       bool                       `json:"auto_create_dashboard"` //自动创建自定义看板
 	LogMonitorTemplate        *LogMonitorTemplateDto     `json:"log_monitor_template"`
 	LogMonitorTemplateVersion string                     `json:"log_monitor_template_version"`
+	DerivedMetricList         []*LogMetricDerivedTable   `json:"derived_metric_list"` // 派生指标
+}
+
+// LogMetricDerivedTable 派生指标,用同一日志指标组下的指标做四则运算,如 req_fail_count / req_count * 100,由服务端生成表达式
+type LogMetricDerivedTable struct {
+	Guid           string    `json:"guid" xorm:"guid"`
+	LogMetricGroup string    `json:"log_metric_group" xorm:"log_metric_group"`
+	Metric         string    `json:"metric" xorm:"metric"`
+	DisplayName    string    `json:"display_name" xorm:"display_name"`
+	Expression     string    `json:"expression" xorm:"expression"`
+	ColorGroup     string    `json:"color_group" xorm:"color_group"`
+	AutoAlarm      bool      `json:"auto_alarm" xorm:"auto_alarm"`
+	RangeConfig    string    `json:"range_config" xorm:"range_config"`
+	CreateUser     string    `json:"create_user" xorm:"create_user"`
+	UpdateUser     string    `json:"update_user" xorm:"update_user"`
+	CreateTime     time.Time `json:"-" xorm:"create_time"`
+	UpdateTime     time.Time `json:"-" xorm:"update_time"`
 }
 
 type LogMetricThreshold struct {
@@ -252,6 +332,7 @@ type LogMetricGroupNeObj struct {
 	LogMetricGroup string                 `json:"log_metric_group"`
 	LogType        string                 `json:"log_type"`
 	JsonRegular    string                 `json:"json_regular"`
+	Delimiter      string                 `json:"delimiter"`
 	ParamList      []*LogMetricParamNeObj `json:"param_list"`
 	MetricConfig   []*LogMetricNeObj      `json:"custom"`
 }
@@ -278,3 +359,19 @@ type CreateLogMetricGroupDto struct {
 type IdsParam struct {
 	Ids []string `json:"ids"`
 }
+
+// LogMonitorThrottleObj 主机上被限流或抽样的日志监控,行数是最近5分钟的增量
+type LogMonitorThrottleObj struct {
+	Endpoint      string  `json:"endpoint"`
+	Ip            string  `json:"ip"`
+	MonitorType   string  `json:"monitor_type"`
+	Path          string  `json:"path"`
+	ServiceGroup  string  `json:"service_group"`
+	Lines         float64 `json:"lines"`
+	SampledLines  float64 `json:"sampled_lines"`
+	RateDropLines float64 `json:"rate_drop_lines"`
+	FullDropLines float64 `json:"full_drop_lines"`
+	DropPercent   float64 `json:"drop_percent"`
+	SampleEvery   float64 `json:"sample_every"`
+	Throttled     bool    `json:"throttled"`
+}
@@ -8,6 +8,7 @@ type LogMonitorTemplate struct {
 	LogType          string    `json:"log_type" xorm:"log_type"`
 	DemoLog          string    `json:"demo_log" xorm:"demo_log"`
 	JsonRegular      string    `json:"json_regular" xorm:"json_regular"`
+	Delimiter        string    `json:"delimiter" xorm:"delimiter"`
 	CalcResult       string    `json:"-" xorm:"calc_result"`
 	CreateUser       string    `json:"create_user" xorm:"create_user"`
 	UpdateUser       string    `json:"update_user" xorm:"update_user"`
@@ -101,6 +102,8 @@ type LogMonitorTemplateDto struct {
 	MetricList                []*LogMetricTemplate          `json:"metric_list"`
 	Permission                *LogMonitorTemplatePermission `json:"permission"`
 	LogMonitorTemplateVersion string                        `json:"log_monitor_template_version"`
+	Version                   int                           `json:"version"`    // 模版版本号
+	ChangeLog                 string                        `json:"change_log"` // 本次修改说明,更新时填写
 }
 
 type LogMonitorTemplatePermission struct {
@@ -117,11 +120,16 @@ type LogMonitorTemplateListResp struct {
 	JsonList    []*LogMonitorTemplate `json:"json_list"`
 	RegularList []*LogMonitorTemplate `json:"regular_list"`
 	CustomList  []*LogMonitorTemplate `json:"custom_list"`
+	// logfmt、csv、combined类型的模版
+	StructuredList []*LogMonitorTemplate `json:"structured_list"`
 }
 
 type LogMonitorRegMatchParam struct {
-	DemoLog   string                 `json:"demo_log"`
-	ParamList []*LogParamTemplateObj `json:"param_list"`
+	DemoLog     string                 `json:"demo_log"`
+	LogType     string                 `json:"log_type"`
+	JsonRegular string                 `json:"json_regular"`
+	Delimiter   string                 `json:"delimiter"`
+	ParamList   []*LogParamTemplateObj `json:"param_list"`
 }
 
 type LogMetricGroup struct {
@@ -143,8 +151,15 @@ type LogMetricGroup struct {
 	RefTemplateVersion string    `json:"log_monitor_template_version" xorm:"ref_template_version"`
 	AutoAlarm          int       `json:"auto_alarm" xorm:"auto_alarm"`
 	AutoDashboard      int       `json:"auto_dashboard" xorm:"auto_dashboard"`
+	// 锁定的模版版本,0表示跟随模版最新配置
+	PinnedTemplateVersion int `json:"pinned_template_version" xorm:"pinned_template_version"`
+	// 上一次发布前锁定的版本,用于回滚,0表示没有可回滚的发布,-1表示发布前跟随模版最新配置
+	PrevTemplateVersion int `json:"prev_template_version" xorm:"prev_template_version"`
 }
 
+// LogMetricGroupUnpinnedVersion 发布前未锁定版本的日志指标组记录的上一版本
+const LogMetricGroupUnpinnedVersion = -1
+
 type LogMetricParam struct {
 	Guid           string    `json:"guid" xorm:"guid"`
 	Name           string    `json:"name" xorm:"name"`
@@ -176,3 +191,39 @@ type LogTemplateAffectGroupObj struct {
 type LogTemplateExportParam struct {
 	GuidList []string `json:"guidList"`
 }
+
+type LogMonitorTemplateVersionTable struct {
+	Guid               string    `json:"guid" xorm:"guid"`
+	LogMonitorTemplate string    `json:"log_monitor_template" xorm:"log_monitor_template"`
+	Version            int       `json:"version" xorm:"version"`
+	Snapshot           string    `json:"-" xorm:"snapshot"`
+	ChangeLog          string    `json:"change_log" xorm:"change_log"`
+	CreateUser         string    `json:"create_user" xorm:"create_user"`
+	CreateTime         time.Time `json:"-" xorm:"create_time"`
+	CreateTimeString   string    `json:"create_time" xorm:"-"`
+	ServiceGroupList   []string  `json:"service_group_list" xorm:"-"` // 使用该版本的层级对象
+}
+
+type LogMonitorTemplateDiffObj struct {
+	Type     string `json:"type"`   // 差异类型 -> template(模版基本信息) | param(参数) | metric(指标)
+	Name     string `json:"name"`   // 字段名、参数名或指标名
+	Action   string `json:"action"` // add | delete | update
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+type LogMonitorTemplateDiffResp struct {
+	FromVersion int                          `json:"from_version"`
+	ToVersion   int                          `json:"to_version"`
+	DiffList    []*LogMonitorTemplateDiffObj `json:"diff_list"`
+}
+
+type LogMonitorTemplateRolloutParam struct {
+	LogMonitorTemplate string   `json:"log_monitor_template" binding:"required"`
+	Version            int      `json:"version"` // 回滚时不需要填
+	ServiceGroupList   []string `json:"service_group_list" binding:"required"`
+}
+
+type LogMonitorTemplateRolloutResp struct {
+	LogMetricGroupList []*LogMetricGroup `json:"log_metric_group_list"`
+}
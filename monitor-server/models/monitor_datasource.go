@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	DatasourceTypePrometheus      = "prometheus"
+	DatasourceTypeVictoriaMetrics = "victoriametrics"
+	DatasourceTypeThanos          = "thanos"
+	DatasourceStatusOk            = "ok"
+	DatasourceStatusError         = "error"
+	DatasourceStatusUnknown       = "unknown"
+	DatasourceTokenMask           = "******"
+)
+
+// MonitorDatasourceTable 兼容Prometheus查询接口的数据源,指标、对象类型和自定义图表通过guid引用
+type MonitorDatasourceTable struct {
+	Guid          string    `json:"guid" xorm:"guid"`
+	Name          string    `json:"name" xorm:"name" binding:"required"`
+	Type          string    `json:"type" xorm:"type"`
+	Address       string    `json:"address" xorm:"address" binding:"required"`
+	Token         string    `json:"token" xorm:"token"`
+	Region        string    `json:"region" xorm:"region"`
+	IsDefault     int       `json:"is_default" xorm:"is_default"`
+	Description   string    `json:"description" xorm:"description"`
+	Status        string    `json:"status" xorm:"status"`
+	StatusMessage string    `json:"status_message" xorm:"status_message"`
+	CheckAt       time.Time `json:"-" xorm:"check_at"`
+	CreateUser    string    `json:"create_user" xorm:"create_user"`
+	UpdateUser    string    `json:"update_user" xorm:"update_user"`
+	CreateAt      time.Time `json:"-" xorm:"create_at"`
+	UpdateAt      time.Time `json:"-" xorm:"update_at"`
+	CheckTime     string    `json:"check_time" xorm:"-"`
+	CreateTime    string    `json:"create_time" xorm:"-"`
+	UpdateTime    string    `json:"update_time" xorm:"-"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import "time"
+
+type NotifyChannelTable struct {
+	Guid          string    `json:"guid" xorm:"guid"`
+	Notify        string    `json:"notify" xorm:"notify"`
+	Name          string    `json:"name" xorm:"name"`
+	ChannelType   string    `json:"channel_type" xorm:"channel_type"` // webhook|slack|dingtalk|wecom|feishu|sms
+	Url           string    `json:"url" xorm:"url"`
+	Secret        string    `json:"secret" xorm:"secret"`
+	Template      string    `json:"template" xorm:"template"`
+	Receiver      string    `json:"receiver" xorm:"receiver"`
+	Timeout       int       `json:"timeout" xorm:"timeout"`
+	RetryTimes    int       `json:"retry_times" xorm:"retry_times"`
+	RetryInterval int       `json:"retry_interval" xorm:"retry_interval"`
+	Enable        int       `json:"enable" xorm:"enable"`
+	LastStatus    string    `json:"last_status" xorm:"last_status"` // success|fail
+	LastError     string    `json:"last_error" xorm:"last_error"`
+	LastAttempt   int       `json:"last_attempt" xorm:"last_attempt"`
+	LastSendTime  time.Time `json:"last_send_time" xorm:"last_send_time"`
+	UpdateTime    time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type NotifyChannelObj struct {
+	Guid          string `json:"guid"`
+	Name          string `json:"name"`
+	ChannelType   string `json:"channel_type"`
+	Url           string `json:"url"`
+	Secret        string `json:"secret"`
+	Template      string `json:"template"`
+	Receiver      string `json:"receiver"`
+	Timeout       int    `json:"timeout"`
+	RetryTimes    int    `json:"retry_times"`
+	RetryInterval int    `json:"retry_interval"`
+	Enable        *int   `json:"enable"` // 不传时默认启用
+	LastStatus    string `json:"last_status"`
+	LastError     string `json:"last_error"`
+	LastAttempt   int    `json:"last_attempt"`
+	LastSendTime  string `json:"last_send_time"`
+}
+
+// NotifyChannelTestParam 传了渠道guid时测试已保存的渠道配置,不传时按页面填的地址测试,只有管理员可以
+type NotifyChannelTestParam struct {
+	Channel *NotifyChannelObj `json:"channel" binding:"required"`
+	Subject string            `json:"subject"`
+	Content string            `json:"content"`
+}
+
+const (
+	NotifyChannelSecretMask = "******"
+
+	NotifyDeliveryChannelMail  = "mail"
+	NotifyDeliveryChannelEvent = "event"
+
+	NotifyDeliveryStatusPending = "pending"
+	NotifyDeliveryStatusSending = "sending"
+	NotifyDeliveryStatusSuccess = "success"
+	NotifyDeliveryStatusFail    = "fail"
+	NotifyDeliveryStatusCancel  = "cancel"
+)
+
+type NotifyDeliveryTable struct {
+	Id          int       `json:"id" xorm:"id"`
+	AlarmId     int       `json:"alarm_id" xorm:"alarm_id"`
+	AlarmStatus string    `json:"alarm_status" xorm:"alarm_status"`
+	NotifyId    string    `json:"notify_id" xorm:"notify_id"`
+	Channel     string    `json:"channel" xorm:"channel"`           // mail|event|notify_channel.guid
+	ChannelType string    `json:"channel_type" xorm:"channel_type"` // mail|event|webhook|slack|dingtalk|wecom|feishu|sms
+	Receivers   string    `json:"receivers" xorm:"receivers"`
+	Payload     string    `json:"payload" xorm:"payload"`
+	Status      string    `json:"status" xorm:"status"` // pending|sending|success|fail|cancel
+	Attempt     int       `json:"attempt" xorm:"attempt"`
+	MaxAttempt  int       `json:"max_attempt" xorm:"max_attempt"`
+	RetryBase   int       `json:"retry_base" xorm:"retry_base"`
+	NotifyDelay int       `json:"notify_delay" xorm:"notify_delay"`
+	LastError   string    `json:"last_error" xorm:"last_error"`
+	Operator    string    `json:"operator" xorm:"operator"`
+	NextTime    time.Time `json:"next_time" xorm:"next_time"`
+	CreateTime  time.Time `json:"create_time" xorm:"create_time"`
+	UpdateTime  time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type NotifyDeliveryObj struct {
+	Id          int    `json:"id"`
+	AlarmId     int    `json:"alarm_id"`
+	AlarmStatus string `json:"alarm_status"`
+	NotifyId    string `json:"notify_id"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	Receivers   string `json:"receivers"`
+	Payload     string `json:"payload"`
+	Status      string `json:"status"`
+	Attempt     int    `json:"attempt"`
+	MaxAttempt  int    `json:"max_attempt"`
+	LastError   string `json:"last_error"`
+	Operator    string `json:"operator"`
+	NextTime    string `json:"next_time"`
+	CreateTime  string `json:"create_time"`
+	UpdateTime  string `json:"update_time"`
+}
+
+type NotifyDeliveryQueryParam struct {
+	AlarmId     int    `json:"alarm_id"`
+	NotifyId    string `json:"notify_id"`
+	Status      string `json:"status"`
+	ChannelType string `json:"channel_type"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	StartIndex  int    `json:"startIndex"`
+	PageSize    int    `json:"pageSize"`
+}
+
+type NotifyDeliveryRetryParam struct {
+	Ids []int `json:"ids" binding:"required"`
+}
+
+// NotifyDeliveryMailPayload 邮件投递内容,入队时已经渲染好
+type NotifyDeliveryMailPayload struct {
+	Subject string   `json:"subject"`
+	Content string   `json:"content"`
+	To      []string `json:"to"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+type AlarmSilenceTable struct {
+	Guid          string    `json:"guid" xorm:"guid"`
+	StartTime     time.Time `json:"start_time" xorm:"start_time"`
+	EndTime       time.Time `json:"end_time" xorm:"end_time"`
+	Comment       string    `json:"comment" xorm:"comment"`
+	Endpoint      string    `json:"endpoint" xorm:"endpoint"`
+	EndpointGroup string    `json:"endpoint_group" xorm:"endpoint_group"`
+	ServiceGroup  string    `json:"service_group" xorm:"service_group"`
+	Metric        string    `json:"metric" xorm:"metric"`
+	AlarmStrategy string    `json:"alarm_strategy" xorm:"alarm_strategy"`
+	Tags          string    `json:"tags" xorm:"tags"` // key=value,多个用逗号分隔,需全部命中
+	CreateUser    string    `json:"create_user" xorm:"create_user"`
+	CreateTime    time.Time `json:"create_time" xorm:"create_time"`
+	UpdateUser    string    `json:"update_user" xorm:"update_user"`
+	UpdateTime    time.Time `json:"update_time" xorm:"update_time"`
+}
+
+type AlarmSilenceMutedTable struct {
+	Id            int       `json:"id" xorm:"id"`
+	Silence       string    `json:"silence" xorm:"silence"`
+	AlarmKey      string    `json:"alarm_key" xorm:"alarm_key"`
+	Endpoint      string    `json:"endpoint" xorm:"endpoint"`
+	Metric        string    `json:"metric" xorm:"metric"`
+	AlarmStrategy string    `json:"alarm_strategy" xorm:"alarm_strategy"`
+	AlarmName     string    `json:"alarm_name" xorm:"alarm_name"`
+	Tags          string    `json:"tags" xorm:"tags"`
+	MutedCount    int       `json:"muted_count" xorm:"muted_count"`
+	FirstTime     time.Time `json:"first_time" xorm:"first_time"`
+	LastTime      time.Time `json:"last_time" xorm:"last_time"`
+}
+
+type AlarmSilenceObj struct {
+	Guid          string   `json:"guid"`
+	StartTime     string   `json:"start_time" binding:"required"`
+	EndTime       string   `json:"end_time" binding:"required"`
+	Comment       string   `json:"comment"`
+	Endpoint      string   `json:"endpoint"`
+	EndpointGroup string   `json:"endpoint_group"`
+	ServiceGroup  string   `json:"service_group"`
+	Metric        string   `json:"metric"`
+	AlarmStrategy string   `json:"alarm_strategy"`
+	Tags          []string `json:"tags"`
+	Status        string   `json:"status"` // pending|active|expired
+	MutedCount    int      `json:"muted_count"`
+	CreateUser    string   `json:"create_user"`
+	CreateTime    string   `json:"create_time"`
+	UpdateUser    string   `json:"update_user"`
+	UpdateTime    string   `json:"update_time"`
+}
+
+type AlarmSilenceQueryParam struct {
+	Status     string `json:"status"` // pending|active|expired,空表示全部
+	Endpoint   string `json:"endpoint"`
+	StartIndex int    `json:"startIndex"`
+	PageSize   int    `json:"pageSize"`
+}
+
+type AlarmSilenceMutedObj struct {
+	Endpoint      string `json:"endpoint"`
+	Metric        string `json:"metric"`
+	AlarmStrategy string `json:"alarm_strategy"`
+	AlarmName     string `json:"alarm_name"`
+	Tags          string `json:"tags"`
+	MutedCount    int    `json:"muted_count"`
+	FirstTime     string `json:"first_time"`
+	LastTime      string `json:"last_time"`
+}
+
+// AlarmSilenceMatchParam 新告警用来匹配静默规则的信息
+type AlarmSilenceMatchParam struct {
+	Endpoint      string
+	EndpointGroup string
+	Metric        string
+	AlarmStrategy string
+	AlarmName     string
+	Tags          string
+}
@@ -8,6 +8,12 @@ type TypeConfig struct {
 	CreateUser  string `json:"createUser" xorm:"create_user"`
 	CreateTime  string `json:"createTime" xorm:"create_time"`
 	ObjectCount int    `json:"objectCount" xorm:"-"` // 对象数
+	Datasource  string `json:"datasource" xorm:"datasource"`
+}
+
+type TypeConfigDatasourceParam struct {
+	Guid       string `json:"guid" binding:"required"`
+	Datasource string `json:"datasource"`
 }
 
 type BatchAddTypeConfigParam struct {
@@ -41,6 +41,8 @@ type DataSourceParam struct {
 	DataSource  *DataSource
 	Host  string
 	Token  string
+	Guid  string
+	AuthToken  string
 }
 
 var ptc = proxyTransportCache{
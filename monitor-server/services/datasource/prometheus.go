@@ -1,12 +1,10 @@
 package datasource
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
 	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
-	"golang.org/x/net/context/ctxhttp"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -28,18 +26,17 @@ func InitPrometheusDatasource() {
 var PieLegendBlackName = []string{"job", "instance", "__name__", "e_guid"}
 
 func PrometheusData(query *m.QueryMonitorData) []*m.SerialModel {
-	log.Logger.Debug("prometheus data query", log.JsonObj("queryParam", query))
-	serials := []*m.SerialModel{}
-	urlParams := url.Values{}
-	hostAddress := promDS.Host
-	if query.Cluster != "" && query.Cluster != "default" {
-		hostAddress = query.Cluster
-	}
-	requestUrl, err := url.Parse(fmt.Sprintf("http://%s/api/v1/query_range", hostAddress))
+	queryResult, err := QueryMonitorRangeData(query)
 	if err != nil {
-		log.Logger.Error("Make url fail", log.Error(err))
-		return serials
+		log.Logger.Error("Query prometheus data fail", log.Error(err))
+		return []*m.SerialModel{}
 	}
+	return BuildSerialData(query, queryResult)
+}
+
+// QueryMonitorRangeData 查询原始数据,步长按查询时长放大,开始结束时间按步长对齐后走查询缓存
+func QueryMonitorRangeData(query *m.QueryMonitorData) (queryResult *m.PrometheusData, err error) {
+	log.Logger.Debug("prometheus data query", log.JsonObj("queryParam", query))
 	var tmpStep int64
 	tmpStep = 10
 	if query.Step > 0 && query.Step != 10 {
@@ -52,59 +49,41 @@ func PrometheusData(query *m.QueryMonitorData) []*m.SerialModel {
 	if subSec > 86400 {
 		tmpStep = tmpStep * (subSec/86400 + 1)
 	}
-	urlParams.Set("start", strconv.FormatInt(query.Start, 10))
-	urlParams.Set("end", strconv.FormatInt(query.End, 10))
-	urlParams.Set("step", fmt.Sprintf("%d", tmpStep))
-	urlParams.Set("query", query.PromQ)
-	requestUrl.RawQuery = urlParams.Encode()
-	req, err := http.NewRequest(http.MethodGet, requestUrl.String(), strings.NewReader(""))
-	if err != nil {
-		log.Logger.Error("Failed to create request", log.Error(err))
-		return serials
-	}
-	req.Header.Set("Content-Type", "application/json")
-	httpClient, err := promDS.DataSource.GetHttpClient()
-	if err != nil {
-		log.Logger.Error("Get httpClient fail", log.Error(err))
-		return serials
-	}
-	res, err := ctxhttp.Do(context.Background(), httpClient, req)
-	if err != nil {
-		log.Logger.Error("Http request fail", log.Error(err))
-		return serials
-	}
-	body, err := ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
-	if err != nil {
-		log.Logger.Error("Http request body read fail", log.Error(err))
-		return serials
-	}
-	//log.Logger.Debug("prometheus data result", log.String("response", string(body)))
-	if res.StatusCode/100 != 2 {
-		log.Logger.Warn("Request fail with bad status", log.String("status", res.Status))
-		return serials
-	}
-	var data m.PrometheusResponse
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		log.Logger.Error("Unmarshal response fail", log.Error(err))
-		return serials
-	}
-	if data.Status != "success" {
-		log.Logger.Warn("Query prometheus data fail", log.String("status", data.Status))
-		return serials
+	queryStart, queryEnd := alignQueryTime(query.Start, query.End, tmpStep)
+	if len(query.DatasourceList) > 0 {
+		cacheKey := buildQueryCacheKey(strings.Join(query.DatasourceList, ","), query.PromQ, queryStart, queryEnd, tmpStep)
+		queryResult, err = queryRangeWithCache(cacheKey, func() (*m.PrometheusData, error) {
+			return QueryPrometheusRangeByDatasource(query.DatasourceList, query.PromQ, queryStart, queryEnd, tmpStep)
+		})
+	} else {
+		dsParam := getDatasourceParam("")
+		// 兼容按集群地址查询
+		if query.Cluster != "" && query.Cluster != "default" {
+			dsParam = promDS
+			dsParam.Host = query.Cluster
+		}
+		cacheKey := buildQueryCacheKey(dsParam.Host, query.PromQ, queryStart, queryEnd, tmpStep)
+		queryResult, err = queryRangeWithCache(cacheKey, func() (*m.PrometheusData, error) {
+			return queryDatasourceRange(dsParam, query.PromQ, queryStart, queryEnd, tmpStep)
+		})
 	}
+	return
+}
+
+// BuildSerialData 把原始数据转成图表序列,饼图数据写到query.PieData
+func BuildSerialData(query *m.QueryMonitorData, queryResult *m.PrometheusData) []*m.SerialModel {
+	serials := []*m.SerialModel{}
 	if query.ChartType == "pie" {
-		buildPieData(query, data.Data.Result)
+		buildPieData(query, queryResult.Result)
 		return serials
 	}
-	for _, otr := range data.Data.Result {
+	for _, otr := range queryResult.Result {
 		//if len(otr.Metric) == 0 {
 		//	continue
 		//}
 		var serial m.SerialModel
 		serial.Type = "line"
-		serial.Name = GetSerialName(query, otr.Metric, len(data.Data.Result), query.CustomDashboard)
+		serial.Name = GetSerialName(query, otr.Metric, len(queryResult.Result), query.CustomDashboard)
 		// 同环比 指标
 		if query.ComparisonFlag == "Y" {
 			if otr.Metric["calc_type"] == "diff" {
@@ -128,35 +107,14 @@ func PrometheusData(query *m.QueryMonitorData) []*m.SerialModel {
 }
 
 func CheckPrometheusQL(promQl string) error {
-	hostAddress := promDS.Host
-	requestUrl, _ := url.Parse(fmt.Sprintf("http://%s/api/v1/query_range", hostAddress))
 	nowTime := time.Now().Unix()
 	urlParams := url.Values{}
 	urlParams.Set("start", strconv.FormatInt(nowTime-10, 10))
 	urlParams.Set("end", strconv.FormatInt(nowTime, 10))
 	urlParams.Set("step", "10")
 	urlParams.Set("query", promQl)
-	requestUrl.RawQuery = urlParams.Encode()
-	req, err := http.NewRequest(http.MethodGet, requestUrl.String(), strings.NewReader(""))
-	if err != nil {
-		return fmt.Errorf("Failed to create request:%s ", err.Error())
-	}
-	req.Header.Set("Content-Type", "application/json")
-	httpClient, getClientErr := promDS.DataSource.GetHttpClient()
-	if getClientErr != nil {
-		return fmt.Errorf("Get httpClient fail:%s ", getClientErr.Error())
-	}
-	res, doHttpErr := ctxhttp.Do(context.Background(), httpClient, req)
-	if doHttpErr != nil {
-		return fmt.Errorf("Http request fail:%s ", doHttpErr.Error())
-	}
-	_, err = ioutil.ReadAll(res.Body)
-	defer res.Body.Close()
-	if err != nil {
-		return fmt.Errorf("Http request body read fail:%s ", err.Error())
-	}
-	if res.StatusCode/100 != 2 {
-		return fmt.Errorf("Request fail with bad statusCode: %d ", res.StatusCode)
+	if _, err := doDatasourceRequest(getDatasourceParam(""), "/api/v1/query_range", urlParams); err != nil {
+		return fmt.Errorf("Check promQl fail:%s ", err.Error())
 	}
 	return nil
 }
@@ -453,12 +411,20 @@ func QueryPromQLMetric(promQl, address string, start, end int64) (metricList []s
 
 // QueryLogKeywordData keywordMode -> log | db
 func QueryLogKeywordData(keywordMode string) (result map[string]float64, err error) {
+	return queryLogKeywordDataAt(keywordMode, time.Now().Unix())
+}
+
+// QueryLogKeywordHistoryData 查询若干秒之前的关键字计数,用于计算时间窗口内的增量
+func QueryLogKeywordHistoryData(keywordMode string, offsetSeconds int64) (result map[string]float64, err error) {
+	return queryLogKeywordDataAt(keywordMode, time.Now().Unix()-offsetSeconds)
+}
+
+func queryLogKeywordDataAt(keywordMode string, nowTime int64) (result map[string]float64, err error) {
 	result = make(map[string]float64)
 	queryQl := "node_log_monitor_count_total"
 	if keywordMode == "db" {
 		queryQl = "db_keyword_value"
 	}
-	nowTime := time.Now().Unix()
 	queryResult, queryErr := QueryPrometheusRange(queryQl, nowTime-10, nowTime, 10)
 	if queryErr != nil {
 		err = queryErr
@@ -477,11 +443,60 @@ func QueryLogKeywordData(keywordMode string) (result map[string]float64, err err
 	}
 	for _, otr := range queryResult.Result {
 		key := fmt.Sprintf("e_guid:%s^t_guid:%s^file:%s^keyword:%s", otr.Metric["e_guid"], otr.Metric["t_guid"], otr.Metric["file"], otr.Metric["keyword"])
+		// 关键字相同排除条件不同的规则分开计数
+		if excludeKeyword := otr.Metric["exclude_keyword"]; excludeKeyword != "" {
+			key += "^exclude_keyword:" + excludeKeyword
+		}
 		tmpValue := float64(0)
 		if len(otr.Values) > 0 {
 			tmpValue, _ = strconv.ParseFloat(otr.Values[len(otr.Values)-1][1].(string), 64)
 		}
-		result[key] = tmpValue
+		// 通配符路径会按实际文件(real_file)拆成多条数据,按配置的路径汇总
+		result[key] += tmpValue
+	}
+	return
+}
+
+// QueryLogThrottleData 查询agent上报的日志监控限流数据,key为 e_guid^type^file^service_group
+func QueryLogThrottleData() (result map[string]*m.LogMonitorThrottleObj, err error) {
+	result = make(map[string]*m.LogMonitorThrottleObj)
+	nowTime := time.Now().Unix()
+	queryList := []string{"increase(node_log_throttle_lines_total[5m])", "increase(node_log_throttle_dropped_lines_total[5m])", "node_log_throttle_sample_every", "max_over_time(node_log_throttle_throttled[5m])"}
+	for i, queryQl := range queryList {
+		queryResult, queryErr := QueryPrometheusRange(queryQl, nowTime-10, nowTime, 10)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		for _, otr := range queryResult.Result {
+			if len(otr.Values) == 0 {
+				continue
+			}
+			tmpValue, _ := strconv.ParseFloat(otr.Values[len(otr.Values)-1][1].(string), 64)
+			key := fmt.Sprintf("%s^%s^%s^%s", otr.Metric["e_guid"], otr.Metric["type"], otr.Metric["file"], otr.Metric["service_group"])
+			throttleObj, b := result[key]
+			if !b {
+				throttleObj = &m.LogMonitorThrottleObj{Endpoint: otr.Metric["e_guid"], MonitorType: otr.Metric["type"], Path: otr.Metric["file"], ServiceGroup: otr.Metric["service_group"], SampleEvery: 1}
+				result[key] = throttleObj
+			}
+			switch i {
+			case 0:
+				throttleObj.Lines = tmpValue
+			case 1:
+				switch otr.Metric["reason"] {
+				case "sample":
+					throttleObj.SampledLines = tmpValue
+				case "rate_limit":
+					throttleObj.RateDropLines = tmpValue
+				case "channel_full":
+					throttleObj.FullDropLines = tmpValue
+				}
+			case 2:
+				throttleObj.SampleEvery = tmpValue
+			case 3:
+				throttleObj.Throttled = tmpValue > 0
+			}
+		}
 	}
 	return
 }
@@ -494,28 +509,17 @@ func QueryPromSeries(promQL string) (result []map[string]string, err error) {
 	//		promQL = strings.Replace(promQL, string(vv), "=~\".*\"", -1)
 	//	}
 	//}
+	return QueryPromSeriesByDatasource("", promQL)
+}
+
+// QueryPromSeriesByDatasource 在指定数据源上查询序列,datasourceGuid为空时用默认数据源
+func QueryPromSeriesByDatasource(datasourceGuid, promQL string) (result []map[string]string, err error) {
 	promQL = getPromQlMainExpr(promQL)
-	requestUrl, urlParseErr := url.Parse(fmt.Sprintf("http://%s/api/v1/series", promDS.Host))
-	if urlParseErr != nil {
-		return result, fmt.Errorf("Url parse fail,%s ", urlParseErr.Error())
-	}
 	urlParams := url.Values{}
 	urlParams.Set("match[]", promQL)
-	requestUrl.RawQuery = urlParams.Encode()
-	req, _ := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
-	req.Header.Set("Content-Type", "application/json")
-	httpClient, getClientErr := promDS.DataSource.GetHttpClient()
-	if getClientErr != nil {
-		return result, fmt.Errorf("Get httpClient fail,%s ", getClientErr.Error())
-	}
-	res, reqErr := ctxhttp.Do(context.Background(), httpClient, req)
+	body, reqErr := doDatasourceRequest(getDatasourceParam(datasourceGuid), "/api/v1/series", urlParams)
 	if reqErr != nil {
-		return result, fmt.Errorf("http do request fail,%s ", reqErr.Error())
-	}
-	body, _ := ioutil.ReadAll(res.Body)
-	res.Body.Close()
-	if res.StatusCode/100 != 2 {
-		return result, fmt.Errorf("Request fail with bad status:%d ", res.StatusCode)
+		return result, reqErr
 	}
 	var data m.PromSeriesResponse
 	err = json.Unmarshal(body, &data)
@@ -544,41 +548,7 @@ func getPromQlMainExpr(input string) (output string) {
 
 // QueryPrometheusRange start/end/step second value
 func QueryPrometheusRange(promQL string, start, end, step int64) (result *m.PrometheusData, err error) {
-	requestUrl, urlParseErr := url.Parse(fmt.Sprintf("http://%s/api/v1/query_range", promDS.Host))
-	if urlParseErr != nil {
-		return result, fmt.Errorf("Url parse fail,%s ", urlParseErr.Error())
-	}
-	urlParams := url.Values{}
-	urlParams.Set("start", strconv.FormatInt(start, 10))
-	urlParams.Set("end", strconv.FormatInt(end, 10))
-	urlParams.Set("step", strconv.FormatInt(step, 10))
-	urlParams.Set("query", promQL)
-	requestUrl.RawQuery = urlParams.Encode()
-	req, _ := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
-	req.Header.Set("Content-Type", "application/json")
-	httpClient, getClientErr := promDS.DataSource.GetHttpClient()
-	if getClientErr != nil {
-		return result, fmt.Errorf("Get httpClient fail,%s ", getClientErr.Error())
-	}
-	res, reqErr := ctxhttp.Do(context.Background(), httpClient, req)
-	if reqErr != nil {
-		return result, fmt.Errorf("http do request fail,%s ", reqErr.Error())
-	}
-	body, _ := ioutil.ReadAll(res.Body)
-	res.Body.Close()
-	if res.StatusCode/100 != 2 {
-		return result, fmt.Errorf("Request fail with bad status:%d ", res.StatusCode)
-	}
-	var data m.PrometheusResponse
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return result, fmt.Errorf("Json unmarshal response fail,%s ", err.Error())
-	}
-	if data.Status != "success" {
-		return result, fmt.Errorf("Query prometheus data fail,status:%s ", data.Status)
-	}
-	result = &data.Data
-	return
+	return queryDatasourceRange(getDatasourceParam(""), promQL, start, end, step)
 }
 
 // ResetPrometheusMetricMap 重置 Prometheus返回的metric
@@ -0,0 +1,178 @@
+package datasource
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+const (
+	queryCacheDefaultTtl       = 10
+	queryCacheDefaultMaxMemory = 64
+)
+
+var (
+	queryCacheObj = &queryCache{entryMap: make(map[string]*list.Element), lruList: list.New(), callMap: make(map[string]*queryCacheCall)}
+)
+
+type queryCache struct {
+	lock          sync.Mutex
+	entryMap      map[string]*list.Element
+	lruList       *list.List
+	callMap       map[string]*queryCacheCall
+	usedBytes     int64
+	hitCount      int64
+	missCount     int64
+	coalesceCount int64
+	evictCount    int64
+}
+
+type queryCacheEntry struct {
+	key      string
+	data     *m.PrometheusData
+	size     int64
+	expireAt time.Time
+}
+
+// queryCacheCall 同一个key正在查询时,后来的请求等待这次查询的结果,不再重复请求数据源
+type queryCacheCall struct {
+	wg   sync.WaitGroup
+	data *m.PrometheusData
+	err  error
+}
+
+// alignQueryTime 开始和结束时间按步长向下取整,同一个步长内打开的看板用同一份缓存
+func alignQueryTime(start, end, step int64) (int64, int64) {
+	if step <= 0 {
+		return start, end
+	}
+	return start - start%step, end - end%step
+}
+
+func buildQueryCacheKey(source, promQL string, start, end, step int64) string {
+	return fmt.Sprintf("%s|%d|%d|%d|%s", source, start, end, step, promQL)
+}
+
+// queryRangeWithCache 缓存没开时直接查,缓存中的结果只读,调用方不能修改
+func queryRangeWithCache(key string, queryFunc func() (*m.PrometheusData, error)) (*m.PrometheusData, error) {
+	cacheConfig := m.Config().Datasource.QueryCache
+	if !cacheConfig.Enable {
+		return queryFunc()
+	}
+	return queryCacheObj.get(key, cacheConfig, queryFunc)
+}
+
+func (q *queryCache) get(key string, cacheConfig m.QueryCacheConfig, queryFunc func() (*m.PrometheusData, error)) (*m.PrometheusData, error) {
+	q.lock.Lock()
+	if element, b := q.entryMap[key]; b {
+		entry := element.Value.(*queryCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			q.lruList.MoveToFront(element)
+			q.lock.Unlock()
+			atomic.AddInt64(&q.hitCount, 1)
+			return entry.data, nil
+		}
+		q.removeElement(element)
+	}
+	if call, b := q.callMap[key]; b {
+		q.lock.Unlock()
+		atomic.AddInt64(&q.coalesceCount, 1)
+		call.wg.Wait()
+		return call.data, call.err
+	}
+	call := &queryCacheCall{}
+	call.wg.Add(1)
+	q.callMap[key] = call
+	q.lock.Unlock()
+	atomic.AddInt64(&q.missCount, 1)
+	q.doCall(key, call, cacheConfig, queryFunc)
+	return call.data, call.err
+}
+
+// doCall 查询函数panic时也要清理callMap并唤醒等待的请求,等待的请求拿到错误返回
+func (q *queryCache) doCall(key string, call *queryCacheCall, cacheConfig m.QueryCacheConfig, queryFunc func() (*m.PrometheusData, error)) {
+	defer func() {
+		q.lock.Lock()
+		delete(q.callMap, key)
+		if call.err == nil && call.data != nil {
+			q.add(key, call.data, cacheConfig)
+		}
+		q.lock.Unlock()
+		call.wg.Done()
+	}()
+	call.err = fmt.Errorf("query %s panic", key)
+	call.data, call.err = queryFunc()
+}
+
+func (q *queryCache) add(key string, data *m.PrometheusData, cacheConfig m.QueryCacheConfig) {
+	ttl := cacheConfig.TtlSeconds
+	if ttl <= 0 {
+		ttl = queryCacheDefaultTtl
+	}
+	maxMemory := cacheConfig.MaxMemoryMb
+	if maxMemory <= 0 {
+		maxMemory = queryCacheDefaultMaxMemory
+	}
+	maxBytes := int64(maxMemory) * 1024 * 1024
+	size := estimateQueryDataSize(key, data)
+	if size > maxBytes {
+		return
+	}
+	if element, b := q.entryMap[key]; b {
+		q.removeElement(element)
+	}
+	for q.usedBytes+size > maxBytes && q.lruList.Len() > 0 {
+		q.removeElement(q.lruList.Back())
+		q.evictCount++
+	}
+	entry := &queryCacheEntry{key: key, data: data, size: size, expireAt: time.Now().Add(time.Duration(ttl) * time.Second)}
+	q.entryMap[key] = q.lruList.PushFront(entry)
+	q.usedBytes += size
+}
+
+func (q *queryCache) removeElement(element *list.Element) {
+	entry := element.Value.(*queryCacheEntry)
+	q.lruList.Remove(element)
+	delete(q.entryMap, entry.key)
+	q.usedBytes -= entry.size
+}
+
+// estimateQueryDataSize 粗略估算占用内存,每个点按时间戳和字符串值算
+func estimateQueryDataSize(key string, data *m.PrometheusData) int64 {
+	size := int64(len(key)) + 64
+	for _, series := range data.Result {
+		for k, v := range series.Metric {
+			size += int64(len(k)+len(v)) + 32
+		}
+		size += int64(len(series.Values)) * 64
+	}
+	return size
+}
+
+// QueryCacheMetrics 按Prometheus文本格式输出缓存命中情况
+func QueryCacheMetrics() string {
+	queryCacheObj.lock.Lock()
+	entryCount, usedBytes, evictCount := queryCacheObj.lruList.Len(), queryCacheObj.usedBytes, queryCacheObj.evictCount
+	queryCacheObj.lock.Unlock()
+	var buf bytes.Buffer
+	buf.WriteString("# HELP open_monitor_query_cache_requests_total Chart query requests handled by the query cache.\n")
+	buf.WriteString("# TYPE open_monitor_query_cache_requests_total counter\n")
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_requests_total{result=\"hit\"} %d\n", atomic.LoadInt64(&queryCacheObj.hitCount)))
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_requests_total{result=\"miss\"} %d\n", atomic.LoadInt64(&queryCacheObj.missCount)))
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_requests_total{result=\"coalesced\"} %d\n", atomic.LoadInt64(&queryCacheObj.coalesceCount)))
+	buf.WriteString("# HELP open_monitor_query_cache_evictions_total Entries evicted from the query cache because of the memory cap.\n")
+	buf.WriteString("# TYPE open_monitor_query_cache_evictions_total counter\n")
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_evictions_total %d\n", evictCount))
+	buf.WriteString("# HELP open_monitor_query_cache_entries Entries in the query cache.\n")
+	buf.WriteString("# TYPE open_monitor_query_cache_entries gauge\n")
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_entries %d\n", entryCount))
+	buf.WriteString("# HELP open_monitor_query_cache_bytes Estimated memory used by the query cache.\n")
+	buf.WriteString("# TYPE open_monitor_query_cache_bytes gauge\n")
+	buf.WriteString(fmt.Sprintf("open_monitor_query_cache_bytes %d\n", usedBytes))
+	return buf.String()
+}
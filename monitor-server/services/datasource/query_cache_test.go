@@ -0,0 +1,170 @@
+package datasource
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func newTestQueryCache() *queryCache {
+	return &queryCache{entryMap: make(map[string]*list.Element), lruList: list.New(), callMap: make(map[string]*queryCacheCall)}
+}
+
+// newTestQueryData 每个点按64字节估算,pointNum控制估算的内存大小
+func newTestQueryData(pointNum int) *m.PrometheusData {
+	values := make([][]interface{}, pointNum)
+	for i := range values {
+		values[i] = []interface{}{float64(i), "1"}
+	}
+	return &m.PrometheusData{ResultType: "matrix", Result: []m.PrometheusResult{{Metric: map[string]string{"instance": "a"}, Values: values}}}
+}
+
+func queryTestData(data *m.PrometheusData) func() (*m.PrometheusData, error) {
+	return func() (*m.PrometheusData, error) {
+		return data, nil
+	}
+}
+
+func TestQueryCacheLruEvict(t *testing.T) {
+	q := newTestQueryCache()
+	// 每个条目约384KB,1MB只能放下2个
+	cacheConfig := m.QueryCacheConfig{Enable: true, TtlSeconds: 60, MaxMemoryMb: 1}
+	for _, key := range []string{"a", "b"} {
+		q.get(key, cacheConfig, queryTestData(newTestQueryData(6000)))
+	}
+	// 访问a后b变成最久没用的
+	q.get("a", cacheConfig, queryTestData(nil))
+	q.get("c", cacheConfig, queryTestData(newTestQueryData(6000)))
+	if _, b := q.entryMap["b"]; b {
+		t.Errorf("least recently used entry b should be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, b := q.entryMap[key]; !b {
+			t.Errorf("entry %s should be kept", key)
+		}
+	}
+	if q.evictCount != 1 || q.lruList.Len() != 2 || q.hitCount != 1 {
+		t.Errorf("want evict 1 entries 2 hit 1, got evict %d entries %d hit %d", q.evictCount, q.lruList.Len(), q.hitCount)
+	}
+}
+
+func TestQueryCacheMemoryCap(t *testing.T) {
+	q := newTestQueryCache()
+	cacheConfig := m.QueryCacheConfig{Enable: true, TtlSeconds: 60, MaxMemoryMb: 1}
+	maxBytes := int64(cacheConfig.MaxMemoryMb) * 1024 * 1024
+	for i := 0; i < 20; i++ {
+		q.get(fmt.Sprintf("key%d", i), cacheConfig, queryTestData(newTestQueryData(1000)))
+		if q.usedBytes > maxBytes {
+			t.Fatalf("used bytes %d over memory cap %d", q.usedBytes, maxBytes)
+		}
+	}
+	// 单个结果超过上限时不缓存
+	q.get("huge", cacheConfig, queryTestData(newTestQueryData(20000)))
+	if _, b := q.entryMap["huge"]; b {
+		t.Errorf("data larger than memory cap should not be cached")
+	}
+	var usedBytes int64
+	for element := q.lruList.Front(); element != nil; element = element.Next() {
+		usedBytes += element.Value.(*queryCacheEntry).size
+	}
+	if usedBytes != q.usedBytes {
+		t.Errorf("used bytes %d not equal to sum of entries %d", q.usedBytes, usedBytes)
+	}
+}
+
+func TestQueryCacheTtl(t *testing.T) {
+	q := newTestQueryCache()
+	cacheConfig := m.QueryCacheConfig{Enable: true, TtlSeconds: 60, MaxMemoryMb: 1}
+	first, second := newTestQueryData(1), newTestQueryData(1)
+	q.get("a", cacheConfig, queryTestData(first))
+	if data, _ := q.get("a", cacheConfig, queryTestData(second)); data != first {
+		t.Fatalf("want cached data before expire")
+	}
+	q.entryMap["a"].Value.(*queryCacheEntry).expireAt = time.Now().Add(-time.Second)
+	if data, _ := q.get("a", cacheConfig, queryTestData(second)); data != second {
+		t.Fatalf("want query again after expire")
+	}
+	if q.lruList.Len() != 1 || q.missCount != 2 {
+		t.Errorf("want 1 entry miss 2, got entries %d miss %d", q.lruList.Len(), q.missCount)
+	}
+}
+
+func TestQueryCacheCoalesce(t *testing.T) {
+	q := newTestQueryCache()
+	cacheConfig := m.QueryCacheConfig{Enable: true, TtlSeconds: 60, MaxMemoryMb: 1}
+	data := newTestQueryData(1)
+	var queryCount int64
+	release := make(chan struct{})
+	queryFunc := func() (*m.PrometheusData, error) {
+		atomic.AddInt64(&queryCount, 1)
+		<-release
+		return data, nil
+	}
+	var wg sync.WaitGroup
+	results := make([]*m.PrometheusData, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = q.get("a", cacheConfig, queryFunc)
+		}(i)
+	}
+	// 等其它请求都在等第一个查询的结果
+	for atomic.LoadInt64(&q.coalesceCount)+atomic.LoadInt64(&q.missCount) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if queryCount != 1 || q.coalesceCount != 4 {
+		t.Errorf("want 1 query 4 coalesced, got query %d coalesced %d", queryCount, q.coalesceCount)
+	}
+	for i, result := range results {
+		if result != data {
+			t.Errorf("request %d got different data", i)
+		}
+	}
+}
+
+func TestQueryCacheQueryPanic(t *testing.T) {
+	q := newTestQueryCache()
+	cacheConfig := m.QueryCacheConfig{Enable: true, TtlSeconds: 60, MaxMemoryMb: 1}
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() {
+			recover()
+		}()
+		q.get("a", cacheConfig, func() (*m.PrometheusData, error) {
+			close(started)
+			<-release
+			panic("query panic")
+		})
+	}()
+	<-started
+	waitErr := make(chan error)
+	go func() {
+		_, err := q.get("a", cacheConfig, queryTestData(nil))
+		waitErr <- err
+	}()
+	for atomic.LoadInt64(&q.coalesceCount) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	select {
+	case err := <-waitErr:
+		if err == nil {
+			t.Errorf("waiting request should get error when query panic")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waiting request hang after query panic")
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, b := q.callMap["a"]; b {
+		t.Errorf("call should be removed after query panic")
+	}
+}
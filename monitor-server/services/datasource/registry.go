@@ -0,0 +1,225 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+var (
+	datasourceMap         = make(map[string]DataSourceParam)
+	datasourceDefaultGuid string
+	datasourceLock        = new(sync.RWMutex)
+)
+
+// ReloadDatasourceRegistry 数据源表变更后重新加载,配置了默认数据源时替代配置文件里的Prometheus
+func ReloadDatasourceRegistry(rows []*m.MonitorDatasourceTable) {
+	newMap := make(map[string]DataSourceParam)
+	defaultGuid := ""
+	for _, row := range rows {
+		newMap[row.Guid] = buildDatasourceParam(row)
+		if row.IsDefault == 1 {
+			defaultGuid = row.Guid
+		}
+	}
+	datasourceLock.Lock()
+	datasourceMap = newMap
+	datasourceDefaultGuid = defaultGuid
+	datasourceLock.Unlock()
+}
+
+func buildDatasourceParam(row *m.MonitorDatasourceTable) DataSourceParam {
+	dsObj := &DataSource{Id: int(crc32.ChecksumIEEE([]byte(row.Guid))), Name: row.Name, Type: row.Type, Url: buildDatasourceUrl(row.Address, ""), IsDefault: row.IsDefault == 1, Updated: row.UpdateAt}
+	return DataSourceParam{DataSource: dsObj, Host: row.Address, Guid: row.Guid, AuthToken: row.Token}
+}
+
+// getDatasourceParam 找不到数据源时用默认数据源
+func getDatasourceParam(guid string) DataSourceParam {
+	datasourceLock.RLock()
+	defer datasourceLock.RUnlock()
+	if dsParam, b := datasourceMap[guid]; b && guid != "" {
+		return dsParam
+	}
+	if dsParam, b := datasourceMap[datasourceDefaultGuid]; b {
+		return dsParam
+	}
+	return promDS
+}
+
+// buildDatasourceUrl 地址可以带协议和路径前缀,如VictoriaMetrics集群版的 host:8481/select/0/prometheus
+func buildDatasourceUrl(address, path string) string {
+	address = strings.TrimSuffix(address, "/")
+	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+		address = "http://" + address
+	}
+	return address + path
+}
+
+func doDatasourceRequest(dsParam DataSourceParam, path string, urlParams url.Values) (body []byte, err error) {
+	requestUrl, urlParseErr := url.Parse(buildDatasourceUrl(dsParam.Host, path))
+	if urlParseErr != nil {
+		return body, fmt.Errorf("Url parse fail,%s ", urlParseErr.Error())
+	}
+	requestUrl.RawQuery = urlParams.Encode()
+	req, _ := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
+	req.Header.Set("Content-Type", "application/json")
+	if dsParam.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+dsParam.AuthToken)
+	}
+	httpClient, getClientErr := dsParam.DataSource.GetHttpClient()
+	if getClientErr != nil {
+		return body, fmt.Errorf("Get httpClient fail,%s ", getClientErr.Error())
+	}
+	res, reqErr := ctxhttp.Do(context.Background(), httpClient, req)
+	if reqErr != nil {
+		return body, fmt.Errorf("http do request fail,%s ", reqErr.Error())
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return body, fmt.Errorf("Request fail with bad status:%d ", res.StatusCode)
+	}
+	return
+}
+
+func queryDatasourceRange(dsParam DataSourceParam, promQL string, start, end, step int64) (result *m.PrometheusData, err error) {
+	urlParams := url.Values{}
+	urlParams.Set("start", strconv.FormatInt(start, 10))
+	urlParams.Set("end", strconv.FormatInt(end, 10))
+	urlParams.Set("step", strconv.FormatInt(step, 10))
+	urlParams.Set("query", promQL)
+	body, reqErr := doDatasourceRequest(dsParam, "/api/v1/query_range", urlParams)
+	if reqErr != nil {
+		return result, reqErr
+	}
+	var data m.PrometheusResponse
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return result, fmt.Errorf("Json unmarshal response fail,%s ", err.Error())
+	}
+	if data.Status != "success" {
+		return result, fmt.Errorf("Query prometheus data fail,status:%s ", data.Status)
+	}
+	result = &data.Data
+	return
+}
+
+// QueryPrometheusRangeByDatasource 多个数据源时并发查询后合并,标签完全相同的序列按时间点去重合并,部分数据源失败时返回其它数据源的结果
+func QueryPrometheusRangeByDatasource(datasourceList []string, promQL string, start, end, step int64) (result *m.PrometheusData, err error) {
+	if len(datasourceList) <= 1 {
+		guid := ""
+		if len(datasourceList) == 1 {
+			guid = datasourceList[0]
+		}
+		return queryDatasourceRange(getDatasourceParam(guid), promQL, start, end, step)
+	}
+	resultList := make([]*m.PrometheusData, len(datasourceList))
+	errList := make([]error, len(datasourceList))
+	wg := sync.WaitGroup{}
+	for i, guid := range datasourceList {
+		wg.Add(1)
+		go func(index int, dsParam DataSourceParam) {
+			defer wg.Done()
+			resultList[index], errList[index] = queryDatasourceRange(dsParam, promQL, start, end, step)
+		}(i, getDatasourceParam(guid))
+	}
+	wg.Wait()
+	successList := []*m.PrometheusData{}
+	for i, tmpResult := range resultList {
+		if errList[i] != nil {
+			log.Logger.Warn("Query datasource fail", log.String("datasource", datasourceList[i]), log.Error(errList[i]))
+			continue
+		}
+		successList = append(successList, tmpResult)
+	}
+	if len(successList) == 0 {
+		return result, errList[0]
+	}
+	result = MergePrometheusData(successList...)
+	return
+}
+
+// MergePrometheusData 标签完全相同的序列按时间点合并,同一时间点保留前面数据的值,返回新的结果不修改入参(入参可能来自查询缓存)
+func MergePrometheusData(dataList ...*m.PrometheusData) (result *m.PrometheusData) {
+	result = &m.PrometheusData{Result: []m.PrometheusResult{}}
+	seriesIndexMap := make(map[string]int)
+	for _, tmpData := range dataList {
+		if tmpData == nil {
+			continue
+		}
+		if tmpData.ResultType != "" {
+			result.ResultType = tmpData.ResultType
+		}
+		for _, series := range tmpData.Result {
+			seriesKey := buildSeriesKey(series.Metric)
+			if index, b := seriesIndexMap[seriesKey]; b {
+				result.Result[index].Values = mergeSeriesValues(result.Result[index].Values, series.Values)
+				continue
+			}
+			seriesIndexMap[seriesKey] = len(result.Result)
+			result.Result = append(result.Result, m.PrometheusResult{Metric: series.Metric, Values: append([][]interface{}{}, series.Values...)})
+		}
+	}
+	return
+}
+
+func buildSeriesKey(metric map[string]string) string {
+	keyList := []string{}
+	for k, v := range metric {
+		keyList = append(keyList, k+"="+v)
+	}
+	sort.Strings(keyList)
+	return strings.Join(keyList, ",")
+}
+
+func mergeSeriesValues(existValues, newValues [][]interface{}) [][]interface{} {
+	timeMap := make(map[float64]bool)
+	for _, v := range existValues {
+		if tmpTime, ok := v[0].(float64); ok {
+			timeMap[tmpTime] = true
+		}
+	}
+	for _, v := range newValues {
+		if tmpTime, ok := v[0].(float64); ok && !timeMap[tmpTime] {
+			existValues = append(existValues, v)
+		}
+	}
+	sort.Slice(existValues, func(i, j int) bool {
+		iTime, _ := existValues[i][0].(float64)
+		jTime, _ := existValues[j][0].(float64)
+		return iTime < jTime
+	})
+	return existValues
+}
+
+// CheckDatasourceHealth 用即时查询检查数据源是否可用,Prometheus、VictoriaMetrics和Thanos都支持
+func CheckDatasourceHealth(row *m.MonitorDatasourceTable) error {
+	urlParams := url.Values{}
+	urlParams.Set("query", "1")
+	urlParams.Set("time", strconv.FormatInt(time.Now().Unix(), 10))
+	body, err := doDatasourceRequest(buildDatasourceParam(row), "/api/v1/query", urlParams)
+	if err != nil {
+		return err
+	}
+	var data m.PrometheusResponse
+	if err = json.Unmarshal(body, &data); err != nil {
+		return fmt.Errorf("Json unmarshal response fail,%s ", err.Error())
+	}
+	if data.Status != "success" {
+		return fmt.Errorf("Query datasource fail,status:%s ", data.Status)
+	}
+	return nil
+}
@@ -502,6 +502,11 @@ func GetAlarms(cond m.QueryAlarmCondition) (error, m.AlarmProblemList) {
 		params = append(params, []interface{}{fmt.Sprintf("%%%s%%", cond.Query), fmt.Sprintf("%%%s%%", cond.Query),
 			fmt.Sprintf("%%%s%%", cond.Query), fmt.Sprintf("%%%s%%", cond.Query), fmt.Sprintf("%%%s%%", cond.Query)}...)
 	}
+	if cond.AckFilter == "yes" {
+		whereSql += " and ack_time is not null "
+	} else if cond.AckFilter == "no" {
+		whereSql += " and ack_time is null "
+	}
 
 	sql := "SELECT * FROM alarm where 1=1 " + whereSql + " ORDER BY id DESC "
 	if cond.Limit > 0 {
@@ -516,6 +521,10 @@ func GetAlarms(cond m.QueryAlarmCondition) (error, m.AlarmProblemList) {
 	for _, v := range result {
 		v.StartString = v.Start.Format(m.DatetimeFormat)
 		v.EndString = v.End.Format(m.DatetimeFormat)
+		if !v.AckTime.IsZero() {
+			v.Ack = true
+			v.AckTimeString = v.AckTime.Format(m.DatetimeFormat)
+		}
 		if v.AlarmName == "" {
 			v.AlarmName = v.Content
 		}
@@ -614,7 +623,7 @@ func GetAlarms(cond m.QueryAlarmCondition) (error, m.AlarmProblemList) {
 			}
 		}
 	}
-	if cond.ExtOpenAlarm && len(cond.MetricFilterList) == 0 && len(cond.EndpointFilterList) == 0 {
+	if cond.ExtOpenAlarm && len(cond.MetricFilterList) == 0 && len(cond.EndpointFilterList) == 0 && cond.AckFilter != "yes" {
 		for _, v := range GetOpenAlarm(m.CustomAlarmQueryParam{Enable: true, Status: "problem", Start: "", End: "", Level: cond.PriorityList, AlterTitleList: cond.AlarmNameFilterList, Query: cond.Query}) {
 			result = append(result, v)
 		}
@@ -1086,10 +1095,46 @@ func CloseAlarm(param m.AlarmCloseParam) (actions []*Action, err error) {
 			actions = append(actions, &Action{Sql: "UPDATE alarm_condition SET STATUS='closed',end=NOW() WHERE guid in (select alarm_condition from alarm_condition_rel where alarm=?)", Param: []interface{}{v.Id}})
 		}
 		actions = append(actions, &Action{Sql: "delete from alarm_firing where alarm_id=?", Param: []interface{}{v.Id}})
+		actions = append(actions, getAlarmEscalationCancelAction(v.Id))
+		actions = append(actions, getAlarmIncidentCloseAction(v.Id))
 	}
 	return
 }
 
+// AckAlarm 确认告警,确认后不再发送firing通知和升级通知,告警仍保持firing直到恢复或关闭
+func AckAlarm(alarmId int, operator string) error {
+	execResult, err := x.Exec("update alarm set ack_user=?,ack_time=? where id=? and status='firing'", operator, time.Now(), alarmId)
+	if err != nil {
+		return fmt.Errorf("update alarm ack fail,%s ", err.Error())
+	}
+	if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+		return fmt.Errorf("can not find firing alarm with id:%d ", alarmId)
+	}
+	return nil
+}
+
+func UnAckAlarm(alarmId int) error {
+	_, err := x.Exec("update alarm set ack_user=null,ack_time=null where id=?", alarmId)
+	if err != nil {
+		return fmt.Errorf("update alarm unack fail,%s ", err.Error())
+	}
+	return nil
+}
+
+func GetAlarmAckInfo(alarmId int) (ackUser, ackTime string) {
+	var alarmRows []*m.AlarmTable
+	x.SQL("select id,ack_user,ack_time from alarm where id=?", alarmId).Find(&alarmRows)
+	if len(alarmRows) > 0 && !alarmRows[0].AckTime.IsZero() {
+		ackUser, ackTime = alarmRows[0].AckUser, alarmRows[0].AckTime.Format(m.DatetimeFormat)
+	}
+	return
+}
+
+func checkAlarmAck(alarmId int) bool {
+	queryRows, _ := x.QueryString("select id from alarm where id=? and ack_time is not null", alarmId)
+	return len(queryRows) > 0
+}
+
 func UpdateAlarmCustomMessage(param m.UpdateAlarmCustomMessageDto) error {
 	var err error
 	if param.IsCustom {
@@ -1832,7 +1877,14 @@ func ManualNotifyAlarm(alarmId int, operator string) (err error) {
 		return
 	}
 	notifyObj := notifyRows[0]
-	if _, err = notifyEventAction(notifyObj, &alarmObj, false, operator); err != nil {
+	requestParam, buildErr := buildNotifyEventRequest(notifyObj, &alarmObj, operator)
+	if buildErr != nil {
+		err = fmt.Errorf("notify event action fail:%s ", buildErr.Error())
+		return
+	}
+	err = postNotifyEvent(&requestParam)
+	recordManualNotifyDelivery(notifyObj, &alarmObj, &requestParam, operator, err)
+	if err != nil {
 		err = fmt.Errorf("notify event action fail:%s ", err.Error())
 	} else {
 		_, err = x.Exec("insert into alarm_notify(alarm_id,notify_id,endpoint,metric,status,proc_def_key,proc_def_name,notify_description,created_user,created_time) values (?,?,?,?,?,?,?,?,?,?)",
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const anomalyQueryStep = 10
+
+type anomalyPoint struct {
+	Timestamp int64
+	Value     float64
+}
+
+// GetAnomalyWindowSecond 异常检测窗口,没配置时sigma默认1小时移动平均,rate默认5分钟变化率,day/week默认按单点对比
+func GetAnomalyWindowSecond(conditionType, anomalyWindow string) int64 {
+	if anomalyWindow != "" {
+		if d, err := time.ParseDuration(anomalyWindow); err == nil && d >= time.Second {
+			return int64(d.Seconds())
+		}
+	}
+	switch conditionType {
+	case models.StrategyConditionSigma:
+		return 3600
+	case models.StrategyConditionRate:
+		return 300
+	}
+	return anomalyQueryStep
+}
+
+// QueryStrategyConditionData 按阈值条件类型查询数据,异常检测条件返回的是计算后的偏离值(sigma倍数或百分比),可直接和阈值比较
+func QueryStrategyConditionData(promQl, conditionType, anomalyWindow string, start, end int64) (result *models.PrometheusData, err error) {
+	return QueryStrategyConditionDataByDatasource("", promQl, conditionType, anomalyWindow, start, end)
+}
+
+// QueryStrategyConditionDataByDatasource 在指标所在的数据源上查询,datasourceGuid为空时用默认数据源
+func QueryStrategyConditionDataByDatasource(datasourceGuid, promQl, conditionType, anomalyWindow string, start, end int64) (result *models.PrometheusData, err error) {
+	datasourceList := SplitDatasourceList(datasourceGuid)
+	return queryStrategyConditionData(func(queryStart, queryEnd, step int64) (*models.PrometheusData, error) {
+		return datasource.QueryPrometheusRangeByDatasource(datasourceList, promQl, queryStart, queryEnd, step)
+	}, conditionType, anomalyWindow, start, end)
+}
+
+// QueryStrategyConditionLongTermData 告警回测用,超过本地存储天数的部分(含同比的历史数据)从归档库按对象和指标补齐
+func QueryStrategyConditionLongTermData(metricGuid string, endpointList []string, promQl, conditionType, anomalyWindow string, start, end int64) (result *models.PrometheusData, err error) {
+	datasourceList := SplitDatasourceList(GetMetricDatasource(metricGuid))
+	metricList := []string{}
+	if metricRow, getMetricErr := GetSimpleMetric(metricGuid); getMetricErr == nil {
+		metricList = append(metricList, metricRow.Metric)
+	}
+	return queryStrategyConditionData(func(queryStart, queryEnd, step int64) (queryResult *models.PrometheusData, queryErr error) {
+		queryResult, _, queryErr = QueryLongTermRangeData(datasourceList, promQl, endpointList, metricList, "avg", queryStart, queryEnd, step)
+		return
+	}, conditionType, anomalyWindow, start, end)
+}
+
+func queryStrategyConditionData(rangeFunc func(start, end, step int64) (*models.PrometheusData, error), conditionType, anomalyWindow string, start, end int64) (result *models.PrometheusData, err error) {
+	windowSec := GetAnomalyWindowSecond(conditionType, anomalyWindow)
+	// 查询范围超过一天时加大步长,避免超过Prometheus单次查询的点数限制
+	step := int64(anomalyQueryStep)
+	if end-start > 86400 {
+		step = step * ((end-start)/86400 + 1)
+	}
+	switch conditionType {
+	case "", models.StrategyConditionNoData:
+		return rangeFunc(start, end, step)
+	case models.StrategyConditionSigma:
+		result, err = rangeFunc(start-windowSec, end, step)
+		if err != nil {
+			return
+		}
+		for i, series := range result.Result {
+			result.Result[i].Values = buildAnomalyValues(calcSigmaDeviation(getAnomalyPoints(series.Values), start, windowSec))
+		}
+	case models.StrategyConditionRate:
+		result, err = rangeFunc(start-windowSec, end, step)
+		if err != nil {
+			return
+		}
+		for i, series := range result.Result {
+			result.Result[i].Values = buildAnomalyValues(calcRateOfChange(getAnomalyPoints(series.Values), start, windowSec))
+		}
+	case models.StrategyConditionDay, models.StrategyConditionWeek:
+		offset := int64(86400)
+		if conditionType == models.StrategyConditionWeek {
+			offset = 86400 * 7
+		}
+		result, err = rangeFunc(start-windowSec, end, step)
+		if err != nil {
+			return
+		}
+		historyData, historyErr := rangeFunc(start-windowSec-offset, end-offset, step)
+		if historyErr != nil {
+			err = fmt.Errorf("query history data fail,%s ", historyErr.Error())
+			return
+		}
+		historyMap := make(map[string][]*anomalyPoint)
+		for _, series := range historyData.Result {
+			historyMap[getAnomalySeriesKey(series.Metric)] = getAnomalyPoints(series.Values)
+		}
+		for i, series := range result.Result {
+			result.Result[i].Values = buildAnomalyValues(calcComparisonDiffPercent(getAnomalyPoints(series.Values), historyMap[getAnomalySeriesKey(series.Metric)], start, windowSec, offset))
+		}
+	default:
+		err = fmt.Errorf("condition type:%s illegal", conditionType)
+	}
+	return
+}
+
+// calcSigmaDeviation 每个点和前面窗口内数据的均值比较,偏离了几倍标准差
+func calcSigmaDeviation(points []*anomalyPoint, start, windowSec int64) (output []*anomalyPoint) {
+	for i, point := range points {
+		if point.Timestamp < start {
+			continue
+		}
+		var sum, squareSum float64
+		var num int
+		for j := i - 1; j >= 0 && points[j].Timestamp >= point.Timestamp-windowSec; j-- {
+			sum += points[j].Value
+			squareSum += points[j].Value * points[j].Value
+			num++
+		}
+		if num < 2 {
+			continue
+		}
+		avg := sum / float64(num)
+		std := math.Sqrt(math.Max(squareSum/float64(num)-avg*avg, 0))
+		if std == 0 {
+			continue
+		}
+		output = append(output, &anomalyPoint{Timestamp: point.Timestamp, Value: (point.Value - avg) / std})
+	}
+	return
+}
+
+// calcRateOfChange 每个点和窗口前的值比较,变化了百分之多少
+func calcRateOfChange(points []*anomalyPoint, start, windowSec int64) (output []*anomalyPoint) {
+	for i, point := range points {
+		if point.Timestamp < start {
+			continue
+		}
+		var prev *anomalyPoint
+		for j := i - 1; j >= 0; j-- {
+			if points[j].Timestamp <= point.Timestamp-windowSec {
+				prev = points[j]
+				break
+			}
+		}
+		if prev == nil || prev.Value == 0 {
+			continue
+		}
+		output = append(output, &anomalyPoint{Timestamp: point.Timestamp, Value: (point.Value - prev.Value) * 100 / math.Abs(prev.Value)})
+	}
+	return
+}
+
+// calcComparisonDiffPercent 同环比,和metric_comparison_exporter的avg+diff_percent算法一致,窗口内均值和历史同时段均值的差值百分比
+func calcComparisonDiffPercent(points, historyPoints []*anomalyPoint, start, windowSec, offset int64) (output []*anomalyPoint) {
+	if len(historyPoints) == 0 {
+		return
+	}
+	for _, point := range points {
+		if point.Timestamp < start {
+			continue
+		}
+		dataVal, dataOk := getAnomalyAvg(points, point.Timestamp-windowSec, point.Timestamp)
+		historyDataVal, historyOk := getAnomalyAvg(historyPoints, point.Timestamp-offset-windowSec, point.Timestamp-offset)
+		if !dataOk || !historyOk || historyDataVal == 0 {
+			continue
+		}
+		output = append(output, &anomalyPoint{Timestamp: point.Timestamp, Value: (dataVal - historyDataVal) * 100 / historyDataVal})
+	}
+	return
+}
+
+// getAnomalyAvg 计算(start,end]内的均值
+func getAnomalyAvg(points []*anomalyPoint, start, end int64) (avg float64, ok bool) {
+	var sum float64
+	var num int
+	for _, point := range points {
+		if point.Timestamp > start && point.Timestamp <= end {
+			sum += point.Value
+			num++
+		}
+	}
+	if num == 0 {
+		return
+	}
+	return sum / float64(num), true
+}
+
+func getAnomalyPoints(values [][]interface{}) (points []*anomalyPoint) {
+	for _, v := range values {
+		if len(v) < 2 {
+			continue
+		}
+		timestamp, tsOk := v[0].(float64)
+		valueString, valueOk := v[1].(string)
+		if !tsOk || !valueOk {
+			continue
+		}
+		tmpValue, parseErr := strconv.ParseFloat(valueString, 64)
+		if parseErr != nil || math.IsNaN(tmpValue) || math.IsInf(tmpValue, 0) {
+			continue
+		}
+		points = append(points, &anomalyPoint{Timestamp: int64(timestamp), Value: tmpValue})
+	}
+	return
+}
+
+// buildAnomalyValues 转回Prometheus返回的[时间戳,字符串值]格式,和原始数据一样处理
+func buildAnomalyValues(points []*anomalyPoint) (values [][]interface{}) {
+	values = [][]interface{}{}
+	for _, point := range points {
+		values = append(values, []interface{}{float64(point.Timestamp), strconv.FormatFloat(point.Value, 'f', 4, 64)})
+	}
+	return
+}
+
+func getAnomalySeriesKey(metric map[string]string) string {
+	var tagList []string
+	for k, v := range metric {
+		if k == "__name__" {
+			continue
+		}
+		tagList = append(tagList, k+"="+v)
+	}
+	sort.Strings(tagList)
+	return strings.Join(tagList, ",")
+}
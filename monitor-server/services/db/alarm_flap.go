@@ -0,0 +1,122 @@
+package db
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"time"
+)
+
+const alarmFlapCheckInterval = 30
+
+// DetectAlarmFlapping 新产生的firing告警,同一对象同一阈值同一标签在窗口内状态变化次数达到阈值时标记为抖动,抖动期间不发通知
+func DetectAlarmFlapping(alarms []*models.AlarmHandleObj) {
+	strategyMap := make(map[string]*models.AlarmStrategyMetricObj)
+	for _, alarmObj := range alarms {
+		if alarmObj.Id <= 0 || alarmObj.Status != "firing" || alarmObj.AlarmStrategy == "" || alarmObj.AlarmConditionGuid != "" {
+			continue
+		}
+		// 抖动配置按产生告警的条件取
+		strategyKey := alarmObj.AlarmStrategy + "^" + alarmObj.AlarmConditionCrcHash
+		strategyObj, b := strategyMap[strategyKey]
+		if !b {
+			var tmpStrategyObj models.AlarmStrategyMetricObj
+			var getErr error
+			if alarmObj.AlarmConditionCrcHash != "" {
+				tmpStrategyObj, _, getErr = GetAlarmStrategy(alarmObj.AlarmStrategy, alarmObj.AlarmConditionCrcHash)
+			} else {
+				tmpStrategyObj, getErr = GetAlarmStrategyByAlarm(&alarmObj.AlarmTable)
+			}
+			if getErr == nil {
+				strategyObj = &tmpStrategyObj
+			}
+			strategyMap[strategyKey] = strategyObj
+		}
+		if strategyObj == nil || strategyObj.FlapCount <= 0 || strategyObj.FlapWindow <= 0 {
+			continue
+		}
+		windowStart := time.Now().Add(time.Duration(-strategyObj.FlapWindow) * time.Second)
+		// 窗口内每次开始和每次恢复都算一次状态变化,包含本次告警
+		queryRows, err := x.QueryString("select (select count(1) from alarm where alarm_strategy=? and endpoint=? and tags=? and `start`>=?)+(select count(1) from alarm where alarm_strategy=? and endpoint=? and tags=? and status<>'firing' and `end`>=?) as num",
+			alarmObj.AlarmStrategy, alarmObj.Endpoint, alarmObj.Tags, windowStart, alarmObj.AlarmStrategy, alarmObj.Endpoint, alarmObj.Tags, windowStart)
+		if err != nil || len(queryRows) == 0 {
+			log.Logger.Error("Query alarm state change count fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+			continue
+		}
+		var changeCount int
+		fmt.Sscanf(queryRows[0]["num"], "%d", &changeCount)
+		if changeCount < strategyObj.FlapCount {
+			continue
+		}
+		if _, err = x.Exec("update alarm set flapping=1 where id=?", alarmObj.Id); err != nil {
+			log.Logger.Error("Update alarm flapping fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+			continue
+		}
+		alarmObj.Flapping = 1
+		log.Logger.Info("Alarm mark flapping", log.Int("alarmId", alarmObj.Id), log.String("alarmStrategy", alarmObj.AlarmStrategy), log.Int("changeCount", changeCount))
+	}
+}
+
+func checkAlarmFlapping(alarmId int) bool {
+	queryRows, _ := x.QueryString("select id from alarm where id=? and flapping=1", alarmId)
+	return len(queryRows) > 0
+}
+
+// StartAlarmFlapCron 抖动的告警持续firing一个窗口不再变化后认为已稳定,清除抖动标记并补发firing通知
+func StartAlarmFlapCron() {
+	t := time.NewTicker(time.Duration(alarmFlapCheckInterval) * time.Second).C
+	for {
+		<-t
+		doAlarmFlapStableJob()
+	}
+}
+
+func doAlarmFlapStableJob() {
+	var alarmRows []*models.AlarmTable
+	if err := x.SQL("select * from alarm where status='firing' and flapping=1").Find(&alarmRows); err != nil {
+		log.Logger.Error("Query flapping alarm fail", log.Error(err))
+		return
+	}
+	nowTime := time.Now()
+	for _, row := range alarmRows {
+		strategyObj, getStrategyErr := GetAlarmStrategyByAlarm(row)
+		if getStrategyErr == nil && nowTime.Sub(row.Start) < time.Duration(strategyObj.FlapWindow)*time.Second {
+			continue
+		}
+		// 条件更新保证多实例下只补发一次
+		execResult, err := x.Exec("update alarm set flapping=0 where id=? and flapping=1", row.Id)
+		if err != nil {
+			log.Logger.Error("Update alarm flapping stable fail", log.Int("alarmId", row.Id), log.Error(err))
+			continue
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+			continue
+		}
+		log.Logger.Info("Flapping alarm stable", log.Int("alarmId", row.Id))
+		if getStrategyErr != nil || strategyObj.NotifyEnable == 0 {
+			continue
+		}
+		row.Flapping = 0
+		go NotifyStrategyAlarm(&models.AlarmHandleObj{AlarmTable: *row, NotifyEnable: strategyObj.NotifyEnable, NotifyDelay: strategyObj.NotifyDelaySecond})
+	}
+}
+
+// SetAlarmRecoverPending 收到恢复但阈值配置了恢复条件,先记录等待恢复条件满足
+func SetAlarmRecoverPending(alarmId int, nowTime time.Time) {
+	if _, err := x.Exec("update alarm set recover_time=? where id=? and status='firing' and recover_time is null", nowTime, alarmId); err != nil {
+		log.Logger.Error("Update alarm recover time fail", log.Int("alarmId", alarmId), log.Error(err))
+	}
+}
+
+func ClearAlarmRecoverPending(alarmId int) {
+	if _, err := x.Exec("update alarm set recover_time=null where id=? and recover_time is not null", alarmId); err != nil {
+		log.Logger.Error("Clear alarm recover time fail", log.Int("alarmId", alarmId), log.Error(err))
+	}
+}
+
+func GetAlarmRecoverPendingList() (alarmRows []*models.AlarmTable, err error) {
+	if err = x.SQL("select * from alarm where status='firing' and recover_time is not null").Find(&alarmRows); err != nil {
+		err = fmt.Errorf("query alarm recover pending list fail,%s ", err.Error())
+	}
+	return
+}
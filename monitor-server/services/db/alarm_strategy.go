@@ -39,7 +39,8 @@ func QueryAlarmStrategyByGroup(endpointGroup, alarmName, show, operator string)
 		return
 	}
 	for _, v := range alarmStrategyTable {
-		tmpStrategyObj := models.GroupStrategyObj{Guid: v.Guid, Name: v.Name, EndpointGroup: v.EndpointGroup, Metric: v.Metric, MetricName: v.MetricName, Condition: v.Condition, Last: v.Last, Priority: v.Priority, Content: v.Content, NotifyEnable: v.NotifyEnable, NotifyDelaySecond: v.NotifyDelaySecond, ActiveWindow: v.ActiveWindow}
+		tmpStrategyObj := models.GroupStrategyObj{Guid: v.Guid, Name: v.Name, EndpointGroup: v.EndpointGroup, Metric: v.Metric, MetricName: v.MetricName, Condition: v.Condition, Last: v.Last, Priority: v.Priority, Content: v.Content, NotifyEnable: v.NotifyEnable, NotifyDelaySecond: v.NotifyDelaySecond, ActiveWindow: v.ActiveWindow,
+			RecoverCondition: v.RecoverCondition, RecoverLast: v.RecoverLast, FlapCount: v.FlapCount, FlapWindow: v.FlapWindow}
 		tmpStrategyObj.ActiveWindowList = strings.Split(tmpStrategyObj.ActiveWindow, ",")
 		tmpStrategyObj.UpdateTime = v.UpdateTime
 		tmpStrategyObj.UpdateUser = v.UpdateUser
@@ -133,7 +134,7 @@ func GetAlarmStrategy(strategyGuid, conditionCrc string) (result models.AlarmStr
 	}
 	result = *strategyTable[0]
 	conditions = []*models.AlarmStrategyMetricWithExpr{}
-	err = x.SQL("select t1.guid,t1.alarm_strategy,t1.metric,t1.`condition`,t1.`last`,t1.crc_hash,t2.metric as 'metric_name',t2.prom_expr as 'metric_expr',t2.monitor_type as 'metric_type' from alarm_strategy_metric t1 left join metric t2 on t1.metric=t2.guid where t1.alarm_strategy=?", strategyGuid).Find(&conditions)
+	err = x.SQL("select t1.guid,t1.alarm_strategy,t1.metric,t1.`condition`,t1.`last`,t1.crc_hash,t1.condition_type,t1.anomaly_window,t1.recover_condition,t1.recover_last,t1.flap_count,t1.flap_window,t2.metric as 'metric_name',t2.prom_expr as 'metric_expr',t2.monitor_type as 'metric_type' from alarm_strategy_metric t1 left join metric t2 on t1.metric=t2.guid where t1.alarm_strategy=?", strategyGuid).Find(&conditions)
 	if err != nil {
 		err = fmt.Errorf("Query alarm strategy metric table fail,%s ", err.Error())
 		return
@@ -141,12 +142,7 @@ func GetAlarmStrategy(strategyGuid, conditionCrc string) (result models.AlarmStr
 	if conditionCrc != "" && len(conditions) > 0 {
 		for _, conditionRow := range conditions {
 			if conditionRow.CrcHash == conditionCrc {
-				result.ConditionCrc = conditionRow.CrcHash
-				result.Metric = conditionRow.Metric
-				result.MetricName = conditionRow.MetricName
-				result.MetricExpr = conditionRow.MetricExpr
-				result.Condition = conditionRow.Condition
-				result.Last = conditionRow.Last
+				applyStrategyCondition(&result, conditionRow)
 				break
 			}
 		}
@@ -157,6 +153,44 @@ func GetAlarmStrategy(strategyGuid, conditionCrc string) (result models.AlarmStr
 	return
 }
 
+// applyStrategyCondition 用条件覆盖阈值配置的指标和条件,恢复和抖动配置条件自己有的用条件的,
+// 阈值配置的恢复条件是按阈值配置的指标设置的,只对同一个指标的条件生效
+func applyStrategyCondition(result *models.AlarmStrategyMetricObj, conditionRow *models.AlarmStrategyMetricWithExpr) {
+	strategyMetric := result.Metric
+	result.ConditionCrc = conditionRow.CrcHash
+	result.Metric = conditionRow.Metric
+	result.MetricName = conditionRow.MetricName
+	result.MetricExpr = conditionRow.MetricExpr
+	result.Condition = conditionRow.Condition
+	result.Last = conditionRow.Last
+	result.ConditionType = conditionRow.ConditionType
+	result.AnomalyWindow = conditionRow.AnomalyWindow
+	if conditionRow.RecoverCondition != "" || conditionRow.RecoverLast != "" {
+		result.RecoverCondition, result.RecoverLast = conditionRow.RecoverCondition, conditionRow.RecoverLast
+	} else if conditionRow.Metric != strategyMetric {
+		result.RecoverCondition = ""
+	}
+	if conditionRow.FlapCount > 0 {
+		result.FlapCount, result.FlapWindow = conditionRow.FlapCount, conditionRow.FlapWindow
+	}
+}
+
+// GetAlarmStrategyByAlarm 告警表没有记录条件crc,按告警的指标和条件找到产生告警的条件,找不到时只返回阈值配置
+func GetAlarmStrategyByAlarm(alarmRow *models.AlarmTable) (result models.AlarmStrategyMetricObj, err error) {
+	var conditions []*models.AlarmStrategyMetricWithExpr
+	if result, conditions, err = GetAlarmStrategy(alarmRow.AlarmStrategy, ""); err != nil {
+		return
+	}
+	alarmCond := strings.ReplaceAll(alarmRow.SCond, " ", "")
+	for _, conditionRow := range conditions {
+		if len(conditions) == 1 || (conditionRow.MetricName == alarmRow.SMetric && strings.ReplaceAll(conditionRow.Condition, " ", "") == alarmCond) {
+			applyStrategyCondition(&result, conditionRow)
+			break
+		}
+	}
+	return
+}
+
 func CreateAlarmStrategy(param *models.GroupStrategyObj, operator string) error {
 	var err error
 	var actions []*Action
@@ -169,8 +203,9 @@ func CreateAlarmStrategy(param *models.GroupStrategyObj, operator string) error
 
 func getCreateAlarmStrategyActions(param *models.GroupStrategyObj, nowTime, operator string) (actions []*Action, err error) {
 	param.Guid = "strategy_" + guid.CreateGuid()
-	var insertAction = Action{Sql: "insert into alarm_strategy(guid,name,endpoint_group,metric,`condition`,`last`,priority,content,notify_enable,notify_delay_second,active_window,update_time,create_user,update_user,log_metric_group) value (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"}
-	insertAction.Param = []interface{}{param.Guid, param.Name, param.EndpointGroup, param.Metric, param.Condition, param.Last, param.Priority, param.Content, param.NotifyEnable, param.NotifyDelaySecond, param.ActiveWindow, nowTime, operator, operator, param.LogMetricGroup}
+	var insertAction = Action{Sql: "insert into alarm_strategy(guid,name,endpoint_group,metric,`condition`,`last`,priority,content,notify_enable,notify_delay_second,active_window,update_time,create_user,update_user,log_metric_group,recover_condition,recover_last,flap_count,flap_window) value (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"}
+	insertAction.Param = []interface{}{param.Guid, param.Name, param.EndpointGroup, param.Metric, param.Condition, param.Last, param.Priority, param.Content, param.NotifyEnable, param.NotifyDelaySecond, param.ActiveWindow, nowTime, operator, operator, param.LogMetricGroup,
+		param.RecoverCondition, param.RecoverLast, param.FlapCount, param.FlapWindow}
 	actions = append(actions, &insertAction)
 	if len(param.NotifyList) > 0 {
 		for _, v := range param.NotifyList {
@@ -218,8 +253,8 @@ func UpdateAlarmStrategy(param *models.GroupStrategyObj, operator string) error
 	nowTime := time.Now().Format(models.DatetimeFormat)
 	var updateConditionActions, actions []*Action
 	var err error
-	updateAction := Action{Sql: "update alarm_strategy set name=?,priority=?,content=?,notify_enable=?,notify_delay_second=?,active_window=?,update_time=?,update_user=? where guid=?"}
-	updateAction.Param = []interface{}{param.Name, param.Priority, param.Content, param.NotifyEnable, param.NotifyDelaySecond, param.ActiveWindow, nowTime, operator, param.Guid}
+	updateAction := Action{Sql: "update alarm_strategy set name=?,priority=?,content=?,notify_enable=?,notify_delay_second=?,active_window=?,recover_condition=?,recover_last=?,flap_count=?,flap_window=?,update_time=?,update_user=? where guid=?"}
+	updateAction.Param = []interface{}{param.Name, param.Priority, param.Content, param.NotifyEnable, param.NotifyDelaySecond, param.ActiveWindow, param.RecoverCondition, param.RecoverLast, param.FlapCount, param.FlapWindow, nowTime, operator, param.Guid}
 	actions = append(actions, &updateAction)
 	for _, v := range param.NotifyList {
 		v.AlarmStrategy = param.Guid
@@ -256,6 +291,7 @@ func GetDeleteAlarmStrategyActions(strategyGuid string) (delAlarmStrategyActions
 	endpointGroup = strategyTable[0].EndpointGroup
 	delAlarmStrategyActions = append(delAlarmStrategyActions, getNotifyListDeleteAction(strategyGuid, "", "")...)
 	delAlarmStrategyActions = append(delAlarmStrategyActions, getStrategyConditionDeleteAction(strategyGuid)...)
+	delAlarmStrategyActions = append(delAlarmStrategyActions, getAlarmEscalationDeleteActions("alarm_strategy", strategyGuid)...)
 	delAlarmStrategyActions = append(delAlarmStrategyActions, &Action{Sql: "delete from alarm_strategy where guid=?", Param: []interface{}{strategyGuid}})
 	return
 }
@@ -282,8 +318,8 @@ func getNotifyList(alarmStrategy, endpointGroup, serviceGroup string) (result []
 			okNotify = v
 		}
 	}
-	result = append(result, &models.NotifyObj{Guid: firingNotify.Guid, NotifyRoles: getNotifyRoles(firingNotify.Guid), EndpointGroup: firingNotify.EndpointGroup, ServiceGroup: firingNotify.ServiceGroup, AlarmStrategy: firingNotify.AlarmStrategy, AlarmAction: firingNotify.AlarmAction, AlarmPriority: firingNotify.AlarmPriority, NotifyNum: firingNotify.NotifyNum, ProcCallbackName: firingNotify.ProcCallbackName, ProcCallbackKey: firingNotify.ProcCallbackKey, CallbackUrl: firingNotify.CallbackUrl, CallbackParam: firingNotify.CallbackParam, ProcCallbackMode: firingNotify.ProcCallbackMode, Description: firingNotify.Description})
-	result = append(result, &models.NotifyObj{Guid: okNotify.Guid, NotifyRoles: getNotifyRoles(okNotify.Guid), EndpointGroup: okNotify.EndpointGroup, ServiceGroup: okNotify.ServiceGroup, AlarmStrategy: okNotify.AlarmStrategy, AlarmAction: okNotify.AlarmAction, AlarmPriority: okNotify.AlarmPriority, NotifyNum: okNotify.NotifyNum, ProcCallbackName: okNotify.ProcCallbackName, ProcCallbackKey: okNotify.ProcCallbackKey, CallbackUrl: okNotify.CallbackUrl, CallbackParam: okNotify.CallbackParam, ProcCallbackMode: okNotify.ProcCallbackMode, Description: okNotify.Description})
+	result = append(result, &models.NotifyObj{Guid: firingNotify.Guid, NotifyRoles: getNotifyRoles(firingNotify.Guid), EndpointGroup: firingNotify.EndpointGroup, ServiceGroup: firingNotify.ServiceGroup, AlarmStrategy: firingNotify.AlarmStrategy, AlarmAction: firingNotify.AlarmAction, AlarmPriority: firingNotify.AlarmPriority, NotifyNum: firingNotify.NotifyNum, ProcCallbackName: firingNotify.ProcCallbackName, ProcCallbackKey: firingNotify.ProcCallbackKey, CallbackUrl: firingNotify.CallbackUrl, CallbackParam: firingNotify.CallbackParam, ProcCallbackMode: firingNotify.ProcCallbackMode, Description: firingNotify.Description, Channels: getNotifyChannels(firingNotify.Guid)})
+	result = append(result, &models.NotifyObj{Guid: okNotify.Guid, NotifyRoles: getNotifyRoles(okNotify.Guid), EndpointGroup: okNotify.EndpointGroup, ServiceGroup: okNotify.ServiceGroup, AlarmStrategy: okNotify.AlarmStrategy, AlarmAction: okNotify.AlarmAction, AlarmPriority: okNotify.AlarmPriority, NotifyNum: okNotify.NotifyNum, ProcCallbackName: okNotify.ProcCallbackName, ProcCallbackKey: okNotify.ProcCallbackKey, CallbackUrl: okNotify.CallbackUrl, CallbackParam: okNotify.CallbackParam, ProcCallbackMode: okNotify.ProcCallbackMode, Description: okNotify.Description, Channels: getNotifyChannels(okNotify.Guid)})
 	return result
 }
 
@@ -311,18 +347,42 @@ func getNotifyRoles(notifyId string) []string {
 }
 
 func getNotifyListInsertAction(notifyList []*models.NotifyObj) (actions []*Action) {
+	if len(notifyList) == 0 {
+		return []*Action{}
+	}
+	refColumn, refValue := getNotifyRefColumn(notifyList[0])
+	return getNotifyListInsertActionBySource(notifyList, getNotifyGuidListByRef(refColumn, refValue))
+}
+
+func getNotifyRefColumn(notifyObj *models.NotifyObj) (refColumn, refValue string) {
+	if notifyObj.AlarmStrategy != "" {
+		refColumn, refValue = "alarm_strategy", notifyObj.AlarmStrategy
+	} else if notifyObj.EndpointGroup != "" {
+		refColumn, refValue = "endpoint_group", notifyObj.EndpointGroup
+	} else if notifyObj.ServiceGroup != "" {
+		refColumn, refValue = "service_group", notifyObj.ServiceGroup
+	}
+	return
+}
+
+// getNotifyGuidListByRef 阈值、对象组或层级对象原来的通知配置,重建通知配置时只能沿用这些配置下渠道的密钥
+func getNotifyGuidListByRef(refColumn, refValue string) (result []string) {
+	if refColumn == "" {
+		return
+	}
+	if err := x.SQL(fmt.Sprintf("select guid from notify where %s=?", refColumn), refValue).Find(&result); err != nil {
+		log.Logger.Error("query notify fail", log.String(refColumn, refValue), log.Error(err))
+	}
+	return
+}
+
+// getNotifyListInsertActionBySource sourceNotifyList为正在编辑的对象原来的通知配置,渠道密钥是掩码时从这些配置里取
+func getNotifyListInsertActionBySource(notifyList []*models.NotifyObj, sourceNotifyList []string) (actions []*Action) {
 	actions = []*Action{}
 	if len(notifyList) == 0 {
 		return actions
 	}
-	var refColumn, refValue string
-	if notifyList[0].AlarmStrategy != "" {
-		refColumn, refValue = "alarm_strategy", notifyList[0].AlarmStrategy
-	} else if notifyList[0].EndpointGroup != "" {
-		refColumn, refValue = "endpoint_group", notifyList[0].EndpointGroup
-	} else if notifyList[0].ServiceGroup != "" {
-		refColumn, refValue = "service_group", notifyList[0].ServiceGroup
-	}
+	refColumn, refValue := getNotifyRefColumn(notifyList[0])
 	notifyGuidList := guid.CreateGuidList(len(notifyList))
 	for i, v := range notifyList {
 		if v.NotifyNum == 0 {
@@ -337,6 +397,7 @@ func getNotifyListInsertAction(notifyList []*models.NotifyObj) (actions []*Actio
 		}
 		tmpAction.Param = []interface{}{v.Guid, v.AlarmAction, v.AlarmPriority, v.NotifyNum, v.ProcCallbackName, v.ProcCallbackKey, v.CallbackUrl, v.CallbackParam, v.ProcCallbackMode, v.Description}
 		actions = append(actions, &tmpAction)
+		actions = append(actions, getNotifyChannelInsertAction(v.Guid, sourceNotifyList, v.Channels)...)
 		if len(v.NotifyRoles) > 0 {
 			tmpNotifyRoleGuidList := guid.CreateGuidList(len(v.NotifyRoles))
 			for ii, vv := range v.NotifyRoles {
@@ -352,14 +413,8 @@ func getNotifyListUpdateAction(notifyList []*models.NotifyObj) (actions []*Actio
 	if len(notifyList) == 0 {
 		return actions
 	}
-	var refColumn, refValue string
-	if notifyList[0].AlarmStrategy != "" {
-		refColumn, refValue = "alarm_strategy", notifyList[0].AlarmStrategy
-	} else if notifyList[0].EndpointGroup != "" {
-		refColumn, refValue = "endpoint_group", notifyList[0].EndpointGroup
-	} else if notifyList[0].ServiceGroup != "" {
-		refColumn, refValue = "service_group", notifyList[0].ServiceGroup
-	}
+	refColumn, refValue := getNotifyRefColumn(notifyList[0])
+	sourceNotifyList := getNotifyGuidListByRef(refColumn, refValue)
 	notifyGuidList := guid.CreateGuidList(len(notifyList))
 	for i, v := range notifyList {
 		if v.NotifyNum == 0 {
@@ -370,6 +425,7 @@ func getNotifyListUpdateAction(notifyList []*models.NotifyObj) (actions []*Actio
 			tmpAction.Param = []interface{}{v.AlarmAction, v.NotifyNum, v.ProcCallbackName, v.ProcCallbackKey, v.CallbackUrl, v.CallbackParam, v.ProcCallbackMode, v.Description, v.Guid}
 			actions = append(actions, &tmpAction)
 			actions = append(actions, &Action{Sql: "delete from notify_role_rel where notify=?", Param: []interface{}{v.Guid}})
+			actions = append(actions, getNotifyChannelUpdateAction(v.Guid, v.Channels)...)
 		} else {
 			v.Guid = "notify_" + notifyGuidList[i]
 			tmpAction := Action{}
@@ -380,6 +436,7 @@ func getNotifyListUpdateAction(notifyList []*models.NotifyObj) (actions []*Actio
 			}
 			tmpAction.Param = []interface{}{v.Guid, v.AlarmAction, v.AlarmPriority, v.NotifyNum, v.ProcCallbackName, v.ProcCallbackKey, v.CallbackUrl, v.CallbackParam, v.ProcCallbackMode, v.Description}
 			actions = append(actions, &tmpAction)
+			actions = append(actions, getNotifyChannelInsertAction(v.Guid, sourceNotifyList, v.Channels)...)
 		}
 		if len(v.NotifyRoles) > 0 {
 			tmpNotifyRoleGuidList := guid.CreateGuidList(len(v.NotifyRoles))
@@ -406,12 +463,14 @@ func getNotifyListDeleteAction(alarmStrategy, endpointGroup, serviceGroup string
 		actionParam = []interface{}{serviceGroup}
 	}
 	actions = append(actions, &Action{Sql: fmt.Sprintf("delete from notify_role_rel where notify in (select guid from notify where %s=?)", refColumn), Param: actionParam})
+	actions = append(actions, &Action{Sql: fmt.Sprintf("delete from notify_channel where notify in (select guid from notify where %s=?)", refColumn), Param: actionParam})
 	actions = append(actions, &Action{Sql: fmt.Sprintf("delete from notify where %s=?", refColumn), Param: actionParam})
 	return actions
 }
 
 func getNotifyDeleteAction(notifyGuid string) (actions []*Action) {
 	actions = append(actions, &Action{Sql: "delete from notify_role_rel where notify=?", Param: []interface{}{notifyGuid}})
+	actions = append(actions, getNotifyChannelDeleteAction(notifyGuid)...)
 	actions = append(actions, &Action{Sql: "delete from notify where guid=?", Param: []interface{}{notifyGuid}})
 	return actions
 }
@@ -419,7 +478,7 @@ func getNotifyDeleteAction(notifyGuid string) (actions []*Action) {
 func getStrategyConditions(alarmStrategyGuid string) (conditions []*models.StrategyConditionObj, err error) {
 	conditions = []*models.StrategyConditionObj{}
 	var strategyMetricRows []*models.AlarmStrategyMetricQueryRow
-	err = x.SQL("select t1.guid,t1.alarm_strategy,t1.metric,t1.`condition`,t1.`last`,t2.metric as `metric_name` from alarm_strategy_metric t1 left join metric t2 on t1.metric=t2.guid where t1.alarm_strategy=?", alarmStrategyGuid).Find(&strategyMetricRows)
+	err = x.SQL("select t1.guid,t1.alarm_strategy,t1.metric,t1.`condition`,t1.`last`,t1.condition_type,t1.anomaly_window,t1.recover_condition,t1.recover_last,t1.flap_count,t1.flap_window,t2.metric as `metric_name` from alarm_strategy_metric t1 left join metric t2 on t1.metric=t2.guid where t1.alarm_strategy=?", alarmStrategyGuid).Find(&strategyMetricRows)
 	if err != nil {
 		err = fmt.Errorf("query alarm strategy metric with strategyGuid:%s fail,%s ", alarmStrategyGuid, err.Error())
 		return
@@ -448,7 +507,9 @@ func getStrategyConditions(alarmStrategyGuid string) (conditions []*models.Strat
 		}
 	}
 	for _, metricRow := range strategyMetricRows {
-		conditionRow := models.StrategyConditionObj{Metric: metricRow.Metric, Condition: metricRow.Condition, Last: metricRow.Last, Tags: []*models.MetricTag{}, MetricName: metricRow.MetricName}
+		conditionRow := models.StrategyConditionObj{Metric: metricRow.Metric, Condition: metricRow.Condition, Last: metricRow.Last, Tags: []*models.MetricTag{}, MetricName: metricRow.MetricName,
+			ConditionType: metricRow.ConditionType, AnomalyWindow: metricRow.AnomalyWindow, RecoverCondition: metricRow.RecoverCondition, RecoverLast: metricRow.RecoverLast,
+			FlapCount: metricRow.FlapCount, FlapWindow: metricRow.FlapWindow}
 		for _, tagRow := range strategyTagRows {
 			if tagRow.AlarmStrategyMetric == metricRow.Guid {
 				tmpTag := models.MetricTag{TagName: tagRow.Name, TagValue: []string{}, Equal: tagRow.Equal}
@@ -472,8 +533,7 @@ func getStrategyConditionInsertAction(alarmStrategyGuid string, conditions []*mo
 		return
 	}
 	for i, metricRow := range conditions {
-		tmpMetricRowString, _ := json.Marshal(metricRow)
-		tmpCrcHash := fmt.Sprintf("%d", crc64.Checksum(tmpMetricRowString, crc64.MakeTable(crc64.ECMA)))
+		tmpCrcHash := getStrategyConditionCrcHash(metricRow)
 		if _, existFlag := existCrcMap[tmpCrcHash]; existFlag {
 			err = fmt.Errorf("metric condition is duplicated")
 			return
@@ -483,8 +543,13 @@ func getStrategyConditionInsertAction(alarmStrategyGuid string, conditions []*mo
 		if _, ok := monitorEngineMetricMap[metricRow.Metric]; ok {
 			monitorEngineFlag = 1
 		}
-		actions = append(actions, &Action{Sql: "insert into alarm_strategy_metric(guid,alarm_strategy,metric,`condition`,`last`,create_time,crc_hash,monitor_engine,log_type) values (?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-			metricGuidList[i], alarmStrategyGuid, metricRow.Metric, metricRow.Condition, metricRow.Last, nowTime, tmpCrcHash, monitorEngineFlag, metricRow.LogType,
+		// 异常检测条件Prometheus规则无法表达,交给监控引擎计算
+		if metricRow.ConditionType != "" {
+			monitorEngineFlag = 1
+		}
+		actions = append(actions, &Action{Sql: "insert into alarm_strategy_metric(guid,alarm_strategy,metric,`condition`,`last`,create_time,crc_hash,monitor_engine,log_type,condition_type,anomaly_window,recover_condition,recover_last,flap_count,flap_window) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			metricGuidList[i], alarmStrategyGuid, metricRow.Metric, metricRow.Condition, metricRow.Last, nowTime, tmpCrcHash, monitorEngineFlag, metricRow.LogType, metricRow.ConditionType, metricRow.AnomalyWindow,
+			metricRow.RecoverCondition, metricRow.RecoverLast, metricRow.FlapCount, metricRow.FlapWindow,
 		}})
 		if len(metricRow.Tags) > 0 {
 			tagGuidList := guid.CreateGuidList(len(metricRow.Tags))
@@ -499,6 +564,26 @@ func getStrategyConditionInsertAction(alarmStrategyGuid string, conditions []*mo
 	return
 }
 
+// strategyConditionIdentity 参与条件crc计算的字段,条件类型和异常检测窗口不同的是不同的条件;
+// 字段和json名与原来整个条件序列化时一致,已有静态阈值条件的crc不变
+type strategyConditionIdentity struct {
+	Metric        string              `json:"metric"`
+	MetricName    string              `json:"metric_name"`
+	Condition     string              `json:"condition"`
+	Last          string              `json:"last"`
+	Tags          []*models.MetricTag `json:"tags"`
+	LogType       string              `json:"logType"`
+	ConditionType string              `json:"condition_type,omitempty"`
+	AnomalyWindow string              `json:"anomaly_window,omitempty"`
+}
+
+// getStrategyConditionCrcHash 条件的crc用来关联Prometheus规则和告警,恢复和抖动配置修改不应该变成另一个条件
+func getStrategyConditionCrcHash(condition *models.StrategyConditionObj) string {
+	identityBytes, _ := json.Marshal(&strategyConditionIdentity{Metric: condition.Metric, MetricName: condition.MetricName, Condition: condition.Condition, Last: condition.Last,
+		Tags: condition.Tags, LogType: condition.LogType, ConditionType: condition.ConditionType, AnomalyWindow: condition.AnomalyWindow})
+	return fmt.Sprintf("%d", crc64.Checksum(identityBytes, crc64.MakeTable(crc64.ECMA)))
+}
+
 func getStrategyConditionUpdateAction(alarmStrategyGuid string, conditions []*models.StrategyConditionObj) (actions []*Action, err error) {
 	actions = append(actions, getStrategyConditionDeleteAction(alarmStrategyGuid)...)
 	for _, condition := range conditions {
@@ -536,13 +621,7 @@ func SyncPrometheusRuleFile(endpointGroup string, fromPeer bool) error {
 	}
 	log.Logger.Info("SyncPrometheusRuleFile", log.String("endpointGroup", endpointGroup))
 	ruleFileName := "g_" + endpointGroup
-	var endpointList []*models.EndpointNewTable
-	if endpointGroupObj.ServiceGroup == "" {
-		err = x.SQL("select * from endpoint_new where monitor_type=? and guid in (select endpoint from endpoint_group_rel where endpoint_group=?)", endpointGroupObj.MonitorType, endpointGroup).Find(&endpointList)
-	} else {
-		serviceGroupGuidList, _ := fetchGlobalServiceGroupChildGuidList(endpointGroupObj.ServiceGroup)
-		err = x.SQL("select * from endpoint_new where monitor_type=? and guid in (select endpoint from endpoint_service_rel where service_group in ('"+strings.Join(serviceGroupGuidList, "','")+"'))", endpointGroupObj.MonitorType).Find(&endpointList)
-	}
+	endpointList, err := getEndpointGroupMemberList(endpointGroupObj)
 	if err != nil {
 		return err
 	}
@@ -705,6 +784,26 @@ func getAlarmStrategyWithExprNew(endpointGroup string) (result, monitorEngineStr
 	return
 }
 
+// BuildStrategyConditionExprList 按对象组当前成员替换阈值条件的表达式,和下发Prometheus规则时的替换一致,返回的表达式和conditions下标对应
+func BuildStrategyConditionExprList(param *models.GroupStrategyObj) (endpointList []*models.EndpointNewTable, exprList []string, err error) {
+	if endpointList, err = GetEndpointGroupMemberList(param.EndpointGroup); err != nil {
+		return
+	}
+	guidExpr, addressExpr, ipExpr := buildRuleReplaceExprNew(endpointList)
+	for _, condition := range param.Conditions {
+		metricRow, getMetricErr := GetSimpleMetric(condition.Metric)
+		if getMetricErr != nil {
+			err = getMetricErr
+			return
+		}
+		tmpStrategyObj := models.AlarmStrategyMetricObj{Guid: param.Guid, EndpointGroup: param.EndpointGroup, Metric: condition.Metric, Condition: condition.Condition, Last: condition.Last,
+			MetricName: metricRow.Metric, MetricExpr: metricRow.PromExpr, MetricType: metricRow.MonitorType, LogType: condition.LogType, Tags: condition.Tags}
+		buildStrategyAlarmRuleExpr(guidExpr, addressExpr, ipExpr, &tmpStrategyObj)
+		exprList = append(exprList, tmpStrategyObj.MetricExpr)
+	}
+	return
+}
+
 func buildRuleReplaceExprNew(endpointList []*models.EndpointNewTable) (guidExpr, addressExpr, ipExpr string) {
 	for _, endpoint := range endpointList {
 		addressExpr += endpoint.AgentAddress + "|"
@@ -891,17 +990,8 @@ func NotifyStrategyAlarm(alarmObj *models.AlarmHandleObj) {
 	}
 	// 延迟发送通知，在延迟时间内如果告警恢复，则不发送通知，避免那种频繁告警恢复的场景
 	if alarmObj.NotifyDelay > 0 {
-		if alarmObj.Status == "firing" {
-			time.Sleep(time.Duration(alarmObj.NotifyDelay) * time.Second)
-			var nowAlarms []*models.AlarmTable
-			x.SQL("select id,status from alarm where id=?", alarmObj.Id).Find(&nowAlarms)
-			if len(nowAlarms) > 0 {
-				if nowAlarms[0].Status == "ok" {
-					log.Logger.Info("Notify firing alarm break in delay time ", log.Int("alarmId", alarmObj.Id))
-					return
-				}
-			}
-		} else if alarmObj.Status == "ok" {
+		// firing告警不在这里等待,入队时把投递时间推后,到期时由投递队列检查告警是否已恢复
+		if alarmObj.Status == "ok" {
 			var nowAlarms []*models.AlarmTable
 			x.SQL("select id,`start` from alarm where id=?", alarmObj.Id).Find(&nowAlarms)
 			if len(nowAlarms) > 0 {
@@ -912,6 +1002,32 @@ func NotifyStrategyAlarm(alarmObj *models.AlarmHandleObj) {
 			}
 		}
 	}
+	// 已确认的告警不再重复发送firing通知
+	if alarmObj.Status == "firing" && checkAlarmAck(alarmObj.Id) {
+		log.Logger.Info("Notify firing alarm break,alarm is acked", log.Int("alarmId", alarmObj.Id))
+		return
+	}
+	// 抖动中的告警不发通知,稳定后由定时任务补发
+	if checkAlarmFlapping(alarmObj.Id) {
+		log.Logger.Info("Notify alarm break,alarm is flapping", log.Int("alarmId", alarmObj.Id))
+		return
+	}
+	// 归属同一事件的告警,每个阈值配置只由它在事件里的第一条告警发送通知
+	incidentAlarms, skip := getAlarmIncidentNotifyAlarms(alarmObj)
+	if skip {
+		log.Logger.Info("Notify alarm break,alarm incident already notify", log.Int("alarmId", alarmObj.Id))
+		return
+	}
+	if len(incidentAlarms) == 0 {
+		incidentAlarms = []*models.AlarmHandleObj{alarmObj}
+	}
+	for _, notifyAlarm := range incidentAlarms {
+		notifyStrategyAlarmReceiver(notifyAlarm)
+	}
+}
+
+// notifyStrategyAlarmReceiver 按阈值配置、对象组、全局的顺序找通知配置并发送
+func notifyStrategyAlarmReceiver(alarmObj *models.AlarmHandleObj) {
 	// 1.先去单条阈值配置里找通知配置(单条阈值配置里的通知配置)，优先找这颗粒度最小的配置
 	notifyObject := &models.NotifyTable{}
 	var notifyQueryRows []*models.NotifyTable
@@ -970,16 +1086,22 @@ func NotifyStrategyAlarm(alarmObj *models.AlarmHandleObj) {
 
 func notifyAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj) {
 	log.Logger.Info("Start notify action", log.String("procCallKey", notify.ProcCallbackKey), log.String("notify", notify.Guid), log.Int("alarm", alarmObj.Id))
+	var deliveryList []*models.NotifyDeliveryTable
+	mailEnqueue := false
 	// alarmMailEnable==Y
-	var err, mailErr error
 	if models.AlarmMailEnable {
-		mailErr = notifyMailAction(notify, alarmObj)
-		if mailErr != nil {
-			log.Logger.Error("Notify mail fail", log.String("notifyGuid", notify.Guid), log.Error(mailErr))
+		if mailDelivery, err := buildMailDelivery(notify, alarmObj); err != nil {
+			log.Logger.Error("Notify mail fail", log.String("notifyGuid", notify.Guid), log.Error(err))
+		} else if mailDelivery != nil {
+			deliveryList = append(deliveryList, mailDelivery)
+			mailEnqueue = true
 		}
 	}
+	// 自定义通知渠道(webhook/im机器人/短信)不依赖平台回调,单独发送
+	deliveryList = append(deliveryList, buildChannelDeliveryList(notify, alarmObj)...)
 	if notify.ProcCallbackMode != models.AlarmNotifyAutoMode {
 		log.Logger.Info("notify proc callback mode is not auto,done", log.Int("alarmId", alarmObj.Id), log.String("notifyId", notify.Guid), log.String("mode", notify.ProcCallbackMode))
+		enqueueNotifyDelivery(deliveryList, alarmObj)
 		return
 	}
 	if alarmObj.SPriority == "" {
@@ -988,18 +1110,20 @@ func notifyAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj) {
 			alarmObj.SPriority = tmpAlarmRows[0]["s_priority"]
 		}
 	}
-	withoutRetry := false
-	for i := 0; i < 3; i++ {
-		withoutRetry, err = notifyEventAction(notify, alarmObj, true, "system")
-		if err == nil {
-			break
-		} else {
-			log.Logger.Error("Notify event fail", log.String("notifyGuid", notify.Guid), log.Int("try", i), log.Error(err))
-		}
-		if withoutRetry {
-			break
+	if !compareNotifyEventLevel(alarmObj.SPriority) {
+		// 级别低于平台回调的最低级别,改为发邮件
+		log.Logger.Info("notify event disable", log.String("level", alarmObj.SPriority), log.String("minLevel", models.Config().MonitorAlarmCallbackLevelMin))
+		if !mailEnqueue {
+			if mailDelivery, err := buildMailDelivery(notify, alarmObj); err != nil {
+				log.Logger.Error("Notify mail fail", log.String("notifyGuid", notify.Guid), log.Error(err))
+			} else if mailDelivery != nil {
+				deliveryList = append(deliveryList, mailDelivery)
+			}
 		}
+	} else {
+		deliveryList = append(deliveryList, buildEventDelivery(notify, alarmObj, "system"))
 	}
+	enqueueNotifyDelivery(deliveryList, alarmObj)
 }
 
 func compareNotifyEventLevel(level string) bool {
@@ -1016,12 +1140,7 @@ func compareNotifyEventLevel(level string) bool {
 	return result
 }
 
-func notifyEventAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj, compareLevel bool, operator string) (withoutRetry bool, err error) {
-	if compareLevel && !compareNotifyEventLevel(alarmObj.SPriority) {
-		log.Logger.Info("notify event disable", log.String("level", alarmObj.SPriority), log.String("minLevel", models.Config().MonitorAlarmCallbackLevelMin))
-		err = notifyMailAction(notify, alarmObj)
-		return
-	}
+func buildNotifyEventRequest(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj, operator string) (requestParam models.CoreNotifyRequest, err error) {
 	if notify.ProcCallbackKey == "" {
 		if alarmObj.Status == "firing" {
 			if models.FiringCallback != "" && models.FiringCallback != models.DefaultFiringCallback {
@@ -1034,26 +1153,28 @@ func notifyEventAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleO
 		}
 		if notify.ProcCallbackKey == "" {
 			err = fmt.Errorf("Notify:%s procCallbackKey is empty ", notify.Guid)
-			withoutRetry = true
 			return
 		}
 	}
-	var requestParam models.CoreNotifyRequest
 	requestParam.EventSeqNo = fmt.Sprintf("%d-%s-%d-%s", alarmObj.Id, alarmObj.Status, time.Now().Unix(), notify.Guid)
 	requestParam.EventType = "alarm"
 	requestParam.SourceSubSystem = "SYS_MONITOR"
 	requestParam.OperationKey = notify.ProcCallbackKey
 	requestParam.OperationData = fmt.Sprintf("%d-%s-%s-%s", alarmObj.Id, alarmObj.Status, notify.Guid, operator)
 	requestParam.OperationUser = operator
+	return
+}
+
+func postNotifyEvent(requestParam *models.CoreNotifyRequest) (err error) {
 	log.Logger.Info(fmt.Sprintf("new notify request data --> eventSeqNo:%s operationKey:%s operationData:%s", requestParam.EventSeqNo, requestParam.OperationKey, requestParam.OperationData))
 	b, _ := json.Marshal(requestParam)
 	request, reqErr := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/platform/v1/operation-events", models.CoreUrl), strings.NewReader(string(b)))
-	request.Header.Set("Authorization", models.GetCoreToken())
-	request.Header.Set("Content-Type", "application/json")
 	if reqErr != nil {
 		err = fmt.Errorf("Notify core event new request fail, %s ", reqErr.Error())
 		return
 	}
+	request.Header.Set("Authorization", models.GetCoreToken())
+	request.Header.Set("Content-Type", "application/json")
 	res, doHttpErr := ctxhttp.Do(context.Background(), http.DefaultClient, request)
 	if doHttpErr != nil {
 		err = fmt.Errorf("Notify core event ctxhttp request fail,%s ", doHttpErr.Error())
@@ -1090,6 +1211,7 @@ func getNotifyEventMessage(notifyGuid string, alarm models.AlarmTable) (result m
 	}
 	alarmObj := models.AlarmHandleObj{AlarmTable: alarm}
 	alarmObj.AlarmDetail = buildAlarmDetailData(alarmDetailList, "\r\n")
+	alarmObj.AlarmDetail = appendLogKeywordAlarmContext(alarmObj.Id, alarmObj.SMetric, alarmObj.AlarmDetail, "\r\n")
 	result.Subject, result.Content = getNotifyMessage(&alarmObj)
 	var roles []*models.RoleNewTable
 	if notifyObj.ServiceGroup != "" {
@@ -1130,7 +1252,8 @@ func getNotifyEventMessage(notifyGuid string, alarm models.AlarmTable) (result m
 	return result
 }
 
-func notifyMailAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj) error {
+// buildNotifyMail 计算邮件接收人并渲染邮件内容,接收人为空时返回nil
+func buildNotifyMail(notify *models.NotifyTable, alarmObj *models.AlarmHandleObj) (*models.NotifyDeliveryMailPayload, error) {
 	var roles []*models.RoleNewTable
 	var toAddress, roleList, tmpToAddress []string
 	var queryRoleErr error
@@ -1144,7 +1267,7 @@ func notifyMailAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleOb
 		}
 	}
 	if queryRoleErr != nil {
-		log.Logger.Error("notifyMailAction query role fail", log.Int("alarmId", alarmObj.Id), log.Error(queryRoleErr))
+		log.Logger.Error("buildNotifyMail query role fail", log.Int("alarmId", alarmObj.Id), log.Error(queryRoleErr))
 	}
 	// 先拿自己角色表的邮箱，独立运行的情况下有用
 	for _, v := range roles {
@@ -1165,8 +1288,8 @@ func notifyMailAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleOb
 		toAddress = models.DefaultMailReceiver
 	}
 	if len(toAddress) == 0 {
-		log.Logger.Warn("notifyMailAction toAddress empty", log.String("notify", notify.Guid), log.StringList("roleList", roleList))
-		return nil
+		log.Logger.Warn("buildNotifyMail toAddress empty", log.String("notify", notify.Guid), log.StringList("roleList", roleList))
+		return nil, nil
 	}
 	for _, v := range toAddress {
 		for _, vv := range strings.Split(v, ",") {
@@ -1176,6 +1299,24 @@ func notifyMailAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleOb
 		}
 	}
 	toAddress = tmpToAddress
+	var err error
+	alarmDetailList := []*models.AlarmDetailData{}
+	if strings.HasPrefix(alarmObj.EndpointTags, "ac_") {
+		alarmDetailList, err = GetAlarmDetailList(alarmObj.Id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		alarmDetailList = append(alarmDetailList, &models.AlarmDetailData{Metric: alarmObj.SMetric, Cond: alarmObj.SCond, Last: alarmObj.SLast, Start: alarmObj.Start, StartValue: alarmObj.StartValue, End: alarmObj.End, EndValue: alarmObj.EndValue, Tags: alarmObj.Tags})
+	}
+	alarmObj.AlarmDetail = buildAlarmDetailData(alarmDetailList, "\r\n")
+	alarmObj.AlarmDetail = appendLogKeywordAlarmContext(alarmObj.Id, alarmObj.SMetric, alarmObj.AlarmDetail, "\r\n")
+	result := models.NotifyDeliveryMailPayload{To: toAddress}
+	result.Subject, result.Content = getNotifyMessage(alarmObj)
+	return &result, nil
+}
+
+func sendNotifyMail(mail *models.NotifyDeliveryMailPayload) error {
 	mailConfig, err := GetSysAlertMailConfig()
 	if err != nil {
 		return err
@@ -1192,18 +1333,7 @@ func notifyMailAction(notify *models.NotifyTable, alarmObj *models.AlarmHandleOb
 	if err != nil {
 		return err
 	}
-	alarmDetailList := []*models.AlarmDetailData{}
-	if strings.HasPrefix(alarmObj.EndpointTags, "ac_") {
-		alarmDetailList, err = GetAlarmDetailList(alarmObj.Id)
-		if err != nil {
-			return err
-		}
-	} else {
-		alarmDetailList = append(alarmDetailList, &models.AlarmDetailData{Metric: alarmObj.SMetric, Cond: alarmObj.SCond, Last: alarmObj.SLast, Start: alarmObj.Start, StartValue: alarmObj.StartValue, End: alarmObj.End, EndValue: alarmObj.EndValue, Tags: alarmObj.Tags})
-	}
-	alarmObj.AlarmDetail = buildAlarmDetailData(alarmDetailList, "\r\n")
-	subject, content := getNotifyMessage(alarmObj)
-	return mailSender.Send(subject, content, toAddress)
+	return mailSender.Send(mail.Subject, mail.Content, mail.To)
 }
 
 func getNotifyMessage(alarmObj *models.AlarmHandleObj) (subject, content string) {
@@ -1475,6 +1605,7 @@ func GetSimpleAlarmStrategy(alarmStrategyGuid string) (result *models.AlarmStrat
 }
 
 func getStrategyNotifyImportActions(endpointGroup string, notifyList []*models.NotifyObj) (actions []*Action) {
+	actions = append(actions, &Action{Sql: "delete from notify_channel where notify in (select guid from notify where endpoint_group=?)", Param: []interface{}{endpointGroup}})
 	actions = append(actions, &Action{Sql: "delete from notify where endpoint_group=?", Param: []interface{}{endpointGroup}})
 	for _, v := range notifyList {
 		v.AlarmStrategy = ""
@@ -1533,7 +1664,7 @@ func UpdateAlarmStrategyMetricExpr(alarmStrategyMetricObj *models.AlarmStrategyM
 }
 
 func GetMonitorEngineAlarmList() (alarmList []*models.AlarmTable, err error) {
-	err = x.SQL("select id,endpoint,status,s_metric,tags,alarm_strategy from alarm where status='firing' and alarm_strategy in (select alarm_strategy from alarm_strategy_metric where monitor_engine=1) order by id desc").Find(&alarmList)
+	err = x.SQL("select id,endpoint,status,s_metric,s_cond,tags,alarm_strategy from alarm where status='firing' and alarm_strategy in (select alarm_strategy from alarm_strategy_metric where monitor_engine=1) order by id desc").Find(&alarmList)
 	if err != nil {
 		err = fmt.Errorf("get monitor engine alarm firing list fail,%s ", err.Error())
 	}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	m "github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
+)
+
+// archiveGapTolerance Prometheus数据开始时间比查询开始时间晚这么多秒以上才去归档库补数
+const archiveGapTolerance = 120
+
+// archiveAggColumnMap 归档表里每分钟(或5分钟)一行,按聚合方式取对应的列
+var archiveAggColumnMap = map[string]string{"avg": "avg", "min": "min", "max": "max", "p95": "p95", "sum": "sum"}
+
+// QueryMonitorSerialData 图表统一查询入口,返回图表序列和归档数据的步长(没用到归档数据时为0)
+func QueryMonitorSerialData(query *m.QueryMonitorData, agg string) (serials []*m.SerialModel, archiveStep int, err error) {
+	var queryData *m.PrometheusData
+	if queryData, archiveStep, err = QueryMonitorRangeData(query, agg); err != nil {
+		log.Logger.Error("Query monitor data fail", log.Error(err))
+		return []*m.SerialModel{}, archiveStep, err
+	}
+	serials = datasource.BuildSerialData(query, queryData)
+	return
+}
+
+// QueryMonitorRangeData 早于本地存储天数的部分从归档库查,之后的部分查Prometheus,
+// Prometheus实际保留的数据比配置的少时,前面缺的部分也从归档库补,两边标签相同的序列拼成一条
+func QueryMonitorRangeData(query *m.QueryMonitorData, agg string) (result *m.PrometheusData, archiveStep int, err error) {
+	return stitchArchiveData(query.Endpoint, query.Metric, agg, query.Start, query.End, func(promStart int64) (*m.PrometheusData, error) {
+		promQuery := *query
+		promQuery.Start = promStart
+		promData, promErr := datasource.QueryMonitorRangeData(&promQuery)
+		query.PromQ = promQuery.PromQ
+		return promData, promErr
+	})
+}
+
+// QueryLongTermRangeData 告警回测等按表达式查询的场景,endpointList和metricList用来定位归档数据
+func QueryLongTermRangeData(datasourceList []string, promQl string, endpointList, metricList []string, agg string, start, end, step int64) (result *m.PrometheusData, archiveStep int, err error) {
+	return stitchArchiveData(endpointList, metricList, agg, start, end, func(promStart int64) (*m.PrometheusData, error) {
+		return datasource.QueryPrometheusRangeByDatasource(datasourceList, promQl, promStart, end, step)
+	})
+}
+
+func stitchArchiveData(endpointList, metricList []string, agg string, start, end int64, promFunc func(promStart int64) (*m.PrometheusData, error)) (result *m.PrometheusData, archiveStep int, err error) {
+	if !ArchiveEnable || len(endpointList) == 0 || len(metricList) == 0 {
+		result, err = promFunc(start)
+		return
+	}
+	boundary := getArchiveBoundary()
+	archiveEnd := end
+	var promData, archiveData *m.PrometheusData
+	if end > boundary {
+		promStart := start
+		if promStart < boundary {
+			promStart = boundary
+		}
+		promData, err = promFunc(promStart)
+		if err != nil {
+			if start >= boundary {
+				return
+			}
+			log.Logger.Warn("Query prometheus data fail,only use archive data", log.Error(err))
+			err = nil
+		}
+		archiveEnd = getPrometheusDataStart(promData, end)
+		// 查询范围都在本地存储天数内且Prometheus没有数据时,归档库也不会有,不用按天扫归档表
+		if start >= boundary && archiveEnd >= end {
+			archiveEnd = start
+		}
+	}
+	// 归档任务每天凌晨归档前一天的数据,当天的数据不用去归档库查
+	t := time.Now()
+	if todayStart := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Unix(); archiveEnd > todayStart {
+		archiveEnd = todayStart
+	}
+	if archiveEnd > start+archiveGapTolerance {
+		archiveData, archiveStep, err = queryArchiveData(endpointList, metricList, agg, start, archiveEnd)
+		if err != nil {
+			if promData == nil {
+				return
+			}
+			log.Logger.Warn("Query archive data fail,only use prometheus data", log.Error(err))
+			err = nil
+		}
+	}
+	result = datasource.MergePrometheusData(promData, archiveData)
+	return
+}
+
+// getArchiveBoundary 本地Prometheus保留天数以前的数据只在归档库里
+func getArchiveBoundary() int64 {
+	if m.Config().ArchiveMysql.LocalStorageMaxDay <= 0 {
+		return 0
+	}
+	return time.Now().Unix() - m.Config().ArchiveMysql.LocalStorageMaxDay*86400
+}
+
+func getPrometheusDataStart(promData *m.PrometheusData, end int64) (dataStart int64) {
+	dataStart = end
+	if promData == nil {
+		return
+	}
+	for _, series := range promData.Result {
+		if len(series.Values) == 0 {
+			continue
+		}
+		if tmpTime, ok := series.Values[0][0].(float64); ok && int64(tmpTime) < dataStart {
+			dataStart = int64(tmpTime)
+		}
+	}
+	return
+}
+
+// queryArchiveData 按天查归档表,归档库按年分库,表名和列名由日期和聚合方式生成,对象、指标和时间都走参数
+func queryArchiveData(endpointList, metricList []string, agg string, start, end int64) (result *m.PrometheusData, step int, err error) {
+	checkArchiveDatabase()
+	if start >= end {
+		return result, step, fmt.Errorf("get archive data query start and end validate fail,start:%d end:%d ", start, end)
+	}
+	column, b := archiveAggColumnMap[agg]
+	if !b {
+		column = "avg"
+	}
+	step = 60
+	if fiveMinStartDay := m.Config().ArchiveMysql.FiveMinStartDay; fiveMinStartDay > 0 && start < time.Now().Unix()-fiveMinStartDay*86400 {
+		step = 300
+	}
+	// 兼容 metric/tag=value 格式的指标,按标签过滤
+	metricNameList := []string{}
+	metricTagMap := make(map[string]string)
+	for _, metric := range metricList {
+		if slashIndex := strings.Index(metric, "/"); slashIndex >= 0 {
+			metricTagMap[metric[:slashIndex]] = strings.Replace(metric[slashIndex+1:], "=", "=\"", 1) + "\""
+			metric = metric[:slashIndex]
+		}
+		metricNameList = append(metricNameList, metric)
+	}
+	endpointFilterSql, endpointFilterParam := createListParams(endpointList, "")
+	metricFilterSql, metricFilterParam := createListParams(metricNameList, "")
+	result = &m.PrometheusData{ResultType: "matrix", Result: []m.PrometheusResult{}}
+	seriesIndexMap := make(map[string]int)
+	cursorTime := time.Unix(start, 0)
+	for dayStart := time.Date(cursorTime.Year(), cursorTime.Month(), cursorTime.Day(), 0, 0, 0, 0, cursorTime.Location()); dayStart.Unix() <= end; dayStart = dayStart.AddDate(0, 0, 1) {
+		tableName := fmt.Sprintf("`%s%s`.`archive_%s`", m.Config().ArchiveMysql.DatabasePrefix, dayStart.Format("2006"), dayStart.Format("2006_01_02"))
+		var tableData []*m.ArchiveQueryTable
+		queryParams := append(append([]interface{}{}, endpointFilterParam...), metricFilterParam...)
+		queryParams = append(queryParams, start, end)
+		queryErr := archiveMysql.SQL(fmt.Sprintf("SELECT `endpoint`,metric,tags,unix_time,`%s` AS `value` FROM %s WHERE `endpoint` in (%s) AND metric in (%s) AND unix_time>=? AND unix_time<=? ORDER BY unix_time",
+			column, tableName, endpointFilterSql, metricFilterSql), queryParams...).Find(&tableData)
+		if queryErr != nil {
+			if strings.Contains(queryErr.Error(), "doesn't exist") {
+				log.Logger.Debug("Query archive table fail,table doesn't exist", log.String("table", tableName))
+			} else {
+				log.Logger.Warn("Query archive table fail", log.String("table", tableName), log.Error(queryErr))
+			}
+			continue
+		}
+		for _, rowData := range tableData {
+			if tagFilter, b := metricTagMap[rowData.Metric]; b && !strings.Contains(rowData.Tags, tagFilter) {
+				continue
+			}
+			seriesKey := rowData.Endpoint + "|" + rowData.Metric + "|" + rowData.Tags
+			index, b := seriesIndexMap[seriesKey]
+			if !b {
+				index = len(result.Result)
+				seriesIndexMap[seriesKey] = index
+				result.Result = append(result.Result, m.PrometheusResult{Metric: parseArchiveTags(rowData.Tags), Values: [][]interface{}{}})
+			}
+			result.Result[index].Values = append(result.Result[index].Values, []interface{}{float64(rowData.UnixTime), strconv.FormatFloat(rowData.Value, 'f', -1, 64)})
+		}
+	}
+	return
+}
+
+// parseArchiveTags 归档表的tags格式为 key1="value1",key2="value2"
+func parseArchiveTags(tags string) map[string]string {
+	tagMap := make(map[string]string)
+	for tags != "" {
+		eqIndex := strings.Index(tags, "=\"")
+		if eqIndex < 0 {
+			break
+		}
+		key := tags[:eqIndex]
+		tags = tags[eqIndex+2:]
+		valueEnd := strings.Index(tags, "\",")
+		if valueEnd < 0 {
+			tagMap[key] = strings.TrimSuffix(tags, "\"")
+			break
+		}
+		tagMap[key] = tags[:valueEnd]
+		tags = tags[valueEnd+2:]
+	}
+	return tagMap
+}
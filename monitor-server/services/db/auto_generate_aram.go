@@ -25,6 +25,7 @@ const (
 	constReqSucCount    = "req_suc_rate"
 	constConstTimeAvg   = "req_costtime_avg"
 	constConstTimeMax   = "req_costtime_max"
+	constConstTimeP99   = "req_costtime_p99"
 	constSuccess        = "success"
 )
 
@@ -229,40 +230,45 @@ func autoGenerateCustomDashboard(dashboardParam models.AutoCreateDashboardParam)
 	// 添加 other默认告警
 	codeList = append(codeList, constOther)
 	if dashboardParam.AutoCreateDashboard {
-		// 1. 先创建看板
-		dashboard := &models.CustomDashboardTable{
-			Name:           fmt.Sprintf("%s_%s", dashboardParam.ServiceGroup, dashboardParam.MetricPrefixCode),
-			CreateUser:     dashboardParam.Operator,
-			UpdateUser:     dashboardParam.Operator,
-			CreateAt:       now,
-			UpdateAt:       now,
-			RefreshWeek:    10,
-			TimeRange:      -1800,
-			PanelGroups:    strings.Join(codeList, ","),
-			LogMetricGroup: &dashboardParam.LogMetricGroupGuid,
-		}
-		// 看板名称使用显示名
-		if displayServiceGroup != "" {
-			dashboard.Name = fmt.Sprintf("%s_%s", displayServiceGroup, dashboardParam.MetricPrefixCode)
-		}
-		customDashboard = dashboard.Name
-		// 查询看板 名称是否已存在
-		if customDashboardList, err = QueryCustomDashboardListByName(customDashboard); err != nil {
-			return
-		}
-		if len(customDashboardList) > 0 {
-			err = fmt.Errorf(dashboardParam.ErrMsgObj.ImportDashboardNameExistError, customDashboardList[0].Name)
-			return
-		}
-		if len(dashboardParam.ServiceGroupsRoles) == 0 {
-			err = fmt.Errorf("config role empty")
-			return
-		}
-		if subDashboardActions, newDashboardId, err = getAddCustomDashboardActions(dashboard, dashboardParam.ServiceGroupsRoles[:1], dashboardParam.ServiceGroupsRoles); err != nil {
-			return
-		}
-		if len(subDashboardActions) > 0 {
-			actions = append(actions, subDashboardActions...)
+		if dashboardParam.DashboardId > 0 {
+			// 模版版本切换后在原看板下重新生成图表
+			newDashboardId = dashboardParam.DashboardId
+		} else {
+			// 1. 先创建看板
+			dashboard := &models.CustomDashboardTable{
+				Name:           fmt.Sprintf("%s_%s", dashboardParam.ServiceGroup, dashboardParam.MetricPrefixCode),
+				CreateUser:     dashboardParam.Operator,
+				UpdateUser:     dashboardParam.Operator,
+				CreateAt:       now,
+				UpdateAt:       now,
+				RefreshWeek:    10,
+				TimeRange:      -1800,
+				PanelGroups:    strings.Join(codeList, ","),
+				LogMetricGroup: &dashboardParam.LogMetricGroupGuid,
+			}
+			// 看板名称使用显示名
+			if displayServiceGroup != "" {
+				dashboard.Name = fmt.Sprintf("%s_%s", displayServiceGroup, dashboardParam.MetricPrefixCode)
+			}
+			customDashboard = dashboard.Name
+			// 查询看板 名称是否已存在
+			if customDashboardList, err = QueryCustomDashboardListByName(customDashboard); err != nil {
+				return
+			}
+			if len(customDashboardList) > 0 {
+				err = fmt.Errorf(dashboardParam.ErrMsgObj.ImportDashboardNameExistError, customDashboardList[0].Name)
+				return
+			}
+			if len(dashboardParam.ServiceGroupsRoles) == 0 {
+				err = fmt.Errorf("config role empty")
+				return
+			}
+			if subDashboardActions, newDashboardId, err = getAddCustomDashboardActions(dashboard, dashboardParam.ServiceGroupsRoles[:1], dashboardParam.ServiceGroupsRoles); err != nil {
+				return
+			}
+			if len(subDashboardActions) > 0 {
+				actions = append(actions, subDashboardActions...)
+			}
 		}
 		// 2. 新增图表
 		for index, code := range codeList {
@@ -364,11 +370,50 @@ func autoGenerateCustomDashboard(dashboardParam models.AutoCreateDashboardParam)
 			}
 			// 请求量标签线条
 			chartParam3.ChartSeries = append(chartParam3.ChartSeries, chartSeries)
+			// 配置了p99耗时的和平均耗时画在一起,同样只看成功请求
+			if costTimeP99Metric := getMetricByKey(metricMap, dashboardParam.MetricPrefixCode+"_"+constConstTimeP99); costTimeP99Metric != nil {
+				p99ChartSeries := generateChartSeries(dashboardParam.ServiceGroup, dashboardParam.MonitorType, code, serviceGroupName, codeList, costTimeP99Metric)
+				p99ChartSeries.Tags = chartSeries.Tags
+				chartParam3.ChartSeries = append(chartParam3.ChartSeries, p99ChartSeries)
+			}
 			subChart3Actions = handleAutoCreateChart(chartParam3, newDashboardId, dashboardParam.ServiceGroupsRoles, dashboardParam.ServiceGroupsRoles[0], dashboardParam.Operator)
 			if len(subChart3Actions) > 0 {
 				actions = append(actions, subChart3Actions...)
 			}
 		}
+		// 3. 派生指标每个一张折线图,放在固定图表后面;和固定图表同名的派生指标已经画过
+		var derivedMetricList []*models.LogMetricTemplate
+		for _, derivedObj := range dashboardParam.DerivedMetricList {
+			if derivedObj.Metric == constReqCount || derivedObj.Metric == constReqFailCount || derivedObj.Metric == constReqSucCount || derivedObj.Metric == constConstTimeAvg || derivedObj.Metric == constConstTimeP99 {
+				continue
+			}
+			if derivedMetric := getMetricByKey(metricMap, dashboardParam.MetricPrefixCode+"_"+derivedObj.Metric); derivedMetric != nil {
+				derivedMetricList = append(derivedMetricList, derivedMetric)
+			}
+		}
+		for index, code := range codeList {
+			for derivedIndex, derivedMetric := range derivedMetricList {
+				derivedChartParam := &models.CustomChartDto{
+					Public:             true,
+					SourceDashboard:    int(newDashboardId),
+					Name:               fmt.Sprintf("%s-%s/%s", code, derivedMetric.Metric, displayServiceGroup),
+					ChartTemplate:      "one",
+					ChartType:          "line",
+					LineType:           "line",
+					Aggregate:          "none",
+					AggStep:            60,
+					ChartSeries:        []*models.CustomChartSeriesDto{},
+					DisplayConfig:      calcDisplayConfig(len(codeList)*3 + index*len(derivedMetricList) + derivedIndex),
+					GroupDisplayConfig: calcDisplayConfig(3 + derivedIndex),
+					Group:              code,
+					LogMetricGroup:     &dashboardParam.LogMetricGroupGuid,
+				}
+				derivedChartParam.ChartSeries = append(derivedChartParam.ChartSeries, generateChartSeries(dashboardParam.ServiceGroup, dashboardParam.MonitorType, code, serviceGroupName, codeList, derivedMetric))
+				if subDerivedChartActions := handleAutoCreateChart(derivedChartParam, newDashboardId, dashboardParam.ServiceGroupsRoles, dashboardParam.ServiceGroupsRoles[0], dashboardParam.Operator); len(subDerivedChartActions) > 0 {
+					actions = append(actions, subDerivedChartActions...)
+				}
+			}
+		}
 	}
 	return
 }
@@ -661,7 +706,7 @@ func getAlarmMetricUnit(metric string) string {
 	if metric == constReqSuccessRate || metric == constReqFailRate {
 		return "%"
 	}
-	if metric == constConstTimeAvg || metric == constConstTimeMax {
+	if metric == constConstTimeAvg || metric == constConstTimeMax || metric == constConstTimeP99 {
 		return "ms"
 	}
 	return ""
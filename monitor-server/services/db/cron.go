@@ -207,4 +207,13 @@ func cleanAlarmTableJob() {
 	}
 	rowAffected, _ := execResult.RowsAffected()
 	log.Logger.Info("Clean alarm table job done", log.String("last day", lastDayString), log.Int64("delete row num", rowAffected))
+	if _, err = x.Exec("delete from notify_delivery where status in ('success','fail','cancel') and create_time<=?", lastDayString+" 00:00:00"); err != nil {
+		log.Logger.Error("Clean notify delivery table fail", log.Error(err))
+	}
+	var incidentActions []*Action
+	incidentActions = append(incidentActions, &Action{Sql: "delete from alarm_incident_alarm where incident in (select id from alarm_incident where status='ok' and start<=?)", Param: []interface{}{lastDayString + " 00:00:00"}})
+	incidentActions = append(incidentActions, &Action{Sql: "delete from alarm_incident where status='ok' and start<=?", Param: []interface{}{lastDayString + " 00:00:00"}})
+	if err = Transaction(incidentActions); err != nil {
+		log.Logger.Error("Clean alarm incident table fail", log.Error(err))
+	}
 }
@@ -356,8 +356,8 @@ func UpdateCustomChart(chartDto models.CustomChartDto, user string, sourceDashbo
 	var seriesIdList []string
 	now := time.Now().Format(models.DatetimeFormat)
 	actions = append(actions, &Action{Sql: "update custom_chart set name =?,chart_type=?,line_type=?,pie_type=?,aggregate=?," +
-		"agg_step=?,unit=?,update_user=?,update_time=?,chart_template = ? where guid=?", Param: []interface{}{chartDto.Name, chartDto.ChartType,
-		chartDto.LineType, chartDto.PieType, chartDto.Aggregate, chartDto.AggStep, chartDto.Unit, user, now, chartDto.ChartTemplate, chartDto.Id}})
+		"agg_step=?,unit=?,update_user=?,update_time=?,chart_template = ?,datasource=? where guid=?", Param: []interface{}{chartDto.Name, chartDto.ChartType,
+		chartDto.LineType, chartDto.PieType, chartDto.Aggregate, chartDto.AggStep, chartDto.Unit, user, now, chartDto.ChartTemplate, chartDto.Datasource, chartDto.Id}})
 	// 更新源看板
 	if sourceDashboard != 0 {
 		actions = append(actions, &Action{Sql: "update custom_dashboard set update_user =?,update_at=? where id = ?", Param: []interface{}{user, now, sourceDashboard}})
@@ -434,11 +434,12 @@ func getAddCustomChartActions(param models.AddCustomChartParam, user string) (ac
 		UpdateUser:      user,
 		CreateTime:      now,
 		UpdateTime:      now,
+		Datasource:      param.Datasource,
 	}
 	displayConfig, _ = json.Marshal(param.DisplayConfig)
-	actions = append(actions, &Action{Sql: "insert into custom_chart(guid,source_dashboard,public,name,chart_type,line_type,aggregate,agg_step,unit,create_user,update_user,create_time,update_time,chart_template,pie_type) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+	actions = append(actions, &Action{Sql: "insert into custom_chart(guid,source_dashboard,public,name,chart_type,line_type,aggregate,agg_step,unit,create_user,update_user,create_time,update_time,chart_template,pie_type,datasource) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 		chart.Guid, chart.SourceDashboard, chart.Public, chart.Name, chart.ChartType, chart.LineType, chart.Aggregate,
-		chart.AggStep, chart.Unit, chart.CreateUser, chart.UpdateUser, chart.CreateTime, chart.UpdateTime, chart.ChartTemplate, chart.PieType}})
+		chart.AggStep, chart.Unit, chart.CreateUser, chart.UpdateUser, chart.CreateTime, chart.UpdateTime, chart.ChartTemplate, chart.PieType, chart.Datasource}})
 	actions = append(actions, &Action{Sql: "insert into custom_dashboard_chart_rel(guid,custom_dashboard,dashboard_chart, `group`,display_config,create_user,updated_user,create_time,update_time) values(?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 		guid.CreateGuid(), param.DashboardId, chart.Guid, param.Group, string(displayConfig), user, user, now, now}})
 	return
@@ -518,9 +519,9 @@ func CopyCustomChart(dashboardId int, user, group string, chart *models.CustomCh
 		return
 	}
 	chartName = getNewChartName(chart.Name)
-	actions = append(actions, &Action{Sql: "insert into custom_chart(guid,source_dashboard,public,name,chart_type,line_type,aggregate,agg_step,unit,create_user,update_user,create_time,update_time,chart_template,pie_type) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+	actions = append(actions, &Action{Sql: "insert into custom_chart(guid,source_dashboard,public,name,chart_type,line_type,aggregate,agg_step,unit,create_user,update_user,create_time,update_time,chart_template,pie_type,datasource) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 		newChartId, dashboardId, 0, chartName, chart.ChartType, chart.LineType, chart.Aggregate,
-		chart.AggStep, chart.Unit, user, user, now, now, chart.ChartTemplate, chart.PieType}})
+		chart.AggStep, chart.Unit, user, user, now, now, chart.ChartTemplate, chart.PieType, chart.Datasource}})
 	for _, series := range chartSeriesList {
 		seriesId := guid.CreateGuid()
 		actions = append(actions, &Action{Sql: "insert into custom_chart_series(guid,dashboard_chart,endpoint,service_group,endpoint_name,monitor_type,metric,color_group,pie_display_tag,endpoint_type,metric_type,metric_guid)values(?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
@@ -616,6 +617,7 @@ func CreateCustomChartDto(chartExtend *models.CustomChartExtend, configMap map[s
 		GroupDisplayConfig: chartExtend.GroupDisplayConfig,
 		Group:              chartExtend.Group,
 		LogMetricGroup:     &chartExtend.LogMetricGroup,
+		Datasource:         chartExtend.Datasource,
 	}
 	chart.ChartSeries = []*models.CustomChartSeriesDto{}
 	if list, err = QueryCustomChartSeriesByChart(chartExtend.Guid); err != nil {
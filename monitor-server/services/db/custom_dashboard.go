@@ -198,6 +198,7 @@ func GetDeleteCustomDashboardByIdActions(dashboard int) (actions []*Action) {
 	actions = append(actions, &Action{Sql: "delete from main_dashboard where custom_dashboard = ?", Param: []interface{}{dashboard}})
 	actions = append(actions, &Action{Sql: "delete from custom_dashboard_role_rel where custom_dashboard_id = ?", Param: []interface{}{dashboard}})
 	actions = append(actions, &Action{Sql: "delete from custom_dashboard_chart_rel where custom_dashboard = ?", Param: []interface{}{dashboard}})
+	actions = append(actions, &Action{Sql: "delete from custom_dashboard_variable where custom_dashboard = ?", Param: []interface{}{dashboard}})
 	// 删除以该看板为源看板,并且还没有公开的图表
 	actions = append(actions, &Action{Sql: "delete from custom_chart_series_config  where dashboard_chart_config  in(select guid from custom_chart_series  where dashboard_chart  in(select guid from custom_chart where source_dashboard =? and public = 0))", Param: []interface{}{dashboard}})
 	actions = append(actions, &Action{Sql: "delete from custom_chart_series_tagvalue where dashboard_chart_tag in(select guid from custom_chart_series_tag  where dashboard_chart_config  in(select guid from custom_chart_series  where dashboard_chart  in(select guid from custom_chart where source_dashboard =? and public = 0)))", Param: []interface{}{dashboard}})
@@ -397,6 +398,7 @@ func CopyCustomDashboard(param models.CopyCustomDashboardParam, customDashboard
 	var newDashboardId int64
 	var actions, subDashboardPermActions, subDashboardChartActions []*Action
 	var customChartExtendList []*models.CustomChartExtend
+	var variableList []*models.CustomDashboardVariable
 	var exportDto = &models.CustomDashboardExportDto{Charts: make([]*models.CustomChartDto, 0)}
 	var chart *models.CustomChartDto
 	var configMap = make(map[string][]*models.CustomChartSeriesConfig)
@@ -431,6 +433,11 @@ func CopyCustomDashboard(param models.CopyCustomDashboardParam, customDashboard
 	if len(subDashboardPermActions) > 0 {
 		actions = append(actions, subDashboardPermActions...)
 	}
+	// 复制看板变量,复制后改默认值就能用于其它环境
+	if variableList, err = ListCustomDashboardVariable(customDashboard.Id); err != nil {
+		return
+	}
+	actions = append(actions, getInsertDashboardVariableActions(int(newDashboardId), variableList, operator, now.Format(models.DatetimeFormat))...)
 	if customChartExtendList, err = QueryCustomChartListByDashboard(customDashboard.Id); err != nil {
 		return
 	}
@@ -485,6 +492,11 @@ func ImportCustomDashboard(param *models.CustomDashboardExportDto, operator, rul
 			actions = append(actions, &Action{Sql: "delete from custom_chart where source_dashboard = ? and public = 0", Param: []interface{}{historyDashboard.Id}})
 			// 更新看板操作人和时间
 			actions = append(actions, &Action{Sql: "update custom_dashboard set update_at=?,update_user=? where id=?", Param: []interface{}{now, operator, historyDashboard.Id}})
+			// 导入数据带了变量时覆盖原来的变量
+			if len(param.Variables) > 0 {
+				actions = append(actions, &Action{Sql: "delete from custom_dashboard_variable where custom_dashboard=?", Param: []interface{}{historyDashboard.Id}})
+				actions = append(actions, getInsertDashboardVariableActions(historyDashboard.Id, param.Variables, operator, now)...)
+			}
 			if subDashboardChartActions, importRes, err = handleDashboardChart(param, int64(historyDashboard.Id), operator, now, mgmtRole, useRoles); err != nil {
 				return
 			}
@@ -515,6 +527,7 @@ func ImportCustomDashboard(param *models.CustomDashboardExportDto, operator, rul
 	if len(subDashboardPermActions) > 0 {
 		actions = append(actions, subDashboardPermActions...)
 	}
+	actions = append(actions, getInsertDashboardVariableActions(int(newDashboardId), param.Variables, operator, now)...)
 	if subDashboardChartActions, importRes, err = handleDashboardChart(param, newDashboardId, operator, now, mgmtRole, useRoles); err != nil {
 		return
 	}
@@ -553,9 +566,9 @@ func handleDashboardChart(param *models.CustomDashboardExportDto, newDashboardId
 			logMetricGroup = *chart.LogMetricGroup
 		}
 		actions = append(actions, &Action{Sql: "insert into custom_chart(guid,source_dashboard,public,name,chart_type,line_type,aggregate,agg_step,unit," +
-			"create_user,update_user,create_time,update_time,chart_template,pie_type,log_metric_group) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			"create_user,update_user,create_time,update_time,chart_template,pie_type,log_metric_group,datasource) values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 			newChartId, newDashboardId, chart.Public, chart.Name, chart.ChartType, chart.LineType, chart.Aggregate,
-			chart.AggStep, chart.Unit, operator, operator, now, now, chart.ChartTemplate, chart.PieType, logMetricGroup}})
+			chart.AggStep, chart.Unit, operator, operator, now, now, chart.ChartTemplate, chart.PieType, logMetricGroup, chart.Datasource}})
 		// 新增看板图表关系表
 		actions = append(actions, &Action{Sql: "insert into custom_dashboard_chart_rel(guid,custom_dashboard,dashboard_chart,`group`,display_config,create_user,updated_user,create_time,update_time,group_display_config) values(?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 			guid.CreateGuid(), newDashboardId, newChartId, chart.Group, chart.DisplayConfig, operator, operator, now, now, chart.GroupDisplayConfig}})
@@ -601,14 +614,15 @@ func handleDashboardChart(param *models.CustomDashboardExportDto, newDashboardId
 					} else {
 						exist = false
 						for _, metricTable := range metricList {
-							if metricTable.ServiceGroup == series.ServiceGroup {
+							if metricTable.ServiceGroup == series.ServiceGroup || dashboardVariableRefRegexp.MatchString(series.ServiceGroup) {
 								exist = true
 								break
 							}
 						}
 					}
 				}
-				if strings.TrimSpace(series.Endpoint) != "" && series.ServiceGroup == "" {
+				// 引用看板变量的对象查询时才展开,不用检查
+				if strings.TrimSpace(series.Endpoint) != "" && series.ServiceGroup == "" && !dashboardVariableRefRegexp.MatchString(series.Endpoint) {
 					// 监控对象不存在,记录下来
 					var endpointObj models.EndpointTable
 					if _, err = x.SQL("SELECT * FROM endpoint_new WHERE guid=?", series.Endpoint).Get(&endpointObj); err != nil {
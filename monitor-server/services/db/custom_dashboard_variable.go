@@ -0,0 +1,400 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+var (
+	dashboardVariableNameRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	dashboardVariableRefRegexp  = regexp.MustCompile(`^\$\{?(\w+)\}?$`)
+	dashboardVariableTypeMap    = map[string]bool{models.DashboardVariableServiceGroup: true, models.DashboardVariableEndpoint: true,
+		models.DashboardVariableEndpointGroup: true, models.DashboardVariableTagValue: true, models.DashboardVariableInterval: true}
+)
+
+// dashboardVariableValue 变量当前的取值,页面没有传的用保存的默认值
+type dashboardVariableValue struct {
+	varType string
+	value   string
+}
+
+// dashboardVariableObject 图表配置里对象或层级对象引用变量后解析出来的对象
+type dashboardVariableObject struct {
+	endpoint     string
+	serviceGroup string
+}
+
+func ListCustomDashboardVariable(dashboardId int) (result []*models.CustomDashboardVariable, err error) {
+	result = []*models.CustomDashboardVariable{}
+	if err = x.SQL("select * from custom_dashboard_variable where custom_dashboard=? order by sort_index,name", dashboardId).Find(&result); err != nil {
+		err = fmt.Errorf("query custom dashboard variable fail,%s ", err.Error())
+	}
+	return
+}
+
+func GetCustomDashboardVariable(dashboardId int, name string) (result *models.CustomDashboardVariable, err error) {
+	var rows []*models.CustomDashboardVariable
+	if err = x.SQL("select * from custom_dashboard_variable where custom_dashboard=? and name=?", dashboardId, name).Find(&rows); err != nil {
+		err = fmt.Errorf("query custom dashboard variable fail,%s ", err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		err = fmt.Errorf("can not find dashboard variable:%s ", name)
+		return
+	}
+	result = rows[0]
+	return
+}
+
+func validateDashboardVariable(variable *models.CustomDashboardVariable) error {
+	if !dashboardVariableNameRegexp.MatchString(variable.Name) {
+		return fmt.Errorf("variable name:%s illegal,only letters, numbers and underscores are allowed ", variable.Name)
+	}
+	lowerName := strings.ToLower(variable.Name)
+	if stringInList(lowerName, models.DashboardVariableReservedNames) || strings.HasPrefix(lowerName, "t_") || strings.HasPrefix(lowerName, "custom") || strings.HasPrefix(lowerName, "k8s") {
+		return fmt.Errorf("variable name:%s is reserved ", variable.Name)
+	}
+	if !dashboardVariableTypeMap[variable.Type] {
+		return fmt.Errorf("variable type:%s illegal ", variable.Type)
+	}
+	if variable.Type == models.DashboardVariableTagValue && (variable.MetricGuid == "" || variable.TagName == "") {
+		return fmt.Errorf("tag value variable:%s need metric and tag name ", variable.Name)
+	}
+	if variable.Type == models.DashboardVariableInterval && strings.TrimSpace(variable.Options) == "" {
+		variable.Options = models.DashboardVariableIntervalList
+	}
+	return nil
+}
+
+// SaveCustomDashboardVariable 整体替换看板的变量配置和默认值
+func SaveCustomDashboardVariable(param *models.CustomDashboardVariableParam, operator string) (err error) {
+	nameMap := make(map[string]bool)
+	for _, variable := range param.Variables {
+		if err = validateDashboardVariable(variable); err != nil {
+			return
+		}
+		if nameMap[variable.Name] {
+			return fmt.Errorf("variable name:%s duplicate ", variable.Name)
+		}
+		nameMap[variable.Name] = true
+	}
+	var actions []*Action
+	actions = append(actions, &Action{Sql: "delete from custom_dashboard_variable where custom_dashboard=?", Param: []interface{}{param.CustomDashboard}})
+	actions = append(actions, getInsertDashboardVariableActions(param.CustomDashboard, param.Variables, operator, time.Now().Format(models.DatetimeFormat))...)
+	actions = append(actions, UpdateCustomDashboardTimeActions(param.CustomDashboard, operator)...)
+	if err = Transaction(actions); err != nil {
+		err = fmt.Errorf("save custom dashboard variable fail,%s ", err.Error())
+	}
+	return
+}
+
+func getInsertDashboardVariableActions(dashboardId int, variableList []*models.CustomDashboardVariable, operator, now string) (actions []*Action) {
+	for i, variable := range variableList {
+		if variable.SortIndex == 0 {
+			variable.SortIndex = i + 1
+		}
+		actions = append(actions, &Action{Sql: "insert into custom_dashboard_variable(guid,custom_dashboard,name,display_name,type,monitor_type,metric_guid,tag_name,endpoint,options,default_value,sort_index,create_user,update_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+			Param: []interface{}{"dv_" + guid.CreateGuid(), dashboardId, variable.Name, variable.DisplayName, variable.Type, variable.MonitorType, variable.MetricGuid, variable.TagName,
+				variable.Endpoint, variable.Options, variable.DefaultValue, variable.SortIndex, operator, operator, now, now}})
+	}
+	return
+}
+
+func getDashboardVariableValueMap(dashboardId int, inputMap map[string]string) (valueMap map[string]*dashboardVariableValue, err error) {
+	var variableList []*models.CustomDashboardVariable
+	if variableList, err = ListCustomDashboardVariable(dashboardId); err != nil {
+		return
+	}
+	valueMap = make(map[string]*dashboardVariableValue)
+	for _, variable := range variableList {
+		value := variable.DefaultValue
+		if inputValue, b := inputMap[variable.Name]; b {
+			value = inputValue
+		}
+		// 间隔变量没有选时用第一个可选值
+		if value = strings.TrimSpace(value); value == "" && variable.Type == models.DashboardVariableInterval {
+			if optionList := splitDashboardVariableValue(variable.Options + "," + models.DashboardVariableIntervalList); len(optionList) > 0 {
+				value = optionList[0]
+			}
+		}
+		valueMap[variable.Name] = &dashboardVariableValue{varType: variable.Type, value: value}
+	}
+	return
+}
+
+// ResolveChartQueryVariables 图表配置里引用了看板变量的对象、层级对象和标签值换成变量当前的值,
+// 对象和对象组变量会展开成多个对象,返回的变量值用来替换表达式里的其它引用
+func ResolveChartQueryVariables(param *models.ChartQueryParam) (variableValueMap map[string]string, err error) {
+	if param.CustomDashboard <= 0 {
+		return
+	}
+	var valueMap map[string]*dashboardVariableValue
+	if valueMap, err = getDashboardVariableValueMap(param.CustomDashboard, param.Variables); err != nil || len(valueMap) == 0 {
+		return
+	}
+	newDataList := []*models.ChartQueryConfigObj{}
+	for _, dataConfig := range param.Data {
+		dataConfig.PromQl = replaceDashboardVariableText(dataConfig.PromQl, valueMap)
+		dataConfig.Tags = resolveDashboardVariableTags(dataConfig.Tags, valueMap)
+		objectList, referenced, resolveErr := resolveDashboardVariableObject(dataConfig.Endpoint, dataConfig.AppObject, valueMap)
+		if resolveErr != nil {
+			err = resolveErr
+			return
+		}
+		if !referenced {
+			newDataList = append(newDataList, dataConfig)
+			continue
+		}
+		for _, object := range objectList {
+			tmpDataConfig := *dataConfig
+			tmpDataConfig.Endpoint, tmpDataConfig.AppObject = object.endpoint, object.serviceGroup
+			newDataList = append(newDataList, &tmpDataConfig)
+		}
+	}
+	param.Data = newDataList
+	variableValueMap = buildDashboardVariableTextMap(valueMap)
+	return
+}
+
+// ResolveChartSeriesVariables 和ResolveChartQueryVariables一样,用于按图表guid查询时从库里读出来的图表配置
+func ResolveChartSeriesVariables(dashboardId int, inputMap map[string]string, seriesList []*models.CustomChartSeriesDto) (result []*models.CustomChartSeriesDto, variableValueMap map[string]string, err error) {
+	result = seriesList
+	if dashboardId <= 0 {
+		return
+	}
+	var valueMap map[string]*dashboardVariableValue
+	if valueMap, err = getDashboardVariableValueMap(dashboardId, inputMap); err != nil || len(valueMap) == 0 {
+		return
+	}
+	result = []*models.CustomChartSeriesDto{}
+	for _, series := range seriesList {
+		series.Tags = resolveDashboardVariableTags(series.Tags, valueMap)
+		objectList, referenced, resolveErr := resolveDashboardVariableObject(series.Endpoint, series.ServiceGroup, valueMap)
+		if resolveErr != nil {
+			err = resolveErr
+			return
+		}
+		if !referenced {
+			result = append(result, series)
+			continue
+		}
+		for _, object := range objectList {
+			tmpSeries := *series
+			tmpSeries.Endpoint, tmpSeries.ServiceGroup = object.endpoint, object.serviceGroup
+			result = append(result, &tmpSeries)
+		}
+	}
+	variableValueMap = buildDashboardVariableTextMap(valueMap)
+	return
+}
+
+// ReplaceDashboardVariable 表达式里的 $name ${name} 换成变量的值,多选的值按正则或拼接
+func ReplaceDashboardVariable(input string, variableValueMap map[string]string) string {
+	if len(variableValueMap) == 0 || !strings.Contains(input, "$") {
+		return input
+	}
+	return grafanaVariableRegexp.ReplaceAllStringFunc(input, func(ref string) string {
+		subMatch := grafanaVariableRegexp.FindStringSubmatch(ref)
+		if value, b := variableValueMap[subMatch[1]+subMatch[2]+subMatch[3]]; b {
+			return value
+		}
+		return ref
+	})
+}
+
+func buildDashboardVariableTextMap(valueMap map[string]*dashboardVariableValue) map[string]string {
+	result := make(map[string]string)
+	for name, v := range valueMap {
+		if v.value == "" {
+			result[name] = ".*"
+		} else {
+			result[name] = strings.Join(splitDashboardVariableValue(v.value), "|")
+		}
+	}
+	return result
+}
+
+func replaceDashboardVariableText(input string, valueMap map[string]*dashboardVariableValue) string {
+	return ReplaceDashboardVariable(input, buildDashboardVariableTextMap(valueMap))
+}
+
+func getDashboardVariableRef(input string, valueMap map[string]*dashboardVariableValue) *dashboardVariableValue {
+	subMatch := dashboardVariableRefRegexp.FindStringSubmatch(strings.TrimSpace(input))
+	if len(subMatch) < 2 {
+		return nil
+	}
+	return valueMap[subMatch[1]]
+}
+
+// resolveDashboardVariableObject 层级对象变量没选时没有对象,对象和对象组变量展开成对象列表
+func resolveDashboardVariableObject(endpoint, serviceGroup string, valueMap map[string]*dashboardVariableValue) (objectList []*dashboardVariableObject, referenced bool, err error) {
+	objectList = []*dashboardVariableObject{}
+	endpointRef, serviceGroupRef := getDashboardVariableRef(endpoint, valueMap), getDashboardVariableRef(serviceGroup, valueMap)
+	if serviceGroupRef != nil {
+		referenced = true
+		// 层级对象变量多选时每个层级对象一个查询
+		for _, serviceGroupGuid := range splitDashboardVariableValue(serviceGroupRef.value) {
+			object := &dashboardVariableObject{endpoint: endpoint, serviceGroup: serviceGroupGuid}
+			if endpointRef == serviceGroupRef || endpoint == "" {
+				object.endpoint = serviceGroupGuid
+			} else if endpointRef != nil {
+				object.endpoint = serviceGroupGuid
+				if valueList := splitDashboardVariableValue(endpointRef.value); len(valueList) > 0 {
+					object.endpoint = valueList[0]
+				}
+			}
+			objectList = append(objectList, object)
+		}
+		return
+	}
+	if endpointRef == nil {
+		return
+	}
+	referenced = true
+	switch endpointRef.varType {
+	case models.DashboardVariableServiceGroup:
+		for _, serviceGroupGuid := range splitDashboardVariableValue(endpointRef.value) {
+			objectList = append(objectList, &dashboardVariableObject{endpoint: serviceGroupGuid, serviceGroup: serviceGroupGuid})
+		}
+	case models.DashboardVariableEndpointGroup:
+		for _, endpointGroup := range splitDashboardVariableValue(endpointRef.value) {
+			endpointList, getErr := GetEndpointGroupMemberList(endpointGroup)
+			if getErr != nil {
+				err = fmt.Errorf("get endpoint group:%s member fail,%s ", endpointGroup, getErr.Error())
+				return
+			}
+			for _, endpointObj := range endpointList {
+				objectList = append(objectList, &dashboardVariableObject{endpoint: endpointObj.Guid})
+			}
+		}
+	default:
+		for _, endpointGuid := range splitDashboardVariableValue(endpointRef.value) {
+			objectList = append(objectList, &dashboardVariableObject{endpoint: endpointGuid})
+		}
+	}
+	return
+}
+
+// resolveDashboardVariableTags 标签值引用变量时换成变量选中的值,变量没选时去掉,标签没有值就是不过滤
+func resolveDashboardVariableTags(tagList []*models.TagDto, valueMap map[string]*dashboardVariableValue) (result []*models.TagDto) {
+	for _, tag := range tagList {
+		newTag := &models.TagDto{TagName: tag.TagName, Equal: tag.Equal, TagValue: []string{}}
+		for _, tagValue := range tag.TagValue {
+			if ref := getDashboardVariableRef(tagValue, valueMap); ref != nil {
+				newTag.TagValue = append(newTag.TagValue, splitDashboardVariableValue(ref.value)...)
+				continue
+			}
+			newTag.TagValue = append(newTag.TagValue, tagValue)
+		}
+		result = append(result, newTag)
+	}
+	return
+}
+
+func splitDashboardVariableValue(value string) (result []string) {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return
+}
+
+// ResolveDashboardVariableEndpoint 标签值变量查询标签时用的对象,可以直接写对象或层级对象,也可以引用其它变量
+func ResolveDashboardVariableEndpoint(variable *models.CustomDashboardVariable, inputMap map[string]string) (endpoint, serviceGroup string, err error) {
+	if variable.Endpoint == "" {
+		return
+	}
+	var valueMap map[string]*dashboardVariableValue
+	if valueMap, err = getDashboardVariableValueMap(variable.CustomDashboard, inputMap); err != nil {
+		return
+	}
+	if ref := getDashboardVariableRef(variable.Endpoint, valueMap); ref != nil {
+		if valueList := splitDashboardVariableValue(ref.value); len(valueList) > 0 {
+			if ref.varType == models.DashboardVariableServiceGroup {
+				serviceGroup = valueList[0]
+			} else if ref.varType == models.DashboardVariableEndpointGroup {
+				if endpointList, _ := GetEndpointGroupMemberList(valueList[0]); len(endpointList) > 0 {
+					endpoint = endpointList[0].Guid
+				}
+			} else {
+				endpoint = valueList[0]
+			}
+		}
+		return
+	}
+	if endpointObj, _ := GetEndpointNew(&models.EndpointNewTable{Guid: variable.Endpoint}); endpointObj.Guid != "" {
+		endpoint = endpointObj.Guid
+	} else {
+		serviceGroup = variable.Endpoint
+	}
+	return
+}
+
+// QueryDashboardVariableOptions 层级对象、对象、对象组和间隔变量的可选值,标签值变量的可选值按指标标签查
+func QueryDashboardVariableOptions(variable *models.CustomDashboardVariable, inputMap map[string]string) (result []*models.MetricTagValueObj, err error) {
+	result = []*models.MetricTagValueObj{}
+	switch variable.Type {
+	case models.DashboardVariableServiceGroup:
+		var serviceGroupList []*models.ServiceGroupTable
+		if serviceGroupList, err = ListServiceGroup(); err != nil {
+			err = fmt.Errorf("query service group fail,%s ", err.Error())
+			return
+		}
+		for _, row := range serviceGroupList {
+			result = append(result, &models.MetricTagValueObj{Key: row.Guid, Value: row.DisplayName})
+		}
+	case models.DashboardVariableEndpoint:
+		var endpointList []*models.EndpointNewTable
+		endpoint, serviceGroup, resolveErr := ResolveDashboardVariableEndpoint(variable, inputMap)
+		if resolveErr != nil {
+			err = resolveErr
+			return
+		}
+		// 对象变量引用了层级对象变量时只列出层级对象下的对象
+		if serviceGroup != "" {
+			endpointList, err = GetRecursiveEndpointByTypeNew(serviceGroup, variable.MonitorType)
+		} else if endpoint != "" {
+			err = x.SQL("select * from endpoint_new where guid=?", endpoint).Find(&endpointList)
+		} else if variable.MonitorType != "" {
+			err = x.SQL("select * from endpoint_new where monitor_type=? order by guid", variable.MonitorType).Find(&endpointList)
+		} else {
+			err = x.SQL("select * from endpoint_new order by guid").Find(&endpointList)
+		}
+		if err != nil {
+			err = fmt.Errorf("query endpoint fail,%s ", err.Error())
+			return
+		}
+		for _, row := range endpointList {
+			result = append(result, &models.MetricTagValueObj{Key: row.Guid, Value: row.Guid})
+		}
+	case models.DashboardVariableEndpointGroup:
+		var endpointGroupList []*models.EndpointGroupTable
+		if variable.MonitorType != "" {
+			err = x.SQL("select * from endpoint_group where monitor_type=? order by guid", variable.MonitorType).Find(&endpointGroupList)
+		} else {
+			err = x.SQL("select * from endpoint_group order by guid").Find(&endpointGroupList)
+		}
+		if err != nil {
+			err = fmt.Errorf("query endpoint group fail,%s ", err.Error())
+			return
+		}
+		for _, row := range endpointGroupList {
+			result = append(result, &models.MetricTagValueObj{Key: row.Guid, Value: row.DisplayName})
+		}
+	case models.DashboardVariableInterval:
+		options := variable.Options
+		if strings.TrimSpace(options) == "" {
+			options = models.DashboardVariableIntervalList
+		}
+		for _, v := range splitDashboardVariableValue(options) {
+			result = append(result, &models.MetricTagValueObj{Key: v, Value: v})
+		}
+	}
+	return
+}
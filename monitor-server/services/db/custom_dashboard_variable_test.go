@@ -0,0 +1,40 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func TestResolveDashboardVariableServiceGroup(t *testing.T) {
+	valueMap := map[string]*dashboardVariableValue{
+		"sg":   {varType: models.DashboardVariableServiceGroup, value: "sg_a, sg_b"},
+		"host": {varType: models.DashboardVariableEndpoint, value: "host_a,host_b"},
+		"none": {varType: models.DashboardVariableServiceGroup},
+	}
+	testCases := []struct {
+		endpoint     string
+		serviceGroup string
+		want         []dashboardVariableObject
+	}{
+		// 层级对象变量多选时每个层级对象一个对象
+		{"$sg", "$sg", []dashboardVariableObject{{endpoint: "sg_a", serviceGroup: "sg_a"}, {endpoint: "sg_b", serviceGroup: "sg_b"}}},
+		{"$host", "$sg", []dashboardVariableObject{{endpoint: "host_a", serviceGroup: "sg_a"}, {endpoint: "host_a", serviceGroup: "sg_b"}}},
+		{"$sg", "", []dashboardVariableObject{{endpoint: "sg_a", serviceGroup: "sg_a"}, {endpoint: "sg_b", serviceGroup: "sg_b"}}},
+		{"$none", "$none", []dashboardVariableObject{}},
+	}
+	for _, testCase := range testCases {
+		objectList, referenced, err := resolveDashboardVariableObject(testCase.endpoint, testCase.serviceGroup, valueMap)
+		if err != nil || !referenced {
+			t.Fatalf("resolve %s/%s fail,referenced:%v err:%v", testCase.endpoint, testCase.serviceGroup, referenced, err)
+		}
+		if len(objectList) != len(testCase.want) {
+			t.Fatalf("resolve %s/%s: want %d objects, got %d", testCase.endpoint, testCase.serviceGroup, len(testCase.want), len(objectList))
+		}
+		for i, object := range objectList {
+			if *object != testCase.want[i] {
+				t.Errorf("resolve %s/%s object %d: want %+v, got %+v", testCase.endpoint, testCase.serviceGroup, i, testCase.want[i], *object)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/WeBankPartners/go-common-lib/guid"
 	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"strings"
 	"time"
 )
 
@@ -107,6 +108,9 @@ func UpdateGroupEndpointNotify(endpointGroupGuid string, param []*models.NotifyO
 			return fmt.Errorf("procCallbackName can not empty with key:%s ", v.ProcCallbackKey)
 		}
 	}
+	if err := ValidateNotifyChannelList(param); err != nil {
+		return err
+	}
 	//actions := getNotifyListDeleteAction("", endpointGroupGuid, "")
 	//actions = append(actions, getNotifyListInsertAction(param)...)
 	actions := getNotifyListUpdateAction(param)
@@ -143,7 +147,28 @@ func GetSimpleEndpointGroup(guid string) (result *models.EndpointGroupTable, err
 	return
 }
 
+// GetEndpointGroupMemberList 对象组的成员对象,关联了层级对象的取层级对象及其子层级下同类型的对象
+func GetEndpointGroupMemberList(guid string) (endpointList []*models.EndpointNewTable, err error) {
+	endpointGroupObj, getErr := GetSimpleEndpointGroup(guid)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	return getEndpointGroupMemberList(endpointGroupObj)
+}
+
+func getEndpointGroupMemberList(endpointGroupObj *models.EndpointGroupTable) (endpointList []*models.EndpointNewTable, err error) {
+	if endpointGroupObj.ServiceGroup == "" {
+		err = x.SQL("select * from endpoint_new where monitor_type=? and guid in (select endpoint from endpoint_group_rel where endpoint_group=?)", endpointGroupObj.MonitorType, endpointGroupObj.Guid).Find(&endpointList)
+	} else {
+		serviceGroupGuidList, _ := fetchGlobalServiceGroupChildGuidList(endpointGroupObj.ServiceGroup)
+		err = x.SQL("select * from endpoint_new where monitor_type=? and guid in (select endpoint from endpoint_service_rel where service_group in ('"+strings.Join(serviceGroupGuidList, "','")+"'))", endpointGroupObj.MonitorType).Find(&endpointList)
+	}
+	return
+}
+
 func getDeleteEndpointGroupAction(endpointGroupGuid string) (actions []*Action) {
+	actions = append(actions, getAlarmEscalationDeleteActions("endpoint_group", endpointGroupGuid)...)
 	actions = append(actions, &Action{Sql: "delete from notify_role_rel where notify in (select guid from notify where endpoint_group=? or alarm_strategy in (select guid from alarm_strategy where endpoint_group=?))", Param: []interface{}{endpointGroupGuid, endpointGroupGuid}})
 	actions = append(actions, &Action{Sql: "delete from notify where endpoint_group=? or alarm_strategy in (select guid from alarm_strategy where endpoint_group=?)", Param: []interface{}{endpointGroupGuid, endpointGroupGuid}})
 	actions = append(actions, &Action{Sql: "delete from alarm_strategy_tag_value where alarm_strategy_tag in (select guid from  alarm_strategy_tag where alarm_strategy_metric in (select guid from alarm_strategy_metric where alarm_strategy in (select guid from alarm_strategy where endpoint_group=?)))", Param: []interface{}{endpointGroupGuid}})
@@ -502,6 +502,7 @@ func GetAlarmEventEntityData(alarmId int) (result *m.AlarmEventEntityObj, err er
 			alarmDetailList = append(alarmDetailList, &m.AlarmDetailData{Metric: alarmObj.SMetric, Cond: alarmObj.SCond, Last: alarmObj.SLast, Start: alarmObj.Start, StartValue: alarmObj.StartValue, End: alarmObj.End, EndValue: alarmObj.EndValue, Tags: alarmObj.Tags})
 		}
 		result.Detail = buildAlarmDetailData(alarmDetailList, "\n")
+		result.Detail = appendLogKeywordAlarmContext(alarmId, alarmObj.SMetric, result.Detail, "\n")
 	}
 	return
 }
@@ -0,0 +1,290 @@
+package db
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"time"
+)
+
+const alarmEscalationInterval = 30
+
+func ListAlarmEscalation(alarmStrategy, endpointGroup string) (result []*models.AlarmEscalationObj, err error) {
+	result = []*models.AlarmEscalationObj{}
+	var escalationRows []*models.AlarmEscalationTable
+	if alarmStrategy != "" {
+		err = x.SQL("select * from alarm_escalation where alarm_strategy=? order by name", alarmStrategy).Find(&escalationRows)
+	} else if endpointGroup != "" {
+		err = x.SQL("select * from alarm_escalation where endpoint_group=? order by name", endpointGroup).Find(&escalationRows)
+	} else {
+		err = x.SQL("select * from alarm_escalation order by name").Find(&escalationRows)
+	}
+	if err != nil {
+		err = fmt.Errorf("query alarm escalation table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range escalationRows {
+		tmpObj := models.AlarmEscalationObj{Guid: row.Guid, Name: row.Name, AlarmStrategy: row.AlarmStrategy, EndpointGroup: row.EndpointGroup, Enable: row.Enable,
+			Description: row.Description, UpdateUser: row.UpdateUser, UpdateTime: row.UpdateTime.Format(models.DatetimeFormat)}
+		if tmpObj.Steps, err = getAlarmEscalationSteps(row.Guid); err != nil {
+			return
+		}
+		result = append(result, &tmpObj)
+	}
+	return
+}
+
+func getAlarmEscalationSteps(escalationGuid string) (result []*models.AlarmEscalationStepObj, err error) {
+	result = []*models.AlarmEscalationStepObj{}
+	var stepRows []*models.AlarmEscalationStepTable
+	if err = x.SQL("select * from alarm_escalation_step where escalation=? order by step_index", escalationGuid).Find(&stepRows); err != nil {
+		err = fmt.Errorf("query alarm escalation step table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range stepRows {
+		tmpStep := models.AlarmEscalationStepObj{Guid: row.Guid, Delay: row.Delay}
+		if notifyRow, queryErr := getSimpleNotify(row.Notify); queryErr != nil {
+			log.Logger.Warn("get escalation step notify fail", log.String("step", row.Guid), log.Error(queryErr))
+			tmpStep.Notify = &models.NotifyObj{AlarmAction: "firing", NotifyRoles: []string{}, Channels: []*models.NotifyChannelObj{}}
+		} else {
+			tmpStep.Notify = &models.NotifyObj{Guid: notifyRow.Guid, AlarmAction: notifyRow.AlarmAction, NotifyNum: notifyRow.NotifyNum, ProcCallbackName: notifyRow.ProcCallbackName, ProcCallbackKey: notifyRow.ProcCallbackKey,
+				CallbackUrl: notifyRow.CallbackUrl, CallbackParam: notifyRow.CallbackParam, ProcCallbackMode: notifyRow.ProcCallbackMode, Description: notifyRow.Description,
+				NotifyRoles: getNotifyRoles(notifyRow.Guid), Channels: getNotifyChannels(notifyRow.Guid)}
+		}
+		result = append(result, &tmpStep)
+	}
+	return
+}
+
+func ValidateAlarmEscalation(param *models.AlarmEscalationObj) error {
+	if (param.AlarmStrategy == "" && param.EndpointGroup == "") || (param.AlarmStrategy != "" && param.EndpointGroup != "") {
+		return fmt.Errorf("escalation must bind one of alarm_strategy or endpoint_group")
+	}
+	if len(param.Steps) == 0 {
+		return fmt.Errorf("escalation steps can not empty")
+	}
+	for i, step := range param.Steps {
+		if step.Delay < 0 {
+			return fmt.Errorf("escalation step:%d delay illegal", i+1)
+		}
+		if step.Notify == nil || (len(step.Notify.NotifyRoles) == 0 && step.Notify.ProcCallbackKey == "" && len(step.Notify.Channels) == 0) {
+			return fmt.Errorf("escalation step:%d need notify roles,workflow or channels", i+1)
+		}
+		for _, channel := range step.Notify.Channels {
+			if err := ValidateNotifyChannel(channel); err != nil {
+				return err
+			}
+		}
+	}
+	// 同一个阈值配置或对象组只能有一条启用的升级策略,避免告警同时走多条升级链
+	if param.Enable == 1 {
+		var existRows []*models.AlarmEscalationTable
+		x.SQL("select guid from alarm_escalation where enable=1 and guid<>? and alarm_strategy=? and endpoint_group=?", param.Guid, param.AlarmStrategy, param.EndpointGroup).Find(&existRows)
+		if len(existRows) > 0 {
+			return fmt.Errorf("there is already an enabled escalation:%s with same target", existRows[0].Guid)
+		}
+	}
+	return nil
+}
+
+func CreateAlarmEscalation(param *models.AlarmEscalationObj, operator string) error {
+	param.Guid = "esc_" + guid.CreateGuid()
+	var actions []*Action
+	actions = append(actions, &Action{Sql: "insert into alarm_escalation(guid,name,alarm_strategy,endpoint_group,enable,description,update_user,update_time) value (?,?,?,?,?,?,?,?)", Param: []interface{}{
+		param.Guid, param.Name, param.AlarmStrategy, param.EndpointGroup, param.Enable, param.Description, operator, time.Now()}})
+	actions = append(actions, getAlarmEscalationStepInsertActions(param.Guid, param.Steps)...)
+	return Transaction(actions)
+}
+
+func UpdateAlarmEscalation(param *models.AlarmEscalationObj, operator string) error {
+	var actions []*Action
+	actions = append(actions, &Action{Sql: "update alarm_escalation set name=?,alarm_strategy=?,endpoint_group=?,enable=?,description=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
+		param.Name, param.AlarmStrategy, param.EndpointGroup, param.Enable, param.Description, operator, time.Now(), param.Guid}})
+	actions = append(actions, getAlarmEscalationStepDeleteActions(param.Guid)...)
+	actions = append(actions, getAlarmEscalationStepInsertActions(param.Guid, param.Steps)...)
+	// 步骤变化后正在执行的升级从头计算会重复通知,直接结束掉,新告警按新配置走
+	actions = append(actions, &Action{Sql: "update alarm_escalation_state set status=?,update_time=? where escalation=? and status=?", Param: []interface{}{models.EscalationStateCancel, time.Now(), param.Guid, models.EscalationStateRunning}})
+	return Transaction(actions)
+}
+
+func DeleteAlarmEscalation(escalationGuid string) error {
+	return Transaction(getAlarmEscalationDeleteActions("guid", escalationGuid))
+}
+
+func getAlarmEscalationStepInsertActions(escalationGuid string, steps []*models.AlarmEscalationStepObj) (actions []*Action) {
+	// 步骤是删掉重建的,渠道密钥是掩码时只从这个升级配置原来步骤的渠道里取
+	var sourceNotifyList []string
+	if err := x.SQL("select notify from alarm_escalation_step where escalation=?", escalationGuid).Find(&sourceNotifyList); err != nil {
+		log.Logger.Error("query alarm escalation step fail", log.String("escalation", escalationGuid), log.Error(err))
+	}
+	stepGuidList := guid.CreateGuidList(len(steps))
+	for i, step := range steps {
+		// 步骤的通知配置不挂在阈值或对象组上,不会被普通告警通知查到
+		step.Notify.AlarmStrategy, step.Notify.EndpointGroup, step.Notify.ServiceGroup = "", "", ""
+		step.Notify.AlarmAction = "firing"
+		step.Notify.Guid = ""
+		actions = append(actions, getNotifyListInsertActionBySource([]*models.NotifyObj{step.Notify}, sourceNotifyList)...)
+		step.Guid = "esc_step_" + stepGuidList[i]
+		actions = append(actions, &Action{Sql: "insert into alarm_escalation_step(guid,escalation,step_index,delay,notify) value (?,?,?,?,?)", Param: []interface{}{
+			step.Guid, escalationGuid, i, step.Delay, step.Notify.Guid}})
+	}
+	return
+}
+
+func getAlarmEscalationStepDeleteActions(escalationGuid string) (actions []*Action) {
+	stepNotifySql := "select notify from alarm_escalation_step where escalation=?"
+	actions = append(actions, &Action{Sql: "delete from notify_role_rel where notify in (" + stepNotifySql + ")", Param: []interface{}{escalationGuid}})
+	actions = append(actions, &Action{Sql: "delete from notify_channel where notify in (" + stepNotifySql + ")", Param: []interface{}{escalationGuid}})
+	actions = append(actions, &Action{Sql: "delete from notify where guid in (" + stepNotifySql + ")", Param: []interface{}{escalationGuid}})
+	actions = append(actions, &Action{Sql: "delete from alarm_escalation_step where escalation=?", Param: []interface{}{escalationGuid}})
+	return
+}
+
+// getAlarmEscalationDeleteActions refColumn为guid/alarm_strategy/endpoint_group,删除阈值配置或对象组时一起清理
+func getAlarmEscalationDeleteActions(refColumn, refValue string) (actions []*Action) {
+	var escalationRows []*models.AlarmEscalationTable
+	x.SQL(fmt.Sprintf("select guid from alarm_escalation where %s=?", refColumn), refValue).Find(&escalationRows)
+	for _, row := range escalationRows {
+		actions = append(actions, getAlarmEscalationStepDeleteActions(row.Guid)...)
+		actions = append(actions, &Action{Sql: "delete from alarm_escalation_state where escalation=?", Param: []interface{}{row.Guid}})
+		actions = append(actions, &Action{Sql: "delete from alarm_escalation where guid=?", Param: []interface{}{row.Guid}})
+	}
+	return
+}
+
+func getAlarmEscalationCancelAction(alarmId int) *Action {
+	return &Action{Sql: "update alarm_escalation_state set status=?,update_time=NOW() where alarm_id=? and status=?", Param: []interface{}{models.EscalationStateCancel, alarmId, models.EscalationStateRunning}}
+}
+
+// StartAlarmEscalationCron 定时检查firing告警,按升级策略的步骤逐级通知,告警恢复或关闭后停止
+func StartAlarmEscalationCron() {
+	t := time.NewTicker(time.Duration(alarmEscalationInterval) * time.Second).C
+	for {
+		<-t
+		doAlarmEscalationJob()
+	}
+}
+
+func doAlarmEscalationJob() {
+	nowTime := time.Now()
+	if _, err := x.Exec("update alarm_escalation_state set status=?,update_time=? where status=? and alarm_id not in (select id from alarm where status='firing')",
+		models.EscalationStateCancel, nowTime, models.EscalationStateRunning); err != nil {
+		log.Logger.Error("cancel alarm escalation state fail", log.Error(err))
+	}
+	startAlarmEscalation(nowTime)
+	var stateRows []*models.AlarmEscalationStateTable
+	// 已确认或抖动中的告警暂停升级,取消确认或稳定后继续
+	if err := x.SQL("select * from alarm_escalation_state where status=? and next_time<=? and alarm_id not in (select id from alarm where ack_time is not null or flapping=1)", models.EscalationStateRunning, nowTime).Find(&stateRows); err != nil {
+		log.Logger.Error("query alarm escalation state fail", log.Error(err))
+		return
+	}
+	for _, state := range stateRows {
+		if err := executeAlarmEscalationStep(state, nowTime); err != nil {
+			log.Logger.Error("execute alarm escalation step fail", log.Int("alarmId", state.AlarmId), log.String("escalation", state.Escalation), log.Int("step", state.StepIndex), log.Error(err))
+		}
+	}
+}
+
+// startAlarmEscalation 给还没有升级进度的firing告警匹配升级策略,阈值配置上的策略优先于对象组上的
+func startAlarmEscalation(nowTime time.Time) {
+	var escalationRows []*models.AlarmEscalationTable
+	if err := x.SQL("select * from alarm_escalation where enable=1").Find(&escalationRows); err != nil {
+		log.Logger.Error("query alarm escalation fail", log.Error(err))
+		return
+	}
+	if len(escalationRows) == 0 {
+		return
+	}
+	strategyMap, groupMap := make(map[string]string), make(map[string]string)
+	for _, row := range escalationRows {
+		if row.AlarmStrategy != "" {
+			strategyMap[row.AlarmStrategy] = row.Guid
+		} else if row.EndpointGroup != "" {
+			groupMap[row.EndpointGroup] = row.Guid
+		}
+	}
+	var alarmRows []*models.AlarmTable
+	if err := x.SQL("select id,`start`,alarm_strategy from alarm where status='firing' and alarm_strategy<>'' and id not in (select alarm_id from alarm_escalation_state)").Find(&alarmRows); err != nil {
+		log.Logger.Error("query firing alarm for escalation fail", log.Error(err))
+		return
+	}
+	if len(alarmRows) == 0 {
+		return
+	}
+	strategyGroupMap := make(map[string]string)
+	if len(groupMap) > 0 {
+		strategyRows, _ := x.QueryString("select guid,endpoint_group from alarm_strategy")
+		for _, row := range strategyRows {
+			strategyGroupMap[row["guid"]] = row["endpoint_group"]
+		}
+	}
+	for _, alarmRow := range alarmRows {
+		escalationGuid := strategyMap[alarmRow.AlarmStrategy]
+		if escalationGuid == "" {
+			escalationGuid = groupMap[strategyGroupMap[alarmRow.AlarmStrategy]]
+		}
+		if escalationGuid == "" || checkAlarmIncidentEscalationSkip(alarmRow.Id, alarmRow.AlarmStrategy) {
+			continue
+		}
+		var firstStep []*models.AlarmEscalationStepTable
+		x.SQL("select * from alarm_escalation_step where escalation=? and step_index=0", escalationGuid).Find(&firstStep)
+		if len(firstStep) == 0 {
+			continue
+		}
+		nextTime := nowTime
+		if tmpTime := alarmRow.Start.Add(time.Duration(firstStep[0].Delay) * time.Minute); tmpTime.After(nowTime) {
+			nextTime = tmpTime
+		}
+		// 多实例同时处理时靠alarm_id主键去重
+		if _, err := x.Exec("insert ignore into alarm_escalation_state(alarm_id,escalation,step_index,status,next_time,update_time) value (?,?,?,?,?,?)",
+			alarmRow.Id, escalationGuid, 0, models.EscalationStateRunning, nextTime, nowTime); err != nil {
+			log.Logger.Error("insert alarm escalation state fail", log.Int("alarmId", alarmRow.Id), log.Error(err))
+		}
+	}
+}
+
+func executeAlarmEscalationStep(state *models.AlarmEscalationStateTable, nowTime time.Time) (err error) {
+	var stepRows []*models.AlarmEscalationStepTable
+	if err = x.SQL("select * from alarm_escalation_step where escalation=? and step_index>=? order by step_index limit 2", state.Escalation, state.StepIndex).Find(&stepRows); err != nil {
+		return fmt.Errorf("query alarm escalation step fail,%s ", err.Error())
+	}
+	if len(stepRows) == 0 || stepRows[0].StepIndex != state.StepIndex {
+		_, err = x.Exec("update alarm_escalation_state set status=?,update_time=? where alarm_id=? and step_index=?", models.EscalationStateDone, nowTime, state.AlarmId, state.StepIndex)
+		return
+	}
+	var alarmRows []*models.AlarmTable
+	if err = x.SQL("select * from alarm where id=?", state.AlarmId).Find(&alarmRows); err != nil {
+		return fmt.Errorf("query alarm table fail,%s ", err.Error())
+	}
+	if len(alarmRows) == 0 {
+		return fmt.Errorf("can not find alarm with id:%d ", state.AlarmId)
+	}
+	// 开始升级后才归入事件的告警,由事件里同一阈值配置的那条告警升级,这条结束掉
+	if checkAlarmIncidentEscalationSkip(state.AlarmId, alarmRows[0].AlarmStrategy) {
+		log.Logger.Info("alarm escalation cancel,alarm incident already escalate", log.Int("alarmId", state.AlarmId), log.String("escalation", state.Escalation))
+		_, err = x.Exec("update alarm_escalation_state set status=?,update_time=? where alarm_id=? and step_index=? and status=?", models.EscalationStateCancel, nowTime, state.AlarmId, state.StepIndex, models.EscalationStateRunning)
+		return
+	}
+	nextStatus, nextTime := models.EscalationStateDone, nowTime
+	if len(stepRows) > 1 {
+		nextStatus = models.EscalationStateRunning
+		nextTime = nowTime.Add(time.Duration(stepRows[1].Delay) * time.Minute)
+	}
+	// 先推进进度再发通知,通过step_index条件更新保证多实例下同一步只执行一次
+	execResult, execErr := x.Exec("update alarm_escalation_state set step_index=?,status=?,next_time=?,update_time=? where alarm_id=? and step_index=? and status=?",
+		state.StepIndex+1, nextStatus, nextTime, nowTime, state.AlarmId, state.StepIndex, models.EscalationStateRunning)
+	if execErr != nil {
+		return fmt.Errorf("update alarm escalation state fail,%s ", execErr.Error())
+	}
+	if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+		return
+	}
+	notifyRow, getErr := getSimpleNotify(stepRows[0].Notify)
+	if getErr != nil {
+		return getErr
+	}
+	log.Logger.Info("alarm escalation step notify", log.Int("alarmId", state.AlarmId), log.String("escalation", state.Escalation), log.Int("step", state.StepIndex))
+	notifyAction(&notifyRow, &models.AlarmHandleObj{AlarmTable: *alarmRows[0]})
+	return
+}
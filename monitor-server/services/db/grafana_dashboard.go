@@ -0,0 +1,787 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+var (
+	// 支持 $var ${var} ${var:format} [[var]] 四种写法
+	grafanaVariableRegexp = regexp.MustCompile(`\$\{(\w+)(?::\w+)?\}|\[\[(\w+)(?::\w+)?\]\]|\$(\w+)`)
+	grafanaMatcherRegexp  = regexp.MustCompile(`([a-zA-Z_]\w*)\s*(=~|!~|!=|=)\s*"((?:[^"\\]|\\.)*)"`)
+	grafanaClauseRegexp   = regexp.MustCompile(`(?i)\b(by|without|on|ignoring|group_left|group_right)\s*\([^)]*\)`)
+	grafanaTokenRegexp    = regexp.MustCompile(`[a-zA-Z_:][\w:]*`)
+	grafanaRangeRegexp    = regexp.MustCompile(`\[[^\]]*\]`)
+	// label_values(label) 或 label_values(metric, label)
+	grafanaLabelValuesRegexp = regexp.MustCompile(`^\s*label_values\(\s*(?:(.*),)?\s*(\w+)\s*\)\s*$`)
+	// 表达式里这些标签的值用来定位监控对象或层级对象
+	grafanaObjectLabelList = []string{"e_guid", "guid", "instance", "service_group"}
+	grafanaKeywordMap      = map[string]bool{"by": true, "without": true, "on": true, "ignoring": true, "group_left": true, "group_right": true,
+		"bool": true, "offset": true, "and": true, "or": true, "unless": true, "inf": true, "nan": true}
+	grafanaUnitMap = map[string]string{"percent": "%", "bytes": "B", "decbytes": "B", "bits": "b", "ms": "ms", "s": "s", "Bps": "B/s", "reqps": "req/s", "short": "", "none": ""}
+	// 导出时单位反查,多个Grafana单位对应同一个单位时固定用一个
+	grafanaExportUnitMap = map[string]string{"%": "percent", "B": "bytes", "b": "bits", "ms": "ms", "s": "s", "B/s": "Bps", "req/s": "reqps"}
+)
+
+type grafanaImportContext struct {
+	param                models.GrafanaImportParam
+	variableMap          map[string]string
+	dashboardVariableMap map[string]*models.CustomDashboardVariable
+	datasourceList       []*models.MonitorDatasourceTable
+	unresolvedMap        map[string][]string
+}
+
+type grafanaSeriesObject struct {
+	endpoint     *models.EndpointNewTable
+	serviceGroup *models.ServiceGroupTable
+}
+
+// ParseGrafanaDashboard 兼容Grafana接口导出的 {"dashboard":{...}} 和页面上直接导出的看板json
+func ParseGrafanaDashboard(content []byte) (result *models.GrafanaDashboard, err error) {
+	var wrapper models.GrafanaDashboardWrapper
+	if err = json.Unmarshal(content, &wrapper); err != nil {
+		err = fmt.Errorf("json unmarshal grafana dashboard fail,%s ", err.Error())
+		return
+	}
+	if wrapper.Dashboard != nil && len(wrapper.Dashboard.Panels) > 0 {
+		result = wrapper.Dashboard
+		return
+	}
+	result = &models.GrafanaDashboard{}
+	if err = json.Unmarshal(content, result); err != nil {
+		err = fmt.Errorf("json unmarshal grafana dashboard fail,%s ", err.Error())
+	}
+	return
+}
+
+// ConvertGrafanaDashboard 把Grafana看板转成自定义看板的导入格式,不支持的面板和解析不出指标的查询分别返回
+func ConvertGrafanaDashboard(dashboard *models.GrafanaDashboard, param models.GrafanaImportParam) (result *models.CustomDashboardExportDto, importResult *models.GrafanaImportResult, err error) {
+	ctx := &grafanaImportContext{param: param, variableMap: make(map[string]string), dashboardVariableMap: make(map[string]*models.CustomDashboardVariable), unresolvedMap: make(map[string][]string)}
+	if ctx.datasourceList, err = ListMonitorDatasource(); err != nil {
+		return
+	}
+	for _, variable := range dashboard.Templating.List {
+		ctx.variableMap[variable.Name] = getGrafanaVariableCurrent(variable)
+	}
+	for k, v := range param.Variables {
+		ctx.variableMap[k] = v
+	}
+	result = &models.CustomDashboardExportDto{
+		Name:        strings.TrimSpace(dashboard.Title),
+		TimeRange:   -parseGrafanaDuration(strings.TrimPrefix(dashboard.Time.From, "now-"), 1800),
+		RefreshWeek: 60,
+		Charts:      []*models.CustomChartDto{},
+	}
+	if refresh, ok := dashboard.Refresh.(string); ok && refresh != "" {
+		result.RefreshWeek = parseGrafanaDuration(refresh, 60)
+	}
+	importResult = &models.GrafanaImportResult{ChartMap: ctx.unresolvedMap, SkipPanels: []string{}, ConvertPanels: []string{}, SkipVariables: []string{}}
+	result.Variables = ctx.convertVariables(dashboard.Templating.List, importResult)
+	groupList := []string{}
+	currentGroup, groupY := "", 0
+	for _, panel := range dashboard.Panels {
+		if panel.Type == models.GrafanaPanelRow {
+			currentGroup, groupY = strings.TrimSpace(panel.Title), panel.GridPos.Y+panel.GridPos.H
+			if currentGroup != "" {
+				groupList = append(groupList, currentGroup)
+			}
+			// 折叠的行下面的面板放在行的panels里
+			for _, subPanel := range panel.Panels {
+				ctx.appendChart(result, importResult, subPanel, currentGroup, groupY)
+			}
+			continue
+		}
+		ctx.appendChart(result, importResult, panel, currentGroup, groupY)
+	}
+	result.PanelGroups = strings.Join(groupList, ",")
+	return
+}
+
+func (ctx *grafanaImportContext) appendChart(result *models.CustomDashboardExportDto, importResult *models.GrafanaImportResult, panel *models.GrafanaPanel, group string, groupY int) {
+	chartName := strings.TrimSpace(panel.Title)
+	if chartName == "" {
+		chartName = fmt.Sprintf("panel_%d", panel.Id)
+	}
+	chart := &models.CustomChartDto{
+		Name:          chartName,
+		ChartTemplate: "one",
+		ChartType:     "line",
+		LineType:      "line",
+		Aggregate:     "none",
+		AggStep:       60,
+		ChartSeries:   []*models.CustomChartSeriesDto{},
+		Group:         group,
+		Datasource:    ctx.matchDatasource(panel.Datasource),
+	}
+	switch panel.Type {
+	case models.GrafanaPanelTimeSeries, models.GrafanaPanelGraph:
+		if panel.Bars || (panel.FieldConfig != nil && panel.FieldConfig.Defaults.Custom != nil && panel.FieldConfig.Defaults.Custom.DrawStyle == "bars") {
+			chart.ChartType, chart.LineType = "bar", "bar"
+		} else if panel.Fill > 0 || (panel.FieldConfig != nil && panel.FieldConfig.Defaults.Custom != nil && panel.FieldConfig.Defaults.Custom.FillOpacity > 0) {
+			chart.LineType = "area"
+		}
+	case models.GrafanaPanelPie, models.GrafanaPanelOldPie:
+		chart.ChartType, chart.PieType = "pie", "tag"
+	case models.GrafanaPanelStat, models.GrafanaPanelGauge, models.GrafanaPanelTable:
+		// 单值、仪表盘和表格面板没有对应的图表,按曲线图展示并在导入结果里提示
+		importResult.ConvertPanels = append(importResult.ConvertPanels, fmt.Sprintf("%s(%s)", chartName, panel.Type))
+	default:
+		importResult.SkipPanels = append(importResult.SkipPanels, fmt.Sprintf("%s(%s)", chartName, panel.Type))
+		return
+	}
+	if panel.FieldConfig != nil {
+		chart.Unit = convertGrafanaUnit(panel.FieldConfig.Defaults.Unit)
+	}
+	chart.DisplayConfig = buildGrafanaDisplayConfig(panel.GridPos, 0)
+	chart.GroupDisplayConfig = chart.DisplayConfig
+	if group != "" {
+		chart.GroupDisplayConfig = buildGrafanaDisplayConfig(panel.GridPos, groupY)
+	}
+	seriesKeyMap := make(map[string]bool)
+	for _, target := range panel.Targets {
+		if target.Hide || strings.TrimSpace(target.Expr) == "" {
+			continue
+		}
+		if target.OpenMonitor != nil {
+			// 层级对象下每个对象导出一个查询,带的是同一份配置,按整份配置去重
+			seriesKeyBytes, _ := json.Marshal(target.OpenMonitor)
+			if seriesKeyMap[string(seriesKeyBytes)] {
+				continue
+			}
+			seriesKeyMap[string(seriesKeyBytes)] = true
+			chart.ChartSeries = append(chart.ChartSeries, &models.CustomChartSeriesDto{Endpoint: target.OpenMonitor.Endpoint, ServiceGroup: target.OpenMonitor.ServiceGroup,
+				EndpointName: target.OpenMonitor.EndpointName, MonitorType: target.OpenMonitor.MonitorType, ColorGroup: target.OpenMonitor.ColorGroup,
+				PieDisplayTag: target.OpenMonitor.PieDisplayTag, EndpointType: target.OpenMonitor.EndpointType, MetricType: target.OpenMonitor.MetricType,
+				MetricGuid: target.OpenMonitor.MetricGuid, Metric: target.OpenMonitor.Metric, Tags: target.OpenMonitor.Tags})
+			continue
+		}
+		series, convertErr := ctx.convertTarget(target, chart.ChartType)
+		if convertErr != nil {
+			log.Logger.Warn("Convert grafana target fail", log.String("panel", chartName), log.String("expr", target.Expr), log.Error(convertErr))
+			ctx.unresolvedMap[chartName] = append(ctx.unresolvedMap[chartName], target.Expr)
+			continue
+		}
+		chart.ChartSeries = append(chart.ChartSeries, series)
+	}
+	result.Charts = append(result.Charts, chart)
+}
+
+// convertTarget 从表达式里解析出指标、监控对象和标签
+func (ctx *grafanaImportContext) convertTarget(target *models.GrafanaTarget, chartType string) (series *models.CustomChartSeriesDto, err error) {
+	expr := strings.TrimSpace(target.Expr)
+	metricName := getGrafanaExprMetricName(expr)
+	if metricName == "" {
+		err = fmt.Errorf("can not find metric name in expr")
+		return
+	}
+	seriesObj := grafanaSeriesObject{}
+	var objectVariable *models.CustomDashboardVariable
+	objectLabelMap := make(map[string]bool)
+	matcherList := grafanaMatcherRegexp.FindAllStringSubmatch(expr, -1)
+	for _, matcher := range matcherList {
+		isVariable := grafanaVariableRegexp.MatchString(matcher[3])
+		if !isVariable && !stringInList(matcher[1], grafanaObjectLabelList) {
+			continue
+		}
+		if seriesObj.endpoint != nil || seriesObj.serviceGroup != nil {
+			objectLabelMap[matcher[1]] = true
+			continue
+		}
+		value := strings.Split(ctx.replaceVariable(matcher[3]), "|")[0]
+		if tmpObj := getGrafanaSeriesObject(value); tmpObj.endpoint != nil || tmpObj.serviceGroup != nil {
+			seriesObj = tmpObj
+			objectLabelMap[matcher[1]] = true
+			if variable := ctx.getDashboardVariable(matcher[3]); variable != nil && variable.Type != models.DashboardVariableTagValue && variable.Type != models.DashboardVariableInterval {
+				objectVariable = variable
+			}
+		}
+	}
+	if seriesObj.endpoint == nil && seriesObj.serviceGroup == nil {
+		if ctx.param.Endpoint != "" {
+			seriesObj = getGrafanaSeriesObject(ctx.param.Endpoint)
+		} else if ctx.param.ServiceGroup != "" {
+			seriesObj = getGrafanaSeriesObject(ctx.param.ServiceGroup)
+		}
+	}
+	if seriesObj.endpoint == nil && seriesObj.serviceGroup == nil {
+		err = fmt.Errorf("can not find endpoint or service group")
+		return
+	}
+	var metricRow *models.MetricTable
+	if metricRow, err = matchGrafanaMetric(metricName, seriesObj); err != nil {
+		return
+	}
+	series = &models.CustomChartSeriesDto{MonitorType: metricRow.MonitorType, MetricGuid: metricRow.Guid, Metric: metricRow.Metric,
+		MetricType: string(models.MetricTypeCommon), Tags: []*models.TagDto{}}
+	if metricRow.ServiceGroup != "" {
+		series.MetricType = string(models.MetricTypeBusiness)
+	}
+	if seriesObj.endpoint != nil {
+		series.Endpoint, series.EndpointName, series.EndpointType = seriesObj.endpoint.Guid, seriesObj.endpoint.Guid, seriesObj.endpoint.MonitorType
+	} else {
+		series.Endpoint, series.ServiceGroup, series.EndpointName, series.EndpointType = seriesObj.serviceGroup.Guid, seriesObj.serviceGroup.Guid, seriesObj.serviceGroup.DisplayName, seriesObj.serviceGroup.ServiceType
+	}
+	// 对象来自转成看板变量的变量时引用变量,查询时再按变量选中的值展开
+	if objectVariable != nil {
+		series.Endpoint, series.EndpointName = "$"+objectVariable.Name, "$"+objectVariable.Name
+		if objectVariable.Type == models.DashboardVariableServiceGroup {
+			series.ServiceGroup = "$" + objectVariable.Name
+		} else {
+			series.ServiceGroup = ""
+		}
+	}
+	// 只有指标表达式里有 $t_xxx 占位的标签才能作为图表标签过滤
+	for _, matcher := range matcherList {
+		if objectLabelMap[matcher[1]] || !strings.Contains(metricRow.PromExpr, "$t_"+matcher[1]) {
+			continue
+		}
+		tag := &models.TagDto{TagName: matcher[1], Equal: ConstEqualIn, TagValue: []string{}}
+		if matcher[2] == "!=" || matcher[2] == "!~" {
+			tag.Equal = ConstEqualNotIn
+		}
+		if variable := ctx.getDashboardVariable(matcher[3]); variable != nil {
+			tag.TagValue = append(tag.TagValue, "$"+variable.Name)
+			series.Tags = append(series.Tags, tag)
+			continue
+		}
+		for _, value := range strings.Split(ctx.replaceVariable(matcher[3]), "|") {
+			if value != "" && value != ".*" && value != ".+" {
+				tag.TagValue = append(tag.TagValue, value)
+			}
+		}
+		series.Tags = append(series.Tags, tag)
+	}
+	if chartType == "pie" {
+		if legendTag := strings.Trim(strings.TrimSpace(target.LegendFormat), "{} "); legendTag != "" && !strings.Contains(legendTag, "{") {
+			series.PieDisplayTag = legendTag
+		}
+	}
+	return
+}
+
+func (ctx *grafanaImportContext) replaceVariable(input string) string {
+	return grafanaVariableRegexp.ReplaceAllStringFunc(input, func(ref string) string {
+		subMatch := grafanaVariableRegexp.FindStringSubmatch(ref)
+		name := subMatch[1] + subMatch[2] + subMatch[3]
+		if value, b := ctx.variableMap[name]; b {
+			return value
+		}
+		return ref
+	})
+}
+
+// getDashboardVariable 整个值只引用了一个变量,并且这个变量转成了看板变量
+func (ctx *grafanaImportContext) getDashboardVariable(input string) *models.CustomDashboardVariable {
+	input = strings.TrimSpace(input)
+	subMatch := grafanaVariableRegexp.FindStringSubmatch(input)
+	if len(subMatch) == 0 || subMatch[0] != input {
+		return nil
+	}
+	return ctx.dashboardVariableMap[subMatch[1]+subMatch[2]+subMatch[3]]
+}
+
+// convertVariables Grafana变量转成看板变量,本系统导出的按原配置还原,其它的按变量类型和当前值推断,
+// 数据源、常量和文本框等变量只在导入时用来替换表达式
+func (ctx *grafanaImportContext) convertVariables(templateVarList []*models.GrafanaTemplateVar, importResult *models.GrafanaImportResult) (result []*models.CustomDashboardVariable) {
+	result = []*models.CustomDashboardVariable{}
+	for _, templateVar := range templateVarList {
+		if templateVar.Type == "datasource" || templateVar.Type == "constant" || templateVar.Type == "textbox" || templateVar.Type == "adhoc" {
+			continue
+		}
+		variable := templateVar.OpenMonitor
+		if variable == nil {
+			variable = ctx.guessVariable(templateVar)
+		}
+		if variable == nil {
+			importResult.SkipVariables = append(importResult.SkipVariables, fmt.Sprintf("%s(%s)", templateVar.Name, templateVar.Type))
+			continue
+		}
+		variable.Guid, variable.Name = "", templateVar.Name
+		if variable.DisplayName == "" {
+			variable.DisplayName = templateVar.Label
+		}
+		if err := validateDashboardVariable(variable); err != nil || ctx.dashboardVariableMap[variable.Name] != nil {
+			importResult.SkipVariables = append(importResult.SkipVariables, fmt.Sprintf("%s(%s)", templateVar.Name, templateVar.Type))
+			continue
+		}
+		variable.SortIndex = len(result) + 1
+		ctx.dashboardVariableMap[variable.Name] = variable
+		result = append(result, variable)
+	}
+	return
+}
+
+// guessVariable 间隔变量直接转,label_values查询按标签判断是对象还是标签值,自定义变量按当前值能不能找到对象或层级对象判断
+func (ctx *grafanaImportContext) guessVariable(templateVar *models.GrafanaTemplateVar) (result *models.CustomDashboardVariable) {
+	currentValue := ctx.variableMap[templateVar.Name]
+	switch templateVar.Type {
+	case "interval":
+		query, _ := templateVar.Query.(string)
+		return &models.CustomDashboardVariable{Type: models.DashboardVariableInterval, Options: query, DefaultValue: currentValue}
+	case "query":
+		var query string
+		switch v := templateVar.Query.(type) {
+		case string:
+			query = v
+		case map[string]interface{}:
+			query, _ = v["query"].(string)
+		}
+		subMatch := grafanaLabelValuesRegexp.FindStringSubmatch(query)
+		if len(subMatch) == 0 {
+			return
+		}
+		if subMatch[2] == "service_group" {
+			return &models.CustomDashboardVariable{Type: models.DashboardVariableServiceGroup, DefaultValue: currentValue}
+		}
+		if stringInList(subMatch[2], grafanaObjectLabelList) {
+			return ctx.guessObjectVariable(currentValue)
+		}
+		metricName := getGrafanaExprMetricName(subMatch[1])
+		if metricName == "" {
+			return
+		}
+		metricRow, err := matchGrafanaMetric(metricName, grafanaSeriesObject{})
+		if err != nil {
+			log.Logger.Warn("Convert grafana variable fail", log.String("name", templateVar.Name), log.String("query", query), log.Error(err))
+			return
+		}
+		// 标签值按导入时指定的默认对象或层级对象查询
+		endpoint := ctx.param.Endpoint
+		if endpoint == "" {
+			endpoint = ctx.param.ServiceGroup
+		}
+		return &models.CustomDashboardVariable{Type: models.DashboardVariableTagValue, MonitorType: metricRow.MonitorType, MetricGuid: metricRow.Guid,
+			TagName: subMatch[2], Endpoint: endpoint, DefaultValue: currentValue}
+	case "custom":
+		return ctx.guessObjectVariable(currentValue)
+	}
+	return
+}
+
+func (ctx *grafanaImportContext) guessObjectVariable(value string) (result *models.CustomDashboardVariable) {
+	seriesObj := getGrafanaSeriesObject(strings.Split(value, "|")[0])
+	if seriesObj.endpoint != nil {
+		result = &models.CustomDashboardVariable{Type: models.DashboardVariableEndpoint, MonitorType: seriesObj.endpoint.MonitorType, DefaultValue: seriesObj.endpoint.Guid}
+	} else if seriesObj.serviceGroup != nil {
+		result = &models.CustomDashboardVariable{Type: models.DashboardVariableServiceGroup, DefaultValue: seriesObj.serviceGroup.Guid}
+	}
+	return
+}
+
+// matchDatasource 面板数据源按名称或guid匹配,引用变量时先取变量的值,匹配不上用默认数据源
+func (ctx *grafanaImportContext) matchDatasource(input interface{}) string {
+	name := ""
+	switch v := input.(type) {
+	case string:
+		name = v
+	case map[string]interface{}:
+		if uid, ok := v["uid"].(string); ok {
+			name = uid
+		}
+	}
+	name = ctx.replaceVariable(name)
+	for _, row := range ctx.datasourceList {
+		if name != "" && (row.Guid == name || row.Name == name) {
+			return row.Guid
+		}
+	}
+	return ""
+}
+
+func getGrafanaVariableCurrent(variable *models.GrafanaTemplateVar) (value string) {
+	switch v := variable.Current.Value.(type) {
+	case string:
+		value = v
+	case []interface{}:
+		if len(v) > 0 {
+			value, _ = v[0].(string)
+		}
+	}
+	if value == "$__all" {
+		value = ""
+	}
+	return
+}
+
+// getGrafanaSeriesObject 按监控对象guid、ip(可以带端口)或层级对象guid、显示名查找
+func getGrafanaSeriesObject(value string) (result grafanaSeriesObject) {
+	if value = strings.TrimSpace(value); value == "" || strings.ContainsAny(value, "$*") {
+		return
+	}
+	var endpointRows []*models.EndpointNewTable
+	ip := value
+	if colonIndex := strings.LastIndex(value, ":"); colonIndex > 0 {
+		ip = value[:colonIndex]
+	}
+	if err := x.SQL("select * from endpoint_new where guid=? or ip=? order by guid=? desc", value, ip, value).Find(&endpointRows); err != nil {
+		log.Logger.Error("query endpoint fail", log.Error(err))
+		return
+	}
+	if len(endpointRows) > 0 {
+		result.endpoint = endpointRows[0]
+		return
+	}
+	var serviceGroupRows []*models.ServiceGroupTable
+	if err := x.SQL("select * from service_group where guid=? or display_name=?", value, value).Find(&serviceGroupRows); err != nil {
+		log.Logger.Error("query service group fail", log.Error(err))
+		return
+	}
+	if len(serviceGroupRows) > 0 {
+		result.serviceGroup = serviceGroupRows[0]
+	}
+	return
+}
+
+// matchGrafanaMetric 先按指标名精确匹配,再找表达式里用到这个指标的,优先对象类型和层级对象一致的
+func matchGrafanaMetric(metricName string, seriesObj grafanaSeriesObject) (result *models.MetricTable, err error) {
+	var metricRows []*models.MetricTable
+	if err = x.SQL("select * from metric where metric=?", metricName).Find(&metricRows); err != nil {
+		err = fmt.Errorf("query metric table fail,%s ", err.Error())
+		return
+	}
+	if len(metricRows) == 0 {
+		if err = x.SQL("select * from metric where prom_expr like ?", "%"+metricName+"%").Find(&metricRows); err != nil {
+			err = fmt.Errorf("query metric table fail,%s ", err.Error())
+			return
+		}
+	}
+	bestScore := -1
+	for _, row := range metricRows {
+		if seriesObj.endpoint != nil && row.MonitorType != seriesObj.endpoint.MonitorType {
+			continue
+		}
+		score := 0
+		if seriesObj.serviceGroup != nil && row.ServiceGroup == seriesObj.serviceGroup.Guid {
+			score += 2
+		}
+		if row.ServiceGroup == "" {
+			score += 1
+		}
+		if score > bestScore {
+			result, bestScore = row, score
+		}
+	}
+	if result == nil {
+		err = fmt.Errorf("can not find metric:%s ", metricName)
+	}
+	return
+}
+
+// getGrafanaExprMetricName 去掉标签过滤、区间、by子句和函数名后,第一个标识符就是指标名
+func getGrafanaExprMetricName(expr string) string {
+	expr = grafanaMatcherRegexp.ReplaceAllString(expr, "")
+	expr = grafanaClauseRegexp.ReplaceAllString(expr, "")
+	expr = grafanaRangeRegexp.ReplaceAllString(expr, "")
+	for _, loc := range grafanaTokenRegexp.FindAllStringIndex(expr, -1) {
+		token := expr[loc[0]:loc[1]]
+		if grafanaKeywordMap[strings.ToLower(token)] || (loc[0] > 0 && expr[loc[0]-1] == '$') {
+			continue
+		}
+		if rest := strings.TrimSpace(expr[loc[1]:]); strings.HasPrefix(rest, "(") {
+			continue
+		}
+		return token
+	}
+	return ""
+}
+
+// parseGrafanaDuration 解析 30s 5m 1h 7d 这样的时间,返回秒
+func parseGrafanaDuration(input string, defaultValue int) int {
+	input = strings.TrimSpace(input)
+	if len(input) < 2 {
+		return defaultValue
+	}
+	num, err := strconv.Atoi(input[:len(input)-1])
+	if err != nil || num <= 0 {
+		return defaultValue
+	}
+	switch input[len(input)-1] {
+	case 's':
+		return num
+	case 'm':
+		return num * 60
+	case 'h':
+		return num * 3600
+	case 'd':
+		return num * 86400
+	case 'w':
+		return num * 7 * 86400
+	case 'M':
+		return num * 30 * 86400
+	case 'y':
+		return num * 365 * 86400
+	}
+	return defaultValue
+}
+
+func convertGrafanaUnit(unit string) string {
+	if strings.HasPrefix(unit, "suffix:") {
+		return strings.TrimPrefix(unit, "suffix:")
+	}
+	if v, b := grafanaUnitMap[unit]; b {
+		return v
+	}
+	return unit
+}
+
+func buildGrafanaDisplayConfig(gridPos models.GrafanaGridPos, offsetY int) string {
+	displayConfig := models.DisplayConfig{X: float64(gridPos.X / 2), Y: float64(gridPos.Y - offsetY), W: float64((gridPos.W + 1) / 2), H: float64(gridPos.H)}
+	if displayConfig.Y < 0 {
+		displayConfig.Y = 0
+	}
+	if displayConfig.W <= 0 {
+		displayConfig.W = 6
+	}
+	if displayConfig.H <= 0 {
+		displayConfig.H = 8
+	}
+	b, _ := json.Marshal(displayConfig)
+	return string(b)
+}
+
+// BuildGrafanaDashboard 自定义看板转成Grafana看板,每个对象生成一个查询,原图表配置放在查询的openMonitor里方便再导入,
+// 看板变量转成Grafana变量,引用变量的对象和标签在表达式里保留变量
+func BuildGrafanaDashboard(param *models.CustomDashboardExportDto) (result *models.GrafanaDashboard) {
+	datasourceRef := map[string]string{"type": "prometheus", "uid": "${" + models.GrafanaDatasourceVar + "}"}
+	variableMap := make(map[string]*models.CustomDashboardVariable)
+	result = &models.GrafanaDashboard{
+		Title:         param.Name,
+		Tags:          []string{"open-monitor"},
+		Timezone:      "browser",
+		SchemaVersion: 36,
+		Time:          models.GrafanaTimeRange{From: fmt.Sprintf("now-%ds", -param.TimeRange), To: "now"},
+		Templating: models.GrafanaTemplating{List: []*models.GrafanaTemplateVar{{Name: models.GrafanaDatasourceVar, Label: "Datasource", Type: "datasource",
+			Query: "prometheus", Current: models.GrafanaVarCurrent{Text: "default", Value: "default"}}}},
+		Panels: []*models.GrafanaPanel{},
+	}
+	for _, variable := range param.Variables {
+		if variable.Name == models.GrafanaDatasourceVar {
+			continue
+		}
+		variableMap[variable.Name] = variable
+		result.Templating.List = append(result.Templating.List, buildGrafanaTemplateVar(variable, datasourceRef))
+	}
+	if param.TimeRange >= 0 {
+		result.Time.From = "now-30m"
+	}
+	if param.RefreshWeek > 0 {
+		result.Refresh = fmt.Sprintf("%ds", param.RefreshWeek)
+	}
+	groupChartMap := make(map[string][]*models.CustomChartDto)
+	groupList := []string{}
+	for _, group := range strings.Split(param.PanelGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groupChartMap[group] = []*models.CustomChartDto{}
+			groupList = append(groupList, group)
+		}
+	}
+	panelId, maxY := 1, 0
+	for _, chart := range param.Charts {
+		if _, b := groupChartMap[chart.Group]; b {
+			groupChartMap[chart.Group] = append(groupChartMap[chart.Group], chart)
+			continue
+		}
+		panel := buildGrafanaPanel(chart, parseDisplayConfig(chart.DisplayConfig), 0, panelId, datasourceRef, variableMap)
+		if bottom := panel.GridPos.Y + panel.GridPos.H; bottom > maxY {
+			maxY = bottom
+		}
+		result.Panels = append(result.Panels, panel)
+		panelId++
+	}
+	// 分组转成Grafana的行,组内图表的位置相对于行
+	for _, group := range groupList {
+		rowY := maxY
+		result.Panels = append(result.Panels, &models.GrafanaPanel{Id: panelId, Type: models.GrafanaPanelRow, Title: group, GridPos: models.GrafanaGridPos{H: 1, W: models.GrafanaGridColumns, X: 0, Y: rowY}})
+		panelId++
+		maxY = rowY + 1
+		for _, chart := range groupChartMap[group] {
+			panel := buildGrafanaPanel(chart, parseDisplayConfig(chart.GroupDisplayConfig), rowY+1, panelId, datasourceRef, variableMap)
+			if bottom := panel.GridPos.Y + panel.GridPos.H; bottom > maxY {
+				maxY = bottom
+			}
+			result.Panels = append(result.Panels, panel)
+			panelId++
+		}
+	}
+	return
+}
+
+func buildGrafanaPanel(chart *models.CustomChartDto, displayConfig models.DisplayConfig, offsetY, panelId int, datasourceRef map[string]string, variableMap map[string]*models.CustomDashboardVariable) (panel *models.GrafanaPanel) {
+	panel = &models.GrafanaPanel{Id: panelId, Type: models.GrafanaPanelTimeSeries, Title: chart.Name, Datasource: datasourceRef, Targets: []*models.GrafanaTarget{},
+		GridPos: models.GrafanaGridPos{H: int(displayConfig.H), W: int(displayConfig.W) * 2, X: int(displayConfig.X) * 2, Y: int(displayConfig.Y) + offsetY}}
+	panel.FieldConfig = &models.GrafanaFieldConfig{Overrides: []interface{}{}, Defaults: models.GrafanaFieldDefaults{Unit: buildGrafanaUnit(chart.Unit),
+		Color: &models.GrafanaFieldColor{Mode: "palette-classic"}, Custom: &models.GrafanaFieldCustom{DrawStyle: "line"}}}
+	switch {
+	case chart.ChartType == "pie":
+		panel.Type = models.GrafanaPanelPie
+		panel.FieldConfig.Defaults.Custom = nil
+	case chart.ChartType == "bar" || chart.LineType == "bar":
+		panel.FieldConfig.Defaults.Custom.DrawStyle = "bars"
+		panel.FieldConfig.Defaults.Custom.FillOpacity = 100
+	case chart.LineType == "area":
+		panel.FieldConfig.Defaults.Custom.FillOpacity = 30
+	}
+	for _, series := range chart.ChartSeries {
+		extend := &models.GrafanaOpenMonitorSeries{Endpoint: series.Endpoint, ServiceGroup: series.ServiceGroup, EndpointName: series.EndpointName,
+			MonitorType: series.MonitorType, EndpointType: series.EndpointType, MetricType: series.MetricType, MetricGuid: series.MetricGuid, Metric: series.Metric,
+			ColorGroup: series.ColorGroup, PieDisplayTag: series.PieDisplayTag, Tags: series.Tags}
+		legendFormat := ""
+		if series.PieDisplayTag != "" {
+			legendFormat = "{{" + series.PieDisplayTag + "}}"
+		}
+		for _, expr := range buildGrafanaSeriesExpr(series, variableMap) {
+			panel.Targets = append(panel.Targets, &models.GrafanaTarget{RefId: buildGrafanaRefId(len(panel.Targets)), Expr: expr, LegendFormat: legendFormat, Datasource: datasourceRef, OpenMonitor: extend})
+		}
+	}
+	return
+}
+
+// buildGrafanaSeriesExpr 和图表查询一样,层级对象按下面的每个对象生成表达式,业务指标只生成一个,
+// 对象或层级对象引用看板变量时只生成一个表达式,对象标签用变量匹配
+func buildGrafanaSeriesExpr(series *models.CustomChartSeriesDto, variableMap map[string]*models.CustomDashboardVariable) (exprList []string) {
+	serviceGroup := series.ServiceGroup
+	objectVariable := getGrafanaExportVariable(series.ServiceGroup, variableMap)
+	if objectVariable != nil {
+		// 按变量默认的层级对象找指标
+		serviceGroup = ""
+		if valueList := splitDashboardVariableValue(objectVariable.DefaultValue); len(valueList) > 0 {
+			serviceGroup = valueList[0]
+		}
+	} else {
+		objectVariable = getGrafanaExportVariable(series.Endpoint, variableMap)
+	}
+	promQl, err := GetPromQLByMetric(series.Metric, series.MonitorType, serviceGroup)
+	if err != nil || promQl == "" {
+		log.Logger.Warn("Get metric promQl fail", log.String("metric", series.Metric), log.String("monitorType", series.MonitorType), log.Error(err))
+		return
+	}
+	if objectVariable != nil {
+		return []string{buildGrafanaVariableExpr(promQl, series, objectVariable.Name)}
+	}
+	endpointList := []*models.EndpointNewTable{}
+	if series.ServiceGroup != "" {
+		if isServiceMetric, _, _ := CheckMetricIsServiceMetric(series.Metric, series.ServiceGroup); isServiceMetric {
+			return []string{ReplacePromQlKeyword(promQl, series.Metric, &models.EndpointNewTable{}, copyTagDtoList(series.Tags))}
+		}
+		if endpointList, err = GetRecursiveEndpointByTypeNew(series.ServiceGroup, series.MonitorType); err != nil {
+			log.Logger.Warn("Get service group endpoint fail", log.String("serviceGroup", series.ServiceGroup), log.Error(err))
+			return
+		}
+	} else if endpointObj, _ := GetEndpointNew(&models.EndpointNewTable{Guid: series.Endpoint}); endpointObj.Guid != "" {
+		endpointList = append(endpointList, &endpointObj)
+	}
+	for _, endpoint := range endpointList {
+		exprList = append(exprList, ReplacePromQlKeyword(promQl, series.Metric, endpoint, copyTagDtoList(series.Tags)))
+	}
+	return
+}
+
+func getGrafanaExportVariable(input string, variableMap map[string]*models.CustomDashboardVariable) *models.CustomDashboardVariable {
+	subMatch := dashboardVariableRefRegexp.FindStringSubmatch(strings.TrimSpace(input))
+	if len(subMatch) < 2 {
+		return nil
+	}
+	return variableMap[subMatch[1]]
+}
+
+// buildGrafanaVariableExpr 对象的占位符先换成不带$的临时值,避免被当成没有替换的占位符改成.*,再换成正则匹配的变量,多选时也能匹配
+func buildGrafanaVariableExpr(promQl string, series *models.CustomChartSeriesDto, name string) string {
+	placeholder := "__open_monitor_var_" + name + "__"
+	host := &models.EndpointNewTable{Guid: placeholder, Ip: placeholder, AgentAddress: placeholder, Name: placeholder}
+	expr := ReplacePromQlKeyword(promQl, series.Metric, host, copyTagDtoList(series.Tags))
+	expr = strings.Replace(expr, "=\""+placeholder+"\"", "=~\"${"+name+"}\"", -1)
+	return strings.Replace(expr, placeholder, "${"+name+"}", -1)
+}
+
+// buildGrafanaTemplateVar 标签值变量转成Grafana的label_values查询,间隔变量转成间隔变量,
+// 对象、对象组和层级对象变量转成可选值为默认值的自定义变量,原变量配置放在openMonitor里方便再导入
+func buildGrafanaTemplateVar(variable *models.CustomDashboardVariable, datasourceRef map[string]string) (result *models.GrafanaTemplateVar) {
+	extend := *variable
+	extend.Guid, extend.CustomDashboard, extend.CreateUser, extend.UpdateUser, extend.CreateTime, extend.UpdateTime = "", 0, "", "", "", ""
+	valueList := splitDashboardVariableValue(variable.DefaultValue)
+	result = &models.GrafanaTemplateVar{Name: variable.Name, Label: variable.DisplayName, Type: "custom", Query: strings.Join(valueList, ","), Options: []*models.GrafanaVarValue{},
+		Multi: variable.Type != models.DashboardVariableServiceGroup && variable.Type != models.DashboardVariableInterval, OpenMonitor: &extend}
+	switch variable.Type {
+	case models.DashboardVariableInterval:
+		options := variable.Options
+		if strings.TrimSpace(options) == "" {
+			options = models.DashboardVariableIntervalList
+		}
+		optionList := splitDashboardVariableValue(options)
+		result.Type, result.Query = "interval", strings.Join(optionList, ",")
+		if len(valueList) == 0 {
+			valueList = optionList[:1]
+		}
+		for _, v := range optionList {
+			result.Options = append(result.Options, &models.GrafanaVarValue{Text: v, Value: v, Selected: v == valueList[0]})
+		}
+	case models.DashboardVariableTagValue:
+		result.Type, result.Datasource, result.Query = "query", datasourceRef, "label_values("+variable.TagName+")"
+		if metricRow, err := GetSimpleMetric(variable.MetricGuid); err == nil {
+			if metricName := getGrafanaExprMetricName(metricRow.PromExpr); metricName != "" {
+				result.Query = "label_values(" + metricName + ", " + variable.TagName + ")"
+			}
+		}
+	default:
+		for _, v := range valueList {
+			result.Options = append(result.Options, &models.GrafanaVarValue{Text: v, Value: v, Selected: true})
+		}
+	}
+	switch {
+	case len(valueList) == 0:
+		result.Current = models.GrafanaVarCurrent{Text: "", Value: ""}
+	case result.Multi:
+		result.Current = models.GrafanaVarCurrent{Text: valueList, Value: valueList}
+	default:
+		result.Current = models.GrafanaVarCurrent{Text: valueList[0], Value: valueList[0]}
+	}
+	return
+}
+
+// copyTagDtoList 替换表达式时会改标签值,每次替换用一份拷贝
+func copyTagDtoList(tagList []*models.TagDto) (result []*models.TagDto) {
+	for _, tag := range tagList {
+		result = append(result, &models.TagDto{TagName: tag.TagName, Equal: tag.Equal, TagValue: append([]string{}, tag.TagValue...)})
+	}
+	return
+}
+
+func parseDisplayConfig(input interface{}) (result models.DisplayConfig) {
+	var b []byte
+	if v, ok := input.(string); ok {
+		b = []byte(v)
+	} else {
+		b, _ = json.Marshal(input)
+	}
+	json.Unmarshal(b, &result)
+	if result.W <= 0 {
+		result.W = 6
+	}
+	if result.H <= 0 {
+		result.H = 8
+	}
+	return
+}
+
+func buildGrafanaUnit(unit string) string {
+	if v, b := grafanaExportUnitMap[unit]; b {
+		return v
+	}
+	if unit == "" {
+		return ""
+	}
+	return "suffix:" + unit
+}
+
+func buildGrafanaRefId(index int) string {
+	if index < 26 {
+		return string(rune('A' + index))
+	}
+	return fmt.Sprintf("Q%d", index)
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func TestGrafanaAppendChart(t *testing.T) {
+	ctx := &grafanaImportContext{variableMap: make(map[string]string), unresolvedMap: make(map[string][]string)}
+	result := &models.CustomDashboardExportDto{Charts: []*models.CustomChartDto{}}
+	importResult := &models.GrafanaImportResult{ChartMap: ctx.unresolvedMap, SkipPanels: []string{}, ConvertPanels: []string{}}
+	// 层级对象下两个对象导出的两个查询带同一份配置,另一条配置只有标签不同
+	groupSeries := &models.GrafanaOpenMonitorSeries{ServiceGroup: "sg", Endpoint: "sg", MonitorType: "host", Metric: "cpu_used"}
+	tagSeries := &models.GrafanaOpenMonitorSeries{ServiceGroup: "sg", Endpoint: "sg", MonitorType: "host", Metric: "cpu_used",
+		Tags: []*models.TagDto{{TagName: "cpu", Equal: ConstEqualIn, TagValue: []string{"0"}}}}
+	panel := &models.GrafanaPanel{Id: 1, Type: models.GrafanaPanelTimeSeries, Title: "cpu", Targets: []*models.GrafanaTarget{
+		{RefId: "A", Expr: "a", OpenMonitor: groupSeries},
+		{RefId: "B", Expr: "b", OpenMonitor: &models.GrafanaOpenMonitorSeries{ServiceGroup: "sg", Endpoint: "sg", MonitorType: "host", Metric: "cpu_used"}},
+		{RefId: "C", Expr: "c", OpenMonitor: tagSeries},
+	}}
+	ctx.appendChart(result, importResult, panel, "", 0)
+	if len(result.Charts) != 1 || len(result.Charts[0].ChartSeries) != 2 {
+		t.Fatalf("want 1 chart with 2 series, got %d charts", len(result.Charts))
+	}
+
+	ctx.appendChart(result, importResult, &models.GrafanaPanel{Id: 2, Type: models.GrafanaPanelStat, Title: "up"}, "", 0)
+	ctx.appendChart(result, importResult, &models.GrafanaPanel{Id: 3, Type: "text", Title: "note"}, "", 0)
+	if len(result.Charts) != 2 || len(importResult.ConvertPanels) != 1 || importResult.ConvertPanels[0] != "up(stat)" {
+		t.Errorf("stat panel should be converted and reported, got charts %d convert %v", len(result.Charts), importResult.ConvertPanels)
+	}
+	if len(importResult.SkipPanels) != 1 || importResult.SkipPanels[0] != "note(text)" {
+		t.Errorf("text panel should be skipped, got %v", importResult.SkipPanels)
+	}
+}
+
+func TestBuildGrafanaUnit(t *testing.T) {
+	for unit, want := range map[string]string{"%": "percent", "B": "bytes", "": "", "MB": "suffix:MB"} {
+		for i := 0; i < 10; i++ {
+			if got := buildGrafanaUnit(unit); got != want {
+				t.Fatalf("buildGrafanaUnit(%q): want %q, got %q", unit, want, got)
+			}
+		}
+		if want != "" && convertGrafanaUnit(want) != unit {
+			t.Errorf("unit %q should be converted back, got %q", unit, convertGrafanaUnit(want))
+		}
+	}
+}
+
+func TestGrafanaTemplateVarRoundTrip(t *testing.T) {
+	variableList := []*models.CustomDashboardVariable{
+		{Guid: "dv_1", CustomDashboard: 1, Name: "host", DisplayName: "Host", Type: models.DashboardVariableEndpoint, MonitorType: "host", DefaultValue: "a_host,b_host"},
+		{Guid: "dv_2", CustomDashboard: 1, Name: "step", Type: models.DashboardVariableInterval, Options: "1m,5m"},
+	}
+	templateList := []*models.GrafanaTemplateVar{}
+	for _, variable := range variableList {
+		templateList = append(templateList, buildGrafanaTemplateVar(variable, nil))
+	}
+	if templateList[0].Type != "custom" || !templateList[0].Multi || templateList[0].Query != "a_host,b_host" {
+		t.Errorf("endpoint variable should export as multi custom variable, got %+v", templateList[0])
+	}
+	if templateList[1].Type != "interval" || templateList[1].Multi || templateList[1].Current.Value != "1m" {
+		t.Errorf("interval variable should export as interval variable with first option, got %+v", templateList[1])
+	}
+	if templateList[0].OpenMonitor.Guid != "" || templateList[0].OpenMonitor.CustomDashboard != 0 || variableList[0].Guid != "dv_1" {
+		t.Errorf("exported variable should not carry guid and dashboard")
+	}
+
+	// 再导入时按原配置还原,数据源变量和重名的变量不转
+	templateList = append(templateList, &models.GrafanaTemplateVar{Name: models.GrafanaDatasourceVar, Type: "datasource"},
+		&models.GrafanaTemplateVar{Name: "step", Type: "interval", Query: "1h"}, &models.GrafanaTemplateVar{Name: "instance", Type: "custom"})
+	ctx := &grafanaImportContext{variableMap: map[string]string{"step": "1h"}, dashboardVariableMap: make(map[string]*models.CustomDashboardVariable)}
+	importResult := &models.GrafanaImportResult{SkipVariables: []string{}}
+	result := ctx.convertVariables(templateList, importResult)
+	if len(result) != 2 || result[0].Name != "host" || result[0].Type != models.DashboardVariableEndpoint || result[0].DefaultValue != "a_host,b_host" || result[1].SortIndex != 2 {
+		t.Fatalf("unexpected import variables %+v", result)
+	}
+	if len(importResult.SkipVariables) != 2 {
+		t.Errorf("duplicate and unresolved variables should be skipped, got %v", importResult.SkipVariables)
+	}
+	for input, want := range map[string]string{"$host": "host", "${step}": "step", "[[host]]": "host", "$host.*": "", "$other": ""} {
+		variable := ctx.getDashboardVariable(input)
+		if (variable == nil && want != "") || (variable != nil && variable.Name != want) {
+			t.Errorf("getDashboardVariable(%q): want %q, got %+v", input, want, variable)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package db
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const alarmCorrelationDefaultWindow = 300
+
+// 同一批告警可能并发进来,串行做聚合避免同一关联键开出多个事件
+var alarmIncidentLock sync.Mutex
+
+func ListAlarmCorrelationRule() (result []*models.AlarmCorrelationRuleObj, err error) {
+	result = []*models.AlarmCorrelationRuleObj{}
+	var ruleRows []*models.AlarmCorrelationRuleTable
+	if err = x.SQL("select * from alarm_correlation_rule order by sort_index,name").Find(&ruleRows); err != nil {
+		err = fmt.Errorf("query alarm correlation rule table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range ruleRows {
+		result = append(result, &models.AlarmCorrelationRuleObj{Guid: row.Guid, Name: row.Name, MatchType: row.MatchType, ServiceGroup: row.ServiceGroup, TimeWindow: row.TimeWindow,
+			SortIndex: row.SortIndex, Enable: row.Enable, UpdateUser: row.UpdateUser, UpdateTime: row.UpdateTime.Format(models.DatetimeFormat)})
+	}
+	return
+}
+
+func ValidateAlarmCorrelationRule(param *models.AlarmCorrelationRuleObj) error {
+	switch param.MatchType {
+	case models.IncidentMatchHostIp:
+		param.ServiceGroup = ""
+	case models.IncidentMatchServiceGroup, models.IncidentMatchTime:
+		// 按时间关联必须限定层级对象,否则全系统同一时间窗口的告警都会归到一个事件
+		if param.MatchType == models.IncidentMatchTime && param.ServiceGroup == "" {
+			return fmt.Errorf("match_type:time need service_group")
+		}
+		if param.ServiceGroup != "" {
+			if _, err := fetchGlobalServiceGroupParentGuidList(param.ServiceGroup); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("match_type:%s illegal,should be host_ip|service_group|time", param.MatchType)
+	}
+	if param.TimeWindow <= 0 {
+		param.TimeWindow = alarmCorrelationDefaultWindow
+	}
+	return nil
+}
+
+func CreateAlarmCorrelationRule(param *models.AlarmCorrelationRuleObj, operator string) error {
+	param.Guid = "acr_" + guid.CreateGuid()
+	_, err := x.Exec("insert into alarm_correlation_rule(guid,name,match_type,service_group,time_window,sort_index,enable,update_user,update_time) values (?,?,?,?,?,?,?,?,?)",
+		param.Guid, param.Name, param.MatchType, param.ServiceGroup, param.TimeWindow, param.SortIndex, param.Enable, operator, time.Now())
+	if err != nil {
+		err = fmt.Errorf("insert alarm correlation rule fail,%s ", err.Error())
+	}
+	return err
+}
+
+func UpdateAlarmCorrelationRule(param *models.AlarmCorrelationRuleObj, operator string) error {
+	_, err := x.Exec("update alarm_correlation_rule set name=?,match_type=?,service_group=?,time_window=?,sort_index=?,enable=?,update_user=?,update_time=? where guid=?",
+		param.Name, param.MatchType, param.ServiceGroup, param.TimeWindow, param.SortIndex, param.Enable, operator, time.Now(), param.Guid)
+	if err != nil {
+		err = fmt.Errorf("update alarm correlation rule fail,%s ", err.Error())
+	}
+	return err
+}
+
+// DeleteAlarmCorrelationRule 删除规则,已产生的事件保留
+func DeleteAlarmCorrelationRule(ruleGuid string) error {
+	_, err := x.Exec("delete from alarm_correlation_rule where guid=?", ruleGuid)
+	if err != nil {
+		err = fmt.Errorf("delete alarm correlation rule fail,%s ", err.Error())
+	}
+	return err
+}
+
+// CorrelateAlarmIncident 把新产生的firing告警按聚合规则归入事件,恢复的告警刷新所属事件状态
+func CorrelateAlarmIncident(alarms []*models.AlarmHandleObj) {
+	var ruleRows []*models.AlarmCorrelationRuleTable
+	if err := x.SQL("select * from alarm_correlation_rule where enable=1 order by sort_index,name").Find(&ruleRows); err != nil {
+		log.Logger.Error("Correlate alarm incident fail,query rule table error", log.Error(err))
+		return
+	}
+	alarmIncidentLock.Lock()
+	defer alarmIncidentLock.Unlock()
+	for _, alarmObj := range alarms {
+		if alarmObj.Id <= 0 || alarmObj.AlarmConditionGuid != "" {
+			continue
+		}
+		if alarmObj.Status == "firing" {
+			if len(ruleRows) > 0 {
+				joinAlarmIncident(alarmObj, ruleRows)
+			}
+		} else {
+			finishAlarmIncident(alarmObj.Id)
+		}
+	}
+}
+
+func joinAlarmIncident(alarmObj *models.AlarmHandleObj, ruleRows []*models.AlarmCorrelationRuleTable) {
+	existRows, _ := x.QueryString("select incident from alarm_incident_alarm where alarm_id=?", alarmObj.Id)
+	if len(existRows) > 0 {
+		return
+	}
+	nowTime := time.Now()
+	var endpointRows []*models.EndpointNewTable
+	x.SQL("select guid,ip,monitor_type from endpoint_new where guid=?", alarmObj.Endpoint).Find(&endpointRows)
+	endpointIp := ""
+	if len(endpointRows) > 0 {
+		endpointIp = endpointRows[0].Ip
+	}
+	var serviceGroupParents [][]string
+	var directServiceGroups []string
+	if strings.HasPrefix(alarmObj.Endpoint, "sg__") {
+		directServiceGroups = append(directServiceGroups, alarmObj.Endpoint[4:])
+	}
+	serviceGroupRows, _ := x.QueryString("select distinct service_group from endpoint_service_rel where endpoint=?", alarmObj.Endpoint)
+	for _, row := range serviceGroupRows {
+		directServiceGroups = append(directServiceGroups, row["service_group"])
+	}
+	for _, serviceGroup := range directServiceGroups {
+		if tmpGuidList, err := fetchGlobalServiceGroupParentGuidList(serviceGroup); err == nil && len(tmpGuidList) > 0 {
+			serviceGroupParents = append(serviceGroupParents, tmpGuidList)
+		}
+	}
+	var firstRule *models.AlarmCorrelationRuleTable
+	var firstKey string
+	for _, rule := range ruleRows {
+		correlationKey := ""
+		switch rule.MatchType {
+		case models.IncidentMatchHostIp:
+			correlationKey = endpointIp
+		case models.IncidentMatchServiceGroup:
+			// 同一层级对象子树下的告警关联,未指定层级对象时按所属根层级对象关联
+			for _, parentList := range serviceGroupParents {
+				if rule.ServiceGroup == "" {
+					correlationKey = parentList[len(parentList)-1]
+				} else if stringInList(rule.ServiceGroup, parentList) {
+					correlationKey = rule.ServiceGroup
+				}
+				if correlationKey != "" {
+					break
+				}
+			}
+		case models.IncidentMatchTime:
+			// 限定层级对象子树下同一时间窗口内的告警关联
+			for _, parentList := range serviceGroupParents {
+				if rule.ServiceGroup != "" && stringInList(rule.ServiceGroup, parentList) {
+					correlationKey = rule.ServiceGroup
+					break
+				}
+			}
+		}
+		if correlationKey == "" {
+			continue
+		}
+		if firstRule == nil {
+			firstRule, firstKey = rule, correlationKey
+		}
+		var incidentRows []*models.AlarmIncidentTable
+		x.SQL("select * from alarm_incident where rule=? and correlation_key=? and status='firing' and last_time>=? order by id desc limit 1",
+			rule.Guid, correlationKey, nowTime.Add(time.Duration(-rule.TimeWindow)*time.Second)).Find(&incidentRows)
+		if len(incidentRows) > 0 {
+			addAlarmIncidentMember(incidentRows[0], alarmObj, nowTime)
+			return
+		}
+	}
+	if firstRule == nil {
+		return
+	}
+	execResult, err := x.Exec("insert into alarm_incident(rule,correlation_key,status,s_priority,alarm_count,start,last_time,update_time) values (?,?,'firing',?,0,?,?,?)",
+		firstRule.Guid, firstKey, alarmObj.SPriority, nowTime, nowTime, nowTime)
+	if err != nil {
+		log.Logger.Error("Insert alarm incident fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+		return
+	}
+	incidentId, _ := execResult.LastInsertId()
+	addAlarmIncidentMember(&models.AlarmIncidentTable{Id: int(incidentId), SPriority: alarmObj.SPriority}, alarmObj, nowTime)
+}
+
+func addAlarmIncidentMember(incident *models.AlarmIncidentTable, alarmObj *models.AlarmHandleObj, nowTime time.Time) {
+	if _, err := x.Exec("insert ignore into alarm_incident_alarm(incident,alarm_id,join_time) values (?,?,?)", incident.Id, alarmObj.Id, nowTime); err != nil {
+		log.Logger.Error("Insert alarm incident member fail", log.Int("incident", incident.Id), log.Int("alarmId", alarmObj.Id), log.Error(err))
+		return
+	}
+	// 事件内第一条开启了通知的告警代表整个事件发通知
+	notifyAlarm := incident.NotifyAlarm
+	if notifyAlarm == 0 && alarmObj.NotifyEnable != 0 {
+		notifyAlarm = alarmObj.Id
+	}
+	priority := incident.SPriority
+	if getAlarmPriorityWeight(alarmObj.SPriority) > getAlarmPriorityWeight(priority) {
+		priority = alarmObj.SPriority
+	}
+	_, err := x.Exec("update alarm_incident set alarm_count=alarm_count+1,last_time=?,notify_alarm=?,s_priority=?,root_alarm=?,update_time=? where id=?",
+		nowTime, notifyAlarm, priority, suggestAlarmIncidentRoot(incident.Id), nowTime, incident.Id)
+	if err != nil {
+		log.Logger.Error("Update alarm incident fail", log.Int("incident", incident.Id), log.Error(err))
+	}
+}
+
+// suggestAlarmIncidentRoot 推荐根因告警:ping探测和主机对象的告警优先,其次是最早开始的告警
+func suggestAlarmIncidentRoot(incidentId int) (alarmId int) {
+	queryRows, _ := x.QueryString("select a.id from alarm_incident_alarm ia join alarm a on ia.alarm_id=a.id left join endpoint_new e on a.endpoint=e.guid where ia.incident=? "+
+		"order by case e.monitor_type when 'ping' then 0 when 'host' then 1 else 2 end,a.start,a.id limit 1", incidentId)
+	if len(queryRows) > 0 {
+		fmt.Sscanf(queryRows[0]["id"], "%d", &alarmId)
+	}
+	return
+}
+
+func getAlarmPriorityWeight(priority string) int {
+	switch priority {
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+// finishAlarmIncident 事件内告警全部恢复或关闭后结束事件,记录最后恢复的告警用于发恢复通知
+func finishAlarmIncident(alarmId int) {
+	if _, err := x.Exec("update alarm_incident set status='ok',end_alarm=?,end=?,update_time=? where status='firing' and id in (select incident from alarm_incident_alarm where alarm_id=?) "+
+		"and not exists (select 1 from alarm_incident_alarm ia join alarm a on ia.alarm_id=a.id where ia.incident=alarm_incident.id and a.status='firing')", alarmId, time.Now(), time.Now(), alarmId); err != nil {
+		log.Logger.Error("Finish alarm incident fail", log.Int("alarmId", alarmId), log.Error(err))
+	}
+}
+
+// getAlarmIncidentCloseAction 手动关闭告警时同步结束事件,不触发恢复通知
+func getAlarmIncidentCloseAction(alarmId int) *Action {
+	return &Action{Sql: "update alarm_incident set status='ok',end=NOW(),update_time=NOW() where status='firing' and id in (select incident from alarm_incident_alarm where alarm_id=?) " +
+		"and not exists (select 1 from alarm_incident_alarm ia join alarm a on ia.alarm_id=a.id where ia.incident=alarm_incident.id and a.status='firing')", Param: []interface{}{alarmId}}
+}
+
+// getAlarmIncidentNotifyAlarms 同一事件里每个阈值配置只由第一条告警发firing通知,保证各阈值配置的接收人都能收到;
+// 事件结束时由最后恢复的告警给所有发过firing通知的告警发恢复通知,返回需要发通知的告警或是否跳过
+func getAlarmIncidentNotifyAlarms(alarmObj *models.AlarmHandleObj) (notifyAlarms []*models.AlarmHandleObj, skip bool) {
+	var incidentRows []*models.AlarmIncidentTable
+	x.SQL("select i.* from alarm_incident i join alarm_incident_alarm ia on i.id=ia.incident where ia.alarm_id=?", alarmObj.Id).Find(&incidentRows)
+	if len(incidentRows) == 0 {
+		return
+	}
+	incident := incidentRows[0]
+	if alarmObj.Status == "firing" {
+		alarmIncidentLock.Lock()
+		defer alarmIncidentLock.Unlock()
+		queryRows, _ := x.QueryString("select ia.alarm_id from alarm_incident_alarm ia join alarm a on ia.alarm_id=a.id where ia.incident=? and ia.notified=1 and a.alarm_strategy=? and ia.alarm_id<>? limit 1",
+			incident.Id, alarmObj.AlarmStrategy, alarmObj.Id)
+		if len(queryRows) > 0 {
+			skip = true
+			return
+		}
+		if _, err := x.Exec("update alarm_incident_alarm set notified=1 where alarm_id=?", alarmObj.Id); err != nil {
+			log.Logger.Error("Update alarm incident member notified fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+		}
+		return
+	}
+	if incident.Status != "ok" || incident.EndAlarm != alarmObj.Id {
+		skip = true
+		return
+	}
+	// 由发过firing通知的告警发恢复通知,保证和firing通知的接收人一致
+	var alarmRows []*models.AlarmTable
+	x.SQL("select a.* from alarm a join alarm_incident_alarm ia on a.id=ia.alarm_id where ia.incident=? and ia.notified=1 order by a.id", incident.Id).Find(&alarmRows)
+	if len(alarmRows) == 0 {
+		skip = true
+		return
+	}
+	for _, row := range alarmRows {
+		if row.Id == alarmObj.Id {
+			notifyAlarms = append(notifyAlarms, alarmObj)
+			continue
+		}
+		tmpAlarm := &models.AlarmHandleObj{AlarmTable: *row, NotifyEnable: 1, NotifyDelay: alarmObj.NotifyDelay}
+		tmpAlarm.Status = "ok"
+		notifyAlarms = append(notifyAlarms, tmpAlarm)
+	}
+	return
+}
+
+// checkAlarmIncidentEscalationSkip 归属同一事件的告警,每个阈值配置只由发过通知的那条告警升级,都还没发通知时由id最小的升级,
+// 和getAlarmIncidentNotifyAlarms一样保证一个事件每个阈值配置每步只发一次
+func checkAlarmIncidentEscalationSkip(alarmId int, alarmStrategy string) bool {
+	queryRows, err := x.QueryString("select ia.alarm_id from alarm_incident_alarm ia join alarm a on ia.alarm_id=a.id where ia.incident in (select incident from alarm_incident_alarm where alarm_id=?) and a.alarm_strategy=? order by ia.notified desc,ia.alarm_id limit 1",
+		alarmId, alarmStrategy)
+	if err != nil {
+		log.Logger.Error("Query alarm incident member fail", log.Int("alarmId", alarmId), log.Error(err))
+		return false
+	}
+	return len(queryRows) > 0 && queryRows[0]["alarm_id"] != strconv.Itoa(alarmId)
+}
+
+func ListAlarmIncident(param *models.AlarmIncidentQueryParam) (pageInfo models.PageInfo, result []*models.AlarmIncidentObj, err error) {
+	result = []*models.AlarmIncidentObj{}
+	var params []interface{}
+	baseSql := "select * from alarm_incident where 1=1 "
+	if param.Status != "" {
+		baseSql += " and status=? "
+		params = append(params, param.Status)
+	}
+	if param.StartTime != "" {
+		baseSql += " and start>=? "
+		params = append(params, param.StartTime)
+	}
+	if param.EndTime != "" {
+		baseSql += " and start<=? "
+		params = append(params, param.EndTime)
+	}
+	baseSql += " order by id desc "
+	pageInfo.StartIndex = param.StartIndex
+	pageInfo.PageSize = param.PageSize
+	pageInfo.TotalRows = queryCount(baseSql, params...)
+	baseSql += " limit ?,? "
+	params = append(params, param.StartIndex, param.PageSize)
+	var incidentRows []*models.AlarmIncidentTable
+	if err = x.SQL(baseSql, params...).Find(&incidentRows); err != nil {
+		err = fmt.Errorf("query alarm incident table fail,%s ", err.Error())
+		return
+	}
+	ruleMap := getAlarmCorrelationRuleMap()
+	for _, row := range incidentRows {
+		tmpObj := buildAlarmIncidentObj(row, ruleMap)
+		if rootAlarm := getAlarmIncidentItems("a.id=?", row.RootAlarm); len(rootAlarm) > 0 {
+			tmpObj.RootAlarm = rootAlarm[0]
+			tmpObj.RootAlarm.IsRoot = true
+		}
+		result = append(result, tmpObj)
+	}
+	return
+}
+
+func GetAlarmIncident(incidentId int) (result *models.AlarmIncidentObj, err error) {
+	var incidentRows []*models.AlarmIncidentTable
+	if err = x.SQL("select * from alarm_incident where id=?", incidentId).Find(&incidentRows); err != nil {
+		err = fmt.Errorf("query alarm incident table fail,%s ", err.Error())
+		return
+	}
+	if len(incidentRows) == 0 {
+		err = fmt.Errorf("can not find alarm incident with id:%d ", incidentId)
+		return
+	}
+	result = buildAlarmIncidentObj(incidentRows[0], getAlarmCorrelationRuleMap())
+	result.Alarms = getAlarmIncidentItems("a.id in (select alarm_id from alarm_incident_alarm where incident=?)", incidentId)
+	for _, item := range result.Alarms {
+		if item.Id == incidentRows[0].RootAlarm {
+			item.IsRoot = true
+			result.RootAlarm = item
+		}
+	}
+	return
+}
+
+func buildAlarmIncidentObj(row *models.AlarmIncidentTable, ruleMap map[string]*models.AlarmCorrelationRuleTable) *models.AlarmIncidentObj {
+	tmpObj := models.AlarmIncidentObj{Id: row.Id, Rule: row.Rule, CorrelationKey: row.CorrelationKey, Status: row.Status, SPriority: row.SPriority, AlarmCount: row.AlarmCount,
+		Start: row.Start.Format(models.DatetimeFormat), LastTime: row.LastTime.Format(models.DatetimeFormat)}
+	if !row.End.IsZero() {
+		tmpObj.End = row.End.Format(models.DatetimeFormat)
+	}
+	if rule, b := ruleMap[row.Rule]; b {
+		tmpObj.RuleName, tmpObj.MatchType = rule.Name, rule.MatchType
+	}
+	return &tmpObj
+}
+
+func getAlarmCorrelationRuleMap() map[string]*models.AlarmCorrelationRuleTable {
+	ruleMap := make(map[string]*models.AlarmCorrelationRuleTable)
+	var ruleRows []*models.AlarmCorrelationRuleTable
+	x.SQL("select guid,name,match_type from alarm_correlation_rule").Find(&ruleRows)
+	for _, row := range ruleRows {
+		ruleMap[row.Guid] = row
+	}
+	return ruleMap
+}
+
+func getAlarmIncidentItems(filterSql string, param interface{}) (result []*models.AlarmIncidentItem) {
+	result = []*models.AlarmIncidentItem{}
+	var alarmRows []*models.AlarmTable
+	if err := x.SQL("select a.* from alarm a where "+filterSql+" order by a.start", param).Find(&alarmRows); err != nil {
+		log.Logger.Error("Query alarm incident member fail", log.Error(err))
+		return
+	}
+	for _, row := range alarmRows {
+		tmpItem := models.AlarmIncidentItem{Id: row.Id, Endpoint: row.Endpoint, Status: row.Status, SMetric: row.SMetric, SPriority: row.SPriority, AlarmName: row.AlarmName,
+			Content: row.Content, Tags: row.Tags, StartValue: row.StartValue, Start: row.Start.Format(models.DatetimeFormat)}
+		if row.Status != "firing" && !row.End.IsZero() {
+			tmpItem.End = row.End.Format(models.DatetimeFormat)
+		}
+		result = append(result, &tmpItem)
+	}
+	return
+}
@@ -29,7 +29,7 @@ func GetLogKeywordByServiceGroup(serviceGroupGuid, alarmName string) (result []*
 	}
 	var configList []*models.LogKeywordMonitorObj
 	for _, v := range logKeywordTable {
-		configObj := models.LogKeywordMonitorObj{Guid: v.Guid, ServiceGroup: serviceGroupGuid, LogPath: v.LogPath, MonitorType: v.MonitorType}
+		configObj := models.LogKeywordMonitorObj{Guid: v.Guid, ServiceGroup: serviceGroupGuid, LogPath: v.LogPath, MonitorType: v.MonitorType, LogMultilineConfig: v.GetMultilineConfig()}
 		if configObj.KeywordList, err = ListLogKeyword(v.Guid, alarmName); err != nil {
 			return
 		}
@@ -106,7 +106,8 @@ func CreateLogKeywordMonitor(param *models.LogKeywordMonitorCreateObj) (err erro
 		} else {
 			existLogPathMap[path] = 1
 		}
-		actions = append(actions, &Action{Sql: "insert into log_keyword_monitor(guid,service_group,log_path,monitor_type,update_time) value (?,?,?,?,?)", Param: []interface{}{logKeywordGuidList[i], param.ServiceGroup, path, param.MonitorType, nowTime}})
+		actions = append(actions, &Action{Sql: "insert into log_keyword_monitor(guid,service_group,log_path,monitor_type,update_time,multiline_start,multiline_continue,multiline_max_lines,multiline_timeout) value (?,?,?,?,?,?,?,?,?)", Param: []interface{}{logKeywordGuidList[i], param.ServiceGroup, path, param.MonitorType, nowTime,
+			param.MultilineStart, param.MultilineContinue, param.MultilineMaxLines, param.MultilineTimeout}})
 		endpointRelActions, tmpErr := getLogKeywordEndpointRelCreateAction(param.EndpointRel, logKeywordGuidList[i])
 		if tmpErr != nil {
 			err = tmpErr
@@ -147,7 +148,8 @@ func UpdateLogKeywordMonitor(param *models.LogKeywordMonitorObj) (err error) {
 	}
 	var actions []*Action
 	nowTime := time.Now().Format(models.DatetimeFormat)
-	actions = append(actions, &Action{Sql: "update log_keyword_monitor set log_path=?,monitor_type=?,update_time=? where guid=?", Param: []interface{}{param.LogPath, param.MonitorType, nowTime, param.Guid}})
+	actions = append(actions, &Action{Sql: "update log_keyword_monitor set log_path=?,monitor_type=?,update_time=?,multiline_start=?,multiline_continue=?,multiline_max_lines=?,multiline_timeout=? where guid=?", Param: []interface{}{param.LogPath, param.MonitorType, nowTime,
+		param.MultilineStart, param.MultilineContinue, param.MultilineMaxLines, param.MultilineTimeout, param.Guid}})
 	actions = append(actions, &Action{Sql: "delete from log_keyword_endpoint_rel where log_keyword_monitor=?", Param: []interface{}{param.Guid}})
 	endpointRelActions, tmpErr := getLogKeywordEndpointRelCreateAction(param.EndpointRel, param.Guid)
 	if tmpErr != nil {
@@ -274,8 +276,9 @@ func GetLogKeywordConfigUniqueData(guid, name, keyword, logKeywordMonitorGuid st
 func CreateLogKeyword(param *models.LogKeywordConfigTable, operator string) (err error) {
 	var actions []*Action
 	param.Guid = "lk_config_" + guid.CreateGuid()
-	actions = append(actions, &Action{Sql: "insert into log_keyword_config(guid,log_keyword_monitor,keyword,regulative,notify_enable,priority,update_time,content,name,active_window,update_user) value (?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-		param.Guid, param.LogKeywordMonitor, param.Keyword, param.Regulative, param.NotifyEnable, param.Priority, time.Now().Format(models.DatetimeFormat), param.Content, param.Name, param.ActiveWindow, operator}})
+	actions = append(actions, &Action{Sql: "insert into log_keyword_config(guid,log_keyword_monitor,keyword,regulative,notify_enable,priority,update_time,content,name,active_window,update_user,match_type,exclude_keyword,threshold_count,threshold_window) value (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+		param.Guid, param.LogKeywordMonitor, param.Keyword, param.Regulative, param.NotifyEnable, param.Priority, time.Now().Format(models.DatetimeFormat), param.Content, param.Name, param.ActiveWindow, operator,
+		param.MatchType, param.ExcludeKeyword, param.ThresholdCount, param.ThresholdWindow}})
 	if param.Notify != nil {
 		actions = append(actions, getNotifyListInsertAction([]*models.NotifyObj{param.Notify})...)
 		actions = append(actions, &Action{Sql: "insert into log_keyword_notify_rel(guid,log_keyword_config,notify) values (?,?,?)", Param: []interface{}{
@@ -303,8 +306,9 @@ func GetSimpleLogKeywordConfig(logKeywordConfigGuid string) (result *models.LogK
 
 func UpdateLogKeyword(param, existData *models.LogKeywordConfigTable, operator string) (err error) {
 	var actions []*Action
-	actions = append(actions, &Action{Sql: "update log_keyword_config set keyword=?,regulative=?,notify_enable=?,priority=?,update_time=?,content=?,name=?,active_window=?,update_user=? where guid=?", Param: []interface{}{
-		param.Keyword, param.Regulative, param.NotifyEnable, param.Priority, time.Now().Format(models.DatetimeFormat), param.Content, param.Name, param.ActiveWindow, operator, param.Guid}})
+	actions = append(actions, &Action{Sql: "update log_keyword_config set keyword=?,regulative=?,notify_enable=?,priority=?,update_time=?,content=?,name=?,active_window=?,update_user=?,match_type=?,exclude_keyword=?,threshold_count=?,threshold_window=? where guid=?", Param: []interface{}{
+		param.Keyword, param.Regulative, param.NotifyEnable, param.Priority, time.Now().Format(models.DatetimeFormat), param.Content, param.Name, param.ActiveWindow, operator,
+		param.MatchType, param.ExcludeKeyword, param.ThresholdCount, param.ThresholdWindow, param.Guid}})
 	if param.Notify != nil {
 		actions = append(actions, getNotifyListUpdateAction([]*models.NotifyObj{param.Notify})...)
 		actions = append(actions, &Action{Sql: "delete from log_keyword_notify_rel where log_keyword_config=?", Param: []interface{}{param.Guid}})
@@ -312,7 +316,7 @@ func UpdateLogKeyword(param, existData *models.LogKeywordConfigTable, operator s
 			guid.CreateGuid(), param.Guid, param.Notify.Guid,
 		}})
 	}
-	if existData.Name != param.Name || existData.Keyword != param.Keyword || existData.Priority != param.Priority {
+	if existData.Name != param.Name || existData.Keyword != param.Keyword || existData.Priority != param.Priority || existData.MatchType != param.MatchType || existData.ExcludeKeyword != param.ExcludeKeyword {
 		// 关键信息改了，把已有告警关闭
 		closeAlarmActions, tmpErr := getLogKeywordCloseAlarmActions(param.Guid)
 		if tmpErr != nil {
@@ -391,7 +395,7 @@ func doLogKeywordMonitorJob() {
 		return
 	}
 	var logKeywordConfigs []*models.LogKeywordCronJobQuery
-	x.SQL("select t1.guid,t1.service_group,t1.log_path,t1.monitor_type,t2.keyword,t2.notify_enable,t2.priority,t2.content,t2.name,t2.guid as log_keyword_config_guid,t2.active_window,t3.source_endpoint,t3.target_endpoint,t4.agent_address from log_keyword_monitor t1 left join log_keyword_config t2 on t1.guid=t2.log_keyword_monitor left join log_keyword_endpoint_rel t3 on t1.guid=t3.log_keyword_monitor left join endpoint_new t4 on t3.source_endpoint=t4.guid where t3.source_endpoint is not null").Find(&logKeywordConfigs)
+	x.SQL("select t1.guid,t1.service_group,t1.log_path,t1.monitor_type,t2.keyword,t2.exclude_keyword,t2.notify_enable,t2.priority,t2.content,t2.name,t2.guid as log_keyword_config_guid,t2.active_window,t2.threshold_count,t2.threshold_window,t3.source_endpoint,t3.target_endpoint,t4.agent_address from log_keyword_monitor t1 left join log_keyword_config t2 on t1.guid=t2.log_keyword_monitor left join log_keyword_endpoint_rel t3 on t1.guid=t3.log_keyword_monitor left join endpoint_new t4 on t3.source_endpoint=t4.guid where t3.source_endpoint is not null").Find(&logKeywordConfigs)
 	if len(logKeywordConfigs) == 0 {
 		log.Logger.Debug("Check log keyword break with empty config ")
 		return
@@ -410,6 +414,10 @@ func doLogKeywordMonitorJob() {
 		alarmMap[v.Tags] = v
 	}
 	var addAlarmRows []*models.AlarmTable
+	// 告警对应的匹配上下文,告警入库拿到id后保存
+	logContextMap := make(map[*models.AlarmTable]*models.AlarmLogContextTable)
+	// 按统计窗口(分钟)缓存窗口开始时的计数
+	historyDataMap := make(map[int]map[string]float64)
 	var newValue, oldValue float64
 	//notifyMap := make(map[string]string)
 	nowTime := time.Now()
@@ -422,8 +430,13 @@ func doLogKeywordMonitorJob() {
 			notifyConfigMap[config.LogKeywordConfigGuid] = 1
 		}
 		key := fmt.Sprintf("e_guid:%s^t_guid:%s^file:%s^keyword:%s", config.SourceEndpoint, config.TargetEndpoint, config.LogPath, config.Keyword)
+		// 告警标签保持原格式,取数据时带上排除条件
+		dataKey := key
+		if config.ExcludeKeyword != "" {
+			dataKey += "^exclude_keyword:" + config.ExcludeKeyword
+		}
 		newValue, oldValue = 0, 0
-		if dataValue, b := dataMap[key]; b {
+		if dataValue, b := dataMap[dataKey]; b {
 			newValue = dataValue
 		} else {
 			log.Logger.Debug("doLogKeywordMonitorJob ignore lgoKeywordConfig", log.String("key", key))
@@ -444,8 +457,11 @@ func doLogKeywordMonitorJob() {
 				continue
 			}
 			if existAlarm.Status == "firing" || !InActiveWindowList(config.ActiveWindow) {
-				existAlarm.Content = strings.Split(existAlarm.Content, "^^")[0] + "^^" + getLogKeywordLastRow(config.AgentAddress, config.LogPath, config.Keyword)
-				addAlarmRows = append(addAlarmRows, &models.AlarmTable{Id: existAlarm.AlarmId, Status: existAlarm.Status, EndValue: newValue, Content: existAlarm.Content, End: nowTime})
+				lastRow, fetchObj := getLogKeywordLastRow(config.AgentAddress, config.LogPath, config.Keyword)
+				existAlarm.Content = strings.Split(existAlarm.Content, "^^")[0] + "^^" + lastRow
+				tmpAlarmRow := models.AlarmTable{Id: existAlarm.AlarmId, Status: existAlarm.Status, EndValue: newValue, Content: existAlarm.Content, End: nowTime}
+				addAlarmRows = append(addAlarmRows, &tmpAlarmRow)
+				logContextMap[&tmpAlarmRow] = buildAlarmLogContext(config.LogPath, config.Keyword, fetchObj)
 			} else {
 				addFlag = true
 			}
@@ -454,13 +470,35 @@ func doLogKeywordMonitorJob() {
 				addFlag = true
 			}
 		}
+		// 配置了次数阈值时,时间窗口内新增的次数超过阈值才告警
+		alarmCond, alarmLast := ">0", "10s"
+		if addFlag && config.ThresholdCount > 0 {
+			historyValue, getHistoryErr := getLogKeywordHistoryValue(historyDataMap, config.ThresholdWindow, dataKey)
+			if getHistoryErr != nil {
+				log.Logger.Error("doLogKeywordMonitorJob get history value fail", log.String("key", key), log.Error(getHistoryErr))
+				continue
+			}
+			increaseValue := newValue - historyValue
+			if historyValue > newValue {
+				// 计数从0重新开始(agent重启)
+				increaseValue = newValue
+			}
+			if increaseValue <= float64(config.ThresholdCount) {
+				log.Logger.Debug("doLogKeywordMonitorJob ignore lgoKeywordConfig under threshold", log.String("key", key), log.Float64("increase", increaseValue))
+				continue
+			}
+			alarmCond, alarmLast = fmt.Sprintf(">%d", config.ThresholdCount), fmt.Sprintf("%dm", config.ThresholdWindow)
+		}
 		if addFlag {
 			//if config.NotifyEnable > 0 {
 			//	notifyMap[key] = config.ServiceGroup
 			//}
 			alarmContent := config.Content
 			alarmContent = alarmContent + "<br/>"
-			addAlarmRows = append(addAlarmRows, &models.AlarmTable{StrategyId: 0, Endpoint: config.TargetEndpoint, Status: "firing", SMetric: "log_monitor", SExpr: "node_log_monitor_count_total", SCond: ">0", SLast: "10s", SPriority: config.Priority, Content: alarmContent + getLogKeywordLastRow(config.AgentAddress, config.LogPath, config.Keyword), Tags: key, StartValue: newValue, Start: nowTime, AlarmName: config.Name, AlarmStrategy: config.LogKeywordConfigGuid})
+			lastRow, fetchObj := getLogKeywordLastRow(config.AgentAddress, config.LogPath, config.Keyword)
+			tmpAlarmRow := models.AlarmTable{StrategyId: 0, Endpoint: config.TargetEndpoint, Status: "firing", SMetric: "log_monitor", SExpr: "node_log_monitor_count_total", SCond: alarmCond, SLast: alarmLast, SPriority: config.Priority, Content: alarmContent + lastRow, Tags: key, StartValue: newValue, Start: nowTime, AlarmName: config.Name, AlarmStrategy: config.LogKeywordConfigGuid}
+			addAlarmRows = append(addAlarmRows, &tmpAlarmRow)
+			logContextMap[&tmpAlarmRow] = buildAlarmLogContext(config.LogPath, config.Keyword, fetchObj)
 		}
 	}
 	if len(addAlarmRows) == 0 {
@@ -470,12 +508,18 @@ func doLogKeywordMonitorJob() {
 		if tmpErr := doLogKeywordDBAction(v); tmpErr != nil {
 			log.Logger.Error("Update log keyword alarm table fail", log.String("tags", v.Tags), log.Error(tmpErr))
 		} else {
-			if v.Id <= 0 {
+			if v.Id > 0 {
+				saveAlarmLogContext(v.Id, logContextMap[v])
+			} else {
+				// 先保存上下文,通知内容里需要带上
+				tmpAlarmObj := getSimpleAlarmByLogKeywordTags(v.Tags)
+				if tmpAlarmObj.Id > 0 {
+					saveAlarmLogContext(tmpAlarmObj.Id, logContextMap[v])
+				}
 				if _, b := notifyConfigMap[v.AlarmStrategy]; !b {
 					log.Logger.Warn("Log keyword monitor notify disable,ignore", log.String("logKeywordConfig", v.AlarmStrategy))
 					continue
 				}
-				tmpAlarmObj := getSimpleAlarmByLogKeywordTags(v.Tags)
 				if tmpAlarmObj.Id <= 0 {
 					log.Logger.Warn("Log keyword monitor notify fail,query alarm with tags fail", log.String("tags", v.Tags))
 					continue
@@ -500,6 +544,22 @@ func calcAlarmUniqueFlag(input *models.AlarmTable) {
 	input.EndpointTags = fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%d_%s_%s_%d_%s", input.StrategyId, input.Endpoint, input.SMetric, input.Start.Unix(), input.Tags))))
 }
 
+func getLogKeywordHistoryValue(historyDataMap map[int]map[string]float64, windowMinute int, key string) (value float64, err error) {
+	if windowMinute <= 0 {
+		windowMinute = 1
+	}
+	historyData, b := historyDataMap[windowMinute]
+	if !b {
+		if historyData, err = datasource.QueryLogKeywordHistoryData("log", int64(windowMinute*60)); err != nil {
+			return
+		}
+		historyDataMap[windowMinute] = historyData
+	}
+	// 窗口开始时还没有数据的,按0计算
+	value = historyData[key]
+	return
+}
+
 func getSimpleAlarmByLogKeywordTags(tags string) (result models.AlarmTable) {
 	var alarmTable []*models.AlarmTable
 	x.SQL("select * from alarm where tags=? and status='firing' order by id desc limit 1", tags).Find(&alarmTable)
@@ -509,23 +569,22 @@ func getSimpleAlarmByLogKeywordTags(tags string) (result models.AlarmTable) {
 	return
 }
 
-func getLogKeywordLastRow(address, path, keyword string) string {
-	var result string
+func getLogKeywordLastRow(address, path, keyword string) (result string, fetchObj *models.LogKeywordFetchObj) {
 	if address == "" || path == "" || keyword == "" {
-		return result
+		return
 	}
 	param := models.LogKeywordRowsHttpDto{Path: path, Keyword: keyword}
 	postData, _ := json.Marshal(param)
 	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/log_keyword/rows", address), strings.NewReader(string(postData)))
 	if err != nil {
 		log.Logger.Error("Get log keyword rows fail,new request error", log.Error(err))
-		return result
+		return
 	}
 	req.Header.Set("Content-Type", "application/json")
 	resp, respErr := http.DefaultClient.Do(req)
 	if respErr != nil {
 		log.Logger.Error("Get log keyword rows fail,response error", log.Error(respErr))
-		return result
+		return
 	}
 	var responseData models.LogKeywordRowsHttpResult
 	respBytes, _ := ioutil.ReadAll(resp.Body)
@@ -533,16 +592,17 @@ func getLogKeywordLastRow(address, path, keyword string) string {
 	err = json.Unmarshal(respBytes, &responseData)
 	if err != nil {
 		log.Logger.Error("Get log keyword rows fail,response data json unmarshal error", log.Error(err))
-		return result
+		return
 	}
 	if responseData.Status != "ok" {
 		log.Logger.Error("Get log keyword rows fail,response status error", log.String("status", responseData.Status), log.String("message", responseData.Message))
-		return result
+		return
 	}
 	for _, v := range responseData.Data {
 		result = v.Content
+		fetchObj = v
 	}
-	return result
+	return
 }
 
 func ImportLogAndDbKeyword(param *models.LogKeywordServiceGroupObj, operator string) (err error) {
@@ -571,8 +631,9 @@ func ImportLogAndDbKeyword(param *models.LogKeywordServiceGroupObj, operator str
 
 	nowTime := time.Now().Format(models.DatetimeFormat)
 	for _, inputKeywordConfig := range param.Config {
-		actions = append(actions, &Action{Sql: "insert into log_keyword_monitor(guid,service_group,log_path,monitor_type,update_time,update_user) value (?,?,?,?,?,?)",
-			Param: []interface{}{inputKeywordConfig.Guid, inputKeywordConfig.ServiceGroup, inputKeywordConfig.LogPath, inputKeywordConfig.MonitorType, nowTime, operator}})
+		actions = append(actions, &Action{Sql: "insert into log_keyword_monitor(guid,service_group,log_path,monitor_type,update_time,update_user,multiline_start,multiline_continue,multiline_max_lines,multiline_timeout) value (?,?,?,?,?,?,?,?,?,?)",
+			Param: []interface{}{inputKeywordConfig.Guid, inputKeywordConfig.ServiceGroup, inputKeywordConfig.LogPath, inputKeywordConfig.MonitorType, nowTime, operator,
+				inputKeywordConfig.MultilineStart, inputKeywordConfig.MultilineContinue, inputKeywordConfig.MultilineMaxLines, inputKeywordConfig.MultilineTimeout}})
 		if inputKeywordConfig.Notify != nil {
 			inputKeywordConfig.Notify.EndpointGroup = ""
 			inputKeywordConfig.Notify.ServiceGroup = ""
@@ -585,9 +646,10 @@ func ImportLogAndDbKeyword(param *models.LogKeywordServiceGroupObj, operator str
 		}
 		for _, keywordObj := range inputKeywordConfig.KeywordList {
 			actions = append(actions, &Action{Sql: "insert into log_keyword_config(guid,log_keyword_monitor,keyword,regulative,notify_enable,priority," +
-				"update_time,name,content,active_window,create_time,update_user) value (?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{keywordObj.Guid,
+				"update_time,name,content,active_window,create_time,update_user,match_type,exclude_keyword,threshold_count,threshold_window) value (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{keywordObj.Guid,
 				keywordObj.LogKeywordMonitor, keywordObj.Keyword, keywordObj.Regulative, keywordObj.NotifyEnable, keywordObj.Priority,
-				nowTime, keywordObj.Name, keywordObj.Content, keywordObj.ActiveWindow, nowTime, operator}})
+				nowTime, keywordObj.Name, keywordObj.Content, keywordObj.ActiveWindow, nowTime, operator,
+				keywordObj.MatchType, keywordObj.ExcludeKeyword, keywordObj.ThresholdCount, keywordObj.ThresholdWindow}})
 			if keywordObj.Notify != nil {
 				keywordObj.Notify.EndpointGroup = ""
 				keywordObj.Notify.ServiceGroup = ""
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+// buildAlarmLogContext 把agent返回的最近一次匹配转成告警上下文记录
+func buildAlarmLogContext(path, keyword string, fetchObj *models.LogKeywordFetchObj) *models.AlarmLogContextTable {
+	if fetchObj == nil {
+		return nil
+	}
+	result := models.AlarmLogContextTable{LogPath: path, Keyword: keyword, Content: fetchObj.Content, UpdateTime: time.Now()}
+	if fetchObj.Time > 0 {
+		result.MatchTime = time.Unix(fetchObj.Time, 0)
+	} else {
+		result.MatchTime = result.UpdateTime
+	}
+	beforeBytes, _ := json.Marshal(fetchObj.Before)
+	afterBytes, _ := json.Marshal(fetchObj.After)
+	windowCountBytes, _ := json.Marshal(fetchObj.WindowCounts)
+	result.BeforeRows = string(beforeBytes)
+	result.AfterRows = string(afterBytes)
+	result.WindowCounts = string(windowCountBytes)
+	return &result
+}
+
+// saveAlarmLogContext 告警开始时写入,之后只有匹配内容变化时才更新
+func saveAlarmLogContext(alarmId int, logContext *models.AlarmLogContextTable) {
+	if alarmId <= 0 || logContext == nil {
+		return
+	}
+	existContext, err := getAlarmLogContext(alarmId)
+	if err != nil {
+		log.Logger.Error("save alarm log context fail", log.Int("alarmId", alarmId), log.Error(err))
+		return
+	}
+	if existContext == nil {
+		_, err = x.Exec("insert into alarm_log_context(alarm_id,log_path,keyword,match_time,content,before_rows,after_rows,window_counts,update_time) values (?,?,?,?,?,?,?,?,?)",
+			alarmId, logContext.LogPath, logContext.Keyword, logContext.MatchTime.Format(models.DatetimeFormat), logContext.Content, logContext.BeforeRows, logContext.AfterRows, logContext.WindowCounts, logContext.UpdateTime.Format(models.DatetimeFormat))
+	} else if existContext.MatchTime.Unix() != logContext.MatchTime.Unix() || existContext.Content != logContext.Content || existContext.BeforeRows != logContext.BeforeRows ||
+		existContext.AfterRows != logContext.AfterRows || existContext.WindowCounts != logContext.WindowCounts {
+		_, err = x.Exec("update alarm_log_context set log_path=?,keyword=?,match_time=?,content=?,before_rows=?,after_rows=?,window_counts=?,update_time=? where alarm_id=?",
+			logContext.LogPath, logContext.Keyword, logContext.MatchTime.Format(models.DatetimeFormat), logContext.Content, logContext.BeforeRows, logContext.AfterRows, logContext.WindowCounts, logContext.UpdateTime.Format(models.DatetimeFormat), alarmId)
+	}
+	if err != nil {
+		log.Logger.Error("save alarm log context fail", log.Int("alarmId", alarmId), log.Error(err))
+	}
+}
+
+func getAlarmLogContext(alarmId int) (result *models.AlarmLogContextTable, err error) {
+	var logContextRows []*models.AlarmLogContextTable
+	err = x.SQL("select * from alarm_log_context where alarm_id=?", alarmId).Find(&logContextRows)
+	if err != nil {
+		err = fmt.Errorf("query alarm log context table fail,%s ", err.Error())
+		return
+	}
+	if len(logContextRows) > 0 {
+		result = logContextRows[0]
+	}
+	return
+}
+
+// appendLogKeywordAlarmContext 日志关键字告警的通知详情里追加匹配行的上下文和每分钟匹配次数
+func appendLogKeywordAlarmContext(alarmId int, sMetric, alarmDetail, splitChar string) string {
+	if sMetric != "log_monitor" || alarmId <= 0 {
+		return alarmDetail
+	}
+	logContext, err := getAlarmLogContext(alarmId)
+	if err != nil {
+		log.Logger.Error("get alarm log context fail", log.Int("alarmId", alarmId), log.Error(err))
+		return alarmDetail
+	}
+	if logContext == nil {
+		return alarmDetail
+	}
+	var beforeRows, afterRows []string
+	var windowCounts []*models.LogKeywordWindowCount
+	json.Unmarshal([]byte(logContext.BeforeRows), &beforeRows)
+	json.Unmarshal([]byte(logContext.AfterRows), &afterRows)
+	json.Unmarshal([]byte(logContext.WindowCounts), &windowCounts)
+	contextList := []string{fmt.Sprintf("Log:%s Time:%s", logContext.LogPath, logContext.MatchTime.Format(models.DatetimeFormat))}
+	contextList = append(contextList, beforeRows...)
+	contextList = append(contextList, ">> "+logContext.Content)
+	contextList = append(contextList, afterRows...)
+	if len(windowCounts) > 0 {
+		countList := []string{}
+		for _, v := range windowCounts {
+			countList = append(countList, fmt.Sprintf("%s=%.0f", time.Unix(v.Start, 0).Format("15:04"), v.Count))
+		}
+		contextList = append(contextList, "Match count per minute:"+strings.Join(countList, ","))
+	}
+	if alarmDetail != "" {
+		alarmDetail = alarmDetail + splitChar
+	}
+	return alarmDetail + strings.Join(contextList, splitChar)
+}
+
+// parseLogKeywordAlarmTags 从 e_guid:xx^t_guid:xx^file:xx^keyword:xx 中取出对象、日志路径和关键字
+func parseLogKeywordAlarmTags(tags string) (endpointGuid, path, keyword string) {
+	keywordIndex := strings.Index(tags, "^keyword:")
+	fileIndex := strings.Index(tags, "^file:")
+	targetIndex := strings.Index(tags, "^t_guid:")
+	if keywordIndex < 0 || fileIndex < 0 || targetIndex < 0 || !strings.HasPrefix(tags, "e_guid:") {
+		return
+	}
+	endpointGuid = tags[len("e_guid:"):targetIndex]
+	path = tags[fileIndex+len("^file:") : keywordIndex]
+	keyword = tags[keywordIndex+len("^keyword:"):]
+	return
+}
+
+// GetLogKeywordAlarmMatches 分页查询告警期间的关键字匹配,agent每个关键字只保留最近200条(logKeywordMatchBufferLength),
+// 更早的匹配查不到;agent不可用时返回保存的最近一次上下文
+func GetLogKeywordAlarmMatches(param *models.LogKeywordAlarmMatchQueryParam) (pageInfo models.PageInfo, result *models.LogKeywordAlarmMatchResp, err error) {
+	result = &models.LogKeywordAlarmMatchResp{Matches: []*models.LogKeywordMatchObj{}, WindowCounts: []*models.LogKeywordWindowCount{}}
+	pageInfo.StartIndex = param.StartIndex
+	pageInfo.PageSize = param.PageSize
+	var alarmRows []*models.AlarmTable
+	err = x.SQL("select * from alarm where id=?", param.AlarmId).Find(&alarmRows)
+	if err != nil {
+		err = fmt.Errorf("query alarm table with id:%d error:%s ", param.AlarmId, err.Error())
+		return
+	}
+	if len(alarmRows) == 0 {
+		err = fmt.Errorf("can not find alarm with id:%d ", param.AlarmId)
+		return
+	}
+	alarmObj := alarmRows[0]
+	if alarmObj.SMetric != "log_monitor" {
+		err = fmt.Errorf("alarm:%d is not log keyword alarm", param.AlarmId)
+		return
+	}
+	endpointGuid, path, keyword := parseLogKeywordAlarmTags(alarmObj.Tags)
+	endTime := time.Now()
+	if alarmObj.Status != "firing" && alarmObj.End.Unix() > 0 {
+		endTime = alarmObj.End
+	}
+	// 告警开始时间是检测任务的时间,匹配发生在它之前的一个检测周期内
+	queryParam := models.LogKeywordMatchHttpDto{Path: path, Keyword: keyword, StartTime: alarmObj.Start.Unix() - 10, EndTime: endTime.Unix(), StartIndex: param.StartIndex, PageSize: param.PageSize}
+	pageData, queryErr := queryAgentLogKeywordMatches(endpointGuid, &queryParam)
+	if queryErr == nil && pageData.Total > 0 {
+		pageInfo.TotalRows = pageData.Total
+		result.Matches = pageData.Matches
+		result.WindowCounts = pageData.WindowCounts
+		return
+	}
+	if queryErr != nil {
+		log.Logger.Warn("query agent log keyword matches fail,use saved context", log.Int("alarmId", param.AlarmId), log.Error(queryErr))
+	}
+	logContext, getContextErr := getAlarmLogContext(param.AlarmId)
+	if getContextErr != nil {
+		err = getContextErr
+		return
+	}
+	if logContext == nil {
+		return
+	}
+	matchObj := models.LogKeywordMatchObj{Time: logContext.MatchTime.Unix(), File: logContext.LogPath, Content: logContext.Content, Before: []string{}, After: []string{}}
+	json.Unmarshal([]byte(logContext.BeforeRows), &matchObj.Before)
+	json.Unmarshal([]byte(logContext.AfterRows), &matchObj.After)
+	json.Unmarshal([]byte(logContext.WindowCounts), &result.WindowCounts)
+	pageInfo.TotalRows = 1
+	if param.StartIndex == 0 {
+		result.Matches = append(result.Matches, &matchObj)
+	}
+	return
+}
+
+func queryAgentLogKeywordMatches(endpointGuid string, param *models.LogKeywordMatchHttpDto) (result *models.LogKeywordMatchPageData, err error) {
+	endpointObj, getErr := GetEndpointNew(&models.EndpointNewTable{Guid: endpointGuid})
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if endpointObj.AgentAddress == "" {
+		err = fmt.Errorf("endpoint:%s agent address is empty", endpointGuid)
+		return
+	}
+	postData, _ := json.Marshal(param)
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/log_keyword/matches", endpointObj.AgentAddress), strings.NewReader(string(postData)))
+	req.Header.Set("Content-Type", "application/json")
+	timeOutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, respErr := http.DefaultClient.Do(req.WithContext(timeOutCtx))
+	if respErr != nil {
+		err = fmt.Errorf("Do http request to %s fail,%s ", endpointObj.AgentAddress, respErr.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("Do http request to %s fail,status code:%d ", endpointObj.AgentAddress, resp.StatusCode)
+		return
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	var response models.LogKeywordMatchHttpResult
+	if err = json.Unmarshal(b, &response); err != nil {
+		err = fmt.Errorf("json unmarhsal reponse body fail,%s ", err.Error())
+		return
+	}
+	if response.Status != "ok" {
+		err = fmt.Errorf("query log keyword matches fail,%s ", response.Message)
+		return
+	}
+	if response.Data == nil {
+		err = fmt.Errorf("query log keyword matches fail,response data is empty")
+		return
+	}
+	result = response.Data
+	return
+}
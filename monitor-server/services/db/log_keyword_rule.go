@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/WeBankPartners/go-common-lib/pcre"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+// 关键字布尔表达式解析,和agent上的解析逻辑保持一致:支持 && || ! 和括号,带特殊字符的关键字用双引号括起来
+type logKeywordExprParser struct {
+	tokens []string
+	pos    int
+	terms  []string
+}
+
+func tokenizeLogKeywordExpression(expr string) (tokens []string, err error) {
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == ' ' || expr[i] == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case expr[i] == '!' || expr[i] == '(' || expr[i] == ')':
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case expr[i] == '"':
+			var termBuilder strings.Builder
+			i++
+			for i < len(expr) && expr[i] != '"' {
+				if expr[i] == '\\' && i+1 < len(expr) && expr[i+1] == '"' {
+					i++
+				}
+				termBuilder.WriteByte(expr[i])
+				i++
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("keyword expression:%s quote not closed", expr)
+			}
+			i++
+			tokens = append(tokens, "\""+termBuilder.String())
+		default:
+			termStart := i
+			for i < len(expr) && expr[i] != '(' && expr[i] != ')' && expr[i] != '"' && !strings.HasPrefix(expr[i:], "&&") && !strings.HasPrefix(expr[i:], "||") {
+				i++
+			}
+			tokens = append(tokens, "\""+strings.TrimSpace(expr[termStart:i]))
+		}
+	}
+	return
+}
+
+// parseLogKeywordExpression 校验表达式语法,返回表达式里的所有关键字
+func parseLogKeywordExpression(expr string) (terms []string, err error) {
+	parser := logKeywordExprParser{}
+	if parser.tokens, err = tokenizeLogKeywordExpression(expr); err != nil {
+		return
+	}
+	if len(parser.tokens) == 0 {
+		return nil, fmt.Errorf("keyword expression can not empty")
+	}
+	if err = parser.parseOr(); err != nil {
+		return
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("keyword expression:%s unexpected token:%s", expr, strings.TrimPrefix(parser.tokens[parser.pos], "\""))
+	}
+	terms = parser.terms
+	return
+}
+
+func (p *logKeywordExprParser) parseOr() (err error) {
+	if err = p.parseAnd(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos] == "||" {
+		p.pos++
+		if err = p.parseAnd(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *logKeywordExprParser) parseAnd() (err error) {
+	if err = p.parseFactor(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos] == "&&" {
+		p.pos++
+		if err = p.parseFactor(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *logKeywordExprParser) parseFactor() (err error) {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("keyword expression incomplete")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token {
+	case "!":
+		err = p.parseFactor()
+	case "(":
+		if err = p.parseOr(); err != nil {
+			return
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return fmt.Errorf("keyword expression bracket not closed")
+		}
+		p.pos++
+	case ")", "&&", "||":
+		err = fmt.Errorf("keyword expression unexpected token:%s", token)
+	default:
+		term := strings.TrimPrefix(token, "\"")
+		if term == "" {
+			return fmt.Errorf("keyword expression contains empty keyword")
+		}
+		p.terms = append(p.terms, term)
+	}
+	return
+}
+
+// ValidateLogKeywordRule 校验匹配方式、表达式、排除条件和次数阈值
+func ValidateLogKeywordRule(param *models.LogKeywordConfigTable) (err error) {
+	if param.MatchType == "" {
+		param.MatchType = models.LogKeywordMatchTypeKeyword
+	}
+	regList := []string{}
+	switch param.MatchType {
+	case models.LogKeywordMatchTypeKeyword:
+		regList = append(regList, param.Keyword)
+	case models.LogKeywordMatchTypeExpression:
+		terms, parseErr := parseLogKeywordExpression(param.Keyword)
+		if parseErr != nil {
+			return parseErr
+		}
+		regList = append(regList, terms...)
+	default:
+		return fmt.Errorf("match type:%s illegal", param.MatchType)
+	}
+	if param.ExcludeKeyword != "" {
+		regList = append(regList, param.ExcludeKeyword)
+	}
+	if param.Regulative > 0 {
+		for _, regString := range regList {
+			if _, compileErr := pcre.Compile(regString, 0); compileErr != nil {
+				return fmt.Errorf("reg:%s compile fail,%s ", regString, compileErr.Message)
+			}
+		}
+	}
+	if param.ThresholdCount < 0 || param.ThresholdWindow < 0 {
+		return fmt.Errorf("threshold count and window can not be negative")
+	}
+	if param.ThresholdCount > 0 && param.ThresholdWindow == 0 {
+		param.ThresholdWindow = 1
+	}
+	return
+}
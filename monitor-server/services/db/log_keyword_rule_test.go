@@ -0,0 +1,94 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+// 分词和错误用例和agent端 collector/monitor_log_keyword_rule_linux_test.go 保持一致
+
+func TestTokenizeLogKeywordExpression(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{`ERROR`, []string{`"ERROR`}, false},
+		{`ERROR && !timeout`, []string{`"ERROR`, "&&", "!", `"timeout`}, false},
+		{`"retry ok"||(a&&b)`, []string{`"retry ok`, "||", "(", `"a`, "&&", `"b`, ")"}, false},
+		{` out of memory  || oom `, []string{`"out of memory`, "||", `"oom`}, false},
+		{`"say \"hi\""`, []string{`"say "hi"`}, false},
+		{`"&&"`, []string{`"&&`}, false},
+		{`"not closed`, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := tokenizeLogKeywordExpression(tc.expr)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("tokenizeLogKeywordExpression(%q): want error %v, got %v", tc.expr, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tokenizeLogKeywordExpression(%q): want %q, got %q", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestParseLogKeywordExpressionError(t *testing.T) {
+	for _, expr := range []string{``, `   `, `a &&`, `|| a`, `(a || b`, `a) `, `a && ()`, `!`, `a "" b`, `"" || a`} {
+		if _, err := parseLogKeywordExpression(expr); err == nil {
+			t.Errorf("parseLogKeywordExpression(%q): want error, got nil", expr)
+		}
+	}
+}
+
+func TestParseLogKeywordExpressionTerms(t *testing.T) {
+	testCases := []struct {
+		expr string
+		want []string
+	}{
+		{`ERROR`, []string{"ERROR"}},
+		{`"ERROR" && !("retry ok" || timeout)`, []string{"ERROR", "retry ok", "timeout"}},
+		{`!!a`, []string{"a"}},
+	}
+	for _, tc := range testCases {
+		got, err := parseLogKeywordExpression(tc.expr)
+		if err != nil {
+			t.Errorf("parseLogKeywordExpression(%q): unexpected error %v", tc.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLogKeywordExpression(%q): want %q, got %q", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestValidateLogKeywordRule(t *testing.T) {
+	testCases := []struct {
+		param      models.LogKeywordConfigTable
+		wantErr    bool
+		wantWindow int
+	}{
+		{models.LogKeywordConfigTable{Keyword: "ERROR"}, false, 0},
+		{models.LogKeywordConfigTable{Keyword: "ERROR && !retry", MatchType: models.LogKeywordMatchTypeExpression}, false, 0},
+		{models.LogKeywordConfigTable{Keyword: "ERROR &&", MatchType: models.LogKeywordMatchTypeExpression}, true, 0},
+		{models.LogKeywordConfigTable{Keyword: "ERROR", MatchType: "unknown"}, true, 0},
+		{models.LogKeywordConfigTable{Keyword: "ERROR", ThresholdCount: -1}, true, 0},
+		{models.LogKeywordConfigTable{Keyword: "ERROR", ThresholdCount: 5}, false, 1},
+	}
+	for _, tc := range testCases {
+		param := tc.param
+		err := ValidateLogKeywordRule(&param)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ValidateLogKeywordRule(%+v): want error %v, got %v", tc.param, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && param.ThresholdWindow != tc.wantWindow {
+			t.Errorf("ValidateLogKeywordRule(%+v): want threshold window %d, got %d", tc.param, tc.wantWindow, param.ThresholdWindow)
+		}
+		if !tc.wantErr && param.MatchType == "" {
+			t.Errorf("ValidateLogKeywordRule(%+v): match type should default to keyword", tc.param)
+		}
+	}
+}
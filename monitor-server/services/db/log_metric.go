@@ -8,6 +8,8 @@ import (
 	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
 	"github.com/WeBankPartners/open-monitor/monitor-server/models"
 	"github.com/dlclark/regexp2"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -25,7 +27,7 @@ func GetLogMetricByServiceGroup(serviceGroup, metricKey string) (result models.L
 		return
 	}
 	for _, logMetricMonitor := range logMetricMonitorTable {
-		tmpConfig := models.LogMetricMonitorObj{Guid: logMetricMonitor.Guid, ServiceGroup: logMetricMonitor.ServiceGroup, LogPath: logMetricMonitor.LogPath, MetricType: logMetricMonitor.MetricType, MonitorType: logMetricMonitor.MonitorType}
+		tmpConfig := models.LogMetricMonitorObj{Guid: logMetricMonitor.Guid, ServiceGroup: logMetricMonitor.ServiceGroup, LogPath: logMetricMonitor.LogPath, MetricType: logMetricMonitor.MetricType, MonitorType: logMetricMonitor.MonitorType, LogMultilineConfig: logMetricMonitor.GetMultilineConfig()}
 		tmpConfig.EndpointRel = ListLogMetricEndpointRel(logMetricMonitor.Guid)
 		tmpConfig.JsonConfigList = ListLogMetricJson(logMetricMonitor.Guid)
 		tmpConfig.MetricConfigList = ListLogMetricConfig("", logMetricMonitor.Guid)
@@ -45,7 +47,7 @@ func GetLogMetricByServiceGroup(serviceGroup, metricKey string) (result models.L
 			logMetricGroupObj.ServiceGroup = serviceGroup
 			logMetricGroupObj.MonitorType = logMetricMonitor.MonitorType
 			if strings.TrimSpace(logMetricGroupObj.LogMonitorTemplate) != "" {
-				if logMonitorTemplateDto, err = GetLogMonitorTemplate(logMetricGroupObj.LogMonitorTemplate); err != nil {
+				if logMonitorTemplateDto, err = getLogMetricGroupTemplate(&logMetricGroupObj.LogMetricGroup); err != nil {
 					return
 				}
 				logMetricGroupObj.LogMonitorTemplateDto = logMonitorTemplateDto
@@ -222,7 +224,8 @@ func CreateLogMetricMonitor(param *models.LogMetricMonitorCreateDto) error {
 	logMonitorGuidList := guid.CreateGuidList(len(param.LogPath))
 	for i, v := range param.LogPath {
 		tmpLogPath := strings.TrimSpace(v)
-		actions = append(actions, &Action{Sql: "insert into log_metric_monitor(guid,service_group,log_path,metric_type,monitor_type,update_time) value (?,?,?,?,?,?)", Param: []interface{}{logMonitorGuidList[i], param.ServiceGroup, tmpLogPath, param.MetricType, param.MonitorType, nowTime}})
+		actions = append(actions, &Action{Sql: "insert into log_metric_monitor(guid,service_group,log_path,metric_type,monitor_type,update_time,multiline_start,multiline_continue,multiline_max_lines,multiline_timeout) value (?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{logMonitorGuidList[i], param.ServiceGroup, tmpLogPath, param.MetricType, param.MonitorType, nowTime,
+			param.MultilineStart, param.MultilineContinue, param.MultilineMaxLines, param.MultilineTimeout}})
 		relGuidList := guid.CreateGuidList(len(param.EndpointRel))
 		for ii, vv := range param.EndpointRel {
 			if vv.TargetEndpoint == "" {
@@ -243,7 +246,7 @@ func GetLogMetricMonitor(logMetricMonitorGuid string) (result models.LogMetricMo
 	if len(logMetricMonitorTable) == 0 {
 		return result, fmt.Errorf("Can not find log_metric_monitor with guid:%s ", logMetricMonitorGuid)
 	}
-	result = models.LogMetricMonitorObj{Guid: logMetricMonitorTable[0].Guid, ServiceGroup: logMetricMonitorTable[0].ServiceGroup, LogPath: logMetricMonitorTable[0].LogPath, MetricType: logMetricMonitorTable[0].MetricType, MonitorType: logMetricMonitorTable[0].MonitorType}
+	result = models.LogMetricMonitorObj{Guid: logMetricMonitorTable[0].Guid, ServiceGroup: logMetricMonitorTable[0].ServiceGroup, LogPath: logMetricMonitorTable[0].LogPath, MetricType: logMetricMonitorTable[0].MetricType, MonitorType: logMetricMonitorTable[0].MonitorType, LogMultilineConfig: logMetricMonitorTable[0].GetMultilineConfig()}
 	result.EndpointRel = ListLogMetricEndpointRel(logMetricMonitorTable[0].Guid)
 	result.JsonConfigList = ListLogMetricJson(logMetricMonitorTable[0].Guid)
 	result.MetricConfigList = ListLogMetricConfig("", logMetricMonitorTable[0].Guid)
@@ -254,7 +257,8 @@ func GetLogMetricMonitor(logMetricMonitorGuid string) (result models.LogMetricMo
 func UpdateLogMetricMonitor(param *models.LogMetricMonitorObj) error {
 	nowTime := time.Now().Format(models.DatetimeFormat)
 	var actions []*Action
-	actions = append(actions, &Action{Sql: "update log_metric_monitor set log_path=?,monitor_type=?,update_time=? where guid=?", Param: []interface{}{param.LogPath, param.MonitorType, nowTime, param.Guid}})
+	actions = append(actions, &Action{Sql: "update log_metric_monitor set log_path=?,monitor_type=?,update_time=?,multiline_start=?,multiline_continue=?,multiline_max_lines=?,multiline_timeout=? where guid=?", Param: []interface{}{param.LogPath, param.MonitorType, nowTime,
+		param.MultilineStart, param.MultilineContinue, param.MultilineMaxLines, param.MultilineTimeout, param.Guid}})
 	actions = append(actions, &Action{Sql: "delete from log_metric_endpoint_rel where log_metric_monitor=?", Param: []interface{}{param.Guid}})
 	guidList := guid.CreateGuidList(len(param.EndpointRel))
 	for i, v := range param.EndpointRel {
@@ -558,12 +562,83 @@ func getLogMetricExprByAggType(metric, aggType, serviceGroup string, tagList []s
 		result = fmt.Sprintf("min(%s{key=\"%s\",agg=\"%s\",service_group=\"%s\"%s}) by (key,agg,service_group%s)", models.LogMetricName, metric, aggType, serviceGroup, tagFilterString, tagString)
 	case "avg":
 		result = fmt.Sprintf("sum(%s{key=\"%s\",agg=\"sum\",service_group=\"%s\"%s}) by (key,service_group%s)/sum(%s{key=\"%s\",agg=\"count\",service_group=\"%s\"%s}) by (key,service_group%s) > 0 or (0*sum(%s{key=\"%s\",agg=\"sum\",service_group=\"%s\"%s}) by (key,service_group%s))", models.LogMetricName, metric, serviceGroup, tagFilterString, tagString, models.LogMetricName, metric, serviceGroup, tagFilterString, tagString, models.LogMetricName, metric, serviceGroup, tagFilterString, tagString)
+	case "p50", "p90", "p95", "p99":
+		// 各主机的分位值不能相加,取最大的作为整体分位值
+		result = fmt.Sprintf("max(%s{key=\"%s\",agg=\"%s\",service_group=\"%s\"%s}) by (key,agg,service_group%s)", models.LogMetricName, metric, aggType, serviceGroup, tagFilterString, tagString)
+	case models.LogMetricAggHistogram:
+		// 直方图合并所有主机的桶后计算p99
+		result = fmt.Sprintf("histogram_quantile(0.99,sum(rate(%s_bucket{key=\"%s\",service_group=\"%s\"%s}[1m])) by (le,key,service_group%s))", models.LogMetricHistogramName, metric, serviceGroup, tagFilterString, tagString)
+	case models.LogMetricAggSummary:
+		result = fmt.Sprintf("max(%s{key=\"%s\",quantile=\"0.99\",service_group=\"%s\"%s}) by (key,service_group%s)", models.LogMetricSummaryName, metric, serviceGroup, tagFilterString, tagString)
 	default:
 		result = fmt.Sprintf("%s{key=\"%s\",agg=\"%s\",service_group=\"%s\"%s}", models.LogMetricName, metric, aggType, serviceGroup, tagFilterString)
 	}
 	return result
 }
 
+// ValidateLogMetricAggConfig 校验聚合方式,直方图的桶为逗号分隔的上界,为空时agent使用默认桶
+func ValidateLogMetricAggConfig(aggType, buckets string) error {
+	if aggType != "" && !models.IsLogMetricAggType(aggType) {
+		return fmt.Errorf("agg_type:%s illegal", aggType)
+	}
+	if strings.TrimSpace(buckets) == "" {
+		return nil
+	}
+	if aggType != models.LogMetricAggHistogram {
+		return fmt.Errorf("buckets only support histogram agg_type")
+	}
+	for _, v := range strings.Split(buckets, ",") {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return fmt.Errorf("bucket:%s illegal", v)
+		}
+	}
+	return nil
+}
+
+// validateImportLogMetricAggConfig 导入的日志指标和自定义指标组逐个校验聚合方式和桶配置
+func validateImportLogMetricAggConfig(param *models.LogMetricQueryObj) error {
+	for _, logMonitor := range param.Config {
+		for _, logMetric := range logMonitor.MetricConfigList {
+			if err := ValidateLogMetricAggConfig(logMetric.AggType, ""); err != nil {
+				return fmt.Errorf("metric:%s %s", logMetric.Metric, err.Error())
+			}
+		}
+		for _, logJson := range logMonitor.JsonConfigList {
+			for _, logMetric := range logJson.MetricList {
+				if err := ValidateLogMetricAggConfig(logMetric.AggType, ""); err != nil {
+					return fmt.Errorf("metric:%s %s", logMetric.Metric, err.Error())
+				}
+			}
+		}
+		for _, metricGroup := range logMonitor.MetricGroups {
+			for _, logMetric := range metricGroup.MetricList {
+				if err := ValidateLogMetricAggConfig(logMetric.AggType, logMetric.Buckets); err != nil {
+					return fmt.Errorf("metric:%s %s", logMetric.Metric, err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getLogMetricBucketList 解析直方图桶配置,去重并按上界升序
+func getLogMetricBucketList(buckets string) (result []float64) {
+	if strings.TrimSpace(buckets) == "" {
+		return
+	}
+	existMap := make(map[float64]bool)
+	for _, v := range strings.Split(buckets, ",") {
+		tmpBucket, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || existMap[tmpBucket] {
+			continue
+		}
+		existMap[tmpBucket] = true
+		result = append(result, tmpBucket)
+	}
+	sort.Float64s(result)
+	return
+}
+
 func getLogMetricRatePromExpr(metric, metricPrefix, aggType, serviceGroup, sucRetCode string) (result string) {
 	aggType = "count"
 	if metricPrefix != "" {
@@ -769,6 +844,9 @@ func regexp2FindStringMatch(re *regexp2.Regexp, lineText string) (matchString st
 }
 
 func ImportLogMetric(param *models.LogMetricQueryObj, operator string, roles []string, errMsgObj *models.ErrorMessageObj) (err error) {
+	if err = validateImportLogMetricAggConfig(param); err != nil {
+		return
+	}
 	var actions []*Action
 	var dashboardIdList []int64
 	var existLogMetricMonitorMap = make(map[string]*models.LogMetricMonitorObj)
@@ -813,7 +891,8 @@ func ImportLogMetric(param *models.LogMetricQueryObj, operator string, roles []s
 			}
 		} else {
 			inputLogMonitor.Guid = "lmm_" + guid.CreateGuid()
-			actions = append(actions, &Action{Sql: "insert into log_metric_monitor(guid,service_group,log_path,metric_type,monitor_type,update_time) value (?,?,?,?,?,?)", Param: []interface{}{inputLogMonitor.Guid, param.Guid, inputLogMonitor.LogPath, inputLogMonitor.MetricType, inputLogMonitor.MonitorType, nowTime}})
+			actions = append(actions, &Action{Sql: "insert into log_metric_monitor(guid,service_group,log_path,metric_type,monitor_type,update_time,multiline_start,multiline_continue,multiline_max_lines,multiline_timeout) value (?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{inputLogMonitor.Guid, param.Guid, inputLogMonitor.LogPath, inputLogMonitor.MetricType, inputLogMonitor.MonitorType, nowTime,
+				inputLogMonitor.MultilineStart, inputLogMonitor.MultilineContinue, inputLogMonitor.MultilineMaxLines, inputLogMonitor.MultilineTimeout}})
 			if len(existObj.EndpointRel) > 0 {
 				for _, endpointRel := range existObj.EndpointRel {
 					actions = append(actions, &Action{Sql: "insert into log_metric_endpoint_rel(guid,log_metric_monitor,source_endpoint,target_endpoint) value (?,?,?,?)", Param: []interface{}{guid.CreateGuid(), inputLogMonitor.Guid, endpointRel.SourceEndpoint, endpointRel.TargetEndpoint}})
@@ -1045,7 +1124,7 @@ func getCreateDBMetricMonitorByImport(inputObj *models.DbMetricMonitorObj, nowTi
 
 func getCreateLogMetricGroupByImport(metricGroup *models.LogMetricGroupObj, operator string, existMetricMap map[string]string, errMsgObj *models.ErrorMessageObj, roles []string) (actions []*Action, newDashboardId int64, err error) {
 	var tmpActions []*Action
-	if metricGroup.LogMonitorTemplate != "" && (metricGroup.LogType == models.LogMonitorRegularType || metricGroup.LogType == models.LogMonitorJsonType) {
+	if metricGroup.LogMonitorTemplate != "" && (metricGroup.LogType == models.LogMonitorRegularType || metricGroup.LogType == models.LogMonitorJsonType || models.IsLogMonitorStructuredType(metricGroup.LogType)) {
 		metricGroup.LogMonitorTemplate, err = GetLogTemplateGuidByName(metricGroup.LogMonitorTemplateName)
 		if err != nil {
 			return
@@ -1100,7 +1179,7 @@ func getUpdateLogMetricGroupByImport(metricGroup *models.LogMetricGroupObj, oper
 				tmpCreateParam.RetCodeStringMap = mgParamObj.StringMap
 			}
 		}
-		actions, err = getUpdateLogMetricGroupActions(&tmpCreateParam, operator)
+		actions, affectEndpointGroups, err = getUpdateLogMetricGroupActions(&tmpCreateParam, operator)
 	} else {
 		actions, affectEndpointGroups, err = getUpdateLogMetricCustomGroupActions(metricGroup, operator)
 	}
@@ -1254,6 +1333,9 @@ func GetLogMetricGroup(logMetricGroupGuid string) (result *models.LogMetricGroup
 		LogMonitorTemplateVersion: metricGroupObj.RefTemplateVersion,
 		LogMonitorTemplate:        logMonitorTemplate,
 		RetCodeStringMap:          []*models.LogMetricStringMapTable{}}
+	if result.DerivedMetricList, err = getLogMetricDerivedList(logMetricGroupGuid); err != nil {
+		return
+	}
 	for _, row := range logMetricStringMapRows {
 		if row.LogParamName == "code" {
 			result.CodeStringMap = append(result.CodeStringMap, row)
@@ -1330,10 +1412,11 @@ func getCreateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 			log.Logger.Warn("json unmarshal log template success code fail", log.String("successCode", logMonitorTemplateObj.SuccessCode), log.Error(unmarshalErr))
 		}
 	}
+	// 新建的日志指标组不锁定版本(pinned_template_version为0),跟随模版最新配置,需要固定版本时通过发布锁定
 	actions = append(actions, &Action{Sql: "insert into log_metric_group(guid,name,metric_prefix_code,log_type,log_metric_monitor,log_monitor_template,create_user," +
-		"create_time,update_user,update_time,template_snapshot,ref_template_version,auto_alarm,auto_dashboard) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+		"create_time,update_user,update_time,template_snapshot,ref_template_version,auto_alarm,auto_dashboard,pinned_template_version) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
 		param.LogMetricGroupGuid, param.Name, param.MetricPrefixCode, logMonitorTemplateObj.LogType, param.LogMetricMonitorGuid, param.LogMonitorTemplateGuid, operator,
-		nowTime, operator, nowTime, templateSnapshot, refTemplateVersion, autoAlarm, autoDashboard,
+		nowTime, operator, nowTime, templateSnapshot, refTemplateVersion, autoAlarm, autoDashboard, 0,
 	}})
 	sucRetCode, createMapActions := getCreateLogMetricGroupMapAction(param, nowTime)
 	actions = append(actions, createMapActions...)
@@ -1363,6 +1446,14 @@ func getCreateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 		actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,log_metric_template,log_metric_group,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?,?,?,?,?)",
 			Param: []interface{}{tmpMetricGuid, tmpMetricWithPrefix, monitorType, promExpr, serviceGroup, models.MetricWorkspaceService, nowTime, v.Guid, param.LogMetricGroupGuid, nowTime, operator, operator}})
 	}
+	derivedActions, derivedMetricList, getDerivedErr := getCreateLogMetricDerivedActions(param, logMonitorTemplateObj.MetricList, sucRetCode, operator, existMetricMap, doImport, nowTime)
+	if getDerivedErr != nil {
+		err = getDerivedErr
+		return
+	}
+	actions = append(actions, derivedActions...)
+	// 派生指标和模版指标一样参与自动创建告警和看板
+	autoMetricList := append(append([]*models.LogMetricTemplate{}, logMonitorTemplateObj.MetricList...), derivedMetricList...)
 	if serviceGroup != "" && monitorType != "" {
 		var endpointGroupIds []string
 		serviceGroupsRoles = getServiceGroupRoles(serviceGroup)
@@ -1376,7 +1467,7 @@ func getCreateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 	if len(serviceGroupsRoles) == 0 && len(roles) > 0 {
 		serviceGroupsRoles = roles[:1]
 	}
-	alarmStrategyParam := models.AutoAlarmStrategyParam{LogMetricGroupWithTemplate: param, MetricList: logMonitorTemplateObj.MetricList, ServiceGroupsRoles: serviceGroupsRoles,
+	alarmStrategyParam := models.AutoAlarmStrategyParam{LogMetricGroupWithTemplate: param, MetricList: autoMetricList, ServiceGroupsRoles: serviceGroupsRoles,
 		ServiceGroup: serviceGroup, EndpointGroup: endpointGroup, Operator: operator, ErrMsgObj: errMsgObj}
 	if subCreateAlarmStrategyActions, alarmStrategyList, err = autoGenerateAlarmStrategy(alarmStrategyParam); err != nil {
 		return
@@ -1385,7 +1476,7 @@ func getCreateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 		actions = append(actions, subCreateAlarmStrategyActions...)
 		result.AlarmList = alarmStrategyList
 	}
-	var dashboardParam = models.AutoCreateDashboardParam{LogMetricGroupWithTemplate: param, MetricList: logMonitorTemplateObj.MetricList, ServiceGroupsRoles: serviceGroupsRoles,
+	var dashboardParam = models.AutoCreateDashboardParam{LogMetricGroupWithTemplate: param, MetricList: autoMetricList, ServiceGroupsRoles: serviceGroupsRoles,
 		ServiceGroup: serviceGroup, Operator: operator, ErrMsgObj: errMsgObj}
 	if subCreateDashboardActions, result.CustomDashboard, newDashboardId, err = autoGenerateCustomDashboard(dashboardParam); err != nil {
 		return
@@ -1417,15 +1508,21 @@ func getCreateLogMetricGroupMapAction(param *models.LogMetricGroupWithTemplate,
 
 func UpdateLogMetricGroup(param *models.LogMetricGroupWithTemplate, operator string) (err error) {
 	var actions []*Action
-	actions, err = getUpdateLogMetricGroupActions(param, operator)
+	var affectEndpointGroup []string
+	actions, affectEndpointGroup, err = getUpdateLogMetricGroupActions(param, operator)
 	if err != nil {
 		return
 	}
 	err = Transaction(actions)
+	if err == nil && len(affectEndpointGroup) > 0 {
+		for _, v := range distinctStringList(affectEndpointGroup) {
+			SyncPrometheusRuleFile(v, false)
+		}
+	}
 	return
 }
 
-func getUpdateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, operator string) (actions []*Action, err error) {
+func getUpdateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, operator string) (actions []*Action, affectEndpointGroup []string, err error) {
 	nowTime := time.Now()
 	actions = append(actions, &Action{Sql: "update log_metric_group set name=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
 		param.Name, operator, nowTime, param.LogMetricGroupGuid,
@@ -1452,7 +1549,7 @@ func getUpdateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 			err = getErr
 			return
 		}
-		logMonitorTemplateObj, getTemplateErr := GetLogMonitorTemplate(param.LogMonitorTemplateGuid)
+		logMonitorTemplateObj, getTemplateErr := getLogMetricGroupTemplate(logMetricGroupObj)
 		if getTemplateErr != nil {
 			err = getTemplateErr
 			return
@@ -1472,6 +1569,19 @@ func getUpdateLogMetricGroupActions(param *models.LogMetricGroupWithTemplate, op
 			actions = append(actions, &Action{Sql: "update metric set prom_expr=?,update_time=?,update_user=? where guid=?", Param: []interface{}{promExpr, nowTime, operator, fmt.Sprintf("%s__%s", tmpMetricWithPrefix, serviceGroup)}})
 		}
 	}
+	// 派生指标列表为空数组时删除全部派生指标,未传时不处理
+	if param.DerivedMetricList != nil {
+		metricGroupObj, getErr := GetSimpleLogMetricGroup(param.LogMetricGroupGuid)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		var derivedActions []*Action
+		if derivedActions, affectEndpointGroup, err = getUpdateLogMetricDerivedActions(param, metricGroupObj, newSucRetCode, operator, nowTime); err != nil {
+			return
+		}
+		actions = append(actions, derivedActions...)
+	}
 	actions = append(actions, updateMapActions...)
 	return
 }
@@ -1548,7 +1658,7 @@ func getDeleteLogMetricGroupActions(logMetricGroupGuid string) (actions []*Actio
 	serviceGroup, _ := GetLogMetricServiceGroup(metricGroupObj.LogMetricMonitor)
 	var existMetricRows []*models.LogMetricConfigDto
 	if metricGroupObj.LogMonitorTemplate != "" {
-		logMonitorTemplateObj, getTemplateErr := GetLogMonitorTemplate(metricGroupObj.LogMonitorTemplate)
+		logMonitorTemplateObj, getTemplateErr := getLogMetricGroupTemplate(metricGroupObj)
 		if getTemplateErr != nil {
 			err = getTemplateErr
 			return
@@ -1572,6 +1682,16 @@ func getDeleteLogMetricGroupActions(logMetricGroupGuid string) (actions []*Actio
 		actions = append(actions, deleteMetricActions...)
 		affectEndpointGroup = append(affectEndpointGroup, endpointGroups...)
 	}
+	derivedList, getDerivedErr := getLogMetricDerivedList(logMetricGroupGuid)
+	if getDerivedErr != nil {
+		err = getDerivedErr
+		return
+	}
+	for _, derivedObj := range derivedList {
+		deleteDerivedActions, endpointGroups := getDeleteLogMetricDerivedActions(derivedObj, metricGroupObj.MetricPrefixCode, serviceGroup)
+		actions = append(actions, deleteDerivedActions...)
+		affectEndpointGroup = append(affectEndpointGroup, endpointGroups...)
+	}
 	return
 }
 
@@ -1618,11 +1738,12 @@ func ListLogMetricGroups(logMetricMonitor, metricKey string) (result []*models.L
 		v.UpdateTimeString = v.UpdateTime.Format(models.DatetimeFormat)
 		logMetricGroupData := &models.LogMetricGroupObj{LogMetricGroup: *v, AutoCreateDashboard: v.AutoDashboard == 1, AutoCreateWarn: v.AutoAlarm == 1}
 		if v.LogMonitorTemplate != "" && v.LogType != "custom" {
-			tmpTemplateObj, tmpGetTemplateErr := GetLogMonitorTemplate(v.LogMonitorTemplate)
+			tmpTemplateObj, tmpGetTemplateErr := getLogMetricGroupTemplate(v)
 			if tmpGetTemplateErr != nil {
 				log.Logger.Error("ListLogMetricGroups fail get template data ", log.String("templateGuid", v.LogMonitorTemplate), log.Error(tmpGetTemplateErr))
 			} else {
 				logMetricGroupData.JsonRegular = tmpTemplateObj.JsonRegular
+				logMetricGroupData.Delimiter = tmpTemplateObj.Delimiter
 				logMetricStringMapData, getStringMapErr := getLogMetricGroupMapData(v.Guid)
 				if getStringMapErr != nil {
 					log.Logger.Error("ListLogMetricGroups getLogMetricGroupMapData fail ", log.String("logMetricGroupGuid", v.Guid), log.Error(getStringMapErr))
@@ -1845,8 +1966,8 @@ func getCreateLogMetricCustomGroupActions(param *models.LogMetricGroupObj, opera
 			tmpMetricWithPrefix = param.MetricPrefixCode + "_" + v.Metric
 		}
 		actions = append(actions, &Action{Sql: "insert into log_metric_config(guid,log_metric_monitor,log_metric_group,log_param_name,metric,display_name," +
-			"regular,step,agg_type,tag_config,create_user,create_time,auto_alarm,range_config,color_group) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-			tmpMetricConfigGuid, param.LogMetricMonitor, param.Guid, v.LogParamName, tmpMetricWithPrefix, v.DisplayName, v.Regular, v.Step, v.AggType, string(tmpTagListBytes),
+			"regular,step,agg_type,buckets,tag_config,create_user,create_time,auto_alarm,range_config,color_group) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			tmpMetricConfigGuid, param.LogMetricMonitor, param.Guid, v.LogParamName, tmpMetricWithPrefix, v.DisplayName, v.Regular, v.Step, v.AggType, v.Buckets, string(tmpTagListBytes),
 			operator, nowTime, v.AutoAlarm, string(rangeConf), v.ColorGroup,
 		}})
 		// 自动添加增加 metric
@@ -2004,8 +2125,8 @@ func getUpdateLogMetricCustomGroupActions(param *models.LogMetricGroupObj, opera
 		inputMetricObj.TagConfig = string(tmpTagListBytes)
 		if inputMetricObj.Guid == "" {
 			tmpMetricConfigGuid := "lmc_" + metricGuidList[i]
-			actions = append(actions, &Action{Sql: "insert into log_metric_config(guid,log_metric_monitor,log_metric_group,log_param_name,metric,display_name,regular,step,agg_type,tag_config,create_user,create_time) values (?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-				tmpMetricConfigGuid, existLogGroupData.LogMetricMonitor, param.Guid, inputMetricObj.LogParamName, inputMetricObj.Metric, inputMetricObj.DisplayName, inputMetricObj.Regular, inputMetricObj.Step, inputMetricObj.AggType, string(tmpTagListBytes), operator, nowTime,
+			actions = append(actions, &Action{Sql: "insert into log_metric_config(guid,log_metric_monitor,log_metric_group,log_param_name,metric,display_name,regular,step,agg_type,buckets,tag_config,create_user,create_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+				tmpMetricConfigGuid, existLogGroupData.LogMetricMonitor, param.Guid, inputMetricObj.LogParamName, inputMetricObj.Metric, inputMetricObj.DisplayName, inputMetricObj.Regular, inputMetricObj.Step, inputMetricObj.AggType, inputMetricObj.Buckets, string(tmpTagListBytes), operator, nowTime,
 			}})
 			tmpTagList := []string{}
 			if len(inputMetricObj.TagConfigList) > 0 {
@@ -2015,8 +2136,8 @@ func getUpdateLogMetricCustomGroupActions(param *models.LogMetricGroupObj, opera
 				Param: []interface{}{fmt.Sprintf("%s__%s", inputMetricObj.Metric, serviceGroup), inputMetricObj.Metric, monitorType, getLogMetricExprByAggType(inputMetricObj.Metric, inputMetricObj.AggType, serviceGroup,
 					tmpTagList), serviceGroup, models.MetricWorkspaceService, nowTime, tmpMetricConfigGuid, param.Guid, nowTime, operator, operator}})
 		} else {
-			actions = append(actions, &Action{Sql: "update log_metric_config set log_param_name=?,metric=?,display_name=?,regular=?,step=?,agg_type=?,tag_config=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
-				inputMetricObj.LogParamName, inputMetricObj.Metric, inputMetricObj.DisplayName, inputMetricObj.Regular, inputMetricObj.Step, inputMetricObj.AggType, string(tmpTagListBytes), operator, nowTime, inputMetricObj.Guid,
+			actions = append(actions, &Action{Sql: "update log_metric_config set log_param_name=?,metric=?,display_name=?,regular=?,step=?,agg_type=?,buckets=?,tag_config=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
+				inputMetricObj.LogParamName, inputMetricObj.Metric, inputMetricObj.DisplayName, inputMetricObj.Regular, inputMetricObj.Step, inputMetricObj.AggType, inputMetricObj.Buckets, string(tmpTagListBytes), operator, nowTime, inputMetricObj.Guid,
 			}})
 			if existMetricObj, ok := existMetricDataMap[inputMetricObj.Guid]; ok {
 				oldMetricGuid := fmt.Sprintf("%s__%s", existMetricObj.Metric, serviceGroup)
@@ -2142,6 +2263,7 @@ func convertLogMetricConfigTable2Dto(config *models.LogMetricConfigTable) (dto *
 		Regular:          config.Regular,
 		AggType:          config.AggType,
 		Step:             config.Step,
+		Buckets:          config.Buckets,
 		TagConfigList:    config.TagConfigList,
 		CreateUser:       config.CreateUser,
 		UpdateUser:       config.UpdateUser,
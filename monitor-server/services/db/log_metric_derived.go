@@ -0,0 +1,401 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+// 派生指标表达式支持同组的指标名、数字、+ - * / 和括号,如 req_fail_count / req_count * 100
+type logMetricDerivedExprParser struct {
+	tokens []string
+	pos    int
+}
+
+func isLogMetricDerivedIdentChar(ch byte, first bool) bool {
+	if (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_' {
+		return true
+	}
+	return !first && ch >= '0' && ch <= '9'
+}
+
+func isLogMetricDerivedMetricToken(token string) bool {
+	return token != "" && isLogMetricDerivedIdentChar(token[0], true)
+}
+
+func tokenizeLogMetricDerivedExpression(expr string) (tokens []string, err error) {
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case strings.IndexByte("+-*/()", ch) >= 0:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case (ch >= '0' && ch <= '9') || ch == '.':
+			numStart := i
+			for i < len(expr) && ((expr[i] >= '0' && expr[i] <= '9') || expr[i] == '.') {
+				i++
+			}
+			if _, parseErr := strconv.ParseFloat(expr[numStart:i], 64); parseErr != nil {
+				return nil, fmt.Errorf("derived metric expression:%s number:%s illegal", expr, expr[numStart:i])
+			}
+			tokens = append(tokens, expr[numStart:i])
+		case isLogMetricDerivedIdentChar(ch, true):
+			identStart := i
+			for i < len(expr) && isLogMetricDerivedIdentChar(expr[i], false) {
+				i++
+			}
+			tokens = append(tokens, expr[identStart:i])
+		default:
+			return nil, fmt.Errorf("derived metric expression:%s contains illegal char:%c", expr, ch)
+		}
+	}
+	return
+}
+
+// parseLogMetricDerivedExpression 校验表达式语法,返回token列表和引用的指标名
+func parseLogMetricDerivedExpression(expr string) (tokens, refMetrics []string, err error) {
+	parser := logMetricDerivedExprParser{}
+	if parser.tokens, err = tokenizeLogMetricDerivedExpression(expr); err != nil {
+		return
+	}
+	if len(parser.tokens) == 0 {
+		err = fmt.Errorf("derived metric expression can not empty")
+		return
+	}
+	if err = parser.parseExpr(); err != nil {
+		return
+	}
+	if parser.pos < len(parser.tokens) {
+		err = fmt.Errorf("derived metric expression:%s unexpected token:%s", expr, parser.tokens[parser.pos])
+		return
+	}
+	tokens = parser.tokens
+	for _, token := range tokens {
+		if isLogMetricDerivedMetricToken(token) {
+			refMetrics = append(refMetrics, token)
+		}
+	}
+	if len(refMetrics) == 0 {
+		err = fmt.Errorf("derived metric expression:%s must reference at least one metric", expr)
+		return
+	}
+	refMetrics = distinctStringList(refMetrics)
+	return
+}
+
+func (p *logMetricDerivedExprParser) parseExpr() (err error) {
+	if err = p.parseTerm(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "+" || p.tokens[p.pos] == "-") {
+		p.pos++
+		if err = p.parseTerm(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *logMetricDerivedExprParser) parseTerm() (err error) {
+	if err = p.parseFactor(); err != nil {
+		return
+	}
+	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "*" || p.tokens[p.pos] == "/") {
+		p.pos++
+		if err = p.parseFactor(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *logMetricDerivedExprParser) parseFactor() (err error) {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("derived metric expression incomplete")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token {
+	case "-":
+		err = p.parseFactor()
+	case "(":
+		if err = p.parseExpr(); err != nil {
+			return
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return fmt.Errorf("derived metric expression bracket not closed")
+		}
+		p.pos++
+	case ")", "+", "*", "/":
+		err = fmt.Errorf("derived metric expression unexpected token:%s", token)
+	}
+	return
+}
+
+func getLogMetricTemplatePromExpr(metricObj *models.LogMetricTemplate, metricPrefixCode, serviceGroup, sucRetCode string) string {
+	if metricObj.Metric == "req_suc_count" || metricObj.Metric == "req_fail_count" || metricObj.Metric == "req_fail_count_detail" || metricObj.Metric == "req_suc_rate" || metricObj.Metric == "req_fail_rate" {
+		return getLogMetricRatePromExpr(metricObj.Metric, metricPrefixCode, metricObj.AggType, serviceGroup, sucRetCode)
+	}
+	tmpMetricWithPrefix := metricObj.Metric
+	if metricPrefixCode != "" {
+		tmpMetricWithPrefix = metricPrefixCode + "_" + metricObj.Metric
+	}
+	return getLogMetricExprByAggType(tmpMetricWithPrefix, metricObj.AggType, serviceGroup, metricObj.TagConfigList)
+}
+
+// getLogMetricDerivedPromExpr 把表达式里的指标替换成该指标的表达式,并按引用指标的公共标签重新聚合,去掉key、agg等标签使不同指标之间可以直接运算
+func getLogMetricDerivedPromExpr(derivedObj *models.LogMetricDerivedTable, metricMap map[string]*models.LogMetricTemplate, metricPrefixCode, serviceGroup, sucRetCode string) (promExpr string, tagList []string, err error) {
+	tokens, refMetrics, parseErr := parseLogMetricDerivedExpression(derivedObj.Expression)
+	if parseErr != nil {
+		err = parseErr
+		return
+	}
+	for i, refMetric := range refMetrics {
+		metricObj, ok := metricMap[refMetric]
+		if !ok {
+			err = fmt.Errorf("derived metric:%s reference metric:%s not exist in log metric group", derivedObj.Metric, refMetric)
+			return
+		}
+		if i == 0 {
+			tagList = append([]string{}, metricObj.TagConfigList...)
+			continue
+		}
+		var commonTagList []string
+		for _, tag := range tagList {
+			for _, refTag := range metricObj.TagConfigList {
+				if tag == refTag {
+					commonTagList = append(commonTagList, tag)
+					break
+				}
+			}
+		}
+		tagList = commonTagList
+	}
+	byString := "service_group"
+	if len(tagList) > 0 {
+		byString = byString + "," + strings.Join(tagList, ",")
+	}
+	exprList := []string{}
+	for _, token := range tokens {
+		if !isLogMetricDerivedMetricToken(token) {
+			exprList = append(exprList, token)
+			continue
+		}
+		metricObj := metricMap[token]
+		aggFunc := "sum"
+		switch metricObj.AggType {
+		case "max", "p50", "p90", "p95", "p99", models.LogMetricAggHistogram, models.LogMetricAggSummary:
+			aggFunc = "max"
+		case "min":
+			aggFunc = "min"
+		}
+		exprList = append(exprList, fmt.Sprintf("%s(%s) by (%s)", aggFunc, getLogMetricTemplatePromExpr(metricObj, metricPrefixCode, serviceGroup, sucRetCode), byString))
+	}
+	promExpr = strings.Join(exprList, " ")
+	return
+}
+
+func getLogMetricDerivedList(logMetricGroupGuid string) (result []*models.LogMetricDerivedTable, err error) {
+	result = []*models.LogMetricDerivedTable{}
+	err = x.SQL("select * from log_metric_derived where log_metric_group=? order by metric", logMetricGroupGuid).Find(&result)
+	if err != nil {
+		err = fmt.Errorf("query log_metric_derived table fail,%s ", err.Error())
+	}
+	return
+}
+
+func buildLogMetricDerivedMap(metricList []*models.LogMetricTemplate, derivedList []*models.LogMetricDerivedTable) (metricMap map[string]*models.LogMetricTemplate, err error) {
+	metricMap = make(map[string]*models.LogMetricTemplate)
+	for _, v := range metricList {
+		metricMap[v.Metric] = v
+	}
+	derivedNameMap := make(map[string]bool)
+	for _, v := range derivedList {
+		if _, ok := metricMap[v.Metric]; ok || derivedNameMap[v.Metric] {
+			err = fmt.Errorf("derived metric:%s duplicate", v.Metric)
+			return
+		}
+		derivedNameMap[v.Metric] = true
+	}
+	return
+}
+
+// transLogMetricDerivedToTemplate 转成模版指标的结构,给自动创建告警和看板使用
+func transLogMetricDerivedToTemplate(derivedObj *models.LogMetricDerivedTable, tagList []string) *models.LogMetricTemplate {
+	tagConfigBytes, _ := json.Marshal(tagList)
+	return &models.LogMetricTemplate{Guid: derivedObj.Guid, Metric: derivedObj.Metric, DisplayName: derivedObj.DisplayName, TagConfig: string(tagConfigBytes),
+		TagConfigList: tagList, ColorGroup: derivedObj.ColorGroup, AutoAlarm: derivedObj.AutoAlarm, RangeConfig: derivedObj.RangeConfig}
+}
+
+func getCreateLogMetricDerivedActions(param *models.LogMetricGroupWithTemplate, metricList []*models.LogMetricTemplate, sucRetCode, operator string, existMetricMap map[string]string, doImport bool, nowTime time.Time) (actions []*Action, derivedMetricList []*models.LogMetricTemplate, err error) {
+	metricMap, buildErr := buildLogMetricDerivedMap(metricList, param.DerivedMetricList)
+	if buildErr != nil {
+		err = buildErr
+		return
+	}
+	derivedGuidList := guid.CreateGuidList(len(param.DerivedMetricList))
+	for i, derivedObj := range param.DerivedMetricList {
+		promExpr, tagList, getExprErr := getLogMetricDerivedPromExpr(derivedObj, metricMap, param.MetricPrefixCode, param.ServiceGroup, sucRetCode)
+		if getExprErr != nil {
+			err = getExprErr
+			return
+		}
+		derivedObj.Guid = "lmd_" + derivedGuidList[i]
+		tmpMetricWithPrefix := derivedObj.Metric
+		if param.MetricPrefixCode != "" {
+			tmpMetricWithPrefix = param.MetricPrefixCode + "_" + derivedObj.Metric
+		}
+		tmpMetricGuid := generateMetricGuid(tmpMetricWithPrefix, param.ServiceGroup)
+		if duplicateMetric, ok := existMetricMap[tmpMetricGuid]; ok && !doImport {
+			err = fmt.Errorf("Metric: %s duplicate ", duplicateMetric)
+			return
+		}
+		actions = append(actions, &Action{Sql: "insert into log_metric_derived(guid,log_metric_group,metric,display_name,expression,color_group,auto_alarm,range_config,create_user,update_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			derivedObj.Guid, param.LogMetricGroupGuid, derivedObj.Metric, derivedObj.DisplayName, derivedObj.Expression, derivedObj.ColorGroup, derivedObj.AutoAlarm, derivedObj.RangeConfig, operator, operator, nowTime, nowTime,
+		}})
+		actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,log_metric_group,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?,?,?,?)",
+			Param: []interface{}{tmpMetricGuid, tmpMetricWithPrefix, param.MonitorType, promExpr, param.ServiceGroup, models.MetricWorkspaceService, nowTime, param.LogMetricGroupGuid, nowTime, operator, operator}})
+		derivedMetricList = append(derivedMetricList, transLogMetricDerivedToTemplate(derivedObj, tagList))
+	}
+	return
+}
+
+// getUpdateLogMetricDerivedActions 按指标名对比,删除去掉的派生指标,更新已有的,新增新加的;成功码变化时一起刷新表达式
+func getUpdateLogMetricDerivedActions(param *models.LogMetricGroupWithTemplate, metricGroupObj *models.LogMetricGroup, sucRetCode, operator string, nowTime time.Time) (actions []*Action, affectEndpointGroup []string, err error) {
+	logMonitorTemplateObj, getTemplateErr := getLogMetricGroupTemplate(metricGroupObj)
+	if getTemplateErr != nil {
+		err = getTemplateErr
+		return
+	}
+	existDerivedList, getExistErr := getLogMetricDerivedList(metricGroupObj.Guid)
+	if getExistErr != nil {
+		err = getExistErr
+		return
+	}
+	metricMap, buildErr := buildLogMetricDerivedMap(logMonitorTemplateObj.MetricList, param.DerivedMetricList)
+	if buildErr != nil {
+		err = buildErr
+		return
+	}
+	serviceGroup, monitorType := GetLogMetricServiceGroup(metricGroupObj.LogMetricMonitor)
+	existDerivedMap := make(map[string]*models.LogMetricDerivedTable)
+	for _, v := range existDerivedList {
+		existDerivedMap[v.Metric] = v
+	}
+	inputDerivedMap := make(map[string]bool)
+	for _, derivedObj := range param.DerivedMetricList {
+		inputDerivedMap[derivedObj.Metric] = true
+		promExpr, _, getExprErr := getLogMetricDerivedPromExpr(derivedObj, metricMap, metricGroupObj.MetricPrefixCode, serviceGroup, sucRetCode)
+		if getExprErr != nil {
+			err = getExprErr
+			return
+		}
+		tmpMetricWithPrefix := derivedObj.Metric
+		if metricGroupObj.MetricPrefixCode != "" {
+			tmpMetricWithPrefix = metricGroupObj.MetricPrefixCode + "_" + derivedObj.Metric
+		}
+		if existDerivedObj, ok := existDerivedMap[derivedObj.Metric]; ok {
+			actions = append(actions, &Action{Sql: "update log_metric_derived set display_name=?,expression=?,color_group=?,auto_alarm=?,range_config=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
+				derivedObj.DisplayName, derivedObj.Expression, derivedObj.ColorGroup, derivedObj.AutoAlarm, derivedObj.RangeConfig, operator, nowTime, existDerivedObj.Guid,
+			}})
+			actions = append(actions, &Action{Sql: "update metric set prom_expr=?,update_time=?,update_user=? where guid=?", Param: []interface{}{promExpr, nowTime, operator, generateMetricGuid(tmpMetricWithPrefix, serviceGroup)}})
+			continue
+		}
+		actions = append(actions, &Action{Sql: "insert into log_metric_derived(guid,log_metric_group,metric,display_name,expression,color_group,auto_alarm,range_config,create_user,update_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			"lmd_" + guid.CreateGuid(), metricGroupObj.Guid, derivedObj.Metric, derivedObj.DisplayName, derivedObj.Expression, derivedObj.ColorGroup, derivedObj.AutoAlarm, derivedObj.RangeConfig, operator, operator, nowTime, nowTime,
+		}})
+		actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,log_metric_group,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?,?,?,?)",
+			Param: []interface{}{generateMetricGuid(tmpMetricWithPrefix, serviceGroup), tmpMetricWithPrefix, monitorType, promExpr, serviceGroup, models.MetricWorkspaceService, nowTime, metricGroupObj.Guid, nowTime, operator, operator}})
+	}
+	for _, existDerivedObj := range existDerivedList {
+		if inputDerivedMap[existDerivedObj.Metric] {
+			continue
+		}
+		tmpActions, tmpEndpointGroups := getDeleteLogMetricDerivedActions(existDerivedObj, metricGroupObj.MetricPrefixCode, serviceGroup)
+		actions = append(actions, tmpActions...)
+		affectEndpointGroup = append(affectEndpointGroup, tmpEndpointGroups...)
+	}
+	return
+}
+
+// getRefreshLogMetricDerivedActions 模版指标变化(模版修改、版本发布和回滚)后按新的指标列表重新生成派生指标的表达式
+func getRefreshLogMetricDerivedActions(metricGroupObj *models.LogMetricGroup, derivedList []*models.LogMetricDerivedTable, metricList []*models.LogMetricTemplate, sucRetCode, operator string, nowTime time.Time) (actions []*Action, derivedMetricList []*models.LogMetricTemplate, affectEndpointGroup []string, err error) {
+	if len(derivedList) == 0 {
+		return
+	}
+	metricMap, buildErr := buildLogMetricDerivedMap(metricList, derivedList)
+	if buildErr != nil {
+		err = fmt.Errorf("log metric group:%s %s", metricGroupObj.Name, buildErr.Error())
+		return
+	}
+	serviceGroup, _ := GetLogMetricServiceGroup(metricGroupObj.LogMetricMonitor)
+	var metricGuidList []string
+	for _, derivedObj := range derivedList {
+		promExpr, tagList, getExprErr := getLogMetricDerivedPromExpr(derivedObj, metricMap, metricGroupObj.MetricPrefixCode, serviceGroup, sucRetCode)
+		if getExprErr != nil {
+			err = fmt.Errorf("log metric group:%s %s", metricGroupObj.Name, getExprErr.Error())
+			return
+		}
+		tmpMetricWithPrefix := derivedObj.Metric
+		if metricGroupObj.MetricPrefixCode != "" {
+			tmpMetricWithPrefix = metricGroupObj.MetricPrefixCode + "_" + derivedObj.Metric
+		}
+		tmpMetricGuid := generateMetricGuid(tmpMetricWithPrefix, serviceGroup)
+		actions = append(actions, &Action{Sql: "update metric set prom_expr=?,update_time=?,update_user=? where guid=?", Param: []interface{}{promExpr, nowTime, operator, tmpMetricGuid}})
+		metricGuidList = append(metricGuidList, tmpMetricGuid)
+		derivedMetricList = append(derivedMetricList, transLogMetricDerivedToTemplate(derivedObj, tagList))
+	}
+	affectEndpointGroup, err = getMetricAlarmEndpointGroupList(metricGuidList)
+	return
+}
+
+// getRefreshTemplateLogMetricDerivedActions 模版修改后刷新跟随模版最新配置的日志指标组下的派生指标,锁定版本的在发布时刷新
+func getRefreshTemplateLogMetricDerivedActions(logMonitorTemplateGuid string, metricList []*models.LogMetricTemplate, operator string, nowTime time.Time) (actions []*Action, affectEndpointGroup []string, err error) {
+	var metricGroupList []*models.LogMetricGroup
+	if err = x.SQL("select * from log_metric_group where log_monitor_template=? and pinned_template_version=0", logMonitorTemplateGuid).Find(&metricGroupList); err != nil {
+		err = fmt.Errorf("query log_metric_group table fail,%s ", err.Error())
+		return
+	}
+	for _, metricGroupObj := range metricGroupList {
+		derivedList, getDerivedErr := getLogMetricDerivedList(metricGroupObj.Guid)
+		if getDerivedErr != nil {
+			err = getDerivedErr
+			return
+		}
+		if len(derivedList) == 0 {
+			continue
+		}
+		stringMapData, getMapErr := getLogMetricGroupMapData(metricGroupObj.Guid)
+		if getMapErr != nil {
+			err = getMapErr
+			return
+		}
+		tmpActions, _, tmpEndpointGroups, tmpErr := getRefreshLogMetricDerivedActions(metricGroupObj, derivedList, metricList, getRetCodeSuccessCode(stringMapData["retcode"]), operator, nowTime)
+		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		actions = append(actions, tmpActions...)
+		affectEndpointGroup = append(affectEndpointGroup, tmpEndpointGroups...)
+	}
+	return
+}
+
+func getDeleteLogMetricDerivedActions(derivedObj *models.LogMetricDerivedTable, metricPrefixCode, serviceGroup string) (actions []*Action, affectEndpointGroup []string) {
+	tmpMetricWithPrefix := derivedObj.Metric
+	if metricPrefixCode != "" {
+		tmpMetricWithPrefix = metricPrefixCode + "_" + derivedObj.Metric
+	}
+	actions = append(actions, &Action{Sql: "delete from log_metric_derived where guid=?", Param: []interface{}{derivedObj.Guid}})
+	deleteMetricActions, endpointGroups := getDeleteLogMetricActions(tmpMetricWithPrefix, serviceGroup)
+	actions = append(actions, deleteMetricActions...)
+	affectEndpointGroup = append(affectEndpointGroup, endpointGroups...)
+	return
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func TestTokenizeLogMetricDerivedExpression(t *testing.T) {
+	testCases := []struct {
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{`req_fail_count/req_count*100`, []string{"req_fail_count", "/", "req_count", "*", "100"}, false},
+		{` (a + b2) - 0.5 `, []string{"(", "a", "+", "b2", ")", "-", "0.5"}, false},
+		{"a\t*\t-b", []string{"a", "*", "-", "b"}, false},
+		{`a % b`, nil, true},
+		{`a + 1..2`, nil, true},
+		{`a{code="200"}`, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := tokenizeLogMetricDerivedExpression(tc.expr)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("tokenizeLogMetricDerivedExpression(%q): want error %v, got %v", tc.expr, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("tokenizeLogMetricDerivedExpression(%q): want %q, got %q", tc.expr, tc.want, got)
+		}
+	}
+}
+
+func TestParseLogMetricDerivedExpression(t *testing.T) {
+	testCases := []struct {
+		expr string
+		want []string
+	}{
+		{`req_fail_count / req_count * 100`, []string{"req_fail_count", "req_count"}},
+		{`-(a - b) / (a + 1)`, []string{"a", "b"}},
+		{`((a))`, []string{"a"}},
+		{`100 - a * 2`, []string{"a"}},
+	}
+	for _, tc := range testCases {
+		_, refMetrics, err := parseLogMetricDerivedExpression(tc.expr)
+		if err != nil {
+			t.Errorf("parseLogMetricDerivedExpression(%q): unexpected error %v", tc.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(refMetrics, tc.want) {
+			t.Errorf("parseLogMetricDerivedExpression(%q): want %q, got %q", tc.expr, tc.want, refMetrics)
+		}
+	}
+}
+
+func TestParseLogMetricDerivedExpressionError(t *testing.T) {
+	for _, expr := range []string{``, `   `, `a +`, `* a`, `(a`, `a)`, `a b`, `()`, `1 + 2`, `a / (b -)`, `a $ b`} {
+		if _, _, err := parseLogMetricDerivedExpression(expr); err == nil {
+			t.Errorf("parseLogMetricDerivedExpression(%q): want error, got nil", expr)
+		}
+	}
+}
+
+func TestGetLogMetricDerivedPromExpr(t *testing.T) {
+	metricList := []*models.LogMetricTemplate{
+		{Metric: "req_count", AggType: "count", TagConfigList: []string{"code", "retcode"}},
+		{Metric: "err_count", AggType: "count", TagConfigList: []string{"code"}},
+		{Metric: "cost_max", AggType: "max", TagConfigList: []string{"code"}},
+	}
+	derivedObj := &models.LogMetricDerivedTable{Metric: "err_rate", Expression: "err_count / req_count * 100"}
+	metricMap, err := buildLogMetricDerivedMap(metricList, []*models.LogMetricDerivedTable{derivedObj})
+	if err != nil {
+		t.Fatalf("buildLogMetricDerivedMap: unexpected error %v", err)
+	}
+	promExpr, tagList, err := getLogMetricDerivedPromExpr(derivedObj, metricMap, "app", "sg", "200")
+	if err != nil {
+		t.Fatalf("getLogMetricDerivedPromExpr: unexpected error %v", err)
+	}
+	// 只保留引用指标的公共标签
+	if !reflect.DeepEqual(tagList, []string{"code"}) {
+		t.Errorf("want tag list [code], got %q", tagList)
+	}
+	wantExpr := fmt.Sprintf("sum(%s) by (service_group,code) / sum(%s) by (service_group,code) * 100",
+		getLogMetricExprByAggType("app_err_count", "count", "sg", []string{"code"}), getLogMetricExprByAggType("app_req_count", "count", "sg", []string{"code", "retcode"}))
+	if promExpr != wantExpr {
+		t.Errorf("want expr %s, got %s", wantExpr, promExpr)
+	}
+
+	maxObj := &models.LogMetricDerivedTable{Metric: "cost_max_s", Expression: "cost_max / 1000"}
+	if promExpr, _, err = getLogMetricDerivedPromExpr(maxObj, metricMap, "", "sg", ""); err != nil || !strings.HasPrefix(promExpr, "max(") {
+		t.Errorf("max agg metric should be aggregated with max, got %s err %v", promExpr, err)
+	}
+
+	// 引用的指标不在指标组里,如模版新版本删掉了该指标
+	missingObj := &models.LogMetricDerivedTable{Metric: "timeout_rate", Expression: "timeout_count / req_count"}
+	if _, _, err = getLogMetricDerivedPromExpr(missingObj, metricMap, "app", "sg", "200"); err == nil {
+		t.Errorf("reference metric not exist: want error, got nil")
+	}
+}
+
+func TestBuildLogMetricDerivedMapDuplicate(t *testing.T) {
+	metricList := []*models.LogMetricTemplate{{Metric: "req_count"}}
+	if _, err := buildLogMetricDerivedMap(metricList, []*models.LogMetricDerivedTable{{Metric: "req_count"}}); err == nil {
+		t.Errorf("derived metric same as template metric: want error, got nil")
+	}
+	if _, err := buildLogMetricDerivedMap(metricList, []*models.LogMetricDerivedTable{{Metric: "a"}, {Metric: "a"}}); err == nil {
+		t.Errorf("duplicate derived metric: want error, got nil")
+	}
+}
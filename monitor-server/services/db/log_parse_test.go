@@ -0,0 +1,105 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 和agent端 collector/monitor_log_parse_linux_test.go 使用相同的用例,两边的解析逻辑需要保持一致
+
+func TestParseLogfmtLine(t *testing.T) {
+	testCases := []struct {
+		line string
+		want map[string]string
+	}{
+		{`level=info code=200 cost=12`, map[string]string{"level": "info", "code": "200", "cost": "12"}},
+		{`msg="hello world" user=bob`, map[string]string{"msg": "hello world", "user": "bob"}},
+		{`msg="say \"hi\"" a=1`, map[string]string{"msg": `say "hi"`, "a": "1"}},
+		{`debug  key= other`, map[string]string{"debug": "", "key": "", "other": ""}},
+		{`=value a=1`, map[string]string{"a": "1"}},
+		{``, map[string]string{}},
+	}
+	for _, tc := range testCases {
+		if got := parseLogfmtLine(tc.line); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLogfmtLine(%q): want %v, got %v", tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestGetLogCsvDelimiter(t *testing.T) {
+	testCases := []struct {
+		delimiter string
+		want      rune
+		wantErr   bool
+	}{
+		{"", ',', false},
+		{"|", '|', false},
+		{"\\t", '\t', false},
+		{"；", '；', false},
+		{"||", 0, true},
+		{"\"", 0, true},
+		{"\n", 0, true},
+	}
+	for _, tc := range testCases {
+		got, err := getLogCsvDelimiter(tc.delimiter)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("getLogCsvDelimiter(%q): want error %v, got %v", tc.delimiter, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("getLogCsvDelimiter(%q): want %q, got %q", tc.delimiter, tc.want, got)
+		}
+	}
+}
+
+func TestParseLogCsvLine(t *testing.T) {
+	testCases := []struct {
+		delimiter string
+		line      string
+		want      map[string]string
+		wantErr   bool
+	}{
+		{"", `a,b,c`, map[string]string{"1": "a", "2": "b", "3": "c"}, false},
+		{"|", `a|"b|c"|`, map[string]string{"1": "a", "2": "b|c", "3": ""}, false},
+		{"\\t", "x\ty", map[string]string{"1": "x", "2": "y"}, false},
+		{"", `a,b"c,d`, map[string]string{"1": "a", "2": `b"c`, "3": "d"}, false},
+		{"||", `a,b`, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := parseLogCsvLine(tc.delimiter, tc.line)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseLogCsvLine(%q, %q): want error %v, got %v", tc.delimiter, tc.line, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLogCsvLine(%q, %q): want %v, got %v", tc.delimiter, tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestParseLogCombinedLine(t *testing.T) {
+	testCases := []struct {
+		line    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{`127.0.0.1 - bob [10/Oct/2024:13:55:36 +0800] "GET /api/v1/user?id=1 HTTP/1.1" 200 2326 "http://a.com/" "curl/7.29.0" 0.012 "upstream"`,
+			map[string]string{"remote_addr": "127.0.0.1", "remote_user": "bob", "time_local": "10/Oct/2024:13:55:36 +0800", "request": "GET /api/v1/user?id=1 HTTP/1.1",
+				"status": "200", "body_bytes_sent": "2326", "http_referer": "http://a.com/", "http_user_agent": "curl/7.29.0",
+				"method": "GET", "uri": "/api/v1/user?id=1", "protocol": "HTTP/1.1", "extra_1": "0.012", "extra_2": "upstream"}, false},
+		{`10.0.0.1 - - [10/Oct/2024:13:55:36 +0800] "bad \"request\"" 400 0`,
+			map[string]string{"remote_addr": "10.0.0.1", "remote_user": "-", "time_local": "10/Oct/2024:13:55:36 +0800", "request": `bad \"request\"`,
+				"status": "400", "body_bytes_sent": "0", "http_referer": "", "http_user_agent": ""}, false},
+		{`not a combined line`, nil, true},
+	}
+	for _, tc := range testCases {
+		got, err := parseLogCombinedLine(tc.line)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseLogCombinedLine(%q): want error %v, got %v", tc.line, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseLogCombinedLine(%q): want %v, got %v", tc.line, tc.want, got)
+		}
+	}
+}
@@ -36,7 +36,7 @@ func ListLogMonitorTemplate(param *models.LogMonitorTemplateListParam, userRoles
 		err = fmt.Errorf("query log_monitor_template table fail,%s ", err.Error())
 		return
 	}
-	result = &models.LogMonitorTemplateListResp{JsonList: []*models.LogMonitorTemplate{}, RegularList: []*models.LogMonitorTemplate{}}
+	result = &models.LogMonitorTemplateListResp{JsonList: []*models.LogMonitorTemplate{}, RegularList: []*models.LogMonitorTemplate{}, StructuredList: []*models.LogMonitorTemplate{}}
 	for _, row := range rows {
 		row.CreateTimeString = row.CreateTime.Format(models.DatetimeFormat)
 		row.UpdateTimeString = row.UpdateTime.Format(models.DatetimeFormat)
@@ -46,6 +46,8 @@ func ListLogMonitorTemplate(param *models.LogMonitorTemplateListParam, userRoles
 			result.RegularList = append(result.RegularList, row)
 		} else if row.LogType == models.LogMonitorCustomType {
 			result.CustomList = append(result.CustomList, row)
+		} else if models.IsLogMonitorStructuredType(row.LogType) {
+			result.StructuredList = append(result.StructuredList, row)
 		}
 	}
 	return
@@ -61,6 +63,9 @@ func GetLogMonitorTemplate(logMonitorTemplateGuid string) (result *models.LogMon
 	logMonitorTemplateRow.UpdateTimeString = logMonitorTemplateRow.UpdateTime.Format(models.DatetimeFormat)
 	result = &models.LogMonitorTemplateDto{LogMonitorTemplate: *logMonitorTemplateRow, CalcResultObj: &models.CheckRegExpResult{}, ParamList: []*models.LogParamTemplateObj{}, MetricList: []*models.LogMetricTemplate{}}
 	result.LogMonitorTemplateVersion = logMonitorTemplateRow.UpdateTime.Format(models.DatetimeDigitFormat)
+	if result.Version, err = getLatestLogMonitorTemplateVersion(logMonitorTemplateGuid); err != nil {
+		return
+	}
 	if result.CalcResult != "" {
 		if err = json.Unmarshal([]byte(result.CalcResult), result.CalcResultObj); err != nil {
 			err = fmt.Errorf("json unmarhsal calc result:%s fail:%s ", result.CalcResult, err.Error())
@@ -142,6 +147,7 @@ func GetSimpleLogMonitorTemplate(guid string) (logMonitorTemplateRow *models.Log
 func CreateLogMonitorTemplate(param *models.LogMonitorTemplateDto, operator string) (err error) {
 	param.Guid = ""
 	actions := getCreateLogMonitorTemplateActions(param, operator)
+	actions = append(actions, getSaveLogMonitorTemplateVersionAction(param, param.ChangeLog, operator))
 	err = Transaction(actions)
 	return
 }
@@ -151,18 +157,21 @@ func getCreateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 		param.Guid = "lmt_" + guid.CreateGuid()
 	}
 	nowTime := time.Now()
-	actions = append(actions, &Action{Sql: "insert into log_monitor_template(guid,name,log_type,json_regular,demo_log,calc_result,create_user,update_user,create_time,update_time,success_code) values (?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-		param.Guid, param.Name, param.LogType, param.JsonRegular, param.DemoLog, param.CalcResult, operator, operator, nowTime, nowTime, param.SuccessCode,
+	param.CreateUser, param.UpdateUser, param.CreateTime, param.UpdateTime = operator, operator, nowTime, nowTime
+	actions = append(actions, &Action{Sql: "insert into log_monitor_template(guid,name,log_type,json_regular,demo_log,calc_result,create_user,update_user,create_time,update_time,success_code,delimiter) values (?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+		param.Guid, param.Name, param.LogType, param.JsonRegular, param.DemoLog, param.CalcResult, operator, operator, nowTime, nowTime, param.SuccessCode, param.Delimiter,
 	}})
 	logParamGuidList := guid.CreateGuidList(len(param.ParamList))
 	for i, logParamObj := range param.ParamList {
+		logParamObj.Guid = "lpt_" + logParamGuidList[i]
 		actions = append(actions, &Action{Sql: "insert into log_param_template(guid,log_monitor_template,name,display_name,json_key,regular,demo_match_value,create_user,update_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-			"lpt_" + logParamGuidList[i], param.Guid, logParamObj.Name, logParamObj.DisplayName, logParamObj.JsonKey, logParamObj.Regular, logParamObj.DemoMatchValue, operator, operator, nowTime, nowTime,
+			logParamObj.Guid, param.Guid, logParamObj.Name, logParamObj.DisplayName, logParamObj.JsonKey, logParamObj.Regular, logParamObj.DemoMatchValue, operator, operator, nowTime, nowTime,
 		}})
 		tmpStringMapGuidList := guid.CreateGuidList(len(logParamObj.StringMap))
 		for stringMapIndex, stringMapObj := range logParamObj.StringMap {
+			stringMapObj.Guid = "lmsm_" + tmpStringMapGuidList[stringMapIndex]
 			actions = append(actions, &Action{Sql: "insert into log_metric_string_map(guid,log_monitor_template,log_param_name,value_type,source_value,regulative,target_value,update_time) values (?,?,?,?,?,?,?,?)", Param: []interface{}{
-				"lmsm_" + tmpStringMapGuidList[stringMapIndex], param.Guid, logParamObj.Name, stringMapObj.ValueType, stringMapObj.SourceValue, stringMapObj.Regulative, stringMapObj.TargetValue, nowTime.Format(models.DatetimeFormat),
+				stringMapObj.Guid, param.Guid, logParamObj.Name, stringMapObj.ValueType, stringMapObj.SourceValue, stringMapObj.Regulative, stringMapObj.TargetValue, nowTime.Format(models.DatetimeFormat),
 			}})
 		}
 	}
@@ -172,20 +181,45 @@ func getCreateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 			tmpTagConfigBytes, _ := json.Marshal(logMetricObj.TagConfigList)
 			logMetricObj.TagConfig = string(tmpTagConfigBytes)
 		}
+		logMetricObj.Guid = "lmet_" + logMetricGuidList[i]
 		actions = append(actions, &Action{Sql: "insert into log_metric_template(guid,log_monitor_template,log_param_name,metric,display_name,step,agg_type,tag_config,create_user,update_user,create_time,update_time,color_group,auto_alarm,range_config) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-			"lmet_" + logMetricGuidList[i], param.Guid, logMetricObj.LogParamName, logMetricObj.Metric, logMetricObj.DisplayName, logMetricObj.Step, logMetricObj.AggType, logMetricObj.TagConfig, operator, operator, nowTime, nowTime, logMetricObj.ColorGroup, logMetricObj.AutoAlarm, logMetricObj.RangeConfig,
+			logMetricObj.Guid, param.Guid, logMetricObj.LogParamName, logMetricObj.Metric, logMetricObj.DisplayName, logMetricObj.Step, logMetricObj.AggType, logMetricObj.TagConfig, operator, operator, nowTime, nowTime, logMetricObj.ColorGroup, logMetricObj.AutoAlarm, logMetricObj.RangeConfig,
 		}})
 	}
 	return
 }
 
 func UpdateLogMonitorTemplate(param *models.LogMonitorTemplateDto, operator string) (affectEndpoints []string, err error) {
-	var actions []*Action
-	actions, affectEndpoints, err = getUpdateLogMonitorTemplateActions(param, operator)
+	var actions, updateActions []*Action
+	var latestVersion int
+	if latestVersion, err = getLatestLogMonitorTemplateVersion(param.Guid); err != nil {
+		return
+	}
+	// 历史模版没有版本记录,先把修改前的配置保存为第一个版本,方便对比和回滚
+	if latestVersion == 0 {
+		existLogMonitorObj, getExistErr := GetLogMonitorTemplate(param.Guid)
+		if getExistErr != nil {
+			err = getExistErr
+			return
+		}
+		actions = append(actions, getSaveLogMonitorTemplateVersionAction(existLogMonitorObj, "init", operator))
+	}
+	updateActions, affectEndpoints, err = getUpdateLogMonitorTemplateActions(param, operator)
 	if err != nil {
 		return
 	}
-	err = Transaction(actions)
+	actions = append(actions, updateActions...)
+	derivedActions, derivedEndpointGroups, refreshErr := getRefreshTemplateLogMetricDerivedActions(param.Guid, param.MetricList, operator, param.UpdateTime)
+	if refreshErr != nil {
+		err = refreshErr
+		return
+	}
+	actions = append(actions, derivedActions...)
+	actions = append(actions, getSaveLogMonitorTemplateVersionAction(param, param.ChangeLog, operator))
+	if err = Transaction(actions); err != nil {
+		return
+	}
+	syncLogMetricGroupPrometheusRule(derivedEndpointGroups)
 	return
 }
 
@@ -196,14 +230,16 @@ func getUpdateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 		return
 	}
 	nowTime := time.Now()
-	actions = append(actions, &Action{Sql: "update log_monitor_template set name=?,json_regular=?,demo_log=?,calc_result=?,update_user=?,update_time=?,success_code=? where guid=?", Param: []interface{}{
-		param.Name, param.JsonRegular, param.DemoLog, param.CalcResult, operator, nowTime, param.SuccessCode, param.Guid,
+	param.CreateUser, param.CreateTime, param.UpdateUser, param.UpdateTime = existLogMonitorObj.CreateUser, existLogMonitorObj.CreateTime, operator, nowTime
+	actions = append(actions, &Action{Sql: "update log_monitor_template set name=?,json_regular=?,demo_log=?,calc_result=?,update_user=?,update_time=?,success_code=?,delimiter=? where guid=?", Param: []interface{}{
+		param.Name, param.JsonRegular, param.DemoLog, param.CalcResult, operator, nowTime, param.SuccessCode, param.Delimiter, param.Guid,
 	}})
 	logParamGuidList := guid.CreateGuidList(len(param.ParamList))
 	for i, logParamObj := range param.ParamList {
 		if logParamObj.Guid == "" {
+			logParamObj.Guid = "lpt_" + logParamGuidList[i]
 			actions = append(actions, &Action{Sql: "insert into log_param_template(guid,log_monitor_template,name,display_name,json_key,regular,demo_match_value,create_user,update_user,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-				"lpt_" + logParamGuidList[i], param.Guid, logParamObj.Name, logParamObj.DisplayName, logParamObj.JsonKey, logParamObj.Regular, logParamObj.DemoMatchValue, operator, operator, nowTime, nowTime,
+				logParamObj.Guid, param.Guid, logParamObj.Name, logParamObj.DisplayName, logParamObj.JsonKey, logParamObj.Regular, logParamObj.DemoMatchValue, operator, operator, nowTime, nowTime,
 			}})
 		} else {
 			actions = append(actions, &Action{Sql: "update log_param_template set name=?,display_name=?,json_key=?,regular=?,demo_match_value=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
@@ -212,8 +248,9 @@ func getUpdateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 		}
 		tmpStringMapGuidList := guid.CreateGuidList(len(logParamObj.StringMap))
 		for stringMapIndex, stringMapObj := range logParamObj.StringMap {
+			stringMapObj.Guid = "lmsm_" + tmpStringMapGuidList[stringMapIndex]
 			actions = append(actions, &Action{Sql: "insert into log_metric_string_map(guid,log_monitor_template,log_param_name,value_type,source_value,regulative,target_value,update_time) values (?,?,?,?,?,?,?,?)", Param: []interface{}{
-				"lmsm_" + tmpStringMapGuidList[stringMapIndex], param.Guid, logParamObj.Name, stringMapObj.ValueType, stringMapObj.SourceValue, stringMapObj.Regulative, stringMapObj.TargetValue, nowTime.Format(models.DatetimeFormat),
+				stringMapObj.Guid, param.Guid, logParamObj.Name, stringMapObj.ValueType, stringMapObj.SourceValue, stringMapObj.Regulative, stringMapObj.TargetValue, nowTime.Format(models.DatetimeFormat),
 			}})
 		}
 	}
@@ -235,8 +272,9 @@ func getUpdateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 	logMetricGuidList := guid.CreateGuidList(len(param.MetricList))
 	for i, logMetricObj := range param.MetricList {
 		if logMetricObj.Guid == "" {
+			logMetricObj.Guid = "lmet_" + logMetricGuidList[i]
 			actions = append(actions, &Action{Sql: "insert into log_metric_template(guid,log_monitor_template,log_param_name,metric,display_name,step,agg_type,tag_config,create_user,update_user,create_time,update_time,color_group,auto_alarm,range_config) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
-				"lmet_" + logMetricGuidList[i], param.Guid, logMetricObj.LogParamName, logMetricObj.Metric, logMetricObj.DisplayName, logMetricObj.Step, logMetricObj.AggType, logMetricObj.TagConfig, operator, operator, nowTime, nowTime, logMetricObj.ColorGroup, logMetricObj.AutoAlarm, logMetricObj.RangeConfig,
+				logMetricObj.Guid, param.Guid, logMetricObj.LogParamName, logMetricObj.Metric, logMetricObj.DisplayName, logMetricObj.Step, logMetricObj.AggType, logMetricObj.TagConfig, operator, operator, nowTime, nowTime, logMetricObj.ColorGroup, logMetricObj.AutoAlarm, logMetricObj.RangeConfig,
 			}})
 		} else {
 			actions = append(actions, &Action{Sql: "update log_metric_template set log_param_name=?,metric=?,display_name=?,step=?,agg_type=?,tag_config=?,update_user=?,update_time=?,color_group=?,auto_alarm=?,range_config=? where guid=?", Param: []interface{}{
@@ -257,7 +295,8 @@ func getUpdateLogMonitorTemplateActions(param *models.LogMonitorTemplateDto, ope
 		}
 	}
 	var endpointRelRows []*models.LogMetricEndpointRelTable
-	queryEndpointErr := x.SQL("select source_endpoint from log_metric_endpoint_rel where log_metric_monitor in (select log_metric_monitor from log_metric_group where log_monitor_template=?)", param.Guid).Find(&endpointRelRows)
+	// 锁定了版本的日志指标组不受模版修改影响,需要通过发布新版本更新
+	queryEndpointErr := x.SQL("select source_endpoint from log_metric_endpoint_rel where log_metric_monitor in (select log_metric_monitor from log_metric_group where log_monitor_template=? and pinned_template_version=0)", param.Guid).Find(&endpointRelRows)
 	if queryEndpointErr != nil {
 		log.Logger.Error("query log metric template affect endpoints fail", log.String("logMonitorTemplate", param.Guid), log.Error(queryEndpointErr))
 	} else {
@@ -291,6 +330,7 @@ func getDeleteLogMonitorTemplateActions(logMonitorTemplateGuid string, errMsgObj
 	}
 	actions = append(actions, &Action{Sql: "delete from log_metric_template where log_monitor_template=?", Param: []interface{}{logMonitorTemplateGuid}})
 	actions = append(actions, &Action{Sql: "delete from log_param_template where log_monitor_template=?", Param: []interface{}{logMonitorTemplateGuid}})
+	actions = append(actions, &Action{Sql: "delete from log_monitor_template_version where log_monitor_template=?", Param: []interface{}{logMonitorTemplateGuid}})
 	actions = append(actions, &Action{Sql: "delete from log_monitor_template where guid=?", Param: []interface{}{logMonitorTemplateGuid}})
 	return
 }
@@ -337,6 +377,14 @@ func GetLogMonitorTemplateById(guid string) (logMonitorTemplate *models.LogMonit
 func ImportLogMonitorTemplate(params []*models.LogMonitorTemplateDto, operator string) (affectEndpoints []string, err error) {
 	var actions []*Action
 	var existLogMonitorTemplate *models.LogMonitorTemplate
+	for _, inputParam := range params {
+		for _, logMetric := range inputParam.MetricList {
+			if err = ValidateLogMetricAggConfig(logMetric.AggType, ""); err != nil {
+				err = fmt.Errorf("template:%s metric:%s %s", inputParam.Name, logMetric.Metric, err.Error())
+				return
+			}
+		}
+	}
 	for _, inputParam := range params {
 		if existLogMonitorTemplate, err = GetLogMonitorTemplateById(inputParam.Guid); err != nil {
 			return
@@ -370,6 +418,7 @@ func ImportLogMonitorTemplate(params []*models.LogMonitorTemplateDto, operator s
 		inputParam.CalcResult = string(calcResultBytes)
 		tmpActions := getCreateLogMonitorTemplateActions(inputParam, operator)
 		actions = append(actions, tmpActions...)
+		actions = append(actions, getSaveLogMonitorTemplateVersionAction(inputParam, inputParam.ChangeLog, operator))
 	}
 	if err = Transaction(actions); err != nil {
 		affectEndpoints = distinctStringList(affectEndpoints)
@@ -0,0 +1,544 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func getLatestLogMonitorTemplateVersion(logMonitorTemplateGuid string) (version int, err error) {
+	queryResult, queryErr := x.QueryString("select max(version) as version from log_monitor_template_version where log_monitor_template=?", logMonitorTemplateGuid)
+	if queryErr != nil {
+		err = fmt.Errorf("query log_monitor_template_version table fail,%s ", queryErr.Error())
+		return
+	}
+	if len(queryResult) > 0 && queryResult[0]["version"] != "" {
+		version, _ = strconv.Atoi(queryResult[0]["version"])
+	}
+	return
+}
+
+func getLogMonitorTemplateVersion(logMonitorTemplateGuid string, version int) (result *models.LogMonitorTemplateVersionTable, err error) {
+	var rows []*models.LogMonitorTemplateVersionTable
+	err = x.SQL("select * from log_monitor_template_version where log_monitor_template=? and version=?", logMonitorTemplateGuid, version).Find(&rows)
+	if err != nil {
+		err = fmt.Errorf("query log_monitor_template_version table fail,%s ", err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		err = fmt.Errorf("can not find log monitor template:%s version:%d ", logMonitorTemplateGuid, version)
+		return
+	}
+	result = rows[0]
+	return
+}
+
+// GetLogMonitorTemplateVersionData 获取某个版本的模版快照
+func GetLogMonitorTemplateVersionData(logMonitorTemplateGuid string, version int) (result *models.LogMonitorTemplateDto, err error) {
+	versionObj, getErr := getLogMonitorTemplateVersion(logMonitorTemplateGuid, version)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	result = &models.LogMonitorTemplateDto{}
+	if err = json.Unmarshal([]byte(versionObj.Snapshot), result); err != nil {
+		err = fmt.Errorf("json unmarshal log monitor template version snapshot fail,%s ", err.Error())
+		return
+	}
+	result.Version = versionObj.Version
+	result.ChangeLog = versionObj.ChangeLog
+	return
+}
+
+// getSaveLogMonitorTemplateVersionAction 把模版配置保存为一个新版本,和模版的修改在同一个事务里执行,版本号取已有最大版本加1
+func getSaveLogMonitorTemplateVersionAction(templateObj *models.LogMonitorTemplateDto, changeLog, operator string) *Action {
+	snapshotObj := *templateObj
+	snapshotObj.Version = 0
+	snapshotObj.ChangeLog = changeLog
+	snapshotObj.Permission = nil
+	snapshotObj.LogMonitorTemplateVersion = templateObj.UpdateTime.Format(models.DatetimeDigitFormat)
+	for _, v := range snapshotObj.MetricList {
+		if v.TagConfigList == nil && v.TagConfig != "" {
+			json.Unmarshal([]byte(v.TagConfig), &v.TagConfigList)
+		}
+	}
+	snapshotBytes, _ := json.Marshal(snapshotObj)
+	return &Action{Sql: "insert into log_monitor_template_version(guid,log_monitor_template,version,snapshot,change_log,create_user,create_time) " +
+		"select ?,?,ifnull(max(version),0)+1,?,?,?,? from log_monitor_template_version where log_monitor_template=?", Param: []interface{}{
+		"lmtv_" + guid.CreateGuid(), templateObj.Guid, string(snapshotBytes), changeLog, operator, time.Now(), templateObj.Guid,
+	}}
+}
+
+// ListLogMonitorTemplateVersion 版本列表,同时返回每个版本被哪些层级对象使用,未锁定版本的日志指标组算在最新版本上
+func ListLogMonitorTemplateVersion(logMonitorTemplateGuid string) (result []*models.LogMonitorTemplateVersionTable, err error) {
+	result = []*models.LogMonitorTemplateVersionTable{}
+	err = x.SQL("select guid,log_monitor_template,version,change_log,create_user,create_time from log_monitor_template_version where log_monitor_template=? order by version desc", logMonitorTemplateGuid).Find(&result)
+	if err != nil {
+		err = fmt.Errorf("query log_monitor_template_version table fail,%s ", err.Error())
+		return
+	}
+	queryRows, queryErr := x.QueryString("select distinct t1.pinned_template_version,t2.service_group from log_metric_group t1 left join log_metric_monitor t2 on t1.log_metric_monitor=t2.guid where t1.log_monitor_template=?", logMonitorTemplateGuid)
+	if queryErr != nil {
+		err = fmt.Errorf("query log metric group version fail,%s ", queryErr.Error())
+		return
+	}
+	versionServiceGroupMap := make(map[string][]string)
+	for _, row := range queryRows {
+		versionServiceGroupMap[row["pinned_template_version"]] = append(versionServiceGroupMap[row["pinned_template_version"]], row["service_group"])
+	}
+	for i, row := range result {
+		row.CreateTimeString = row.CreateTime.Format(models.DatetimeFormat)
+		row.ServiceGroupList = append([]string{}, versionServiceGroupMap[strconv.Itoa(row.Version)]...)
+		if i == 0 {
+			row.ServiceGroupList = append(row.ServiceGroupList, versionServiceGroupMap["0"]...)
+		}
+		row.ServiceGroupList = distinctStringList(row.ServiceGroupList)
+	}
+	return
+}
+
+type logParamTemplateDiffObj struct {
+	DisplayName string                            `json:"display_name"`
+	JsonKey     string                            `json:"json_key"`
+	Regular     string                            `json:"regular"`
+	StringMap   []*models.LogMetricStringMapTable `json:"string_map"`
+}
+
+type logMetricTemplateDiffObj struct {
+	LogParamName string   `json:"log_param_name"`
+	DisplayName  string   `json:"display_name"`
+	Step         int      `json:"step"`
+	AggType      string   `json:"agg_type"`
+	TagConfig    []string `json:"tag_config"`
+	ColorGroup   string   `json:"color_group"`
+	AutoAlarm    bool     `json:"auto_alarm"`
+	RangeConfig  string   `json:"range_config"`
+}
+
+// DiffLogMonitorTemplateVersion 对比两个版本,基本信息按字段对比,参数按参数名对比,指标按指标名对比
+func DiffLogMonitorTemplateVersion(logMonitorTemplateGuid string, fromVersion, toVersion int) (result *models.LogMonitorTemplateDiffResp, err error) {
+	var fromTemplate, toTemplate *models.LogMonitorTemplateDto
+	if fromTemplate, err = GetLogMonitorTemplateVersionData(logMonitorTemplateGuid, fromVersion); err != nil {
+		return
+	}
+	if toTemplate, err = GetLogMonitorTemplateVersionData(logMonitorTemplateGuid, toVersion); err != nil {
+		return
+	}
+	result = &models.LogMonitorTemplateDiffResp{FromVersion: fromVersion, ToVersion: toVersion, DiffList: []*models.LogMonitorTemplateDiffObj{}}
+	fromFieldMap := map[string]string{"name": fromTemplate.Name, "log_type": fromTemplate.LogType, "json_regular": fromTemplate.JsonRegular, "delimiter": fromTemplate.Delimiter, "demo_log": fromTemplate.DemoLog, "success_code": fromTemplate.SuccessCode}
+	toFieldMap := map[string]string{"name": toTemplate.Name, "log_type": toTemplate.LogType, "json_regular": toTemplate.JsonRegular, "delimiter": toTemplate.Delimiter, "demo_log": toTemplate.DemoLog, "success_code": toTemplate.SuccessCode}
+	result.DiffList = append(result.DiffList, diffLogMonitorTemplateMap("template", fromFieldMap, toFieldMap)...)
+	fromParamMap, toParamMap := make(map[string]string), make(map[string]string)
+	for _, v := range fromTemplate.ParamList {
+		fromParamMap[v.Name] = buildLogParamTemplateDiffValue(v)
+	}
+	for _, v := range toTemplate.ParamList {
+		toParamMap[v.Name] = buildLogParamTemplateDiffValue(v)
+	}
+	result.DiffList = append(result.DiffList, diffLogMonitorTemplateMap("param", fromParamMap, toParamMap)...)
+	fromMetricMap, toMetricMap := make(map[string]string), make(map[string]string)
+	for _, v := range fromTemplate.MetricList {
+		fromMetricMap[v.Metric] = buildLogMetricTemplateDiffValue(v)
+	}
+	for _, v := range toTemplate.MetricList {
+		toMetricMap[v.Metric] = buildLogMetricTemplateDiffValue(v)
+	}
+	result.DiffList = append(result.DiffList, diffLogMonitorTemplateMap("metric", fromMetricMap, toMetricMap)...)
+	return
+}
+
+func buildLogParamTemplateDiffValue(input *models.LogParamTemplateObj) string {
+	diffObj := logParamTemplateDiffObj{DisplayName: input.DisplayName, JsonKey: input.JsonKey, Regular: input.Regular, StringMap: []*models.LogMetricStringMapTable{}}
+	for _, v := range input.StringMap {
+		diffObj.StringMap = append(diffObj.StringMap, &models.LogMetricStringMapTable{ValueType: v.ValueType, SourceValue: v.SourceValue, Regulative: v.Regulative, TargetValue: v.TargetValue})
+	}
+	sort.Slice(diffObj.StringMap, func(i, j int) bool {
+		if diffObj.StringMap[i].ValueType != diffObj.StringMap[j].ValueType {
+			return diffObj.StringMap[i].ValueType < diffObj.StringMap[j].ValueType
+		}
+		return diffObj.StringMap[i].SourceValue < diffObj.StringMap[j].SourceValue
+	})
+	diffBytes, _ := json.Marshal(diffObj)
+	return string(diffBytes)
+}
+
+func buildLogMetricTemplateDiffValue(input *models.LogMetricTemplate) string {
+	diffObj := logMetricTemplateDiffObj{LogParamName: input.LogParamName, DisplayName: input.DisplayName, Step: input.Step, AggType: input.AggType, TagConfig: input.TagConfigList,
+		ColorGroup: input.ColorGroup, AutoAlarm: input.AutoAlarm, RangeConfig: input.RangeConfig}
+	diffBytes, _ := json.Marshal(diffObj)
+	return string(diffBytes)
+}
+
+func diffLogMonitorTemplateMap(diffType string, fromMap, toMap map[string]string) (diffList []*models.LogMonitorTemplateDiffObj) {
+	for k, fromValue := range fromMap {
+		toValue, ok := toMap[k]
+		if !ok {
+			diffList = append(diffList, &models.LogMonitorTemplateDiffObj{Type: diffType, Name: k, Action: "delete", OldValue: fromValue})
+		} else if toValue != fromValue {
+			diffList = append(diffList, &models.LogMonitorTemplateDiffObj{Type: diffType, Name: k, Action: "update", OldValue: fromValue, NewValue: toValue})
+		}
+	}
+	for k, toValue := range toMap {
+		if _, ok := fromMap[k]; !ok {
+			diffList = append(diffList, &models.LogMonitorTemplateDiffObj{Type: diffType, Name: k, Action: "add", NewValue: toValue})
+		}
+	}
+	sort.Slice(diffList, func(i, j int) bool {
+		return diffList[i].Name < diffList[j].Name
+	})
+	return
+}
+
+// getLogMetricGroupTemplate 锁定了版本的日志指标组使用版本快照,否则使用模版最新配置
+func getLogMetricGroupTemplate(metricGroup *models.LogMetricGroup) (result *models.LogMonitorTemplateDto, err error) {
+	if metricGroup.PinnedTemplateVersion > 0 && metricGroup.TemplateSnapshot != "" {
+		result = &models.LogMonitorTemplateDto{}
+		if err = json.Unmarshal([]byte(metricGroup.TemplateSnapshot), result); err != nil {
+			err = fmt.Errorf("json unmarshal log metric group:%s template snapshot fail,%s ", metricGroup.Guid, err.Error())
+		}
+		result.Version = metricGroup.PinnedTemplateVersion
+		return
+	}
+	return GetLogMonitorTemplate(metricGroup.LogMonitorTemplate)
+}
+
+func getLogMetricGroupByTemplateServiceGroup(logMonitorTemplateGuid string, serviceGroupList []string) (result []*models.LogMetricGroup, err error) {
+	serviceGroupFilterSql, serviceGroupFilterParam := createListParams(serviceGroupList, "")
+	queryParam := append([]interface{}{logMonitorTemplateGuid}, serviceGroupFilterParam...)
+	err = x.SQL("select * from log_metric_group where log_monitor_template=? and log_metric_monitor in (select guid from log_metric_monitor where service_group in ("+serviceGroupFilterSql+"))", queryParam...).Find(&result)
+	if err != nil {
+		err = fmt.Errorf("query log_metric_group table fail,%s ", err.Error())
+	}
+	return
+}
+
+func getLogMetricGroupAffectEndpoints(metricGroupList []*models.LogMetricGroup) (affectEndpoints []string, err error) {
+	var logMetricMonitorList []string
+	for _, v := range metricGroupList {
+		logMetricMonitorList = append(logMetricMonitorList, v.LogMetricMonitor)
+	}
+	if len(logMetricMonitorList) == 0 {
+		return
+	}
+	monitorFilterSql, monitorFilterParam := createListParams(distinctStringList(logMetricMonitorList), "")
+	var endpointRelRows []*models.LogMetricEndpointRelTable
+	err = x.SQL("select distinct source_endpoint from log_metric_endpoint_rel where log_metric_monitor in ("+monitorFilterSql+")", monitorFilterParam...).Find(&endpointRelRows)
+	if err != nil {
+		err = fmt.Errorf("query log_metric_endpoint_rel table fail,%s ", err.Error())
+		return
+	}
+	for _, v := range endpointRelRows {
+		affectEndpoints = append(affectEndpoints, v.SourceEndpoint)
+	}
+	return
+}
+
+func getUpdateLogMetricGroupVersionAction(metricGroup *models.LogMetricGroup, versionObj *models.LogMonitorTemplateVersionTable, prevVersion int, operator string, nowTime time.Time) (action *Action, snapshotObj *models.LogMonitorTemplateDto, err error) {
+	snapshotObj = &models.LogMonitorTemplateDto{}
+	if err = json.Unmarshal([]byte(versionObj.Snapshot), snapshotObj); err != nil {
+		err = fmt.Errorf("json unmarshal log monitor template version snapshot fail,%s ", err.Error())
+		return
+	}
+	snapshotObj.Version = versionObj.Version
+	action = &Action{Sql: "update log_metric_group set template_snapshot=?,ref_template_version=?,pinned_template_version=?,prev_template_version=?,update_user=?,update_time=? where guid=?", Param: []interface{}{
+		versionObj.Snapshot, snapshotObj.LogMonitorTemplateVersion, versionObj.Version, prevVersion, operator, nowTime, metricGroup.Guid,
+	}}
+	metricGroup.PinnedTemplateVersion = versionObj.Version
+	metricGroup.PrevTemplateVersion = prevVersion
+	metricGroup.RefTemplateVersion = snapshotObj.LogMonitorTemplateVersion
+	metricGroup.TemplateSnapshot = ""
+	return
+}
+
+// getSyncLogMetricGroupTemplateActions 日志指标组切换模版版本后按指标名对比新旧模版,更新已有指标和派生指标的表达式,新增和删除指标;
+// 删除指标时一起删除引用它的告警和图表线条,新增的指标按日志指标组的配置自动创建告警,指标有增减时在原看板下重新生成自动创建的图表
+func getSyncLogMetricGroupTemplateActions(metricGroup *models.LogMetricGroup, oldTemplate, newTemplate *models.LogMonitorTemplateDto, operator string, nowTime time.Time) (actions []*Action, affectEndpointGroup []string, err error) {
+	serviceGroup, monitorType := GetLogMetricServiceGroup(metricGroup.LogMetricMonitor)
+	stringMapData, getMapErr := getLogMetricGroupMapData(metricGroup.Guid)
+	if getMapErr != nil {
+		err = getMapErr
+		return
+	}
+	groupParam := &models.LogMetricGroupWithTemplate{Name: metricGroup.Name, LogMetricMonitorGuid: metricGroup.LogMetricMonitor, LogMetricGroupGuid: metricGroup.Guid,
+		LogMonitorTemplateGuid: metricGroup.LogMonitorTemplate, CodeStringMap: stringMapData["code"], RetCodeStringMap: stringMapData["retcode"], MetricPrefixCode: metricGroup.MetricPrefixCode,
+		ServiceGroup: serviceGroup, MonitorType: monitorType, AutoCreateWarn: metricGroup.AutoAlarm == 1, AutoCreateDashboard: metricGroup.AutoDashboard == 1}
+	sucRetCode := getRetCodeSuccessCode(groupParam.RetCodeStringMap)
+	if groupParam.DerivedMetricList, err = getLogMetricDerivedList(metricGroup.Guid); err != nil {
+		return
+	}
+	// 派生指标引用的模版指标可能变化,先按新版本的指标重新生成表达式,引用的指标不存在时不允许切换
+	derivedActions, derivedMetricList, derivedEndpointGroups, refreshErr := getRefreshLogMetricDerivedActions(metricGroup, groupParam.DerivedMetricList, newTemplate.MetricList, sucRetCode, operator, nowTime)
+	if refreshErr != nil {
+		err = refreshErr
+		return
+	}
+	actions = append(actions, derivedActions...)
+	affectEndpointGroup = append(affectEndpointGroup, derivedEndpointGroups...)
+	oldMetricMap, newMetricMap := make(map[string]bool), make(map[string]bool)
+	for _, v := range oldTemplate.MetricList {
+		oldMetricMap[v.Metric] = true
+	}
+	var addMetricList []*models.LogMetricTemplate
+	var updateMetricGuidList []string
+	for _, v := range newTemplate.MetricList {
+		newMetricMap[v.Metric] = true
+		tmpMetricWithPrefix := v.Metric
+		if metricGroup.MetricPrefixCode != "" {
+			tmpMetricWithPrefix = metricGroup.MetricPrefixCode + "_" + v.Metric
+		}
+		tmpMetricGuid := generateMetricGuid(tmpMetricWithPrefix, serviceGroup)
+		promExpr := getLogMetricTemplatePromExpr(v, metricGroup.MetricPrefixCode, serviceGroup, sucRetCode)
+		if oldMetricMap[v.Metric] {
+			actions = append(actions, &Action{Sql: "update metric set prom_expr=?,log_metric_template=?,update_time=?,update_user=? where guid=?", Param: []interface{}{promExpr, v.Guid, nowTime, operator, tmpMetricGuid}})
+			updateMetricGuidList = append(updateMetricGuidList, tmpMetricGuid)
+			continue
+		}
+		actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,log_metric_template,log_metric_group,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?,?,?,?,?)",
+			Param: []interface{}{tmpMetricGuid, tmpMetricWithPrefix, monitorType, promExpr, serviceGroup, models.MetricWorkspaceService, nowTime, v.Guid, metricGroup.Guid, nowTime, operator, operator}})
+		addMetricList = append(addMetricList, v)
+	}
+	metricChanged := len(addMetricList) > 0
+	for _, v := range oldTemplate.MetricList {
+		if newMetricMap[v.Metric] {
+			continue
+		}
+		tmpMetricWithPrefix := v.Metric
+		if metricGroup.MetricPrefixCode != "" {
+			tmpMetricWithPrefix = metricGroup.MetricPrefixCode + "_" + v.Metric
+		}
+		deleteMetricActions, endpointGroups := getDeleteLogMetricActions(tmpMetricWithPrefix, serviceGroup)
+		actions = append(actions, deleteMetricActions...)
+		affectEndpointGroup = append(affectEndpointGroup, endpointGroups...)
+		metricChanged = true
+	}
+	// 表达式变化后引用这些指标的告警规则需要重新下发
+	updateEndpointGroups, getEndpointGroupErr := getMetricAlarmEndpointGroupList(updateMetricGuidList)
+	if getEndpointGroupErr != nil {
+		err = getEndpointGroupErr
+		return
+	}
+	affectEndpointGroup = append(affectEndpointGroup, updateEndpointGroups...)
+	if !metricChanged {
+		return
+	}
+	var endpointGroup string
+	serviceGroupsRoles := getServiceGroupRoles(serviceGroup)
+	if serviceGroup != "" && monitorType != "" {
+		var endpointGroupIds []string
+		if err = x.SQL("select guid from endpoint_group where service_group=? and monitor_type=?", serviceGroup, monitorType).Find(&endpointGroupIds); err != nil {
+			return
+		}
+		if len(endpointGroupIds) > 0 {
+			endpointGroup = endpointGroupIds[0]
+		}
+	}
+	if groupParam.AutoCreateWarn && len(addMetricList) > 0 {
+		alarmStrategyParam := models.AutoAlarmStrategyParam{LogMetricGroupWithTemplate: groupParam, MetricList: addMetricList, ServiceGroupsRoles: serviceGroupsRoles,
+			ServiceGroup: serviceGroup, EndpointGroup: endpointGroup, Operator: operator}
+		alarmActions, _, genAlarmErr := autoGenerateAlarmStrategy(alarmStrategyParam)
+		if genAlarmErr != nil {
+			err = genAlarmErr
+			return
+		}
+		if len(alarmActions) > 0 {
+			actions = append(actions, alarmActions...)
+			affectEndpointGroup = append(affectEndpointGroup, endpointGroup)
+		}
+	}
+	if groupParam.AutoCreateDashboard && len(serviceGroupsRoles) > 0 {
+		var dashboardIds []int64
+		var chartIds []string
+		if err = x.SQL("select id from custom_dashboard where log_metric_group=?", metricGroup.Guid).Find(&dashboardIds); err != nil {
+			return
+		}
+		if len(dashboardIds) == 0 {
+			return
+		}
+		if err = x.SQL("select guid from custom_chart where log_metric_group=?", metricGroup.Guid).Find(&chartIds); err != nil {
+			return
+		}
+		for _, chartId := range chartIds {
+			delChartActions, delChartErr := GetDeleteCustomDashboardChart(chartId)
+			if delChartErr != nil {
+				err = delChartErr
+				return
+			}
+			actions = append(actions, delChartActions...)
+		}
+		autoMetricList := append(append([]*models.LogMetricTemplate{}, newTemplate.MetricList...), derivedMetricList...)
+		dashboardParam := models.AutoCreateDashboardParam{LogMetricGroupWithTemplate: groupParam, MetricList: autoMetricList, ServiceGroupsRoles: serviceGroupsRoles,
+			ServiceGroup: serviceGroup, Operator: operator, DashboardId: dashboardIds[0]}
+		dashboardActions, _, _, genDashboardErr := autoGenerateCustomDashboard(dashboardParam)
+		if genDashboardErr != nil {
+			err = genDashboardErr
+			return
+		}
+		actions = append(actions, dashboardActions...)
+	}
+	return
+}
+
+// RolloutLogMonitorTemplateVersion 把模版的某个版本发布到选中的层级对象,记录发布前的版本用于回滚,同时按新版本刷新指标、告警和看板
+func RolloutLogMonitorTemplateVersion(param *models.LogMonitorTemplateRolloutParam, operator string) (result *models.LogMonitorTemplateRolloutResp, affectEndpoints []string, err error) {
+	result = &models.LogMonitorTemplateRolloutResp{LogMetricGroupList: []*models.LogMetricGroup{}}
+	versionObj, getVersionErr := getLogMonitorTemplateVersion(param.LogMonitorTemplate, param.Version)
+	if getVersionErr != nil {
+		err = getVersionErr
+		return
+	}
+	metricGroupList, getGroupErr := getLogMetricGroupByTemplateServiceGroup(param.LogMonitorTemplate, param.ServiceGroupList)
+	if getGroupErr != nil {
+		err = getGroupErr
+		return
+	}
+	var actions []*Action
+	var affectEndpointGroup []string
+	nowTime := time.Now()
+	for _, metricGroup := range metricGroupList {
+		if metricGroup.PinnedTemplateVersion == param.Version {
+			continue
+		}
+		oldTemplate, getTemplateErr := getLogMetricGroupTemplate(metricGroup)
+		if getTemplateErr != nil {
+			err = getTemplateErr
+			return
+		}
+		// 未锁定版本的日志指标组回滚时恢复成跟随模版最新配置
+		prevVersion := metricGroup.PinnedTemplateVersion
+		if prevVersion == 0 {
+			prevVersion = models.LogMetricGroupUnpinnedVersion
+		}
+		tmpAction, newTemplate, tmpErr := getUpdateLogMetricGroupVersionAction(metricGroup, versionObj, prevVersion, operator, nowTime)
+		if tmpErr != nil {
+			err = tmpErr
+			return
+		}
+		actions = append(actions, tmpAction)
+		syncActions, syncEndpointGroups, syncErr := getSyncLogMetricGroupTemplateActions(metricGroup, oldTemplate, newTemplate, operator, nowTime)
+		if syncErr != nil {
+			err = syncErr
+			return
+		}
+		actions = append(actions, syncActions...)
+		affectEndpointGroup = append(affectEndpointGroup, syncEndpointGroups...)
+		result.LogMetricGroupList = append(result.LogMetricGroupList, metricGroup)
+	}
+	if len(actions) == 0 {
+		return
+	}
+	if err = Transaction(actions); err != nil {
+		return
+	}
+	syncLogMetricGroupPrometheusRule(affectEndpointGroup)
+	affectEndpoints, err = getLogMetricGroupAffectEndpoints(result.LogMetricGroupList)
+	return
+}
+
+// RollbackLogMonitorTemplateVersion 选中的层级对象回滚到上一次发布前的版本,发布前未锁定版本的恢复成跟随模版最新配置
+func RollbackLogMonitorTemplateVersion(param *models.LogMonitorTemplateRolloutParam, operator string) (result *models.LogMonitorTemplateRolloutResp, affectEndpoints []string, err error) {
+	result = &models.LogMonitorTemplateRolloutResp{LogMetricGroupList: []*models.LogMetricGroup{}}
+	metricGroupList, getGroupErr := getLogMetricGroupByTemplateServiceGroup(param.LogMonitorTemplate, param.ServiceGroupList)
+	if getGroupErr != nil {
+		err = getGroupErr
+		return
+	}
+	var actions []*Action
+	var affectEndpointGroup []string
+	var latestTemplate *models.LogMonitorTemplateDto
+	nowTime := time.Now()
+	versionMap := make(map[int]*models.LogMonitorTemplateVersionTable)
+	for _, metricGroup := range metricGroupList {
+		if metricGroup.PrevTemplateVersion == 0 {
+			continue
+		}
+		oldTemplate, getTemplateErr := getLogMetricGroupTemplate(metricGroup)
+		if getTemplateErr != nil {
+			err = getTemplateErr
+			return
+		}
+		var newTemplate *models.LogMonitorTemplateDto
+		if metricGroup.PrevTemplateVersion == models.LogMetricGroupUnpinnedVersion {
+			if latestTemplate == nil {
+				if latestTemplate, err = GetLogMonitorTemplate(param.LogMonitorTemplate); err != nil {
+					return
+				}
+			}
+			newTemplate = latestTemplate
+			actions = append(actions, &Action{Sql: "update log_metric_group set ref_template_version=?,pinned_template_version=0,prev_template_version=0,update_user=?,update_time=? where guid=?", Param: []interface{}{
+				latestTemplate.LogMonitorTemplateVersion, operator, nowTime, metricGroup.Guid,
+			}})
+			metricGroup.PinnedTemplateVersion = 0
+			metricGroup.PrevTemplateVersion = 0
+			metricGroup.RefTemplateVersion = latestTemplate.LogMonitorTemplateVersion
+			metricGroup.TemplateSnapshot = ""
+		} else {
+			versionObj, ok := versionMap[metricGroup.PrevTemplateVersion]
+			if !ok {
+				if versionObj, err = getLogMonitorTemplateVersion(param.LogMonitorTemplate, metricGroup.PrevTemplateVersion); err != nil {
+					return
+				}
+				versionMap[metricGroup.PrevTemplateVersion] = versionObj
+			}
+			tmpAction, tmpTemplate, tmpErr := getUpdateLogMetricGroupVersionAction(metricGroup, versionObj, 0, operator, nowTime)
+			if tmpErr != nil {
+				err = tmpErr
+				return
+			}
+			actions = append(actions, tmpAction)
+			newTemplate = tmpTemplate
+		}
+		syncActions, syncEndpointGroups, syncErr := getSyncLogMetricGroupTemplateActions(metricGroup, oldTemplate, newTemplate, operator, nowTime)
+		if syncErr != nil {
+			err = syncErr
+			return
+		}
+		actions = append(actions, syncActions...)
+		affectEndpointGroup = append(affectEndpointGroup, syncEndpointGroups...)
+		result.LogMetricGroupList = append(result.LogMetricGroupList, metricGroup)
+	}
+	if len(actions) == 0 {
+		err = fmt.Errorf("no log metric group can rollback")
+		return
+	}
+	if err = Transaction(actions); err != nil {
+		return
+	}
+	syncLogMetricGroupPrometheusRule(affectEndpointGroup)
+	affectEndpoints, err = getLogMetricGroupAffectEndpoints(result.LogMetricGroupList)
+	return
+}
+
+// getMetricAlarmEndpointGroupList 查询引用了这些指标的告警所在的对象组,指标表达式变化后需要重新下发告警规则
+func getMetricAlarmEndpointGroupList(metricGuidList []string) (endpointGroupList []string, err error) {
+	if len(metricGuidList) == 0 {
+		return
+	}
+	var alarmStrategyRows []*models.AlarmStrategyTable
+	metricFilterSql, metricFilterParam := createListParams(metricGuidList, "")
+	queryParam := append(append([]interface{}{}, metricFilterParam...), metricFilterParam...)
+	if err = x.SQL("select distinct t1.endpoint_group from alarm_strategy t1 left join alarm_strategy_metric t2 on t1.guid=t2.alarm_strategy where t1.metric in ("+metricFilterSql+") or t2.metric in ("+metricFilterSql+")", queryParam...).Find(&alarmStrategyRows); err != nil {
+		err = fmt.Errorf("query alarm_strategy table fail,%s ", err.Error())
+		return
+	}
+	for _, v := range alarmStrategyRows {
+		endpointGroupList = append(endpointGroupList, v.EndpointGroup)
+	}
+	return
+}
+
+func syncLogMetricGroupPrometheusRule(endpointGroupList []string) {
+	for _, v := range distinctStringList(endpointGroupList) {
+		if v == "" {
+			continue
+		}
+		if err := SyncPrometheusRuleFile(v, false); err != nil {
+			log.Logger.Error("sync log metric group prometheus rule file fail", log.String("endpointGroup", v), log.Error(err))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
+)
+
+// ListLogMonitorThrottle 列出主机上日志监控的限流情况,serviceGroup不为空时只看该服务组(含子节点)下的主机
+func ListLogMonitorThrottle(serviceGroup string, onlyThrottled bool) (result []*models.LogMonitorThrottleObj, err error) {
+	result = []*models.LogMonitorThrottleObj{}
+	dataMap, queryErr := datasource.QueryLogThrottleData()
+	if queryErr != nil {
+		err = fmt.Errorf("Query log throttle data from prometheus fail,%s ", queryErr.Error())
+		return
+	}
+	var hostFilterMap map[string]bool
+	if serviceGroup != "" {
+		hostFilterMap = make(map[string]bool)
+		for _, v := range getServiceGroupEndpointWithChild(serviceGroup)["host"] {
+			hostFilterMap[v] = true
+		}
+	}
+	endpointList := []string{}
+	for _, v := range dataMap {
+		if hostFilterMap != nil && !hostFilterMap[v.Endpoint] {
+			continue
+		}
+		if onlyThrottled && !v.Throttled {
+			continue
+		}
+		if v.Lines > 0 {
+			v.DropPercent = math.Round((v.SampledLines+v.RateDropLines+v.FullDropLines)*10000/v.Lines) / 100
+		}
+		result = append(result, v)
+		endpointList = append(endpointList, v.Endpoint)
+	}
+	if len(endpointList) > 0 {
+		var endpointRows []*models.EndpointNewTable
+		filterSql, filterParams := createListParams(distinctStringList(endpointList), "")
+		err = x.SQL("select guid,ip from endpoint_new where guid in ("+filterSql+")", filterParams...).Find(&endpointRows)
+		if err != nil {
+			err = fmt.Errorf("Query endpoint table fail,%s ", err.Error())
+			return
+		}
+		endpointIpMap := make(map[string]string)
+		for _, v := range endpointRows {
+			endpointIpMap[v.Guid] = v.Ip
+		}
+		for _, v := range result {
+			v.Ip = endpointIpMap[v.Endpoint]
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DropPercent != result[j].DropPercent {
+			return result[i].DropPercent > result[j].DropPercent
+		}
+		return result[i].Endpoint+result[i].Path < result[j].Endpoint+result[j].Path
+	})
+	return
+}
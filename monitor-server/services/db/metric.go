@@ -58,18 +58,18 @@ func MetricCreate(param []*models.MetricTable, operator string, errMsgObj *model
 	for _, metric := range param {
 		if metric.ServiceGroup != "" {
 			guid = fmt.Sprintf("%s__%s", metric.Metric, metric.MonitorType)
-			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?,?,?)",
-				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, metric.ServiceGroup, metric.Workspace, nowTime, nowTime, operator, operator}})
+			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,service_group,workspace,update_time,create_time,create_user,update_user,datasource) value (?,?,?,?,?,?,?,?,?,?,?)",
+				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, metric.ServiceGroup, metric.Workspace, nowTime, nowTime, operator, operator, metric.Datasource}})
 		} else if metric.EndpointGroup != "" {
 			var monitorType string
 			x.SQL("select monitor_type from endpoint_group where guid=?", metric.EndpointGroup).Get(&monitorType)
 			guid = fmt.Sprintf("%s__%s", metric.Metric, monitorType)
-			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,update_time,create_time,create_user,update_user,endpoint_group) value (?,?,?,?,?,?,?,?,?)",
-				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, nowTime, nowTime, operator, operator, metric.EndpointGroup}})
+			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,update_time,create_time,create_user,update_user,endpoint_group,datasource) value (?,?,?,?,?,?,?,?,?,?)",
+				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, nowTime, nowTime, operator, operator, metric.EndpointGroup, metric.Datasource}})
 		} else {
 			guid = fmt.Sprintf("%s__%s", metric.Metric, metric.MonitorType)
-			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,update_time,create_time,create_user,update_user) value (?,?,?,?,?,?,?,?)",
-				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, nowTime, nowTime, operator, operator}})
+			actions = append(actions, &Action{Sql: "insert into metric(guid,metric,monitor_type,prom_expr,update_time,create_time,create_user,update_user,datasource) value (?,?,?,?,?,?,?,?,?)",
+				Param: []interface{}{guid, metric.Metric, metric.MonitorType, metric.PromExpr, nowTime, nowTime, operator, operator, metric.Datasource}})
 		}
 		if metricTemp, err = GetMetric(guid); err != nil {
 			return err
@@ -104,6 +104,10 @@ func MetricUpdate(param []*models.MetricTable, operator string) (err error) {
 			err = fmt.Errorf("Guid can not empty ")
 			break
 		}
+		// 没有传数据源时保持原来的数据源
+		if metric.Datasource != "" {
+			actions = append(actions, &Action{Sql: "update metric set datasource=? where guid=?", Param: []interface{}{metric.Datasource, metric.Guid}})
+		}
 		if metric.ServiceGroup != "" {
 			actions = append(actions, &Action{Sql: "update metric set prom_expr=?,service_group=?,workspace=?,update_user=?,update_time=? where guid=?", Param: []interface{}{metric.PromExpr, metric.ServiceGroup, metric.Workspace, operator, nowTime, metric.Guid}})
 			actions = append(actions, &Action{Sql: "update metric set service_group=?,workspace=?,update_user=?,update_time=? where guid in (select metric_id from metric_comparison where origin_metric_id=?)",
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"github.com/WeBankPartners/open-monitor/monitor-server/services/datasource"
+)
+
+func ListMonitorDatasource() (result []*models.MonitorDatasourceTable, err error) {
+	result = []*models.MonitorDatasourceTable{}
+	err = x.SQL("select * from monitor_datasource order by is_default desc,name").Find(&result)
+	if err != nil {
+		err = fmt.Errorf("query monitor datasource fail,%s ", err.Error())
+		return
+	}
+	for _, row := range result {
+		row.CreateTime = row.CreateAt.Format(models.DatetimeFormat)
+		row.UpdateTime = row.UpdateAt.Format(models.DatetimeFormat)
+		if !row.CheckAt.IsZero() {
+			row.CheckTime = row.CheckAt.Format(models.DatetimeFormat)
+		}
+		if row.Token != "" {
+			row.Token = models.DatasourceTokenMask
+		}
+	}
+	return
+}
+
+func getMonitorDatasource(datasourceGuid string) (result *models.MonitorDatasourceTable, err error) {
+	var queryRows []*models.MonitorDatasourceTable
+	if err = x.SQL("select * from monitor_datasource where guid=?", datasourceGuid).Find(&queryRows); err != nil {
+		err = fmt.Errorf("query monitor datasource fail,%s ", err.Error())
+		return
+	}
+	if len(queryRows) == 0 {
+		err = fmt.Errorf("can not find datasource:%s ", datasourceGuid)
+		return
+	}
+	result = queryRows[0]
+	return
+}
+
+func validateMonitorDatasource(param *models.MonitorDatasourceTable) error {
+	if param.Type == "" {
+		param.Type = models.DatasourceTypePrometheus
+	}
+	if param.Type != models.DatasourceTypePrometheus && param.Type != models.DatasourceTypeVictoriaMetrics && param.Type != models.DatasourceTypeThanos {
+		return fmt.Errorf("datasource type:%s illegal ", param.Type)
+	}
+	var queryRows []*models.MonitorDatasourceTable
+	x.SQL("select guid from monitor_datasource where name=? and guid<>?", param.Name, param.Guid).Find(&queryRows)
+	if len(queryRows) > 0 {
+		return fmt.Errorf("datasource name:%s already exist ", param.Name)
+	}
+	return nil
+}
+
+func CreateMonitorDatasource(param *models.MonitorDatasourceTable, operator string) (err error) {
+	param.Guid = "ds_" + guid.CreateGuid()
+	if err = validateMonitorDatasource(param); err != nil {
+		return
+	}
+	var actions []*Action
+	nowTime := time.Now()
+	if param.IsDefault == 1 {
+		actions = append(actions, &Action{Sql: "update monitor_datasource set is_default=0 where is_default=1"})
+	}
+	actions = append(actions, &Action{Sql: "insert into monitor_datasource(guid,name,type,address,token,region,is_default,description,status,create_user,update_user,create_at,update_at) values (?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		Param: []interface{}{param.Guid, param.Name, param.Type, param.Address, param.Token, param.Region, param.IsDefault, param.Description, models.DatasourceStatusUnknown, operator, operator, nowTime, nowTime}})
+	if err = Transaction(actions); err != nil {
+		return fmt.Errorf("insert monitor datasource fail,%s ", err.Error())
+	}
+	LoadMonitorDatasource()
+	return
+}
+
+func UpdateMonitorDatasource(param *models.MonitorDatasourceTable, operator string) (err error) {
+	var existRow *models.MonitorDatasourceTable
+	if existRow, err = getMonitorDatasource(param.Guid); err != nil {
+		return
+	}
+	// 列表返回的是掩码,没有修改token时保留原来的;地址改了必须重新填token,避免把原来的token发到新地址
+	if param.Token == models.DatasourceTokenMask {
+		if param.Address != existRow.Address {
+			return fmt.Errorf("datasource address changed,token need to be input again ")
+		}
+		param.Token = existRow.Token
+	}
+	if err = validateMonitorDatasource(param); err != nil {
+		return
+	}
+	var actions []*Action
+	if param.IsDefault == 1 {
+		actions = append(actions, &Action{Sql: "update monitor_datasource set is_default=0 where is_default=1 and guid<>?", Param: []interface{}{param.Guid}})
+	}
+	actions = append(actions, &Action{Sql: "update monitor_datasource set name=?,type=?,address=?,token=?,region=?,is_default=?,description=?,update_user=?,update_at=? where guid=?",
+		Param: []interface{}{param.Name, param.Type, param.Address, param.Token, param.Region, param.IsDefault, param.Description, operator, time.Now(), param.Guid}})
+	if err = Transaction(actions); err != nil {
+		return fmt.Errorf("update monitor datasource fail,%s ", err.Error())
+	}
+	LoadMonitorDatasource()
+	return
+}
+
+// DeleteMonitorDatasource 还有指标、对象类型或自定义图表引用时不允许删除
+func DeleteMonitorDatasource(datasourceGuid string) (err error) {
+	refList := []string{}
+	queryRows, queryErr := x.QueryString("select guid from metric where datasource=? union all select guid from monitor_type where datasource=? union all select guid from custom_chart where concat(',',datasource,',') like ?",
+		datasourceGuid, datasourceGuid, "%,"+datasourceGuid+",%")
+	if queryErr != nil {
+		return fmt.Errorf("query datasource reference fail,%s ", queryErr.Error())
+	}
+	for _, row := range queryRows {
+		refList = append(refList, row["guid"])
+	}
+	if len(refList) > 0 {
+		return fmt.Errorf("datasource is used by %s ", strings.Join(refList, ","))
+	}
+	if _, err = x.Exec("delete from monitor_datasource where guid=?", datasourceGuid); err != nil {
+		return fmt.Errorf("delete monitor datasource fail,%s ", err.Error())
+	}
+	LoadMonitorDatasource()
+	return
+}
+
+func LoadMonitorDatasource() {
+	var rows []*models.MonitorDatasourceTable
+	if err := x.SQL("select * from monitor_datasource").Find(&rows); err != nil {
+		log.Logger.Error("load monitor datasource fail", log.Error(err))
+		return
+	}
+	datasource.ReloadDatasourceRegistry(rows)
+}
+
+// CheckMonitorDatasource 检查数据源连通性并记录状态
+func CheckMonitorDatasource(datasourceGuid string) (result *models.MonitorDatasourceTable, err error) {
+	if result, err = getMonitorDatasource(datasourceGuid); err != nil {
+		return
+	}
+	updateMonitorDatasourceStatus(result)
+	result.CheckTime = result.CheckAt.Format(models.DatetimeFormat)
+	if result.Token != "" {
+		result.Token = models.DatasourceTokenMask
+	}
+	return
+}
+
+func updateMonitorDatasourceStatus(row *models.MonitorDatasourceTable) {
+	row.Status = models.DatasourceStatusOk
+	row.StatusMessage = ""
+	if checkErr := datasource.CheckDatasourceHealth(row); checkErr != nil {
+		row.Status = models.DatasourceStatusError
+		row.StatusMessage = checkErr.Error()
+	}
+	row.CheckAt = time.Now()
+	if _, err := x.Exec("update monitor_datasource set status=?,status_message=?,check_at=? where guid=?", row.Status, row.StatusMessage, row.CheckAt, row.Guid); err != nil {
+		log.Logger.Error("update monitor datasource status fail", log.String("datasource", row.Guid), log.Error(err))
+	}
+}
+
+func StartMonitorDatasourceCron() {
+	LoadMonitorDatasource()
+	t := time.NewTicker(time.Minute).C
+	for {
+		<-t
+		var rows []*models.MonitorDatasourceTable
+		if err := x.SQL("select * from monitor_datasource").Find(&rows); err != nil {
+			log.Logger.Error("query monitor datasource fail", log.Error(err))
+			continue
+		}
+		for _, row := range rows {
+			updateMonitorDatasourceStatus(row)
+		}
+	}
+}
+
+// GetMetricDatasource 指标配置了数据源时用指标的,否则用指标所属对象类型的
+func GetMetricDatasource(metricGuid string) string {
+	queryRows, err := x.QueryString("select t1.datasource,t2.datasource as type_datasource from metric t1 left join monitor_type t2 on t1.monitor_type=t2.guid where t1.guid=?", metricGuid)
+	if err != nil || len(queryRows) == 0 {
+		return ""
+	}
+	if queryRows[0]["datasource"] != "" {
+		return queryRows[0]["datasource"]
+	}
+	return queryRows[0]["type_datasource"]
+}
+
+func getMonitorTypeDatasource(monitorType string) string {
+	queryRows, err := x.QueryString("select datasource from monitor_type where guid=?", monitorType)
+	if err != nil || len(queryRows) == 0 {
+		return ""
+	}
+	return queryRows[0]["datasource"]
+}
+
+// FillQueryDatasource 图表查询没有指定数据源时,按指标、对象类型找数据源,对象配置了集群地址的保持原来的查询方式
+func FillQueryDatasource(query *models.QueryMonitorData) {
+	if len(query.DatasourceList) > 0 || (query.Cluster != "" && query.Cluster != "default") || len(query.Metric) == 0 {
+		return
+	}
+	monitorType := ""
+	if len(query.Endpoint) > 0 {
+		queryRows, _ := x.QueryString("select monitor_type from endpoint_new where guid=?", query.Endpoint[0])
+		if len(queryRows) > 0 {
+			monitorType = queryRows[0]["monitor_type"]
+		}
+	}
+	var metricRows []*models.MetricTable
+	if monitorType != "" {
+		x.SQL("select guid,datasource from metric where metric=? and monitor_type=? and datasource<>''", query.Metric[0], monitorType).Find(&metricRows)
+	} else {
+		x.SQL("select guid,datasource from metric where metric=? and datasource<>''", query.Metric[0]).Find(&metricRows)
+	}
+	datasourceGuid := ""
+	if len(metricRows) > 0 {
+		datasourceGuid = metricRows[0].Datasource
+	} else if monitorType != "" {
+		datasourceGuid = getMonitorTypeDatasource(monitorType)
+	}
+	if datasourceGuid != "" {
+		query.DatasourceList = []string{datasourceGuid}
+	}
+}
+
+// SplitDatasourceList 自定义图表可以配置多个数据源,用逗号分隔
+func SplitDatasourceList(input string) (result []string) {
+	for _, v := range strings.Split(input, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return
+}
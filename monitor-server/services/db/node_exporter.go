@@ -124,7 +124,7 @@ func transLogMetricConfigToJobNew(logMetricConfig []*models.LogMetricQueryObj, e
 	syncParam = []*models.LogMetricMonitorNeObj{}
 	for _, serviceGroupConfig := range logMetricConfig {
 		for _, lmMonitorObj := range serviceGroupConfig.Config {
-			tmpMonitorJob := models.LogMetricMonitorNeObj{Path: lmMonitorObj.LogPath, JsonConfig: []*models.LogMetricJsonNeObj{}, MetricConfig: []*models.LogMetricNeObj{}, MetricGroupConfig: []*models.LogMetricGroupNeObj{}, ServiceGroup: serviceGroupConfig.Guid}
+			tmpMonitorJob := models.LogMetricMonitorNeObj{Path: lmMonitorObj.LogPath, JsonConfig: []*models.LogMetricJsonNeObj{}, MetricConfig: []*models.LogMetricNeObj{}, MetricGroupConfig: []*models.LogMetricGroupNeObj{}, ServiceGroup: serviceGroupConfig.Guid, LogMultilineConfig: lmMonitorObj.LogMultilineConfig}
 			for _, v := range lmMonitorObj.EndpointRel {
 				if v.SourceEndpoint == endpointGuid {
 					tmpMonitorJob.TargetEndpoint = v.TargetEndpoint
@@ -135,7 +135,7 @@ func transLogMetricConfigToJobNew(logMetricConfig []*models.LogMetricQueryObj, e
 				if v.UpdateUser == "old_data" {
 					continue
 				}
-				tmpGroupJob := models.LogMetricGroupNeObj{LogMetricGroup: v.Guid, LogType: v.LogType, JsonRegular: v.JsonRegular, ParamList: []*models.LogMetricParamNeObj{}, MetricConfig: []*models.LogMetricNeObj{}}
+				tmpGroupJob := models.LogMetricGroupNeObj{LogMetricGroup: v.Guid, LogType: v.LogType, JsonRegular: v.JsonRegular, Delimiter: v.Delimiter, ParamList: []*models.LogMetricParamNeObj{}, MetricConfig: []*models.LogMetricNeObj{}}
 				for _, groupParam := range v.ParamList {
 					tmpGroupParamObj := models.LogMetricParamNeObj{Name: groupParam.Name, JsonKey: groupParam.JsonKey, Regular: groupParam.Regular, StringMap: []*models.LogMetricStringMapNeObj{}}
 					for _, vv := range groupParam.StringMap {
@@ -150,14 +150,14 @@ func transLogMetricConfigToJobNew(logMetricConfig []*models.LogMetricQueryObj, e
 					tmpGroupJob.ParamList = append(tmpGroupJob.ParamList, &tmpGroupParamObj)
 				}
 				for _, groupMetric := range v.MetricList {
-					if groupMetric.AggType != "avg" && groupMetric.AggType != "count" && groupMetric.AggType != "sum" && groupMetric.AggType != "max" && groupMetric.AggType != "min" {
+					if !models.IsLogMetricAggType(groupMetric.AggType) {
 						continue
 					}
 					tmpMetric := groupMetric.Metric
 					if v.MetricPrefixCode != "" {
 						tmpMetric = v.MetricPrefixCode + "_" + groupMetric.Metric
 					}
-					tmpGroupMetricObj := models.LogMetricNeObj{Metric: tmpMetric, LogParamName: groupMetric.LogParamName, AggType: groupMetric.AggType, Step: groupMetric.Step, TagConfig: []*models.LogMetricConfigTag{}}
+					tmpGroupMetricObj := models.LogMetricNeObj{Metric: tmpMetric, LogParamName: groupMetric.LogParamName, AggType: groupMetric.AggType, Step: groupMetric.Step, Buckets: getLogMetricBucketList(groupMetric.Buckets), TagConfig: []*models.LogMetricConfigTag{}}
 					for _, vv := range groupMetric.TagConfigList {
 						tmpGroupMetricObj.TagConfig = append(tmpGroupMetricObj.TagConfig, &models.LogMetricConfigTag{LogParamName: vv})
 					}
@@ -265,6 +265,8 @@ func getLogKeywordExporterConfig(endpoint string) (result []*models.LogKeywordHt
 	result = []*models.LogKeywordHttpDto{}
 	var pathList []string
 	pathMap := make(map[string][]*models.LogKeywordHttpRuleObj)
+	// 同一路径配置在多个业务下时,多行配置以第一个为准
+	pathMultilineMap := make(map[string]models.LogMultilineConfig)
 	for _, serviceGroupConfig := range serviceGroupKeywordList {
 		for _, logKeywordMonitor := range serviceGroupConfig.Config {
 			targetEndpoint := ""
@@ -289,7 +291,7 @@ func getLogKeywordExporterConfig(endpoint string) (result []*models.LogKeywordHt
 							continue
 						}
 					}
-					tmpKeywordObj := models.LogKeywordHttpRuleObj{Keyword: logKeywordConfig.Keyword, TargetEndpoint: targetEndpoint, RegularEnable: false}
+					tmpKeywordObj := models.LogKeywordHttpRuleObj{Keyword: logKeywordConfig.Keyword, TargetEndpoint: targetEndpoint, RegularEnable: false, MatchType: logKeywordConfig.MatchType, ExcludeKeyword: logKeywordConfig.ExcludeKeyword}
 					if logKeywordConfig.Regulative > 0 {
 						tmpKeywordObj.RegularEnable = true
 					}
@@ -298,9 +300,10 @@ func getLogKeywordExporterConfig(endpoint string) (result []*models.LogKeywordHt
 				pathMap[logKeywordMonitor.LogPath] = tmpKeywordList
 			} else {
 				pathList = append(pathList, logKeywordMonitor.LogPath)
+				pathMultilineMap[logKeywordMonitor.LogPath] = logKeywordMonitor.LogMultilineConfig
 				tmpKeywordList := []*models.LogKeywordHttpRuleObj{}
 				for _, logKeywordConfig := range logKeywordMonitor.KeywordList {
-					tmpKeywordObj := models.LogKeywordHttpRuleObj{Keyword: logKeywordConfig.Keyword, TargetEndpoint: targetEndpoint, RegularEnable: false}
+					tmpKeywordObj := models.LogKeywordHttpRuleObj{Keyword: logKeywordConfig.Keyword, TargetEndpoint: targetEndpoint, RegularEnable: false, MatchType: logKeywordConfig.MatchType, ExcludeKeyword: logKeywordConfig.ExcludeKeyword}
 					if logKeywordConfig.Regulative > 0 {
 						tmpKeywordObj.RegularEnable = true
 					}
@@ -311,7 +314,48 @@ func getLogKeywordExporterConfig(endpoint string) (result []*models.LogKeywordHt
 		}
 	}
 	for _, path := range pathList {
-		result = append(result, &models.LogKeywordHttpDto{Path: path, Keywords: pathMap[path]})
+		result = append(result, &models.LogKeywordHttpDto{Path: path, Keywords: pathMap[path], LogMultilineConfig: pathMultilineMap[path]})
+	}
+	return
+}
+
+// GetEndpointLogPathMatch 从agent查询日志路径当前匹配到的文件
+func GetEndpointLogPathMatch(endpointGuid string) (result []*models.LogPathMatchObj, err error) {
+	result = []*models.LogPathMatchObj{}
+	endpointObj, getErr := GetEndpointNew(&models.EndpointNewTable{Guid: endpointGuid})
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if endpointObj.AgentAddress == "" {
+		err = fmt.Errorf("endpoint:%s agent address is empty", endpointGuid)
+		return
+	}
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/log_path/match", endpointObj.AgentAddress), nil)
+	timeOutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, respErr := http.DefaultClient.Do(req.WithContext(timeOutCtx))
+	if respErr != nil {
+		err = fmt.Errorf("Do http request to %s fail,%s ", endpointObj.AgentAddress, respErr.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("Do http request to %s fail,status code:%d ", endpointObj.AgentAddress, resp.StatusCode)
+		return
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	var response models.LogPathMatchResponse
+	if err = json.Unmarshal(b, &response); err != nil {
+		err = fmt.Errorf("json unmarhsal reponse body fail,%s ", err.Error())
+		return
+	}
+	if response.Status != "OK" {
+		err = fmt.Errorf(response.Message)
+		return
+	}
+	if response.Data != nil {
+		result = response.Data
 	}
 	return
 }
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/common/notify"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"strings"
+	"time"
+)
+
+func ListNotifyChannelType() []string {
+	return notify.TypeList()
+}
+
+func getNotifyChannels(notifyGuid string) (result []*models.NotifyChannelObj) {
+	result = []*models.NotifyChannelObj{}
+	if notifyGuid == "" {
+		return
+	}
+	var channelRows []*models.NotifyChannelTable
+	if err := x.SQL("select * from notify_channel where notify=? order by name", notifyGuid).Find(&channelRows); err != nil {
+		log.Logger.Error("query notify channel fail", log.String("notify", notifyGuid), log.Error(err))
+		return
+	}
+	for _, row := range channelRows {
+		enable := row.Enable
+		tmpObj := models.NotifyChannelObj{Guid: row.Guid, Name: row.Name, ChannelType: row.ChannelType, Url: row.Url, Template: row.Template, Receiver: row.Receiver, Timeout: row.Timeout,
+			RetryTimes: row.RetryTimes, RetryInterval: row.RetryInterval, Enable: &enable, LastStatus: row.LastStatus, LastError: row.LastError, LastAttempt: row.LastAttempt}
+		if row.Secret != "" {
+			tmpObj.Secret = models.NotifyChannelSecretMask
+		}
+		if !row.LastSendTime.IsZero() {
+			tmpObj.LastSendTime = row.LastSendTime.Format(models.DatetimeFormat)
+		}
+		result = append(result, &tmpObj)
+	}
+	return
+}
+
+func ValidateNotifyChannel(channel *models.NotifyChannelObj) error {
+	if _, ok := notify.GetNotifier(channel.ChannelType); !ok {
+		return fmt.Errorf("notify channel type:%s not support", channel.ChannelType)
+	}
+	if strings.TrimSpace(channel.Url) == "" {
+		return fmt.Errorf("notify channel:%s url can not empty", channel.Name)
+	}
+	if channel.RetryTimes < 0 || channel.RetryInterval < 0 || channel.Timeout < 0 {
+		return fmt.Errorf("notify channel:%s retry or timeout config illegal", channel.Name)
+	}
+	return nil
+}
+
+func ValidateNotifyChannelList(notifyList []*models.NotifyObj) error {
+	for _, notifyObj := range notifyList {
+		for _, channel := range notifyObj.Channels {
+			if err := ValidateNotifyChannel(channel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getNotifyChannelRowMap 查这些通知配置下的渠道,按渠道guid索引
+func getNotifyChannelRowMap(notifyGuidList []string) (rowMap map[string]*models.NotifyChannelTable) {
+	rowMap = make(map[string]*models.NotifyChannelTable)
+	if len(notifyGuidList) == 0 {
+		return
+	}
+	var channelRows []*models.NotifyChannelTable
+	filterSql, filterParam := createListParams(notifyGuidList, "")
+	if err := x.SQL("select * from notify_channel where notify in ("+filterSql+")", filterParam...).Find(&channelRows); err != nil {
+		log.Logger.Error("query notify channel fail", log.StringList("notify", notifyGuidList), log.Error(err))
+	}
+	for _, row := range channelRows {
+		rowMap[row.Guid] = row
+	}
+	return
+}
+
+// fillNotifyChannelDefault 查询返回的密钥是掩码,没改的话沿用库里原来的,地址或渠道类型改了时不沿用,避免把密钥发到别的地址;不传enable时默认启用
+func fillNotifyChannelDefault(channel *models.NotifyChannelObj, existRow *models.NotifyChannelTable) {
+	if channel.Enable == nil {
+		enable := 1
+		channel.Enable = &enable
+	}
+	if channel.Secret == models.NotifyChannelSecretMask {
+		if existRow != nil && existRow.Url == channel.Url && existRow.ChannelType == channel.ChannelType {
+			channel.Secret = existRow.Secret
+		} else {
+			channel.Secret = ""
+		}
+	}
+}
+
+// getNotifyChannelInsertAction 新建的通知配置可能是从正在编辑的对象原来的通知配置重建的(如升级步骤、导入),
+// 原渠道guid只在sourceNotifyList的渠道里取密钥,入库用新的guid
+func getNotifyChannelInsertAction(notifyGuid string, sourceNotifyList []string, channels []*models.NotifyChannelObj) (actions []*Action) {
+	if len(channels) == 0 {
+		return
+	}
+	var sourceRowMap map[string]*models.NotifyChannelTable
+	for _, channel := range channels {
+		if channel.Guid != "" && channel.Secret == models.NotifyChannelSecretMask {
+			sourceRowMap = getNotifyChannelRowMap(sourceNotifyList)
+			break
+		}
+	}
+	nowTime := time.Now()
+	channelGuidList := guid.CreateGuidList(len(channels))
+	for i, channel := range channels {
+		fillNotifyChannelDefault(channel, sourceRowMap[channel.Guid])
+		channel.Guid = "nc_" + channelGuidList[i]
+		actions = append(actions, getNotifyChannelInsertSqlAction(notifyGuid, channel, nowTime))
+	}
+	return
+}
+
+// getNotifyChannelUpdateAction 按渠道guid更新,保留最近一次投递结果,不在列表里的渠道删掉
+func getNotifyChannelUpdateAction(notifyGuid string, channels []*models.NotifyChannelObj) (actions []*Action) {
+	existRowMap := getNotifyChannelRowMap([]string{notifyGuid})
+	nowTime := time.Now()
+	channelGuidList := guid.CreateGuidList(len(channels))
+	keepGuidList := []string{}
+	for i, channel := range channels {
+		existRow, ok := existRowMap[channel.Guid]
+		fillNotifyChannelDefault(channel, existRow)
+		if !ok {
+			channel.Guid = "nc_" + channelGuidList[i]
+			actions = append(actions, getNotifyChannelInsertSqlAction(notifyGuid, channel, nowTime))
+		} else {
+			actions = append(actions, &Action{Sql: "update notify_channel set name=?,channel_type=?,url=?,secret=?,template=?,receiver=?,timeout=?,retry_times=?,retry_interval=?,enable=?,update_time=? where guid=?", Param: []interface{}{
+				channel.Name, channel.ChannelType, channel.Url, channel.Secret, channel.Template, channel.Receiver, channel.Timeout, channel.RetryTimes, channel.RetryInterval, *channel.Enable, nowTime, channel.Guid,
+			}})
+		}
+		keepGuidList = append(keepGuidList, channel.Guid)
+	}
+	if len(keepGuidList) == 0 {
+		return append(getNotifyChannelDeleteAction(notifyGuid), actions...)
+	}
+	filterSql, filterParam := createListParams(keepGuidList, "")
+	actions = append([]*Action{{Sql: "delete from notify_channel where notify=? and guid not in (" + filterSql + ")", Param: append([]interface{}{notifyGuid}, filterParam...)}}, actions...)
+	return
+}
+
+func getNotifyChannelInsertSqlAction(notifyGuid string, channel *models.NotifyChannelObj, nowTime time.Time) *Action {
+	return &Action{Sql: "insert into notify_channel(guid,notify,name,channel_type,url,secret,template,receiver,timeout,retry_times,retry_interval,enable,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+		channel.Guid, notifyGuid, channel.Name, channel.ChannelType, channel.Url, channel.Secret, channel.Template, channel.Receiver, channel.Timeout, channel.RetryTimes, channel.RetryInterval, *channel.Enable, nowTime,
+	}}
+}
+
+func getNotifyChannelDeleteAction(notifyGuid string) (actions []*Action) {
+	actions = append(actions, &Action{Sql: "delete from notify_channel where notify=?", Param: []interface{}{notifyGuid}})
+	return
+}
+
+// buildChannelDeliveryList 为通知配置下每个启用的渠道生成一条投递记录,重试次数和间隔取渠道的重试策略
+func buildChannelDeliveryList(notifyObj *models.NotifyTable, alarmObj *models.AlarmHandleObj) (result []*models.NotifyDeliveryTable) {
+	var channelRows []*models.NotifyChannelTable
+	if err := x.SQL("select * from notify_channel where notify=? and enable=1", notifyObj.Guid).Find(&channelRows); err != nil {
+		log.Logger.Error("query notify channel fail", log.String("notify", notifyObj.Guid), log.Error(err))
+		return
+	}
+	if len(channelRows) == 0 {
+		return
+	}
+	message := buildNotifyChannelMessage(notifyObj, alarmObj)
+	payload, _ := json.Marshal(message)
+	for _, row := range channelRows {
+		policy := notify.GetRetryPolicy(buildNotifyChannel(row))
+		receivers := row.Receiver
+		if row.ChannelType == notify.ChannelSms && receivers == "" {
+			receivers = strings.Join(message.Phones, ",")
+		}
+		result = append(result, &models.NotifyDeliveryTable{NotifyId: notifyObj.Guid, Channel: row.Guid, ChannelType: row.ChannelType, Receivers: receivers,
+			Payload: string(payload), MaxAttempt: policy.Times, RetryBase: policy.Interval})
+	}
+	return
+}
+
+func buildNotifyChannel(row *models.NotifyChannelTable) *notify.Channel {
+	return &notify.Channel{Type: row.ChannelType, Url: row.Url, Secret: row.Secret, Template: row.Template, Receiver: row.Receiver, Timeout: row.Timeout, RetryTimes: row.RetryTimes, RetryInterval: row.RetryInterval}
+}
+
+func buildNotifyChannelMessage(notifyObj *models.NotifyTable, alarmObj *models.AlarmHandleObj) *notify.Message {
+	alarmDetailList := []*models.AlarmDetailData{}
+	if strings.HasPrefix(alarmObj.EndpointTags, "ac_") {
+		if tmpDetailList, err := GetAlarmDetailList(alarmObj.Id); err != nil {
+			log.Logger.Error("get alarm detail list fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+		} else {
+			alarmDetailList = tmpDetailList
+		}
+	} else {
+		alarmDetailList = append(alarmDetailList, &models.AlarmDetailData{Metric: alarmObj.SMetric, Cond: alarmObj.SCond, Last: alarmObj.SLast, Start: alarmObj.Start, StartValue: alarmObj.StartValue, End: alarmObj.End, EndValue: alarmObj.EndValue, Tags: alarmObj.Tags})
+	}
+	alarmObj.AlarmDetail = buildAlarmDetailData(alarmDetailList, "\n")
+	alarmObj.AlarmDetail = appendLogKeywordAlarmContext(alarmObj.Id, alarmObj.SMetric, alarmObj.AlarmDetail, "\n")
+	message := notify.Message{AlarmId: alarmObj.Id, AlarmName: alarmObj.AlarmName, Status: alarmObj.Status, Priority: alarmObj.SPriority, Endpoint: alarmObj.Endpoint, Metric: alarmObj.SMetric,
+		SmsText: getSmsAlarmContent(&alarmObj.AlarmTable), Time: time.Now().Format(models.DatetimeFormat)}
+	message.Subject, message.Content = getNotifyMessage(alarmObj)
+	message.Content = strings.ReplaceAll(message.Content, "\r\n", "\n")
+	var roles []*models.RoleNewTable
+	x.SQL("select guid,email,phone from `role_new` where guid in (select `role` from notify_role_rel where notify=?)", notifyObj.Guid).Find(&roles)
+	for _, v := range roles {
+		if v.Email != "" {
+			message.Mails = append(message.Mails, v.Email)
+		}
+		if v.Phone != "" {
+			message.Phones = append(message.Phones, v.Phone)
+		}
+	}
+	return &message
+}
+
+func recordNotifyChannelResult(channelGuid string, attempt int, sendErr error) {
+	status, errMessage := "success", ""
+	if sendErr != nil {
+		status, errMessage = "fail", sendErr.Error()
+		if len(errMessage) > 1000 {
+			errMessage = errMessage[:1000]
+		}
+	}
+	if _, err := x.Exec("update notify_channel set last_status=?,last_error=?,last_attempt=?,last_send_time=? where guid=?", status, errMessage, attempt, time.Now(), channelGuid); err != nil {
+		log.Logger.Error("record notify channel result fail", log.String("channel", channelGuid), log.Error(err))
+	}
+}
+
+// TestNotifyChannel 传了渠道guid时按库里保存的配置测试并记录结果,没有guid的按传入的地址测试,只给管理员用
+func TestNotifyChannel(param *models.NotifyChannelTestParam, operator string) (attempt int, err error) {
+	var channelRow *models.NotifyChannelTable
+	if param.Channel.Guid != "" {
+		var channelRows []*models.NotifyChannelTable
+		if err = x.SQL("select * from notify_channel where guid=?", param.Channel.Guid).Find(&channelRows); err != nil {
+			err = fmt.Errorf("query notify channel fail,%s ", err.Error())
+			return
+		}
+		if len(channelRows) == 0 {
+			err = fmt.Errorf("can not find notify channel:%s ", param.Channel.Guid)
+			return
+		}
+		channelRow = channelRows[0]
+	} else {
+		if err = ValidateNotifyChannel(param.Channel); err != nil {
+			return
+		}
+		channelRow = &models.NotifyChannelTable{ChannelType: param.Channel.ChannelType, Url: param.Channel.Url, Secret: param.Channel.Secret, Template: param.Channel.Template, Receiver: param.Channel.Receiver, Timeout: param.Channel.Timeout}
+	}
+	message := notify.Message{Status: "firing", Priority: "low", Endpoint: "notify_test", Metric: "notify_test", Subject: param.Subject, Content: param.Content, Time: time.Now().Format(models.DatetimeFormat)}
+	if message.Subject == "" {
+		message.Subject = "[test] monitor notify channel test"
+	}
+	if message.Content == "" {
+		message.Content = fmt.Sprintf("This is a test message from monitor,operator:%s", operator)
+	}
+	message.SmsText = message.Subject
+	testChannel := buildNotifyChannel(channelRow)
+	testChannel.RetryTimes = 1
+	attempt, err = notify.Deliver(testChannel, &message)
+	if channelRow.Guid != "" {
+		recordNotifyChannelResult(channelRow.Guid, attempt, err)
+	}
+	return
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+)
+
+func TestFillNotifyChannelDefault(t *testing.T) {
+	existRow := &models.NotifyChannelTable{Guid: "nc_1", ChannelType: "webhook", Url: "http://a.com/hook", Secret: "token"}
+	testCases := []struct {
+		channel *models.NotifyChannelObj
+		exist   *models.NotifyChannelTable
+		want    string
+	}{
+		{&models.NotifyChannelObj{ChannelType: "webhook", Url: "http://a.com/hook", Secret: models.NotifyChannelSecretMask}, existRow, "token"},
+		{&models.NotifyChannelObj{ChannelType: "webhook", Url: "http://a.com/hook", Secret: "new"}, existRow, "new"},
+		// 地址或类型改了时不能沿用原来的密钥
+		{&models.NotifyChannelObj{ChannelType: "webhook", Url: "http://b.com/hook", Secret: models.NotifyChannelSecretMask}, existRow, ""},
+		{&models.NotifyChannelObj{ChannelType: "wecom", Url: "http://a.com/hook", Secret: models.NotifyChannelSecretMask}, existRow, ""},
+		{&models.NotifyChannelObj{ChannelType: "webhook", Url: "http://a.com/hook", Secret: models.NotifyChannelSecretMask}, nil, ""},
+	}
+	for i, tc := range testCases {
+		fillNotifyChannelDefault(tc.channel, tc.exist)
+		if tc.channel.Secret != tc.want {
+			t.Errorf("case %d: want secret %q, got %q", i, tc.want, tc.channel.Secret)
+		}
+		if tc.channel.Enable == nil || *tc.channel.Enable != 1 {
+			t.Errorf("case %d: enable should default to 1", i)
+		}
+	}
+}
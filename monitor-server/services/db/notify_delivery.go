@@ -0,0 +1,285 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/WeBankPartners/open-monitor/monitor-server/common/notify"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"strings"
+	"time"
+)
+
+const (
+	notifyDeliveryInterval      = 5
+	notifyDeliveryBatchSize     = 100
+	notifyDeliveryDefaultTimes  = 3
+	notifyDeliveryDefaultBase   = 10
+	notifyDeliveryMaxBackoff    = 1800
+	notifyDeliverySendingExpire = 600
+)
+
+func buildMailDelivery(notifyObj *models.NotifyTable, alarmObj *models.AlarmHandleObj) (result *models.NotifyDeliveryTable, err error) {
+	mailPayload, buildErr := buildNotifyMail(notifyObj, alarmObj)
+	if buildErr != nil || mailPayload == nil {
+		err = buildErr
+		return
+	}
+	b, _ := json.Marshal(mailPayload)
+	result = &models.NotifyDeliveryTable{NotifyId: notifyObj.Guid, Channel: models.NotifyDeliveryChannelMail, ChannelType: models.NotifyDeliveryChannelMail,
+		Receivers: strings.Join(mailPayload.To, ","), Payload: string(b), MaxAttempt: notifyDeliveryDefaultTimes, RetryBase: notifyDeliveryDefaultBase}
+	return
+}
+
+// buildEventDelivery 平台回调事件,回调key为空这种不用重试的错误直接记成失败
+func buildEventDelivery(notifyObj *models.NotifyTable, alarmObj *models.AlarmHandleObj, operator string) (result *models.NotifyDeliveryTable) {
+	result = &models.NotifyDeliveryTable{NotifyId: notifyObj.Guid, Channel: models.NotifyDeliveryChannelEvent, ChannelType: models.NotifyDeliveryChannelEvent,
+		MaxAttempt: notifyDeliveryDefaultTimes, RetryBase: notifyDeliveryDefaultBase, Operator: operator}
+	requestParam, err := buildNotifyEventRequest(notifyObj, alarmObj, operator)
+	if err != nil {
+		log.Logger.Error("Notify event fail", log.String("notifyGuid", notifyObj.Guid), log.Int("alarmId", alarmObj.Id), log.Error(err))
+		result.Status = models.NotifyDeliveryStatusFail
+		result.LastError = err.Error()
+		return
+	}
+	result.Receivers = requestParam.OperationKey
+	b, _ := json.Marshal(requestParam)
+	result.Payload = string(b)
+	return
+}
+
+// enqueueNotifyDelivery 把待发送的通知写入投递表,firing告警配置了延迟通知时把首次投递时间推后
+func enqueueNotifyDelivery(deliveryList []*models.NotifyDeliveryTable, alarmObj *models.AlarmHandleObj) {
+	if len(deliveryList) == 0 {
+		return
+	}
+	nowTime := time.Now()
+	nextTime := nowTime
+	notifyDelay := 0
+	if alarmObj.Status == "firing" && alarmObj.NotifyDelay > 0 {
+		notifyDelay = alarmObj.NotifyDelay
+		nextTime = nowTime.Add(time.Duration(notifyDelay) * time.Second)
+	}
+	var actions []*Action
+	for _, row := range deliveryList {
+		if row.Status == "" {
+			row.Status = models.NotifyDeliveryStatusPending
+		}
+		if row.Operator == "" {
+			row.Operator = "system"
+		}
+		actions = append(actions, &Action{Sql: "insert into notify_delivery(alarm_id,alarm_status,notify_id,channel,channel_type,receivers,payload,status,attempt,max_attempt,retry_base,notify_delay,last_error,operator,next_time,create_time,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", Param: []interface{}{
+			alarmObj.Id, alarmObj.Status, row.NotifyId, row.Channel, row.ChannelType, row.Receivers, row.Payload, row.Status, row.Attempt, row.MaxAttempt, row.RetryBase, notifyDelay, row.LastError, row.Operator, nextTime, nowTime, nowTime,
+		}})
+	}
+	if err := Transaction(actions); err != nil {
+		log.Logger.Error("enqueue notify delivery fail", log.Int("alarmId", alarmObj.Id), log.Error(err))
+	}
+}
+
+// StartNotifyDeliveryDispatcher 定时扫描投递表,发送到期的通知,失败按指数退避重试,服务重启后会继续处理未完成的记录
+func StartNotifyDeliveryDispatcher() {
+	t := time.NewTicker(time.Duration(notifyDeliveryInterval) * time.Second).C
+	for {
+		<-t
+		dispatchNotifyDelivery()
+	}
+}
+
+func dispatchNotifyDelivery() {
+	nowTime := time.Now()
+	// 发送中的记录长时间没有更新,说明处理的实例已经挂了,重新放回队列
+	if _, err := x.Exec("update notify_delivery set status=?,update_time=? where status=? and update_time<?", models.NotifyDeliveryStatusPending, nowTime,
+		models.NotifyDeliveryStatusSending, nowTime.Add(-time.Duration(notifyDeliverySendingExpire)*time.Second)); err != nil {
+		log.Logger.Error("reset expire sending notify delivery fail", log.Error(err))
+	}
+	var deliveryRows []*models.NotifyDeliveryTable
+	if err := x.SQL("select * from notify_delivery where status=? and next_time<=? order by id limit ?", models.NotifyDeliveryStatusPending, nowTime, notifyDeliveryBatchSize).Find(&deliveryRows); err != nil {
+		log.Logger.Error("query pending notify delivery fail", log.Error(err))
+		return
+	}
+	for _, row := range deliveryRows {
+		// 多实例部署时通过状态条件更新抢占记录,更新成功的实例负责发送
+		execResult, err := x.Exec("update notify_delivery set status=?,update_time=? where id=? and status=?", models.NotifyDeliveryStatusSending, nowTime, row.Id, models.NotifyDeliveryStatusPending)
+		if err != nil {
+			log.Logger.Error("lock notify delivery fail", log.Int("id", row.Id), log.Error(err))
+			continue
+		}
+		if affectNum, _ := execResult.RowsAffected(); affectNum == 0 {
+			continue
+		}
+		go handleNotifyDelivery(row)
+	}
+}
+
+func handleNotifyDelivery(row *models.NotifyDeliveryTable) {
+	if row.Attempt == 0 && row.AlarmStatus == "firing" {
+		var alarmRows []*models.AlarmTable
+		x.SQL("select id,status,ack_time from alarm where id=?", row.AlarmId).Find(&alarmRows)
+		if len(alarmRows) > 0 {
+			if row.NotifyDelay > 0 && alarmRows[0].Status == "ok" {
+				log.Logger.Info("Notify firing alarm break in delay time ", log.Int("alarmId", row.AlarmId), log.Int("delivery", row.Id))
+				updateNotifyDeliveryResult(row, models.NotifyDeliveryStatusCancel, "alarm recover in notify delay time")
+				return
+			}
+			if !alarmRows[0].AckTime.IsZero() {
+				log.Logger.Info("Notify firing alarm break,alarm is acked", log.Int("alarmId", row.AlarmId), log.Int("delivery", row.Id))
+				updateNotifyDeliveryResult(row, models.NotifyDeliveryStatusCancel, "alarm is acked")
+				return
+			}
+		}
+	}
+	row.Attempt = row.Attempt + 1
+	sendErr := sendNotifyDelivery(row)
+	if sendErr == nil {
+		log.Logger.Info("notify delivery success", log.Int("id", row.Id), log.String("channelType", row.ChannelType), log.Int("alarmId", row.AlarmId), log.Int("attempt", row.Attempt))
+		updateNotifyDeliveryResult(row, models.NotifyDeliveryStatusSuccess, "")
+		return
+	}
+	log.Logger.Error("notify delivery fail", log.Int("id", row.Id), log.String("channelType", row.ChannelType), log.Int("alarmId", row.AlarmId), log.Int("attempt", row.Attempt), log.Error(sendErr))
+	if row.Attempt >= row.MaxAttempt {
+		updateNotifyDeliveryResult(row, models.NotifyDeliveryStatusFail, sendErr.Error())
+		return
+	}
+	row.NextTime = time.Now().Add(time.Duration(calcNotifyDeliveryBackoff(row.RetryBase, row.Attempt)) * time.Second)
+	updateNotifyDeliveryResult(row, models.NotifyDeliveryStatusPending, sendErr.Error())
+}
+
+// calcNotifyDeliveryBackoff 第n次失败后等待 base*2^(n-1) 秒,最多等待30分钟
+func calcNotifyDeliveryBackoff(base, attempt int) int {
+	if base <= 0 {
+		base = notifyDeliveryDefaultBase
+	}
+	backoff := base
+	for i := 1; i < attempt && backoff < notifyDeliveryMaxBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > notifyDeliveryMaxBackoff {
+		backoff = notifyDeliveryMaxBackoff
+	}
+	return backoff
+}
+
+func sendNotifyDelivery(row *models.NotifyDeliveryTable) (err error) {
+	switch row.ChannelType {
+	case models.NotifyDeliveryChannelMail:
+		var mailPayload models.NotifyDeliveryMailPayload
+		if err = json.Unmarshal([]byte(row.Payload), &mailPayload); err != nil {
+			return fmt.Errorf("json unmarshal mail payload fail,%s ", err.Error())
+		}
+		err = sendNotifyMail(&mailPayload)
+	case models.NotifyDeliveryChannelEvent:
+		var requestParam models.CoreNotifyRequest
+		if err = json.Unmarshal([]byte(row.Payload), &requestParam); err != nil {
+			return fmt.Errorf("json unmarshal event payload fail,%s ", err.Error())
+		}
+		err = postNotifyEvent(&requestParam)
+	default:
+		var channelRows []*models.NotifyChannelTable
+		if err = x.SQL("select * from notify_channel where guid=?", row.Channel).Find(&channelRows); err != nil {
+			return fmt.Errorf("query notify channel fail,%s ", err.Error())
+		}
+		if len(channelRows) == 0 {
+			return fmt.Errorf("can not find notify channel:%s ", row.Channel)
+		}
+		var message notify.Message
+		if err = json.Unmarshal([]byte(row.Payload), &message); err != nil {
+			return fmt.Errorf("json unmarshal channel payload fail,%s ", err.Error())
+		}
+		err = notify.Send(buildNotifyChannel(channelRows[0]), &message)
+		recordNotifyChannelResult(row.Channel, row.Attempt, err)
+	}
+	return
+}
+
+func updateNotifyDeliveryResult(row *models.NotifyDeliveryTable, status, errMessage string) {
+	if len(errMessage) > 1000 {
+		errMessage = errMessage[:1000]
+	}
+	nowTime := time.Now()
+	if row.NextTime.IsZero() || status != models.NotifyDeliveryStatusPending {
+		row.NextTime = nowTime
+	}
+	if _, err := x.Exec("update notify_delivery set status=?,attempt=?,last_error=?,next_time=?,update_time=? where id=?", status, row.Attempt, errMessage, row.NextTime, nowTime, row.Id); err != nil {
+		log.Logger.Error("update notify delivery result fail", log.Int("id", row.Id), log.Error(err))
+	}
+}
+
+func ListNotifyDelivery(param *models.NotifyDeliveryQueryParam) (pageInfo models.PageInfo, result []*models.NotifyDeliveryObj, err error) {
+	result = []*models.NotifyDeliveryObj{}
+	var params []interface{}
+	baseSql := "select * from notify_delivery where 1=1 "
+	if param.AlarmId > 0 {
+		baseSql += " and alarm_id=? "
+		params = append(params, param.AlarmId)
+	}
+	if param.NotifyId != "" {
+		baseSql += " and notify_id=? "
+		params = append(params, param.NotifyId)
+	}
+	if param.Status != "" {
+		baseSql += " and status=? "
+		params = append(params, param.Status)
+	}
+	if param.ChannelType != "" {
+		baseSql += " and channel_type=? "
+		params = append(params, param.ChannelType)
+	}
+	if param.StartTime != "" {
+		baseSql += " and create_time>=? "
+		params = append(params, param.StartTime)
+	}
+	if param.EndTime != "" {
+		baseSql += " and create_time<=? "
+		params = append(params, param.EndTime)
+	}
+	baseSql += " order by id desc "
+	pageInfo.StartIndex = param.StartIndex
+	pageInfo.PageSize = param.PageSize
+	pageInfo.TotalRows = queryCount(baseSql, params...)
+	baseSql += " limit ?,? "
+	params = append(params, param.StartIndex, param.PageSize)
+	var deliveryRows []*models.NotifyDeliveryTable
+	if err = x.SQL(baseSql, params...).Find(&deliveryRows); err != nil {
+		err = fmt.Errorf("query notify delivery table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range deliveryRows {
+		result = append(result, &models.NotifyDeliveryObj{Id: row.Id, AlarmId: row.AlarmId, AlarmStatus: row.AlarmStatus, NotifyId: row.NotifyId, Channel: row.Channel, ChannelType: row.ChannelType,
+			Receivers: row.Receivers, Payload: row.Payload, Status: row.Status, Attempt: row.Attempt, MaxAttempt: row.MaxAttempt, LastError: row.LastError, Operator: row.Operator,
+			NextTime: row.NextTime.Format(models.DatetimeFormat), CreateTime: row.CreateTime.Format(models.DatetimeFormat), UpdateTime: row.UpdateTime.Format(models.DatetimeFormat)})
+	}
+	return
+}
+
+// RetryNotifyDelivery 手动重试失败或取消的投递,再给一次发送机会,不再检查延迟通知
+func RetryNotifyDelivery(ids []int, operator string) (err error) {
+	if len(ids) == 0 {
+		return
+	}
+	nowTime := time.Now()
+	idSpecList := make([]string, len(ids))
+	params := []interface{}{"", models.NotifyDeliveryStatusPending, nowTime, nowTime, operator}
+	for i, id := range ids {
+		idSpecList[i] = "?"
+		params = append(params, id)
+	}
+	params = append(params, models.NotifyDeliveryStatusFail, models.NotifyDeliveryStatusCancel)
+	params[0] = "update notify_delivery set status=?,max_attempt=attempt+1,notify_delay=0,next_time=?,update_time=?,operator=? where id in (" + strings.Join(idSpecList, ",") + ") and status in (?,?)"
+	_, err = x.Exec(params...)
+	if err != nil {
+		err = fmt.Errorf("update notify delivery retry status fail,%s ", err.Error())
+	}
+	return
+}
+
+func recordManualNotifyDelivery(notifyObj *models.NotifyTable, alarmObj *models.AlarmHandleObj, requestParam *models.CoreNotifyRequest, operator string, sendErr error) {
+	b, _ := json.Marshal(requestParam)
+	row := models.NotifyDeliveryTable{NotifyId: notifyObj.Guid, Channel: models.NotifyDeliveryChannelEvent, ChannelType: models.NotifyDeliveryChannelEvent, Receivers: requestParam.OperationKey,
+		Payload: string(b), Status: models.NotifyDeliveryStatusSuccess, Attempt: 1, MaxAttempt: 1, RetryBase: notifyDeliveryDefaultBase, Operator: operator}
+	if sendErr != nil {
+		row.Status = models.NotifyDeliveryStatusFail
+		row.LastError = sendErr.Error()
+	}
+	enqueueNotifyDelivery([]*models.NotifyDeliveryTable{&row}, &models.AlarmHandleObj{AlarmTable: models.AlarmTable{Id: alarmObj.Id, Status: alarmObj.Status}})
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"fmt"
+	"github.com/WeBankPartners/go-common-lib/cipher"
+	"github.com/WeBankPartners/go-common-lib/guid"
+	"github.com/WeBankPartners/open-monitor/monitor-server/middleware/log"
+	"github.com/WeBankPartners/open-monitor/monitor-server/models"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	activeSilenceLock     = new(sync.RWMutex)
+	activeSilenceList     []*models.AlarmSilenceTable
+	activeSilenceLoadTime time.Time
+)
+
+func ListAlarmSilence(param *models.AlarmSilenceQueryParam) (pageInfo models.PageInfo, result []*models.AlarmSilenceObj, err error) {
+	result = []*models.AlarmSilenceObj{}
+	var params []interface{}
+	nowTime := time.Now()
+	baseSql := "select * from alarm_silence where 1=1 "
+	switch param.Status {
+	case "pending":
+		baseSql += " and start_time>? "
+		params = append(params, nowTime)
+	case "active":
+		baseSql += " and start_time<=? and end_time>? "
+		params = append(params, nowTime, nowTime)
+	case "expired":
+		baseSql += " and end_time<=? "
+		params = append(params, nowTime)
+	}
+	if param.Endpoint != "" {
+		baseSql += " and endpoint=? "
+		params = append(params, param.Endpoint)
+	}
+	baseSql += " order by end_time desc "
+	pageInfo.StartIndex = param.StartIndex
+	pageInfo.PageSize = param.PageSize
+	pageInfo.TotalRows = queryCount(baseSql, params...)
+	baseSql += " limit ?,? "
+	params = append(params, param.StartIndex, param.PageSize)
+	var silenceRows []*models.AlarmSilenceTable
+	if err = x.SQL(baseSql, params...).Find(&silenceRows); err != nil {
+		err = fmt.Errorf("query alarm silence table fail,%s ", err.Error())
+		return
+	}
+	mutedCountMap := make(map[string]int)
+	if len(silenceRows) > 0 {
+		var silenceGuidList []string
+		for _, row := range silenceRows {
+			silenceGuidList = append(silenceGuidList, row.Guid)
+		}
+		filterSql, filterParam := createListParams(silenceGuidList, "")
+		countRows, _ := x.QueryString(append([]interface{}{"select silence,sum(muted_count) as num from alarm_silence_muted where silence in (" + filterSql + ") group by silence"}, filterParam...)...)
+		for _, row := range countRows {
+			var tmpNum int
+			fmt.Sscanf(row["num"], "%d", &tmpNum)
+			mutedCountMap[row["silence"]] = tmpNum
+		}
+	}
+	for _, row := range silenceRows {
+		tmpObj := models.AlarmSilenceObj{Guid: row.Guid, StartTime: row.StartTime.Format(models.DatetimeFormat), EndTime: row.EndTime.Format(models.DatetimeFormat), Comment: row.Comment,
+			Endpoint: row.Endpoint, EndpointGroup: row.EndpointGroup, ServiceGroup: row.ServiceGroup, Metric: row.Metric, AlarmStrategy: row.AlarmStrategy, Tags: splitSilenceTags(row.Tags),
+			MutedCount: mutedCountMap[row.Guid], CreateUser: row.CreateUser, CreateTime: row.CreateTime.Format(models.DatetimeFormat), UpdateUser: row.UpdateUser, UpdateTime: row.UpdateTime.Format(models.DatetimeFormat)}
+		if row.StartTime.After(nowTime) {
+			tmpObj.Status = "pending"
+		} else if row.EndTime.After(nowTime) {
+			tmpObj.Status = "active"
+		} else {
+			tmpObj.Status = "expired"
+		}
+		result = append(result, &tmpObj)
+	}
+	return
+}
+
+func ValidateAlarmSilence(param *models.AlarmSilenceObj) (startTime, endTime time.Time, err error) {
+	if startTime, err = time.ParseInLocation(models.DatetimeFormat, param.StartTime, time.Local); err != nil {
+		err = fmt.Errorf("start_time:%s format illegal", param.StartTime)
+		return
+	}
+	if endTime, err = time.ParseInLocation(models.DatetimeFormat, param.EndTime, time.Local); err != nil {
+		err = fmt.Errorf("end_time:%s format illegal", param.EndTime)
+		return
+	}
+	if !endTime.After(startTime) {
+		err = fmt.Errorf("end_time must after start_time")
+		return
+	}
+	// 没有任何匹配条件会静默全部告警,不允许
+	if param.Endpoint == "" && param.EndpointGroup == "" && param.ServiceGroup == "" && param.Metric == "" && param.AlarmStrategy == "" && len(param.Tags) == 0 {
+		err = fmt.Errorf("silence matchers can not all empty")
+		return
+	}
+	for _, tag := range param.Tags {
+		if !strings.Contains(tag, "=") {
+			err = fmt.Errorf("silence tag matcher:%s illegal,should be key=value", tag)
+			return
+		}
+	}
+	return
+}
+
+func CreateAlarmSilence(param *models.AlarmSilenceObj, startTime, endTime time.Time, operator string) error {
+	param.Guid = "silence_" + guid.CreateGuid()
+	nowTime := time.Now()
+	_, err := x.Exec("insert into alarm_silence(guid,start_time,end_time,comment,endpoint,endpoint_group,service_group,metric,alarm_strategy,tags,create_user,create_time,update_user,update_time) values (?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		param.Guid, startTime, endTime, param.Comment, param.Endpoint, param.EndpointGroup, param.ServiceGroup, param.Metric, param.AlarmStrategy, strings.Join(param.Tags, ","), operator, nowTime, operator, nowTime)
+	if err != nil {
+		err = fmt.Errorf("insert alarm silence fail,%s ", err.Error())
+	} else {
+		LoadActiveAlarmSilence()
+	}
+	return err
+}
+
+func UpdateAlarmSilence(param *models.AlarmSilenceObj, startTime, endTime time.Time, operator string) error {
+	_, err := x.Exec("update alarm_silence set start_time=?,end_time=?,comment=?,endpoint=?,endpoint_group=?,service_group=?,metric=?,alarm_strategy=?,tags=?,update_user=?,update_time=? where guid=?",
+		startTime, endTime, param.Comment, param.Endpoint, param.EndpointGroup, param.ServiceGroup, param.Metric, param.AlarmStrategy, strings.Join(param.Tags, ","), operator, time.Now(), param.Guid)
+	if err != nil {
+		err = fmt.Errorf("update alarm silence fail,%s ", err.Error())
+	} else {
+		LoadActiveAlarmSilence()
+	}
+	return err
+}
+
+// ExpireAlarmSilence 提前结束静默,保留记录
+func ExpireAlarmSilence(silenceGuid, operator string) error {
+	nowTime := time.Now()
+	_, err := x.Exec("update alarm_silence set end_time=?,update_user=?,update_time=? where guid=? and end_time>?", nowTime, operator, nowTime, silenceGuid, nowTime)
+	if err != nil {
+		err = fmt.Errorf("expire alarm silence fail,%s ", err.Error())
+	} else {
+		LoadActiveAlarmSilence()
+	}
+	return err
+}
+
+func DeleteAlarmSilence(silenceGuid string) error {
+	var actions []*Action
+	actions = append(actions, &Action{Sql: "delete from alarm_silence_muted where silence=?", Param: []interface{}{silenceGuid}})
+	actions = append(actions, &Action{Sql: "delete from alarm_silence where guid=?", Param: []interface{}{silenceGuid}})
+	err := Transaction(actions)
+	if err == nil {
+		LoadActiveAlarmSilence()
+	}
+	return err
+}
+
+func ListAlarmSilenceMuted(silenceGuid string) (result []*models.AlarmSilenceMutedObj, err error) {
+	result = []*models.AlarmSilenceMutedObj{}
+	var mutedRows []*models.AlarmSilenceMutedTable
+	if err = x.SQL("select * from alarm_silence_muted where silence=? order by last_time desc", silenceGuid).Find(&mutedRows); err != nil {
+		err = fmt.Errorf("query alarm silence muted table fail,%s ", err.Error())
+		return
+	}
+	for _, row := range mutedRows {
+		result = append(result, &models.AlarmSilenceMutedObj{Endpoint: row.Endpoint, Metric: row.Metric, AlarmStrategy: row.AlarmStrategy, AlarmName: row.AlarmName, Tags: row.Tags,
+			MutedCount: row.MutedCount, FirstTime: row.FirstTime.Format(models.DatetimeFormat), LastTime: row.LastTime.Format(models.DatetimeFormat)})
+	}
+	return
+}
+
+// LoadActiveAlarmSilence 加载未结束的静默到内存,告警引擎每轮检查开始时调用一次,静默配置修改后也重新加载
+func LoadActiveAlarmSilence() {
+	nowTime := time.Now()
+	var silenceRows []*models.AlarmSilenceTable
+	if err := x.SQL("select * from alarm_silence where end_time>?", nowTime).Find(&silenceRows); err != nil {
+		log.Logger.Error("query active alarm silence fail", log.Error(err))
+		return
+	}
+	activeSilenceLock.Lock()
+	activeSilenceList = silenceRows
+	activeSilenceLoadTime = nowTime
+	activeSilenceLock.Unlock()
+}
+
+func getActiveAlarmSilenceList(nowTime time.Time) (result []*models.AlarmSilenceTable) {
+	activeSilenceLock.RLock()
+	loadTime := activeSilenceLoadTime
+	activeSilenceLock.RUnlock()
+	// 告警接收接口不在引擎的检查周期里,缓存太旧时自己加载
+	if nowTime.Sub(loadTime) > 10*time.Second {
+		LoadActiveAlarmSilence()
+	}
+	activeSilenceLock.RLock()
+	defer activeSilenceLock.RUnlock()
+	for _, silence := range activeSilenceList {
+		if !silence.StartTime.After(nowTime) && silence.EndTime.After(nowTime) {
+			result = append(result, silence)
+		}
+	}
+	return
+}
+
+// MatchAlarmSilence 检查新告警是否命中生效中的静默,命中时记录被静默的告警并返回静默guid
+func MatchAlarmSilence(param *models.AlarmSilenceMatchParam) (silenceGuid string) {
+	nowTime := time.Now()
+	silenceRows := getActiveAlarmSilenceList(nowTime)
+	if len(silenceRows) == 0 {
+		return
+	}
+	var endpointGroupList, serviceGroupList []string
+	groupFetched := false
+	for _, silence := range silenceRows {
+		if silence.Endpoint != "" && silence.Endpoint != param.Endpoint {
+			continue
+		}
+		if silence.Metric != "" && silence.Metric != param.Metric {
+			continue
+		}
+		if silence.AlarmStrategy != "" && silence.AlarmStrategy != param.AlarmStrategy {
+			continue
+		}
+		if !matchSilenceTags(silence.Tags, param.Tags) {
+			continue
+		}
+		if (silence.EndpointGroup != "" || silence.ServiceGroup != "") && !groupFetched {
+			endpointGroupList, serviceGroupList = getAlarmSilenceEndpointGroups(param)
+			groupFetched = true
+		}
+		if silence.EndpointGroup != "" && !stringInList(silence.EndpointGroup, endpointGroupList) {
+			continue
+		}
+		if silence.ServiceGroup != "" && !stringInList(silence.ServiceGroup, serviceGroupList) {
+			continue
+		}
+		silenceGuid = silence.Guid
+		break
+	}
+	if silenceGuid != "" {
+		recordAlarmSilenceMuted(silenceGuid, param, nowTime)
+	}
+	return
+}
+
+// getAlarmSilenceEndpointGroups 告警对象所属的对象组和层级(含所有上级)
+func getAlarmSilenceEndpointGroups(param *models.AlarmSilenceMatchParam) (endpointGroupList, serviceGroupList []string) {
+	if param.EndpointGroup != "" {
+		endpointGroupList = append(endpointGroupList, param.EndpointGroup)
+	}
+	queryRows, _ := x.QueryString("select endpoint_group from endpoint_group_rel where endpoint=?", param.Endpoint)
+	for _, row := range queryRows {
+		endpointGroupList = append(endpointGroupList, row["endpoint_group"])
+	}
+	var directServiceGroups []string
+	if strings.HasPrefix(param.Endpoint, "sg__") {
+		directServiceGroups = append(directServiceGroups, param.Endpoint[4:])
+	}
+	queryRows, _ = x.QueryString("select distinct service_group from endpoint_service_rel where endpoint=?", param.Endpoint)
+	for _, row := range queryRows {
+		directServiceGroups = append(directServiceGroups, row["service_group"])
+	}
+	for _, v := range directServiceGroups {
+		if tmpGuidList, err := fetchGlobalServiceGroupParentGuidList(v); err == nil {
+			serviceGroupList = append(serviceGroupList, tmpGuidList...)
+		} else {
+			serviceGroupList = append(serviceGroupList, v)
+		}
+	}
+	return
+}
+
+// matchSilenceTags 静默的标签条件是 key=value 列表,告警标签格式为 key:value^key:value
+func matchSilenceTags(silenceTags, alarmTags string) bool {
+	if silenceTags == "" {
+		return true
+	}
+	alarmTagMap := make(map[string]string)
+	for _, v := range strings.Split(alarmTags, "^") {
+		if splitIndex := strings.Index(v, ":"); splitIndex > 0 {
+			alarmTagMap[v[:splitIndex]] = v[splitIndex+1:]
+		}
+	}
+	for _, tag := range splitSilenceTags(silenceTags) {
+		splitIndex := strings.Index(tag, "=")
+		if splitIndex <= 0 {
+			return false
+		}
+		if tagValue, ok := alarmTagMap[strings.TrimSpace(tag[:splitIndex])]; !ok || tagValue != strings.TrimSpace(tag[splitIndex+1:]) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitSilenceTags(tags string) (result []string) {
+	result = []string{}
+	for _, v := range strings.Split(tags, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return
+}
+
+func stringInList(input string, list []string) bool {
+	for _, v := range list {
+		if v == input {
+			return true
+		}
+	}
+	return false
+}
+
+func recordAlarmSilenceMuted(silenceGuid string, param *models.AlarmSilenceMatchParam, nowTime time.Time) {
+	alarmKey := cipher.Md5Encode(fmt.Sprintf("%s^%s^%s^%s", param.Endpoint, param.AlarmStrategy, param.Metric, param.Tags))
+	// 同一个告警每轮检查都会命中,只在第一次被静默时计数,之后只更新最近静默时间
+	_, err := x.Exec("insert into alarm_silence_muted(silence,alarm_key,endpoint,metric,alarm_strategy,alarm_name,tags,muted_count,first_time,last_time) values (?,?,?,?,?,?,?,1,?,?) on duplicate key update last_time=?",
+		silenceGuid, alarmKey, param.Endpoint, param.Metric, param.AlarmStrategy, param.AlarmName, param.Tags, nowTime, nowTime, nowTime)
+	if err != nil {
+		log.Logger.Error("record alarm silence muted fail", log.String("silence", silenceGuid), log.Error(err))
+	}
+}
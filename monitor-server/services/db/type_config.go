@@ -58,8 +58,14 @@ func GetTypeConfigListByNames(names []string) (list []*models.TypeConfig, err er
 }
 
 func AddTypeConfig(param models.TypeConfig) (err error) {
-	_, err = x.Exec("insert into monitor_type(guid,display_name,system_type,create_user,create_time) values(?,?,?,?,?)",
-		param.Guid, param.DisplayName, param.SystemType, param.CreateUser, time.Now().Format(models.DatetimeFormat))
+	_, err = x.Exec("insert into monitor_type(guid,display_name,system_type,create_user,create_time,datasource) values(?,?,?,?,?,?)",
+		param.Guid, param.DisplayName, param.SystemType, param.CreateUser, time.Now().Format(models.DatetimeFormat), param.Datasource)
+	return
+}
+
+// UpdateTypeConfigDatasource 设置对象类型默认查询的数据源,为空时用默认数据源
+func UpdateTypeConfigDatasource(param models.TypeConfigDatasourceParam) (err error) {
+	_, err = x.Exec("update monitor_type set datasource=? where guid=?", param.Datasource, param.Guid)
 	return
 }
 
@@ -58,3 +58,283 @@ alter table metric add index metric_update_time(update_time);
 
 
 
+
+CREATE TABLE `notify_channel` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `notify` varchar(64) NOT NULL COMMENT '通知配置',
+    `name` varchar(255) DEFAULT NULL COMMENT '渠道名称',
+    `channel_type` varchar(32) NOT NULL COMMENT '渠道类型 webhook/slack/dingtalk/wecom/feishu/sms',
+    `url` varchar(1024) NOT NULL COMMENT '推送地址',
+    `secret` varchar(255) DEFAULT NULL COMMENT '签名密钥或认证头',
+    `template` text DEFAULT NULL COMMENT '消息模版',
+    `receiver` varchar(1024) DEFAULT NULL COMMENT '额外接收人,逗号分隔',
+    `timeout` int(11) DEFAULT 0 COMMENT '超时秒数',
+    `retry_times` int(11) DEFAULT 0 COMMENT '重试次数,0表示使用渠道默认值',
+    `retry_interval` int(11) DEFAULT 0 COMMENT '重试间隔秒数,0表示使用渠道默认值',
+    `enable` tinyint(1) DEFAULT 1 COMMENT '是否启用',
+    `last_status` varchar(32) DEFAULT NULL COMMENT '最近一次投递结果',
+    `last_error` varchar(1024) DEFAULT NULL COMMENT '最近一次投递错误',
+    `last_attempt` int(11) DEFAULT 0 COMMENT '最近一次投递尝试次数',
+    `last_send_time` datetime DEFAULT NULL COMMENT '最近一次投递时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    KEY `idx_notify_channel_notify` (`notify`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `notify_delivery` (
+    `id` int(11) NOT NULL AUTO_INCREMENT COMMENT '自增id',
+    `alarm_id` int(11) NOT NULL COMMENT '告警id',
+    `alarm_status` varchar(32) DEFAULT NULL COMMENT '入队时告警状态',
+    `notify_id` varchar(64) DEFAULT NULL COMMENT '通知配置',
+    `channel` varchar(64) NOT NULL COMMENT '投递渠道 mail/event/通知渠道guid',
+    `channel_type` varchar(32) NOT NULL COMMENT '渠道类型 mail/event/webhook/slack/dingtalk/wecom/feishu/sms',
+    `receivers` text DEFAULT NULL COMMENT '接收人',
+    `payload` mediumtext DEFAULT NULL COMMENT '投递内容',
+    `status` varchar(32) NOT NULL DEFAULT 'pending' COMMENT '状态 pending/sending/success/fail/cancel',
+    `attempt` int(11) DEFAULT 0 COMMENT '已尝试次数',
+    `max_attempt` int(11) DEFAULT 1 COMMENT '最大尝试次数',
+    `retry_base` int(11) DEFAULT 10 COMMENT '重试基础间隔秒数,按指数退避',
+    `notify_delay` int(11) DEFAULT 0 COMMENT '延迟通知秒数',
+    `last_error` varchar(1024) DEFAULT NULL COMMENT '最近一次错误',
+    `operator` varchar(64) DEFAULT NULL COMMENT '操作人',
+    `next_time` datetime DEFAULT NULL COMMENT '下次投递时间',
+    `create_time` datetime DEFAULT NULL COMMENT '创建时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_notify_delivery_status` (`status`,`next_time`),
+    KEY `idx_notify_delivery_alarm` (`alarm_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_escalation` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `name` varchar(255) NOT NULL COMMENT '名称',
+    `alarm_strategy` varchar(64) DEFAULT '' COMMENT '阈值配置',
+    `endpoint_group` varchar(64) DEFAULT '' COMMENT '对象组',
+    `enable` tinyint(1) DEFAULT 1 COMMENT '是否启用',
+    `description` varchar(512) DEFAULT NULL COMMENT '描述',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    KEY `idx_alarm_escalation_strategy` (`alarm_strategy`),
+    KEY `idx_alarm_escalation_group` (`endpoint_group`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_escalation_step` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `escalation` varchar(64) NOT NULL COMMENT '升级策略',
+    `step_index` int(11) NOT NULL COMMENT '步骤序号,从0开始',
+    `delay` int(11) DEFAULT 0 COMMENT '距上一步(第一步为告警开始)的分钟数',
+    `notify` varchar(64) NOT NULL COMMENT '该步骤的通知配置',
+    PRIMARY KEY (`guid`),
+    KEY `idx_alarm_escalation_step` (`escalation`,`step_index`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_escalation_state` (
+    `alarm_id` int(11) NOT NULL COMMENT '告警id',
+    `escalation` varchar(64) NOT NULL COMMENT '升级策略',
+    `step_index` int(11) DEFAULT 0 COMMENT '下一步要执行的步骤序号',
+    `status` varchar(32) NOT NULL COMMENT '状态 running/done/cancel',
+    `next_time` datetime DEFAULT NULL COMMENT '下一步执行时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`alarm_id`),
+    KEY `idx_alarm_escalation_state` (`status`,`next_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+alter table alarm add column ack_user varchar(64) default null comment '确认人';
+alter table alarm add column ack_time datetime default null comment '确认时间';
+
+CREATE TABLE `alarm_silence` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `start_time` datetime NOT NULL COMMENT '开始时间',
+    `end_time` datetime NOT NULL COMMENT '结束时间',
+    `comment` varchar(1024) DEFAULT NULL COMMENT '备注',
+    `endpoint` varchar(255) DEFAULT '' COMMENT '匹配对象',
+    `endpoint_group` varchar(64) DEFAULT '' COMMENT '匹配对象组',
+    `service_group` varchar(64) DEFAULT '' COMMENT '匹配层级对象,含下级',
+    `metric` varchar(255) DEFAULT '' COMMENT '匹配指标',
+    `alarm_strategy` varchar(64) DEFAULT '' COMMENT '匹配阈值配置',
+    `tags` varchar(1024) DEFAULT '' COMMENT '匹配标签 key=value,逗号分隔',
+    `create_user` varchar(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` datetime DEFAULT NULL COMMENT '创建时间',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    KEY `idx_alarm_silence_time` (`start_time`,`end_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_silence_muted` (
+    `id` int(11) NOT NULL AUTO_INCREMENT COMMENT '自增id',
+    `silence` varchar(64) NOT NULL COMMENT '静默规则',
+    `alarm_key` varchar(64) NOT NULL COMMENT '告警唯一标识(对象+阈值+指标+标签的md5)',
+    `endpoint` varchar(255) DEFAULT NULL COMMENT '告警对象',
+    `metric` varchar(255) DEFAULT NULL COMMENT '告警指标',
+    `alarm_strategy` varchar(64) DEFAULT NULL COMMENT '阈值配置',
+    `alarm_name` varchar(255) DEFAULT NULL COMMENT '告警名称',
+    `tags` text DEFAULT NULL COMMENT '告警标签',
+    `muted_count` int(11) DEFAULT 1 COMMENT '被静默次数',
+    `first_time` datetime DEFAULT NULL COMMENT '首次静默时间',
+    `last_time` datetime DEFAULT NULL COMMENT '最近静默时间',
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_alarm_silence_muted` (`silence`,`alarm_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_correlation_rule` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `name` varchar(255) NOT NULL COMMENT '名称',
+    `match_type` varchar(32) NOT NULL COMMENT '关联方式 host_ip/service_group/time',
+    `service_group` varchar(64) DEFAULT '' COMMENT '限定层级对象,含下级,按时间关联时必填',
+    `time_window` int(11) DEFAULT 300 COMMENT '时间窗口(秒)',
+    `sort_index` int(11) DEFAULT 0 COMMENT '匹配顺序',
+    `enable` tinyint(1) DEFAULT 1 COMMENT '是否启用',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_incident` (
+    `id` int(11) NOT NULL AUTO_INCREMENT COMMENT '自增id',
+    `rule` varchar(64) NOT NULL COMMENT '聚合规则',
+    `correlation_key` varchar(255) NOT NULL COMMENT '关联键(ip/层级对象)',
+    `status` varchar(32) NOT NULL COMMENT '状态 firing/ok',
+    `s_priority` varchar(32) DEFAULT NULL COMMENT '最高告警级别',
+    `root_alarm` int(11) DEFAULT 0 COMMENT '推荐根因告警',
+    `notify_alarm` int(11) DEFAULT 0 COMMENT '代表事件发通知的告警',
+    `end_alarm` int(11) DEFAULT 0 COMMENT '最后恢复的告警',
+    `alarm_count` int(11) DEFAULT 0 COMMENT '告警数',
+    `start` datetime DEFAULT NULL COMMENT '开始时间',
+    `last_time` datetime DEFAULT NULL COMMENT '最近加入告警时间',
+    `end` datetime DEFAULT NULL COMMENT '结束时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_alarm_incident_key` (`rule`,`correlation_key`,`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `alarm_incident_alarm` (
+    `incident` int(11) NOT NULL COMMENT '事件id',
+    `alarm_id` int(11) NOT NULL COMMENT '告警id',
+    `join_time` datetime DEFAULT NULL COMMENT '加入时间',
+    `notified` tinyint(1) DEFAULT 0 COMMENT '是否代表所属阈值配置发过通知',
+    PRIMARY KEY (`alarm_id`),
+    KEY `idx_alarm_incident_alarm` (`incident`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+alter table alarm_strategy add column recover_condition varchar(32) default '' comment '恢复条件,为空则不满足告警条件即恢复';
+alter table alarm_strategy add column recover_last varchar(16) default '' comment '恢复条件持续时间';
+alter table alarm_strategy add column flap_count int(11) default 0 comment '抖动判定的状态变化次数,0不检测';
+alter table alarm_strategy add column flap_window int(11) default 0 comment '抖动判定的时间窗口(秒)';
+alter table alarm add column flapping tinyint(1) default 0 comment '是否抖动中';
+alter table alarm add column recover_time datetime default null comment '等待满足恢复条件的开始时间';
+
+alter table alarm_strategy_metric add column condition_type varchar(32) default '' comment '条件类型,空为静态阈值,sigma|day|week|rate为异常检测';
+alter table alarm_strategy_metric add column anomaly_window varchar(16) default '' comment '异常检测窗口';
+
+alter table log_metric_config add column buckets varchar(255) default '' comment '直方图桶上界,逗号分隔,为空用默认桶';
+
+alter table log_metric_monitor add column multiline_start varchar(255) default '' comment '多行合并,新事件首行正则';
+alter table log_metric_monitor add column multiline_continue varchar(255) default '' comment '多行合并,续行正则';
+alter table log_metric_monitor add column multiline_max_lines int(11) default 0 comment '多行合并最大行数,0用默认值';
+alter table log_metric_monitor add column multiline_timeout int(11) default 0 comment '多行合并等待超时(秒),0用默认值';
+alter table log_keyword_monitor add column multiline_start varchar(255) default '' comment '多行合并,新事件首行正则';
+alter table log_keyword_monitor add column multiline_continue varchar(255) default '' comment '多行合并,续行正则';
+alter table log_keyword_monitor add column multiline_max_lines int(11) default 0 comment '多行合并最大行数,0用默认值';
+alter table log_keyword_monitor add column multiline_timeout int(11) default 0 comment '多行合并等待超时(秒),0用默认值';
+
+alter table log_monitor_template add column delimiter varchar(16) default '' comment 'csv日志分隔符,默认逗号';
+
+CREATE TABLE `alarm_log_context` (
+    `alarm_id` int(11) NOT NULL COMMENT '告警id',
+    `log_path` varchar(255) DEFAULT '' COMMENT '日志路径',
+    `keyword` varchar(255) DEFAULT '' COMMENT '关键字',
+    `match_time` datetime DEFAULT NULL COMMENT '最近一次匹配时间',
+    `content` text COMMENT '匹配行',
+    `before_rows` text COMMENT '匹配行之前的行,json数组',
+    `after_rows` text COMMENT '匹配行之后的行,json数组',
+    `window_counts` text COMMENT '每分钟匹配次数,json数组',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`alarm_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+alter table log_keyword_config add column match_type varchar(16) default 'keyword' comment '匹配方式 -> keyword(单个关键字) | expression(布尔表达式)';
+alter table log_keyword_config add column exclude_keyword varchar(255) default '' comment '排除条件,命中的行不计数';
+alter table log_keyword_config add column threshold_count int(11) default 0 comment '时间窗口内出现次数超过该值才告警,0为出现即告警';
+alter table log_keyword_config add column threshold_window int(11) default 0 comment '次数统计的时间窗口(分钟)';
+
+CREATE TABLE `log_monitor_template_version` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `log_monitor_template` varchar(64) NOT NULL COMMENT '日志模版',
+    `version` int(11) NOT NULL COMMENT '版本号,从1递增',
+    `snapshot` longtext COMMENT '该版本的模版配置快照',
+    `change_log` text COMMENT '修改说明',
+    `create_user` varchar(64) DEFAULT NULL COMMENT '创建人',
+    `create_time` datetime DEFAULT NULL COMMENT '创建时间',
+    PRIMARY KEY (`guid`),
+    UNIQUE KEY `log_template_version_uk` (`log_monitor_template`,`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+alter table log_metric_group add column pinned_template_version int(11) default 0 comment '锁定的模版版本,0表示跟随模版最新配置';
+alter table log_metric_group add column prev_template_version int(11) default 0 comment '上一次发布前锁定的模版版本,用于回滚,-1表示发布前跟随模版最新配置';
+
+CREATE TABLE `log_metric_derived` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `log_metric_group` varchar(64) NOT NULL COMMENT '日志指标组',
+    `metric` varchar(64) NOT NULL COMMENT '派生指标名',
+    `display_name` varchar(255) DEFAULT NULL COMMENT '显示名',
+    `expression` varchar(512) NOT NULL COMMENT '表达式,同组指标的四则运算',
+    `color_group` varchar(32) DEFAULT NULL COMMENT '颜色',
+    `auto_alarm` tinyint(1) DEFAULT 0 COMMENT '是否自动创建告警',
+    `range_config` text COMMENT '告警阈值配置',
+    `create_user` varchar(64) DEFAULT NULL COMMENT '创建人',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `create_time` datetime DEFAULT NULL COMMENT '创建时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    UNIQUE KEY `log_metric_derived_uk` (`log_metric_group`,`metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `monitor_datasource` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `name` varchar(64) NOT NULL COMMENT '名称',
+    `type` varchar(32) DEFAULT 'prometheus' COMMENT '类型,prometheus/victoriametrics/thanos',
+    `address` varchar(255) NOT NULL COMMENT '查询地址,可带协议和路径前缀',
+    `token` varchar(512) DEFAULT NULL COMMENT '认证token',
+    `region` varchar(64) DEFAULT NULL COMMENT '区域',
+    `is_default` tinyint(1) DEFAULT 0 COMMENT '是否默认数据源',
+    `description` varchar(255) DEFAULT NULL COMMENT '描述',
+    `status` varchar(32) DEFAULT 'unknown' COMMENT '健康状态,ok/error/unknown',
+    `status_message` varchar(512) DEFAULT NULL COMMENT '健康检查错误信息',
+    `check_at` datetime DEFAULT NULL COMMENT '最近检查时间',
+    `create_user` varchar(64) DEFAULT NULL COMMENT '创建人',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `create_at` datetime DEFAULT NULL COMMENT '创建时间',
+    `update_at` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    UNIQUE KEY `monitor_datasource_name_uk` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+alter table metric add column datasource varchar(64) default '' comment '数据源,为空时用对象类型的数据源';
+alter table monitor_type add column datasource varchar(64) default '' comment '数据源,为空时用默认数据源';
+alter table custom_chart add column datasource varchar(512) default '' comment '数据源,多个用逗号分隔';
+
+CREATE TABLE `custom_dashboard_variable` (
+    `guid` varchar(64) NOT NULL COMMENT '唯一标识',
+    `custom_dashboard` int(11) NOT NULL COMMENT '所属看板',
+    `name` varchar(64) NOT NULL COMMENT '变量名,图表中用$name引用',
+    `display_name` varchar(128) DEFAULT NULL COMMENT '显示名',
+    `type` varchar(32) NOT NULL COMMENT '类型,service_group/endpoint/endpoint_group/tag_value/interval',
+    `monitor_type` varchar(64) DEFAULT NULL COMMENT '对象类型',
+    `metric_guid` varchar(128) DEFAULT NULL COMMENT '标签值变量的指标',
+    `tag_name` varchar(64) DEFAULT NULL COMMENT '标签值变量的标签名',
+    `endpoint` varchar(255) DEFAULT NULL COMMENT '标签值变量查询的对象或层级对象,可引用其它变量',
+    `options` varchar(512) DEFAULT NULL COMMENT '间隔变量的可选值,逗号分隔',
+    `default_value` varchar(512) DEFAULT NULL COMMENT '默认值',
+    `sort_index` int(11) DEFAULT 0 COMMENT '排序',
+    `create_user` varchar(64) DEFAULT NULL COMMENT '创建人',
+    `update_user` varchar(64) DEFAULT NULL COMMENT '更新人',
+    `create_time` datetime DEFAULT NULL COMMENT '创建时间',
+    `update_time` datetime DEFAULT NULL COMMENT '更新时间',
+    PRIMARY KEY (`guid`),
+    UNIQUE KEY `custom_dashboard_variable_uk` (`custom_dashboard`,`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+alter table alarm_strategy_metric add column recover_condition varchar(32) default '' comment '条件的恢复条件,为空时同指标的条件沿用阈值配置的';
+alter table alarm_strategy_metric add column recover_last varchar(16) default '' comment '条件的恢复条件持续时间';
+alter table alarm_strategy_metric add column flap_count int(11) default 0 comment '条件的抖动判定次数,0沿用阈值配置的';
+alter table alarm_strategy_metric add column flap_window int(11) default 0 comment '条件的抖动判定窗口(秒)';